@@ -0,0 +1,46 @@
+package deptree
+
+import (
+	"sort"
+	"time"
+)
+
+// 归档：ArchiveOrgNode将子树移出正常数据(内存实现的独立索引、sql的*_archive表、ldap的ArchiveBase)，
+// 因此各查询方法无需额外过滤；ID、叶子及岗位保持不变，RestoreOrgNode按原样移回
+
+// archiveRetention 归档保留期 config["ArchiveRetentionDays"] 默认30天
+func archiveRetention(config map[string]interface{}) time.Duration {
+	return time.Duration(configInt(config, "ArchiveRetentionDays", 30)) * 24 * time.Hour
+}
+
+// archiveNow 归档时间 各实现统一精确到秒
+func archiveNow() time.Time {
+	return time.Unix(time.Now().Unix(), 0)
+}
+
+// newArchivedOrg 生成归档信息
+func newArchivedOrg(node OrgNode, archivedAt time.Time, retention time.Duration) ArchivedOrg {
+	return ArchivedOrg{OrgNode: node, ArchivedAt: archivedAt, PurgeAt: archivedAt.Add(retention)}
+}
+
+// sortArchived 按归档时间、ID排序
+func sortArchived(list []ArchivedOrg) {
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].ArchivedAt.Equal(list[j].ArchivedAt) {
+			return list[i].ArchivedAt.Before(list[j].ArchivedAt)
+		}
+		return list[i].Id < list[j].Id
+	})
+}
+
+// expiredArchives 已超过保留期的归档子树根节点ID 已排序
+func expiredArchives(list []ArchivedOrg, now time.Time) []string {
+	ids := []string{}
+	for _, a := range list {
+		if !a.PurgeAt.After(now) {
+			ids = append(ids, a.Id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,126 @@
+package deptree
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// 扩展属性 OrgNode.Attrs LeafNode.Attrs 名称 -> 值列表
+
+// 扩展属性名称及值的最大长度(字符数) 与sql表中name value列的长度一致
+const (
+	attrNameMaxLen  = 64
+	attrValueMaxLen = 255
+)
+
+// checkAttrs 检查扩展属性 名称不能为空、包含= $ \、仅大小写不同或超长，值不能超长
+// 返回去重后的副本：ldap的postalAddress按不区分大小写比较值，同一属性的值只保留大小写不同的第一个
+// attrs为nil时返回nil，以保留修改时nil表示不修改的语义
+func checkAttrs(attrs map[string][]string) (map[string][]string, error) {
+	if attrs == nil {
+		return nil, nil
+	}
+	ret := make(map[string][]string, len(attrs))
+	names := map[string]string{}
+	for _, name := range attrNames(attrs) {
+		if name == "" || strings.ContainsAny(name, `=$\`) || utf8.RuneCountInString(name) > attrNameMaxLen {
+			return nil, newError(ErrInvalidArgument, "", "invalid attribute name: %s", name)
+		}
+		if other, ok := names[strings.ToLower(name)]; ok {
+			return nil, newError(ErrInvalidArgument, "", "attribute names differ only in case: %s %s", other, name)
+		}
+		names[strings.ToLower(name)] = name
+		values := []string{}
+		for _, v := range attrs[name] {
+			if utf8.RuneCountInString(v) > attrValueMaxLen {
+				return nil, newError(ErrInvalidArgument, "", "attribute value too long: %s", name)
+			}
+			if !containsFold(values, v) {
+				values = append(values, v)
+			}
+		}
+		ret[name] = values
+	}
+	return ret, nil
+}
+
+// containsFold 判断列表中是否有不区分大小写相同的值
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyAttrs 深度复制扩展属性 空时返回nil
+func copyAttrs(attrs map[string][]string) map[string][]string {
+	if len(attrs) == 0 {
+		return nil
+	}
+	ret := make(map[string][]string, len(attrs))
+	for k, v := range attrs {
+		ret[k] = append([]string{}, v...)
+	}
+	return ret
+}
+
+// attrNames 扩展属性名称 已排序
+func attrNames(attrs map[string][]string) []string {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// matchAttrs 判断扩展属性是否满足条件 cond为 名称 -> 值，需全部满足，属性的任一值相同即可
+func matchAttrs(attrs map[string][]string, cond map[string]string) bool {
+	for name, value := range cond {
+		if !contains(attrs[name], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// attrValueEscaper ldap中扩展属性值的转义 按RFC 4517 PostalAddress的规则转义\及$
+var (
+	attrValueEscaper   = strings.NewReplacer(`\`, `\5C`, `$`, `\24`)
+	attrValueUnescaper = strings.NewReplacer(`\5C`, `\`, `\5c`, `\`, `\24`, `$`)
+)
+
+// encodeAttr 将一个扩展属性值编码为ldap属性值 name=value
+func encodeAttr(name string, value string) string {
+	return name + "=" + attrValueEscaper.Replace(value)
+}
+
+// encodeAttrs 将扩展属性编码为ldap属性值列表 按名称排序
+func encodeAttrs(attrs map[string][]string) []string {
+	ret := []string{}
+	for _, name := range attrNames(attrs) {
+		for _, v := range attrs[name] {
+			ret = append(ret, encodeAttr(name, v))
+		}
+	}
+	return ret
+}
+
+// decodeAttrs 解析ldap属性值列表 忽略格式错误的值
+func decodeAttrs(values []string) map[string][]string {
+	ret := map[string][]string{}
+	for _, v := range values {
+		i := strings.Index(v, "=")
+		if i <= 0 {
+			continue
+		}
+		ret[v[:i]] = append(ret[v[:i]], attrValueUnescaper.Replace(v[i+1:]))
+	}
+	if len(ret) == 0 {
+		return nil
+	}
+	return ret
+}
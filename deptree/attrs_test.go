@@ -0,0 +1,163 @@
+package deptree
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckAttrs(t *testing.T) {
+	long := strings.Repeat("好", attrValueMaxLen)
+	cases := []struct {
+		name  string
+		attrs map[string][]string
+		want  map[string][]string
+		ok    bool
+	}{
+		{"nil", nil, nil, true},
+		{"empty", map[string][]string{}, map[string][]string{}, true},
+		{"dedupe", map[string][]string{"tag": {"A", "b", "a", "B", "b"}}, map[string][]string{"tag": {"A", "b"}}, true},
+		{"max length", map[string][]string{strings.Repeat("n", attrNameMaxLen): {long}},
+			map[string][]string{strings.Repeat("n", attrNameMaxLen): {long}}, true},
+		{"empty name", map[string][]string{"": {"x"}}, nil, false},
+		{"name with =", map[string][]string{"a=b": {"x"}}, nil, false},
+		{"name with $", map[string][]string{"a$b": {"x"}}, nil, false},
+		{`name with \`, map[string][]string{`a\b`: {"x"}}, nil, false},
+		{"names differ in case", map[string][]string{"tag": {"x"}, "Tag": {"y"}}, nil, false},
+		{"name too long", map[string][]string{strings.Repeat("n", attrNameMaxLen+1): {"x"}}, nil, false},
+		{"value too long", map[string][]string{"tag": {long + "x"}}, nil, false},
+	}
+	for _, c := range cases {
+		got, err := checkAttrs(c.attrs)
+		if !c.ok {
+			if !errors.Is(err, ErrInvalidArgument) {
+				t.Errorf("%s: err = %v, want ErrInvalidArgument", c.name, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, c.want) || (got == nil) != (c.want == nil) {
+			t.Errorf("%s: checkAttrs = %v, %v, want %v", c.name, got, err, c.want)
+		}
+	}
+	// 不修改传入的map
+	attrs := map[string][]string{"tag": {"a", "A"}}
+	checkAttrs(attrs)
+	if len(attrs["tag"]) != 2 {
+		t.Errorf("input modified: %v", attrs)
+	}
+}
+
+func TestEncodeAttrs(t *testing.T) {
+	attrs := map[string][]string{"b": {`x$y`, `a\24b`}, "a": {"=", ""}}
+	values := encodeAttrs(attrs)
+	want := []string{"a==", "a=", `b=x\24y`, `b=a\5C24b`}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("encodeAttrs = %q, want %q", values, want)
+	}
+	if got := decodeAttrs(values); !reflect.DeepEqual(got, attrs) {
+		t.Errorf("decodeAttrs = %q, want %q", got, attrs)
+	}
+}
+
+// 扩展属性在各后端新增、修改、查询后保持一致
+func TestAttrsRoundTrip(t *testing.T) {
+	eachBackend(t, func(t *testing.T, tree DepTree) {
+		seedTree(t, tree, "m")
+		attrs := map[string][]string{"tag": {`a$b`, `c\d`, "C\\D"}, "code": {"1"}}
+		want := map[string][]string{"tag": {`a$b`, `c\d`}, "code": {"1"}}
+		id, err := tree.AddOrgNode(OrgNode{Mid: "m", Pid: "m", Name: "a", Attrs: attrs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err := tree.GetOrgNode("m", id); err != nil || !reflect.DeepEqual(n.Attrs, want) {
+			t.Errorf("org attrs = %q, %v, want %q", n.Attrs, err, want)
+		}
+		if err = tree.AddLeafNode(LeafNode{Mid: "m", Pid: id, Uid: "u", Attrs: attrs}); err != nil {
+			t.Fatal(err)
+		}
+		if l, err := tree.GetLeafNodes("m", id, "u"); err != nil || len(l) != 1 || !reflect.DeepEqual(l[0].Attrs, want) {
+			t.Errorf("leaf = %+v, %v, want attrs %q", l, err, want)
+		}
+
+		// 传nil不修改，空map清空
+		if err = tree.ModifyOrgNode(OrgNode{Mid: "m", Id: id, Name: "b"}); err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := tree.GetOrgNode("m", id); !reflect.DeepEqual(n.Attrs, want) {
+			t.Errorf("org attrs after rename = %q", n.Attrs)
+		}
+		if err = tree.ModifyOrgNode(OrgNode{Mid: "m", Id: id, Attrs: map[string][]string{}}); err != nil {
+			t.Fatal(err)
+		}
+		if n, _ := tree.GetOrgNode("m", id); len(n.Attrs) != 0 {
+			t.Errorf("org attrs after clear = %q", n.Attrs)
+		}
+		leafAttrs := map[string][]string{"code": {"2"}}
+		if err = tree.ModifyLeafNode(LeafNode{Mid: "m", Pid: id, Uid: "u", Attrs: leafAttrs}); err != nil {
+			t.Fatal(err)
+		}
+		if l, _ := tree.GetLeafNodes("m", id, "u"); len(l) != 1 || !reflect.DeepEqual(l[0].Attrs, leafAttrs) {
+			t.Errorf("leaf after modify = %+v", l)
+		}
+
+		bad := map[string][]string{"a$b": {"x"}}
+		if _, err = tree.AddOrgNode(OrgNode{Mid: "m", Pid: "m", Name: "c", Attrs: bad}); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("invalid name err = %v", err)
+		}
+		if err = tree.ModifyLeafNode(LeafNode{Mid: "m", Pid: id, Uid: "u", Attrs: bad}); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("invalid leaf attrs err = %v", err)
+		}
+	})
+}
+
+func TestSearchAttrs(t *testing.T) {
+	eachBackend(t, func(t *testing.T, tree DepTree) {
+		seedTree(t, tree, "m")
+		for _, n := range []OrgNode{
+			{Id: "a", Name: "a", Attrs: map[string][]string{"tag": {"x$1", "y"}, "code": {"1"}}},
+			{Id: "b", Name: "b", Attrs: map[string][]string{"tag": {"y"}, "code": {"2"}}},
+			{Id: "c", Name: "c"},
+		} {
+			n.Mid, n.Pid = "m", "m"
+			if _, err := tree.AddOrgNode(n); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tree.AddLeafNode(LeafNode{Mid: "m", Pid: "a", Uid: "u1", Attrs: map[string][]string{"tag": {`x\y`}}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.AddLeafNode(LeafNode{Mid: "m", Pid: "b", Uid: "u2"}); err != nil {
+			t.Fatal(err)
+		}
+		cases := []struct {
+			target int
+			attrs  map[string]string
+			want   string
+		}{
+			{SEARCH_ORG, map[string]string{"tag": "y"}, "a,b"},
+			{SEARCH_ORG, map[string]string{"tag": "x$1"}, "a"},
+			{SEARCH_ORG, map[string]string{"tag": "y", "code": "2"}, "b"},
+			{SEARCH_ORG, map[string]string{"tag": "z"}, ""},
+			{SEARCH_ORG, map[string]string{"none": "y"}, ""},
+			{SEARCH_LEAF, map[string]string{"tag": `x\y`}, "u1"},
+			{SEARCH_LEAF, map[string]string{"tag": "y"}, ""},
+		}
+		for _, c := range cases {
+			res, err := tree.Search("m", SearchQuery{Target: c.target, Attrs: c.attrs})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := []string{}
+			for _, n := range res.Orgs {
+				ids = append(ids, n.Id)
+			}
+			for _, l := range res.Leafs {
+				ids = append(ids, l.Uid)
+			}
+			if got := strings.Join(ids, ","); got != c.want || res.Total != len(ids) {
+				t.Errorf("Search(%d, %v) = %s total %d, want %s", c.target, c.attrs, got, res.Total, c.want)
+			}
+		}
+	})
+}
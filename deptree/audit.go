@@ -0,0 +1,449 @@
+package deptree
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// AuditRecord 一次修改操作的审计记录
+type AuditRecord struct {
+	Time    time.Time   // 操作时间
+	Actor   string      // 操作人 见AuditTree.As
+	Op      string      // 方法名 如AddOrgNode
+	Mid     string      // 商户ID
+	Target  string      // 操作对象：组织节点ID、岗位ID或叶子的 pid/uid
+	Before  *AuditState // 操作前状态 新增时为nil
+	After   *AuditState // 操作后状态 删除或操作失败时为nil
+	Success bool        // 是否成功
+	Error   string      // 失败原因
+}
+
+// AuditState 审计记录中的状态 按操作对象填充其一
+type AuditState struct {
+	Org      *OrgNode   // 组织节点
+	Tree     *OrgTree   // 整棵子树 用于DelOrgNode ArchiveOrgNode RestoreOrgNode
+	Leaf     *LeafNode  // 叶子节点
+	Leafs    []LeafNode // 同一uid在未归档组织节点下的全部叶子 用于RenameLeafNode
+	Position *Position  // 岗位定义
+}
+
+// AuditSink 审计记录的输出
+type AuditSink interface {
+	Write(record AuditRecord) error
+}
+
+// AuditQueryer 支持查询历史记录的AuditSink
+type AuditQueryer interface {
+	Query(query AuditQuery) ([]AuditRecord, error)
+}
+
+// AuditQuery 审计记录查询条件 空值的条件不做限制，结果按记录时间顺序
+type AuditQuery struct {
+	Mid    string
+	Actor  string
+	Op     string
+	Target string
+	Since  time.Time // 包含
+	Until  time.Time // 不包含
+	Failed bool      // 只返回失败的操作
+	Offset int       // 跳过的结果数
+	Limit  int       // 返回的最大结果数 0为不限制
+}
+
+// match 判断记录是否满足条件
+func (self *AuditQuery) match(r *AuditRecord) bool {
+	return (self.Mid == "" || r.Mid == self.Mid) &&
+		(self.Actor == "" || r.Actor == self.Actor) &&
+		(self.Op == "" || r.Op == self.Op) &&
+		(self.Target == "" || r.Target == self.Target) &&
+		(self.Since.IsZero() || !r.Time.Before(self.Since)) &&
+		(self.Until.IsZero() || r.Time.Before(self.Until)) &&
+		(!self.Failed || !r.Success)
+}
+
+// AuditTree 记录修改操作的DepTree装饰器，通过NewAuditTree获得
+// 每个修改方法在执行前后各查询一次操作对象的状态，写入全部sink；查询方法直接转发
+// 前后状态为分别查询的结果，并发修改时可能包含其他操作的变化
+// sink写入失败不影响操作结果，错误通过标准库log输出
+type AuditTree struct {
+	tree  DepTree
+	sinks []AuditSink
+	actor string
+}
+
+// NewAuditTree 包装任意DepTree实现
+func NewAuditTree(tree DepTree, sinks ...AuditSink) *AuditTree {
+	return &AuditTree{tree: tree, sinks: sinks}
+}
+
+// As 返回以actor为操作人记录的AuditTree 与原对象共用后端及sink
+func (self *AuditTree) As(actor string) *AuditTree {
+	ret := *self
+	ret.actor = actor
+	return &ret
+}
+
+// withContext 返回在ctx下访问被包装对象的副本
+func (self *AuditTree) withContext(ctx context.Context) DepTree {
+	ret := *self
+	ret.tree = BindContext(self.tree, ctx)
+	return &ret
+}
+
+// Query 查询审计记录 使用第一个实现AuditQueryer的sink，没有时返回ErrInvalidArgument
+func (self *AuditTree) Query(query AuditQuery) (_ []AuditRecord, err error) {
+	defer setOp("Query", &err)
+	for _, s := range self.sinks {
+		if q, ok := s.(AuditQueryer); ok {
+			return q.Query(query)
+		}
+	}
+	return nil, newError(ErrInvalidArgument, "", "no audit sink supports query")
+}
+
+// record 生成审计记录并写入sink 操作成功时通过after查询操作后状态
+func (self *AuditTree) record(op string, mid string, target string, before *AuditState, after func() *AuditState, err error) {
+	r := AuditRecord{
+		Time:    time.Now(),
+		Actor:   self.actor,
+		Op:      op,
+		Mid:     mid,
+		Target:  target,
+		Before:  before,
+		Success: err == nil,
+	}
+	if err != nil {
+		r.Error = err.Error()
+	} else if after != nil {
+		r.After = after()
+	}
+	for _, s := range self.sinks {
+		if e := s.Write(r); e != nil {
+			log.Println("deptree: audit sink:", e)
+		}
+	}
+}
+
+// orgState 组织节点状态 不存在时为nil
+func (self *AuditTree) orgState(mid string, id string) *AuditState {
+	node, err := self.tree.GetOrgNode(mid, id)
+	if err != nil || node == nil {
+		return nil
+	}
+	return &AuditState{Org: node}
+}
+
+// treeState 子树状态 不存在时为nil
+func (self *AuditTree) treeState(mid string, id string) *AuditState {
+	tree, err := self.tree.GetSubTree(mid, id)
+	if err != nil || tree == nil {
+		return nil
+	}
+	return &AuditState{Tree: tree}
+}
+
+// leafState 叶子状态 不存在时为nil
+func (self *AuditTree) leafState(mid string, pid string, uid string) *AuditState {
+	leafs, err := self.tree.GetLeafNodes(mid, pid, uid)
+	if err != nil {
+		return nil
+	}
+	for i := range leafs {
+		if leafs[i].Pid == pid && leafs[i].Uid == uid {
+			return &AuditState{Leaf: &leafs[i]}
+		}
+	}
+	return nil
+}
+
+// uidState uid在商户内未归档组织节点下的全部叶子 不存在时为nil
+// 接口无法读取已归档子树下的叶子，RenameLeafNode对这些叶子的修改不在记录的状态中
+func (self *AuditTree) uidState(mid string, uid string) *AuditState {
+	leafs, err := self.tree.GetLeafNodes(mid, mid, uid)
+	if err != nil || len(leafs) == 0 {
+		return nil
+	}
+	return &AuditState{Leafs: leafs}
+}
+
+// positionState 岗位状态 不存在时为nil
+func (self *AuditTree) positionState(mid string, id string) *AuditState {
+	pos, err := self.tree.GetPosition(mid, id)
+	if err != nil || pos == nil {
+		return nil
+	}
+	return &AuditState{Position: pos}
+}
+
+// leafTarget 叶子的操作对象标识
+func leafTarget(pid string, uid string) string {
+	return pid + "/" + uid
+}
+
+// AddOrgNode 新增组织节点
+func (self *AuditTree) AddOrgNode(node OrgNode) (string, error) {
+	id, err := self.tree.AddOrgNode(node)
+	target := id
+	if err != nil {
+		target = node.Id
+	}
+	self.record("AddOrgNode", node.Mid, target, nil, func() *AuditState {
+		return self.orgState(node.Mid, id)
+	}, err)
+	return id, err
+}
+
+// ModifyOrgNode 修改组织节点
+func (self *AuditTree) ModifyOrgNode(node OrgNode) error {
+	before := self.orgState(node.Mid, node.Id)
+	err := self.tree.ModifyOrgNode(node)
+	self.record("ModifyOrgNode", node.Mid, node.Id, before, func() *AuditState {
+		return self.orgState(node.Mid, node.Id)
+	}, err)
+	return err
+}
+
+// DelOrgNode 删除组织节点 记录删除前的整棵子树
+func (self *AuditTree) DelOrgNode(mid string, id string) error {
+	before := self.treeState(mid, id)
+	err := self.tree.DelOrgNode(mid, id)
+	self.record("DelOrgNode", mid, id, before, nil, err)
+	return err
+}
+
+// ArchiveOrgNode 归档组织节点 记录归档前的整棵子树
+func (self *AuditTree) ArchiveOrgNode(mid string, id string) error {
+	before := self.treeState(mid, id)
+	err := self.tree.ArchiveOrgNode(mid, id)
+	self.record("ArchiveOrgNode", mid, id, before, nil, err)
+	return err
+}
+
+// RestoreOrgNode 恢复归档的组织节点 记录恢复后的整棵子树
+func (self *AuditTree) RestoreOrgNode(mid string, id string) error {
+	err := self.tree.RestoreOrgNode(mid, id)
+	self.record("RestoreOrgNode", mid, id, nil, func() *AuditState {
+		return self.treeState(mid, id)
+	}, err)
+	return err
+}
+
+// GetArchivedOrgNodes 取已归档的子树根节点
+func (self *AuditTree) GetArchivedOrgNodes(mid string) ([]ArchivedOrg, error) {
+	return self.tree.GetArchivedOrgNodes(mid)
+}
+
+// PurgeArchivedOrgNodes 清除超过保留期的归档子树 Target为被删除的子树根节点ID(逗号分隔)
+func (self *AuditTree) PurgeArchivedOrgNodes(mid string) ([]string, error) {
+	ids, err := self.tree.PurgeArchivedOrgNodes(mid)
+	self.record("PurgeArchivedOrgNodes", mid, strings.Join(ids, ","), nil, nil, err)
+	return ids, err
+}
+
+// MoveOrgNode 移动组织节点
+func (self *AuditTree) MoveOrgNode(mid string, id string, newPid string) error {
+	before := self.orgState(mid, id)
+	err := self.tree.MoveOrgNode(mid, id, newPid)
+	self.record("MoveOrgNode", mid, id, before, func() *AuditState {
+		return self.orgState(mid, id)
+	}, err)
+	return err
+}
+
+// MergeOrgNodes 合并组织节点 Target为源节点，记录合并前的源子树及合并后的目标子树，DryRun不记录
+func (self *AuditTree) MergeOrgNodes(mid string, sourceId string, targetId string, opts MergeOptions) (*MergeReport, error) {
+	if opts.DryRun {
+		return self.tree.MergeOrgNodes(mid, sourceId, targetId, opts)
+	}
+	before := self.treeState(mid, sourceId)
+	report, err := self.tree.MergeOrgNodes(mid, sourceId, targetId, opts)
+	self.record("MergeOrgNodes", mid, sourceId, before, func() *AuditState {
+		return self.treeState(mid, targetId)
+	}, err)
+	return report, err
+}
+
+// ReorderOrgNode 调整组织节点同级顺序
+func (self *AuditTree) ReorderOrgNode(mid string, id string, place Placement) error {
+	before := self.orgState(mid, id)
+	err := self.tree.ReorderOrgNode(mid, id, place)
+	self.record("ReorderOrgNode", mid, id, before, func() *AuditState {
+		return self.orgState(mid, id)
+	}, err)
+	return err
+}
+
+// AddLeafNode 新增叶子节点
+func (self *AuditTree) AddLeafNode(leaf LeafNode) error {
+	err := self.tree.AddLeafNode(leaf)
+	self.record("AddLeafNode", leaf.Mid, leafTarget(leaf.Pid, leaf.Uid), nil, func() *AuditState {
+		return self.leafState(leaf.Mid, leaf.Pid, leaf.Uid)
+	}, err)
+	return err
+}
+
+// ModifyLeafNode 修改叶子节点
+func (self *AuditTree) ModifyLeafNode(leaf LeafNode) error {
+	before := self.leafState(leaf.Mid, leaf.Pid, leaf.Uid)
+	err := self.tree.ModifyLeafNode(leaf)
+	self.record("ModifyLeafNode", leaf.Mid, leafTarget(leaf.Pid, leaf.Uid), before, func() *AuditState {
+		return self.leafState(leaf.Mid, leaf.Pid, leaf.Uid)
+	}, err)
+	return err
+}
+
+// DelLeafNode 删除叶子节点
+func (self *AuditTree) DelLeafNode(mid string, pid string, uid string) error {
+	before := self.leafState(mid, pid, uid)
+	err := self.tree.DelLeafNode(mid, pid, uid)
+	self.record("DelLeafNode", mid, leafTarget(pid, uid), before, nil, err)
+	return err
+}
+
+// MoveLeafNode 调动叶子节点 Target为原位置
+func (self *AuditTree) MoveLeafNode(mid string, uid string, fromPid string, toPid string) error {
+	before := self.leafState(mid, fromPid, uid)
+	err := self.tree.MoveLeafNode(mid, uid, fromPid, toPid)
+	self.record("MoveLeafNode", mid, leafTarget(fromPid, uid), before, func() *AuditState {
+		return self.leafState(mid, toPid, uid)
+	}, err)
+	return err
+}
+
+// RenameLeafNode 修改叶子uid Target为原uid 前后状态不包含已归档子树下的叶子
+func (self *AuditTree) RenameLeafNode(mid string, oldUid string, newUid string) error {
+	before := self.uidState(mid, oldUid)
+	err := self.tree.RenameLeafNode(mid, oldUid, newUid)
+	self.record("RenameLeafNode", mid, oldUid, before, func() *AuditState {
+		return self.uidState(mid, newUid)
+	}, err)
+	return err
+}
+
+// ReorderLeafNode 调整叶子同级顺序
+func (self *AuditTree) ReorderLeafNode(mid string, pid string, uid string, place Placement) error {
+	before := self.leafState(mid, pid, uid)
+	err := self.tree.ReorderLeafNode(mid, pid, uid, place)
+	self.record("ReorderLeafNode", mid, leafTarget(pid, uid), before, func() *AuditState {
+		return self.leafState(mid, pid, uid)
+	}, err)
+	return err
+}
+
+// GetLeafNodes 根据mid，pid, uid取叶子节点信息
+func (self *AuditTree) GetLeafNodes(mid string, pid string, uid string) ([]LeafNode, error) {
+	return self.tree.GetLeafNodes(mid, pid, uid)
+}
+
+// GetLeafNodesByOrg 根据组织节点，取所有叶子节点信息
+func (self *AuditTree) GetLeafNodesByOrg(mid string, pid string) ([]LeafNode, error) {
+	return self.tree.GetLeafNodesByOrg(mid, pid)
+}
+
+// GetOrgNode 取组织节点信息
+func (self *AuditTree) GetOrgNode(mid string, id string) (*OrgNode, error) {
+	return self.tree.GetOrgNode(mid, id)
+}
+
+// GetOrgNodesByOrg 取组织节点下的节点
+func (self *AuditTree) GetOrgNodesByOrg(mid string, pid string, dept int) ([]OrgNode, error) {
+	return self.tree.GetOrgNodesByOrg(mid, pid, dept)
+}
+
+// GetSubTree 取树形结构
+func (self *AuditTree) GetSubTree(mid string, id string) (*OrgTree, error) {
+	return self.tree.GetSubTree(mid, id)
+}
+
+// GetUsersByPosition 根据岗位查询叶子节点
+func (self *AuditTree) GetUsersByPosition(mid string, pid string, positionid string) ([]LeafNode, error) {
+	return self.tree.GetUsersByPosition(mid, pid, positionid)
+}
+
+// GetParents 取全部父节点
+func (self *AuditTree) GetParents(mid string, id string) ([]OrgNode, error) {
+	return self.tree.GetParents(mid, id)
+}
+
+// GetStatistics 子树统计
+func (self *AuditTree) GetStatistics(mid string, id string) (*OrgStats, error) {
+	return self.tree.GetStatistics(mid, id)
+}
+
+// GetMemberships 取uid的全部叶子节点及父节点路径
+func (self *AuditTree) GetMemberships(mid string, uid string) ([]Membership, error) {
+	return self.tree.GetMemberships(mid, uid)
+}
+
+// GetLeafNodesByOrgPaged 分页取组织节点下的叶子节点
+func (self *AuditTree) GetLeafNodesByOrgPaged(mid string, pid string, pageSize int, cursor string) ([]LeafNode, string, error) {
+	return self.tree.GetLeafNodesByOrgPaged(mid, pid, pageSize, cursor)
+}
+
+// GetOrgNodesByOrgPaged 分页取组织节点下的节点
+func (self *AuditTree) GetOrgNodesByOrgPaged(mid string, pid string, dept int, pageSize int, cursor string) ([]OrgNode, string, error) {
+	return self.tree.GetOrgNodesByOrgPaged(mid, pid, dept, pageSize, cursor)
+}
+
+// GetUsersByPositionPaged 分页根据岗位查询叶子节点
+func (self *AuditTree) GetUsersByPositionPaged(mid string, pid string, positionid string, pageSize int, cursor string) ([]LeafNode, string, error) {
+	return self.tree.GetUsersByPositionPaged(mid, pid, positionid, pageSize, cursor)
+}
+
+// Search 搜索组织节点或叶子节点
+func (self *AuditTree) Search(mid string, query SearchQuery) (*SearchResult, error) {
+	return self.tree.Search(mid, query)
+}
+
+// AddPosition 新增岗位定义
+func (self *AuditTree) AddPosition(pos Position) (string, error) {
+	id, err := self.tree.AddPosition(pos)
+	target := id
+	if err != nil {
+		target = pos.Id
+	}
+	self.record("AddPosition", pos.Mid, target, nil, func() *AuditState {
+		return self.positionState(pos.Mid, id)
+	}, err)
+	return id, err
+}
+
+// ModifyPosition 修改岗位定义
+func (self *AuditTree) ModifyPosition(pos Position) error {
+	before := self.positionState(pos.Mid, pos.Id)
+	err := self.tree.ModifyPosition(pos)
+	self.record("ModifyPosition", pos.Mid, pos.Id, before, func() *AuditState {
+		return self.positionState(pos.Mid, pos.Id)
+	}, err)
+	return err
+}
+
+// RenamePosition 修改岗位ID Target为原ID
+func (self *AuditTree) RenamePosition(mid string, oldId string, newId string) error {
+	before := self.positionState(mid, oldId)
+	err := self.tree.RenamePosition(mid, oldId, newId)
+	self.record("RenamePosition", mid, oldId, before, func() *AuditState {
+		return self.positionState(mid, newId)
+	}, err)
+	return err
+}
+
+// DelPosition 删除岗位定义
+func (self *AuditTree) DelPosition(mid string, id string) error {
+	before := self.positionState(mid, id)
+	err := self.tree.DelPosition(mid, id)
+	self.record("DelPosition", mid, id, before, nil, err)
+	return err
+}
+
+// GetPosition 取岗位定义
+func (self *AuditTree) GetPosition(mid string, id string) (*Position, error) {
+	return self.tree.GetPosition(mid, id)
+}
+
+// GetPositions 取商户的全部岗位定义
+func (self *AuditTree) GetPositions(mid string) ([]Position, error) {
+	return self.tree.GetPositions(mid)
+}
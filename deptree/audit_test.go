@@ -0,0 +1,188 @@
+package deptree
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lastRecord 最后一条审计记录 并检查操作名及操作人
+func lastRecord(t *testing.T, sink *recordSink, op string, actor string) AuditRecord {
+	t.Helper()
+	if len(sink.records) == 0 {
+		t.Fatalf("no record for %s", op)
+	}
+	r := sink.records[len(sink.records)-1]
+	if r.Op != op || r.Actor != actor || r.Mid != "m" {
+		t.Fatalf("record = %s by %q in %s, want %s by %q in m", r.Op, r.Actor, r.Mid, op, actor)
+	}
+	return r
+}
+
+func TestAuditTreeStates(t *testing.T) {
+	sink := &recordSink{}
+	audit := NewAuditTree(newMemDepTree(map[string]interface{}{}), sink)
+	tree := audit.As("alice")
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := tree.AddOrgNode(OrgNode{Mid: "m", Name: "top"})
+	must(err)
+	r := lastRecord(t, sink, "AddOrgNode", "alice")
+	if r.Target != "m" || r.Before != nil || r.After == nil || r.After.Org.Name != "top" || !r.Success {
+		t.Errorf("AddOrgNode record = %+v", r)
+	}
+	_, err = tree.AddOrgNode(OrgNode{Mid: "m", Pid: "m", Id: "a", Name: "a"})
+	must(err)
+	_, err = tree.AddOrgNode(OrgNode{Mid: "m", Pid: "m", Id: "b", Name: "b"})
+	must(err)
+
+	must(tree.ModifyOrgNode(OrgNode{Mid: "m", Id: "a", Name: "sales"}))
+	r = lastRecord(t, sink, "ModifyOrgNode", "alice")
+	if r.Target != "a" || r.Before.Org.Name != "a" || r.After.Org.Name != "sales" {
+		t.Errorf("ModifyOrgNode record = %+v", r)
+	}
+	must(tree.MoveOrgNode("m", "a", "b"))
+	r = lastRecord(t, sink, "MoveOrgNode", "alice")
+	if r.Before.Org.Pid != "m" || r.After.Org.Pid != "b" {
+		t.Errorf("MoveOrgNode pid %s -> %s", r.Before.Org.Pid, r.After.Org.Pid)
+	}
+
+	must(tree.AddLeafNode(LeafNode{Mid: "m", Pid: "a", Uid: "u", Attrs: map[string][]string{"k": {"1"}}}))
+	r = lastRecord(t, sink, "AddLeafNode", "alice")
+	if r.Target != "a/u" || r.After.Leaf == nil || r.After.Leaf.Uid != "u" {
+		t.Errorf("AddLeafNode record = %+v", r)
+	}
+	must(tree.ModifyLeafNode(LeafNode{Mid: "m", Pid: "a", Uid: "u", Attrs: map[string][]string{"k": {"2"}}}))
+	r = lastRecord(t, sink, "ModifyLeafNode", "alice")
+	if r.Before.Leaf.Attrs["k"][0] != "1" || r.After.Leaf.Attrs["k"][0] != "2" {
+		t.Errorf("ModifyLeafNode attrs %v -> %v", r.Before.Leaf.Attrs, r.After.Leaf.Attrs)
+	}
+	must(tree.MoveLeafNode("m", "u", "a", "b"))
+	r = lastRecord(t, sink, "MoveLeafNode", "alice")
+	if r.Target != "a/u" || r.Before.Leaf.Pid != "a" || r.After.Leaf.Pid != "b" {
+		t.Errorf("MoveLeafNode record = %+v", r)
+	}
+	must(tree.DelLeafNode("m", "b", "u"))
+	r = lastRecord(t, sink, "DelLeafNode", "alice")
+	if r.Target != "b/u" || r.Before.Leaf == nil || r.After != nil {
+		t.Errorf("DelLeafNode record = %+v", r)
+	}
+	must(tree.DelOrgNode("m", "b"))
+	r = lastRecord(t, sink, "DelOrgNode", "alice")
+	if r.Before.Tree == nil || len(r.Before.Tree.SubTrees) != 1 || r.Before.Tree.SubTrees[0].Id != "a" || r.After != nil {
+		t.Errorf("DelOrgNode record = %+v", r)
+	}
+
+	// 失败的操作Success为false 有Error 无操作后状态；原对象的操作人为空
+	n := len(sink.records)
+	if err = audit.ModifyOrgNode(OrgNode{Mid: "m", Id: "a", Name: "x"}); err == nil {
+		t.Fatal("modified a deleted node")
+	}
+	r = lastRecord(t, sink, "ModifyOrgNode", "")
+	if len(sink.records) != n+1 || r.Success || r.Error != err.Error() || r.Before != nil || r.After != nil {
+		t.Errorf("failed ModifyOrgNode record = %+v", r)
+	}
+	if err = tree.MoveLeafNode("m", "u", "m", "none"); err == nil {
+		t.Fatal("moved a missing leaf")
+	}
+	if r = lastRecord(t, sink, "MoveLeafNode", "alice"); r.Success || r.Error == "" || r.After != nil {
+		t.Errorf("failed MoveLeafNode record = %+v", r)
+	}
+}
+
+// RenameLeafNode的前后状态不包含已归档子树下的叶子
+func TestAuditRenameSkipsArchived(t *testing.T) {
+	sink := &recordSink{}
+	mem := newMemDepTree(map[string]interface{}{})
+	tree := NewAuditTree(mem, sink)
+	seedTree(t, mem, "m", [2]string{"a", "m"}, [2]string{"b", "m"})
+	for _, pid := range []string{"a", "b"} {
+		if err := mem.AddLeafNode(LeafNode{Mid: "m", Pid: pid, Uid: "u"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mem.ArchiveOrgNode("m", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.RenameLeafNode("m", "u", "v"); err != nil {
+		t.Fatal(err)
+	}
+	r := lastRecord(t, sink, "RenameLeafNode", "")
+	if len(r.Before.Leafs) != 1 || r.Before.Leafs[0].Pid != "a" || len(r.After.Leafs) != 1 || r.After.Leafs[0].Uid != "v" {
+		t.Errorf("RenameLeafNode record = %+v", r)
+	}
+}
+
+func TestAuditFileQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	write := func(file *AuditFile, from int, to int) {
+		for i := from; i < to; i++ {
+			r := AuditRecord{Time: base.Add(time.Duration(i) * time.Hour), Mid: "m", Op: "AddOrgNode",
+				Target: string(rune('a' + i)), Success: i%3 != 0}
+			if err := file.Write(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	file, err := NewAuditFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(file, 0, 5)
+	file.Close()
+	// 无法解析的行被忽略
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("not json\n")
+	f.Close()
+	file, err = NewAuditFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	write(file, 5, 10)
+
+	cases := []struct {
+		name  string
+		query AuditQuery
+		want  string
+	}{
+		{"all", AuditQuery{}, "abcdefghij"},
+		{"since", AuditQuery{Since: base.Add(7 * time.Hour)}, "hij"},
+		{"until", AuditQuery{Until: base.Add(2 * time.Hour)}, "ab"},
+		{"range", AuditQuery{Since: base.Add(3 * time.Hour), Until: base.Add(6 * time.Hour)}, "def"},
+		{"failed", AuditQuery{Failed: true}, "adgj"},
+		{"offset limit", AuditQuery{Offset: 3, Limit: 4}, "defg"},
+		{"failed offset limit", AuditQuery{Failed: true, Offset: 1, Limit: 2}, "dg"},
+		{"offset past end", AuditQuery{Offset: 10}, ""},
+		{"other mid", AuditQuery{Mid: "x"}, ""},
+		{"target", AuditQuery{Target: "f"}, "f"},
+	}
+	tree := NewAuditTree(newMemDepTree(map[string]interface{}{}), &recordSink{}, file)
+	for _, c := range cases {
+		records, err := tree.Query(c.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, r := range records {
+			got = append(got, r.Target)
+		}
+		if strings.Join(got, "") != c.want {
+			t.Errorf("%s: targets = %s, want %s", c.name, strings.Join(got, ""), c.want)
+		}
+	}
+	if _, err := NewAuditTree(newMemDepTree(map[string]interface{}{}), &recordSink{}).Query(AuditQuery{}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("Query without a queryable sink err = %v, want ErrInvalidArgument", err)
+	}
+}
@@ -0,0 +1,83 @@
+package deptree
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// AuditFile 以JSON Lines格式追加写入文件的AuditSink，支持Query
+// 查询时顺序扫描整个文件，适合单机及中小规模的记录量，文件的切分归档由运维工具处理
+type AuditFile struct {
+	path string
+	lock sync.Mutex
+	file *os.File
+}
+
+// NewAuditFile 以追加方式打开(不存在时创建)审计文件
+func NewAuditFile(path string) (*AuditFile, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditFile{path: path, file: f}, nil
+}
+
+// Write 追加一条记录
+func (self *AuditFile) Write(record AuditRecord) error {
+	buff, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	_, err = self.file.Write(append(buff, '\n'))
+	return err
+}
+
+// Query 按条件查询文件中的记录 无法解析的行被忽略
+func (self *AuditFile) Query(query AuditQuery) ([]AuditRecord, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	f, err := os.Open(self.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ret := []AuditRecord{}
+	skipped := 0
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			r := AuditRecord{}
+			if json.Unmarshal(line, &r) == nil && query.match(&r) {
+				if skipped < query.Offset {
+					skipped++
+				} else {
+					ret = append(ret, r)
+				}
+			}
+		}
+		if query.Limit > 0 && len(ret) >= query.Limit {
+			break
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// Close 关闭文件
+func (self *AuditFile) Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.file.Close()
+}
@@ -0,0 +1,51 @@
+// Package auditlog 将deptree审计记录输出到logger模块
+// 独立为子包，避免deptree本身依赖logger及logrus
+package auditlog
+
+import (
+	"encoding/json"
+
+	"saas/common/core/deptree"
+	"saas/common/core/logger"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Sink 输出到logger模块的deptree.AuditSink，不支持查询
+// 成功的操作以info级别、失败的操作以warning级别输出，前后状态以JSON字符串放在Before After字段中
+type Sink struct {
+	log logger.Logger
+}
+
+// NewSink 使用logger.GetLogger(module)输出 module为空时使用主日志
+func NewSink(module string) *Sink {
+	return &Sink{log: logger.GetLogger(module)}
+}
+
+// Write 输出一条审计记录
+func (self *Sink) Write(record deptree.AuditRecord) error {
+	before, err := json.Marshal(record.Before)
+	if err != nil {
+		return err
+	}
+	after, err := json.Marshal(record.After)
+	if err != nil {
+		return err
+	}
+	entry := self.log.WithFields(logrus.Fields{
+		"AuditTime": record.Time,
+		"Actor":     record.Actor,
+		"Op":        record.Op,
+		"Mid":       record.Mid,
+		"Target":    record.Target,
+		"Before":    string(before),
+		"After":     string(after),
+		"Success":   record.Success,
+	})
+	if record.Success {
+		entry.Info("deptree audit")
+	} else {
+		entry.WithField("Error", record.Error).Warn("deptree audit")
+	}
+	return nil
+}
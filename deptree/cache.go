@@ -0,0 +1,531 @@
+package deptree
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 缓存类别
+const (
+	CACHE_SUBTREE  = "SubTree"  // GetSubTree
+	CACHE_PARENTS  = "Parents"  // GetParents
+	CACHE_POSITION = "Position" // GetUsersByPosition
+	CACHE_LEAF     = "Leaf"     // GetLeafNodes GetLeafNodesByOrg GetMemberships
+	CACHE_ORG      = "Org"      // GetOrgNode GetOrgNodesByOrg
+	CACHE_STATS    = "Stats"    // GetStatistics
+)
+
+// 叶子节点变更时需失效的缓存类别，组织节点变更时失效该商户的全部缓存
+var leafCacheKinds = []string{CACHE_SUBTREE, CACHE_POSITION, CACHE_LEAF, CACHE_STATS}
+
+// CacheTree 带缓存的DepTree装饰器，通过NewCacheTree获得
+// 按商户缓存查询结果，经由本对象的修改操作会使该商户受影响的缓存失效
+// 绕过本对象直接修改后端时需调用Invalidate
+type CacheTree struct {
+	tree   DepTree
+	ttls   map[string]time.Duration
+	lock   *sync.Mutex                       // 与BindContext得到的副本共享
+	caches map[string]map[string]*cacheEntry // mid -> key -> 缓存
+	gens   map[string]uint64                 // mid -> 失效次数，防止失效前发起的查询写入旧数据
+	hits   map[string]*uint64
+	misses map[string]*uint64
+
+	size       *cacheSize    // 与副本共享
+	maxEntries int           // 缓存项上限 0为不限
+	sweepEvery time.Duration // 清理过期项的间隔
+}
+
+// cacheSize 缓存项计数 需持有lock
+type cacheSize struct {
+	entries int       // 缓存项数量(含未清理的过期项)
+	swept   time.Time // 上次清理时间
+}
+
+// cacheEntry 缓存项
+type cacheEntry struct {
+	kind    string
+	value   interface{}
+	expires time.Time
+}
+
+// CacheStats 缓存统计
+type CacheStats struct {
+	Hits    uint64               // 命中次数
+	Misses  uint64               // 未命中次数
+	Entries int                  // 当前未过期的缓存项数量
+	Kinds   map[string][2]uint64 // 类别 -> [命中, 未命中]
+}
+
+// NewCacheTree 包装任意DepTree实现
+// config中TTL为默认缓存时间(秒) 默认60
+// SubTreeTTL ParentsTTL PositionTTL LeafTTL OrgTTL StatsTTL 为各类别缓存时间(秒) 默认使用TTL
+// MaxEntries 缓存项上限 默认10000 0为不限，达到上限时先清理过期项，仍不足时淘汰最早过期的项
+// SweepInterval 写入缓存时清理过期项的间隔(秒) 默认60
+func NewCacheTree(tree DepTree, config map[string]interface{}) *CacheTree {
+	ttl := configInt(config, "TTL", 60)
+	ret := &CacheTree{
+		tree:       tree,
+		ttls:       map[string]time.Duration{},
+		lock:       &sync.Mutex{},
+		caches:     map[string]map[string]*cacheEntry{},
+		gens:       map[string]uint64{},
+		hits:       map[string]*uint64{},
+		misses:     map[string]*uint64{},
+		maxEntries: configInt(config, "MaxEntries", 10000),
+		sweepEvery: time.Duration(configInt(config, "SweepInterval", 60)) * time.Second,
+		size:       &cacheSize{swept: time.Now()},
+	}
+	for _, kind := range []string{CACHE_SUBTREE, CACHE_PARENTS, CACHE_POSITION, CACHE_LEAF, CACHE_ORG, CACHE_STATS} {
+		ret.ttls[kind] = time.Duration(configInt(config, kind+"TTL", ttl)) * time.Second
+		ret.hits[kind] = new(uint64)
+		ret.misses[kind] = new(uint64)
+	}
+	return ret
+}
+
+// withContext 返回在ctx下访问后端的副本 与原对象共享缓存
+func (self *CacheTree) withContext(ctx context.Context) DepTree {
+	ret := *self
+	ret.tree = BindContext(self.tree, ctx)
+	return &ret
+}
+
+// Stats 取缓存命中统计
+func (self *CacheTree) Stats() CacheStats {
+	stats := CacheStats{Kinds: map[string][2]uint64{}}
+	for kind := range self.ttls {
+		h := atomic.LoadUint64(self.hits[kind])
+		m := atomic.LoadUint64(self.misses[kind])
+		stats.Hits += h
+		stats.Misses += m
+		stats.Kinds[kind] = [2]uint64{h, m}
+	}
+	now := time.Now()
+	self.lock.Lock()
+	for _, c := range self.caches {
+		for _, e := range c {
+			if now.Before(e.expires) {
+				stats.Entries++
+			}
+		}
+	}
+	self.lock.Unlock()
+	return stats
+}
+
+// Invalidate 使商户的缓存失效 kinds为空时失效全部类别
+func (self *CacheTree) Invalidate(mid string, kinds ...string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.gens[mid]++
+	if len(kinds) == 0 {
+		self.size.entries -= len(self.caches[mid])
+		delete(self.caches, mid)
+		return
+	}
+	c := self.caches[mid]
+	for key, e := range c {
+		for _, kind := range kinds {
+			if e.kind == kind {
+				self.remove(mid, key)
+				break
+			}
+		}
+	}
+}
+
+// remove 删除缓存项 需持有lock
+func (self *CacheTree) remove(mid string, key string) {
+	c := self.caches[mid]
+	if _, ok := c[key]; !ok {
+		return
+	}
+	delete(c, key)
+	self.size.entries--
+	if len(c) == 0 {
+		delete(self.caches, mid)
+	}
+}
+
+// sweep 清理过期项，超过上限时再按过期时间淘汰至上限的90% 需持有lock
+func (self *CacheTree) sweep(now time.Time) {
+	self.size.swept = now
+	for mid, c := range self.caches {
+		for key, e := range c {
+			if !now.Before(e.expires) {
+				self.remove(mid, key)
+			}
+		}
+	}
+	if self.maxEntries <= 0 || self.size.entries < self.maxEntries {
+		return
+	}
+	type item struct {
+		mid, key string
+		expires  time.Time
+	}
+	items := make([]item, 0, self.size.entries)
+	for mid, c := range self.caches {
+		for key, e := range c {
+			items = append(items, item{mid, key, e.expires})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].expires.Before(items[j].expires)
+	})
+	for _, it := range items {
+		if self.size.entries < self.maxEntries*9/10 {
+			break
+		}
+		self.remove(it.mid, it.key)
+	}
+}
+
+// load 读取缓存，未命中或已过期时调用f并缓存结果 错误不缓存
+func (self *CacheTree) load(kind string, mid string, args []string, f func() (interface{}, error)) (interface{}, error) {
+	key := kind + "\x00" + strings.Join(args, "\x00")
+	now := time.Now()
+	self.lock.Lock()
+	if e, ok := self.caches[mid][key]; ok {
+		if now.Before(e.expires) {
+			self.lock.Unlock()
+			atomic.AddUint64(self.hits[kind], 1)
+			return e.value, nil
+		}
+		self.remove(mid, key)
+	}
+	gen := self.gens[mid]
+	self.lock.Unlock()
+	atomic.AddUint64(self.misses[kind], 1)
+
+	value, err := f()
+	if err != nil {
+		return nil, err
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if gen != self.gens[mid] {
+		return value, nil
+	}
+	if now.Sub(self.size.swept) >= self.sweepEvery || self.maxEntries > 0 && self.size.entries >= self.maxEntries {
+		self.sweep(now)
+	}
+	c, ok := self.caches[mid]
+	if !ok {
+		c = map[string]*cacheEntry{}
+		self.caches[mid] = c
+	}
+	if _, ok := c[key]; !ok {
+		self.size.entries++
+	}
+	c[key] = &cacheEntry{kind: kind, value: value, expires: now.Add(self.ttls[kind])}
+	return value, nil
+}
+
+// copyLeafs 复制叶子列表，避免调用方修改缓存内容
+func copyLeafs(leafs []LeafNode) []LeafNode {
+	if leafs == nil {
+		return nil
+	}
+	ret := make([]LeafNode, len(leafs))
+	for i := range leafs {
+		ret[i] = copyLeaf(&leafs[i])
+	}
+	return ret
+}
+
+// copyOrgs 复制组织节点列表
+func copyOrgs(nodes []OrgNode) []OrgNode {
+	if nodes == nil {
+		return nil
+	}
+	ret := make([]OrgNode, len(nodes))
+	for i := range nodes {
+		ret[i] = copyOrg(&nodes[i])
+	}
+	return ret
+}
+
+// copyTree 深度复制树
+func copyTree(tree OrgTree) OrgTree {
+	ret := OrgTree{
+		OrgNode:  copyOrg(&tree.OrgNode),
+		SubTrees: make([]OrgTree, len(tree.SubTrees)),
+		SubLeafs: copyLeafs(tree.SubLeafs),
+	}
+	for i, t := range tree.SubTrees {
+		ret.SubTrees[i] = copyTree(t)
+	}
+	return ret
+}
+
+// AddOrgNode 新增组织节点 失效该商户全部缓存
+func (self *CacheTree) AddOrgNode(node OrgNode) (string, error) {
+	defer self.Invalidate(node.Mid)
+	return self.tree.AddOrgNode(node)
+}
+
+// ModifyOrgNode 修改组织节点 失效该商户全部缓存
+func (self *CacheTree) ModifyOrgNode(node OrgNode) error {
+	defer self.Invalidate(node.Mid)
+	return self.tree.ModifyOrgNode(node)
+}
+
+// DelOrgNode 删除组织节点 失效该商户全部缓存
+func (self *CacheTree) DelOrgNode(mid string, id string) error {
+	defer self.Invalidate(mid)
+	return self.tree.DelOrgNode(mid, id)
+}
+
+// ArchiveOrgNode 归档组织节点 失效该商户全部缓存
+func (self *CacheTree) ArchiveOrgNode(mid string, id string) error {
+	defer self.Invalidate(mid)
+	return self.tree.ArchiveOrgNode(mid, id)
+}
+
+// RestoreOrgNode 恢复归档的组织节点 失效该商户全部缓存
+func (self *CacheTree) RestoreOrgNode(mid string, id string) error {
+	defer self.Invalidate(mid)
+	return self.tree.RestoreOrgNode(mid, id)
+}
+
+// GetArchivedOrgNodes 取已归档的子树根节点 不缓存
+func (self *CacheTree) GetArchivedOrgNodes(mid string) ([]ArchivedOrg, error) {
+	return self.tree.GetArchivedOrgNodes(mid)
+}
+
+// PurgeArchivedOrgNodes 清除超过保留期的归档子树 归档数据不在缓存中，无需失效
+func (self *CacheTree) PurgeArchivedOrgNodes(mid string) ([]string, error) {
+	return self.tree.PurgeArchivedOrgNodes(mid)
+}
+
+// MoveOrgNode 移动组织节点 失效该商户全部缓存
+func (self *CacheTree) MoveOrgNode(mid string, id string, newPid string) error {
+	defer self.Invalidate(mid)
+	return self.tree.MoveOrgNode(mid, id, newPid)
+}
+
+// MergeOrgNodes 合并组织节点 执行时失效该商户全部缓存
+func (self *CacheTree) MergeOrgNodes(mid string, sourceId string, targetId string, opts MergeOptions) (*MergeReport, error) {
+	if !opts.DryRun {
+		defer self.Invalidate(mid)
+	}
+	return self.tree.MergeOrgNodes(mid, sourceId, targetId, opts)
+}
+
+// ReorderOrgNode 调整组织节点同级顺序 失效该商户全部缓存
+func (self *CacheTree) ReorderOrgNode(mid string, id string, place Placement) error {
+	defer self.Invalidate(mid)
+	return self.tree.ReorderOrgNode(mid, id, place)
+}
+
+// AddLeafNode 新增叶子节点 失效该商户叶子相关缓存
+func (self *CacheTree) AddLeafNode(leaf LeafNode) error {
+	defer self.Invalidate(leaf.Mid, leafCacheKinds...)
+	return self.tree.AddLeafNode(leaf)
+}
+
+// ModifyLeafNode 修改叶子节点 失效该商户叶子相关缓存
+func (self *CacheTree) ModifyLeafNode(leaf LeafNode) error {
+	defer self.Invalidate(leaf.Mid, leafCacheKinds...)
+	return self.tree.ModifyLeafNode(leaf)
+}
+
+// DelLeafNode 删除叶子节点 失效该商户叶子相关缓存
+func (self *CacheTree) DelLeafNode(mid string, pid string, uid string) error {
+	defer self.Invalidate(mid, leafCacheKinds...)
+	return self.tree.DelLeafNode(mid, pid, uid)
+}
+
+// MoveLeafNode 调动叶子节点 失效该商户叶子相关缓存
+func (self *CacheTree) MoveLeafNode(mid string, uid string, fromPid string, toPid string) error {
+	defer self.Invalidate(mid, leafCacheKinds...)
+	return self.tree.MoveLeafNode(mid, uid, fromPid, toPid)
+}
+
+// RenameLeafNode 修改叶子uid 失效该商户叶子相关缓存
+func (self *CacheTree) RenameLeafNode(mid string, oldUid string, newUid string) error {
+	defer self.Invalidate(mid, leafCacheKinds...)
+	return self.tree.RenameLeafNode(mid, oldUid, newUid)
+}
+
+// ReorderLeafNode 调整叶子同级顺序 失效该商户叶子相关缓存
+func (self *CacheTree) ReorderLeafNode(mid string, pid string, uid string, place Placement) error {
+	defer self.Invalidate(mid, leafCacheKinds...)
+	return self.tree.ReorderLeafNode(mid, pid, uid, place)
+}
+
+// GetLeafNodes 根据mid，pid, uid取叶子节点信息
+func (self *CacheTree) GetLeafNodes(mid string, pid string, uid string) ([]LeafNode, error) {
+	v, err := self.load(CACHE_LEAF, mid, []string{"uid", pid, uid}, func() (interface{}, error) {
+		return self.tree.GetLeafNodes(mid, pid, uid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyLeafs(v.([]LeafNode)), nil
+}
+
+// GetLeafNodesByOrg 根据组织节点，取所有叶子节点信息
+func (self *CacheTree) GetLeafNodesByOrg(mid string, pid string) ([]LeafNode, error) {
+	v, err := self.load(CACHE_LEAF, mid, []string{"org", pid}, func() (interface{}, error) {
+		return self.tree.GetLeafNodesByOrg(mid, pid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyLeafs(v.([]LeafNode)), nil
+}
+
+// GetOrgNode 取组织节点信息
+func (self *CacheTree) GetOrgNode(mid string, id string) (*OrgNode, error) {
+	v, err := self.load(CACHE_ORG, mid, []string{"node", id}, func() (interface{}, error) {
+		return self.tree.GetOrgNode(mid, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	node := v.(*OrgNode)
+	if node == nil {
+		return nil, nil
+	}
+	ret := copyOrg(node)
+	return &ret, nil
+}
+
+// GetOrgNodesByOrg 取组织节点下的全部节点
+func (self *CacheTree) GetOrgNodesByOrg(mid string, pid string, dept int) ([]OrgNode, error) {
+	v, err := self.load(CACHE_ORG, mid, []string{"org", pid, strconv.Itoa(dept)}, func() (interface{}, error) {
+		return self.tree.GetOrgNodesByOrg(mid, pid, dept)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyOrgs(v.([]OrgNode)), nil
+}
+
+// GetSubTree 取树形结构
+func (self *CacheTree) GetSubTree(mid string, id string) (*OrgTree, error) {
+	v, err := self.load(CACHE_SUBTREE, mid, []string{id}, func() (interface{}, error) {
+		return self.tree.GetSubTree(mid, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	tree := v.(*OrgTree)
+	if tree == nil {
+		return nil, nil
+	}
+	ret := copyTree(*tree)
+	return &ret, nil
+}
+
+// GetStatistics 子树统计
+func (self *CacheTree) GetStatistics(mid string, id string) (*OrgStats, error) {
+	v, err := self.load(CACHE_STATS, mid, []string{id}, func() (interface{}, error) {
+		return self.tree.GetStatistics(mid, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats := v.(*OrgStats)
+	if stats == nil {
+		return nil, nil
+	}
+	return copyStats(stats), nil
+}
+
+// GetUsersByPosition 根据岗位查询UID列表
+func (self *CacheTree) GetUsersByPosition(mid string, pid string, positionid string) ([]LeafNode, error) {
+	v, err := self.load(CACHE_POSITION, mid, []string{pid, positionid}, func() (interface{}, error) {
+		return self.tree.GetUsersByPosition(mid, pid, positionid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyLeafs(v.([]LeafNode)), nil
+}
+
+// GetParents 根据节点id获得全部父节点信息 从近到远
+func (self *CacheTree) GetParents(mid string, id string) ([]OrgNode, error) {
+	v, err := self.load(CACHE_PARENTS, mid, []string{id}, func() (interface{}, error) {
+		return self.tree.GetParents(mid, id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyOrgs(v.([]OrgNode)), nil
+}
+
+// GetLeafNodesByOrgPaged 分页查询不缓存
+func (self *CacheTree) GetLeafNodesByOrgPaged(mid string, pid string, pageSize int, cursor string) ([]LeafNode, string, error) {
+	return self.tree.GetLeafNodesByOrgPaged(mid, pid, pageSize, cursor)
+}
+
+// GetOrgNodesByOrgPaged 分页查询不缓存
+func (self *CacheTree) GetOrgNodesByOrgPaged(mid string, pid string, dept int, pageSize int, cursor string) ([]OrgNode, string, error) {
+	return self.tree.GetOrgNodesByOrgPaged(mid, pid, dept, pageSize, cursor)
+}
+
+// GetUsersByPositionPaged 分页查询不缓存
+func (self *CacheTree) GetUsersByPositionPaged(mid string, pid string, positionid string, pageSize int, cursor string) ([]LeafNode, string, error) {
+	return self.tree.GetUsersByPositionPaged(mid, pid, positionid, pageSize, cursor)
+}
+
+// Search 搜索不缓存
+func (self *CacheTree) Search(mid string, query SearchQuery) (*SearchResult, error) {
+	return self.tree.Search(mid, query)
+}
+
+// AddPosition 新增岗位定义
+func (self *CacheTree) AddPosition(pos Position) (string, error) {
+	return self.tree.AddPosition(pos)
+}
+
+// ModifyPosition 修改岗位定义
+func (self *CacheTree) ModifyPosition(pos Position) error {
+	return self.tree.ModifyPosition(pos)
+}
+
+// RenamePosition 修改岗位ID 失效该商户叶子相关缓存
+func (self *CacheTree) RenamePosition(mid string, oldId string, newId string) error {
+	defer self.Invalidate(mid, leafCacheKinds...)
+	return self.tree.RenamePosition(mid, oldId, newId)
+}
+
+// DelPosition 删除岗位定义 失效该商户叶子相关缓存
+func (self *CacheTree) DelPosition(mid string, id string) error {
+	defer self.Invalidate(mid, leafCacheKinds...)
+	return self.tree.DelPosition(mid, id)
+}
+
+// GetPosition 取岗位定义 不缓存
+func (self *CacheTree) GetPosition(mid string, id string) (*Position, error) {
+	return self.tree.GetPosition(mid, id)
+}
+
+// GetPositions 取商户的全部岗位定义 不缓存
+func (self *CacheTree) GetPositions(mid string) ([]Position, error) {
+	return self.tree.GetPositions(mid)
+}
+
+// GetMemberships 取uid在商户内的全部叶子节点及各自的父节点路径
+func (self *CacheTree) GetMemberships(mid string, uid string) ([]Membership, error) {
+	v, err := self.load(CACHE_LEAF, mid, []string{"member", uid}, func() (interface{}, error) {
+		return self.tree.GetMemberships(mid, uid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	memberships := v.([]Membership)
+	ret := make([]Membership, len(memberships))
+	for i, m := range memberships {
+		ret[i] = Membership{Leaf: copyLeaf(&m.Leaf), Parents: copyOrgs(m.Parents)}
+	}
+	return ret, nil
+}
@@ -0,0 +1,275 @@
+package deptree
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestCache 在内存后端上建立商户m的顶级节点及n个子节点，返回包装后的CacheTree
+func newTestCache(t *testing.T, n int, config map[string]interface{}) *CacheTree {
+	mem := newMemDepTree(nil)
+	if _, err := mem.AddOrgNode(OrgNode{Mid: "m", Name: "top"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if _, err := mem.AddOrgNode(OrgNode{Mid: "m", Pid: "m", Id: fmt.Sprintf("o%d", i), Name: fmt.Sprintf("o%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewCacheTree(mem, config)
+}
+
+// expireAll 使全部缓存项过期
+func expireAll(cache *CacheTree) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	for _, c := range cache.caches {
+		for _, e := range c {
+			e.expires = time.Now().Add(-time.Second)
+		}
+	}
+}
+
+func TestCacheExpiredEntryRemoved(t *testing.T) {
+	cache := newTestCache(t, 0, nil)
+	if _, err := cache.GetOrgNode("m", "m"); err != nil {
+		t.Fatal(err)
+	}
+	if n := cache.Stats().Entries; n != 1 {
+		t.Fatalf("Entries = %d, want 1", n)
+	}
+	expireAll(cache)
+	if n := cache.Stats().Entries; n != 0 {
+		t.Fatalf("Entries after expiry = %d, want 0", n)
+	}
+	if _, err := cache.GetOrgNode("m", "m"); err != nil {
+		t.Fatal(err)
+	}
+	stats := cache.Stats()
+	if stats.Misses != 2 || stats.Hits != 0 {
+		t.Errorf("hits/misses = %d/%d, want 0/2", stats.Hits, stats.Misses)
+	}
+	if cache.size.entries != 1 || stats.Entries != 1 {
+		t.Errorf("entries = %d (stats %d), want 1", cache.size.entries, stats.Entries)
+	}
+}
+
+func TestCacheSweepExpired(t *testing.T) {
+	cache := newTestCache(t, 3, map[string]interface{}{"SweepInterval": 0})
+	for i := 0; i < 3; i++ {
+		if _, err := cache.GetOrgNode("m", fmt.Sprintf("o%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expireAll(cache)
+	// 写入新的缓存项时清理其它已过期的项
+	if _, err := cache.GetParents("m", "o0"); err != nil {
+		t.Fatal(err)
+	}
+	if cache.size.entries != 1 {
+		t.Errorf("entries after sweep = %d, want 1", cache.size.entries)
+	}
+}
+
+func TestCacheMaxEntries(t *testing.T) {
+	cache := newTestCache(t, 50, map[string]interface{}{"MaxEntries": 10})
+	for i := 0; i < 50; i++ {
+		if _, err := cache.GetOrgNode("m", fmt.Sprintf("o%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		if cache.size.entries > 10 {
+			t.Fatalf("entries = %d after %d loads, want <= 10", cache.size.entries, i+1)
+		}
+	}
+	// 最近写入的项保留
+	if _, err := cache.GetOrgNode("m", "o49"); err != nil {
+		t.Fatal(err)
+	}
+	if hits := cache.Stats().Hits; hits != 1 {
+		t.Errorf("hits = %d, want 1", hits)
+	}
+	// 失效后计数归零
+	cache.Invalidate("m")
+	if cache.size.entries != 0 {
+		t.Errorf("entries after Invalidate = %d, want 0", cache.size.entries)
+	}
+}
+
+// cacheKinds 商户各类别的缓存项数量
+func cacheKinds(cache *CacheTree, mid string) map[string]int {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	ret := map[string]int{}
+	for _, e := range cache.caches[mid] {
+		ret[e.kind]++
+	}
+	return ret
+}
+
+// newWarmCache 商户m(o0 o1 o2，o0下有叶子u)及商户n，各类别的查询均已缓存
+func newWarmCache(t *testing.T) (*CacheTree, func()) {
+	cache := newTestCache(t, 3, nil)
+	if _, err := cache.AddOrgNode(OrgNode{Mid: "n", Name: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.AddPosition(Position{Mid: "m", Id: "p1", Name: "p1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.AddLeafNode(LeafNode{Mid: "m", Pid: "o0", Uid: "u", Positions: []string{"p1"}}); err != nil {
+		t.Fatal(err)
+	}
+	warm := func() {
+		t.Helper()
+		for _, f := range []func() error{
+			func() error { _, err := cache.GetSubTree("m", "m"); return err },
+			func() error { _, err := cache.GetParents("m", "o0"); return err },
+			func() error { _, err := cache.GetUsersByPosition("m", "m", "p1"); return err },
+			func() error { _, err := cache.GetLeafNodesByOrg("m", "m"); return err },
+			func() error { _, err := cache.GetOrgNode("m", "o1"); return err },
+			func() error { _, err := cache.GetStatistics("m", "m"); return err },
+			func() error { _, err := cache.GetOrgNode("n", "n"); return err },
+		} {
+			if err := f(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	warm()
+	return cache, warm
+}
+
+// 组织节点变更时失效该商户的全部缓存
+func TestCacheInvalidateOrgMutations(t *testing.T) {
+	cache, warm := newWarmCache(t)
+	if n := len(cacheKinds(cache, "m")); n != 6 {
+		t.Fatalf("cached kinds = %v, want all 6", cacheKinds(cache, "m"))
+	}
+	mutations := map[string]func() error{
+		"AddOrgNode": func() error {
+			_, err := cache.AddOrgNode(OrgNode{Mid: "m", Pid: "o1", Id: "o3", Name: "o3"})
+			return err
+		},
+		"MoveOrgNode":   func() error { return cache.MoveOrgNode("m", "o3", "o2") },
+		"MergeOrgNodes": func() error { _, err := cache.MergeOrgNodes("m", "o2", "o1", MergeOptions{}); return err },
+	}
+	for _, name := range []string{"AddOrgNode", "MoveOrgNode", "MergeOrgNodes"} {
+		warm()
+		if err := mutations[name](); err != nil {
+			t.Fatal(err)
+		}
+		if kinds := cacheKinds(cache, "m"); len(kinds) != 0 {
+			t.Errorf("%s: kinds left = %v", name, kinds)
+		}
+		if kinds := cacheKinds(cache, "n"); kinds[CACHE_ORG] != 1 {
+			t.Errorf("%s: other merchant kinds = %v", name, kinds)
+		}
+	}
+	// MergeOrgNodes的DryRun不失效
+	warm()
+	if _, err := cache.MergeOrgNodes("m", "o1", "o0", MergeOptions{DryRun: true}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(cacheKinds(cache, "m")); n != 6 {
+		t.Errorf("kinds after dry run = %v", cacheKinds(cache, "m"))
+	}
+}
+
+// 叶子变更时只失效leafCacheKinds
+func TestCacheInvalidateLeafMutations(t *testing.T) {
+	cache, warm := newWarmCache(t)
+	mutations := map[string]func() error{
+		"AddLeafNode": func() error { return cache.AddLeafNode(LeafNode{Mid: "m", Pid: "o1", Uid: "v"}) },
+		"ModifyLeafNode": func() error {
+			return cache.ModifyLeafNode(LeafNode{Mid: "m", Pid: "o1", Uid: "v", Positions: []string{"p1"}})
+		},
+		"MoveLeafNode": func() error { return cache.MoveLeafNode("m", "v", "o1", "o2") },
+		"DelLeafNode":  func() error { return cache.DelLeafNode("m", "o2", "v") },
+	}
+	for _, name := range []string{"AddLeafNode", "ModifyLeafNode", "MoveLeafNode", "DelLeafNode"} {
+		warm()
+		if err := mutations[name](); err != nil {
+			t.Fatal(err)
+		}
+		kinds := cacheKinds(cache, "m")
+		if len(kinds) != 2 || kinds[CACHE_PARENTS] != 1 || kinds[CACHE_ORG] != 1 {
+			t.Errorf("%s: kinds left = %v, want Parents and Org", name, kinds)
+		}
+		if kinds := cacheKinds(cache, "n"); kinds[CACHE_ORG] != 1 {
+			t.Errorf("%s: other merchant kinds = %v", name, kinds)
+		}
+	}
+}
+
+// hookTree 在GetOrgNode查询后端之后、返回之前调用hook
+type hookTree struct {
+	DepTree
+	hook func()
+}
+
+func (self *hookTree) GetOrgNode(mid string, id string) (*OrgNode, error) {
+	node, err := self.DepTree.GetOrgNode(mid, id)
+	self.hook()
+	return node, err
+}
+
+// 查询期间发生失效时，查询结果不写入缓存
+func TestCacheLoadDropsStaleResult(t *testing.T) {
+	mem := newMemDepTree(nil)
+	if _, err := mem.AddOrgNode(OrgNode{Mid: "m", Name: "top"}); err != nil {
+		t.Fatal(err)
+	}
+	hook := &hookTree{DepTree: mem}
+	cache := NewCacheTree(hook, nil)
+	hook.hook = func() { cache.Invalidate("m", CACHE_ORG) }
+	if n, err := cache.GetOrgNode("m", "m"); err != nil || n.Name != "top" {
+		t.Fatalf("GetOrgNode = %v, %v", n, err)
+	}
+	if kinds := cacheKinds(cache, "m"); len(kinds) != 0 {
+		t.Errorf("stale result cached: %v", kinds)
+	}
+	// 没有失效时正常缓存
+	hook.hook = func() {}
+	for i := 0; i < 2; i++ {
+		if _, err := cache.GetOrgNode("m", "m"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 2 {
+		t.Errorf("hits/misses = %d/%d, want 1/2", stats.Hits, stats.Misses)
+	}
+}
+
+func TestCacheStatsKinds(t *testing.T) {
+	cache := newTestCache(t, 1, nil)
+	for i := 0; i < 3; i++ {
+		if _, err := cache.GetOrgNode("m", "o0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cache.GetParents("m", "o0"); err != nil {
+		t.Fatal(err)
+	}
+	// 错误不缓存，每次都是未命中
+	for i := 0; i < 2; i++ {
+		if _, err := cache.GetSubTree("m", "none"); err == nil {
+			t.Fatal("GetSubTree of a missing node succeeded")
+		}
+	}
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 4 || stats.Entries != 2 {
+		t.Errorf("hits %d misses %d entries %d, want 2 4 2", stats.Hits, stats.Misses, stats.Entries)
+	}
+	want := map[string][2]uint64{
+		CACHE_ORG: {2, 1}, CACHE_PARENTS: {0, 1}, CACHE_SUBTREE: {0, 2},
+		CACHE_POSITION: {0, 0}, CACHE_LEAF: {0, 0}, CACHE_STATS: {0, 0},
+	}
+	for kind, v := range want {
+		if stats.Kinds[kind] != v {
+			t.Errorf("%s = %v, want %v", kind, stats.Kinds[kind], v)
+		}
+	}
+	if len(stats.Kinds) != len(want) {
+		t.Errorf("kinds = %v", stats.Kinds)
+	}
+}
@@ -0,0 +1,420 @@
+package deptree
+
+import (
+	"context"
+	"time"
+)
+
+// DepTreeContext 支持context的DepTree 各方法与DepTree中的同名方法相同，第一个参数为ctx
+// ctx取消或超过截止时间时返回ErrCanceled，errors.Is(err, context.DeadlineExceeded)可区分超时
+// ldap实现中截止时间作为建立连接、绑定及请求的超时，取消时关闭所用连接以中止进行中的操作；sql实现使用驱动的context方法；
+// memory实现仅在调用前检查ctx。ldap修改操作无事务，中途取消时已完成的步骤不回滚
+type DepTreeContext interface {
+	AddOrgNode(ctx context.Context, node OrgNode) (string, error)
+	ModifyOrgNode(ctx context.Context, node OrgNode) error
+	DelOrgNode(ctx context.Context, mid string, id string) error
+	ArchiveOrgNode(ctx context.Context, mid string, id string) error
+	RestoreOrgNode(ctx context.Context, mid string, id string) error
+	GetArchivedOrgNodes(ctx context.Context, mid string) ([]ArchivedOrg, error)
+	PurgeArchivedOrgNodes(ctx context.Context, mid string) ([]string, error)
+	MoveOrgNode(ctx context.Context, mid string, id string, newPid string) error
+	MergeOrgNodes(ctx context.Context, mid string, sourceId string, targetId string, opts MergeOptions) (*MergeReport, error)
+	ReorderOrgNode(ctx context.Context, mid string, id string, place Placement) error
+	AddLeafNode(ctx context.Context, leaf LeafNode) error
+	ModifyLeafNode(ctx context.Context, leaf LeafNode) error
+	DelLeafNode(ctx context.Context, mid string, pid string, uid string) error
+	MoveLeafNode(ctx context.Context, mid string, uid string, fromPid string, toPid string) error
+	RenameLeafNode(ctx context.Context, mid string, oldUid string, newUid string) error
+	ReorderLeafNode(ctx context.Context, mid string, pid string, uid string, place Placement) error
+	GetLeafNodes(ctx context.Context, mid string, pid string, uid string) ([]LeafNode, error)
+	GetLeafNodesByOrg(ctx context.Context, mid string, pid string) ([]LeafNode, error)
+	GetOrgNode(ctx context.Context, mid string, id string) (*OrgNode, error)
+	GetOrgNodesByOrg(ctx context.Context, mid string, pid string, dept int) ([]OrgNode, error)
+	GetSubTree(ctx context.Context, mid string, id string) (*OrgTree, error)
+	GetUsersByPosition(ctx context.Context, mid string, pid string, positionid string) ([]LeafNode, error)
+	GetParents(ctx context.Context, mid string, id string) ([]OrgNode, error)
+	GetStatistics(ctx context.Context, mid string, id string) (*OrgStats, error)
+	GetMemberships(ctx context.Context, mid string, uid string) ([]Membership, error)
+	GetLeafNodesByOrgPaged(ctx context.Context, mid string, pid string, pageSize int, cursor string) ([]LeafNode, string, error)
+	GetOrgNodesByOrgPaged(ctx context.Context, mid string, pid string, dept int, pageSize int, cursor string) ([]OrgNode, string, error)
+	GetUsersByPositionPaged(ctx context.Context, mid string, pid string, positionid string, pageSize int, cursor string) ([]LeafNode, string, error)
+	Search(ctx context.Context, mid string, query SearchQuery) (*SearchResult, error)
+	AddPosition(ctx context.Context, pos Position) (string, error)
+	ModifyPosition(ctx context.Context, pos Position) error
+	RenamePosition(ctx context.Context, mid string, oldId string, newId string) error
+	DelPosition(ctx context.Context, mid string, id string) error
+	GetPosition(ctx context.Context, mid string, id string) (*Position, error)
+	GetPositions(ctx context.Context, mid string) ([]Position, error)
+}
+
+// contextBinder 可绑定context的DepTree实现
+type contextBinder interface {
+	withContext(ctx context.Context) DepTree
+}
+
+// BindContext 返回在ctx下执行的DepTree 与tree共享连接、缓存等状态
+// 本包的实现及CacheTree AuditTree均支持，其他实现原样返回
+func BindContext(tree DepTree, ctx context.Context) DepTree {
+	if b, ok := tree.(contextBinder); ok {
+		return b.withContext(ctx)
+	}
+	return tree
+}
+
+// WithContext 将DepTree适配为DepTreeContext 原有的DepTree调用方不受影响
+func WithContext(tree DepTree) DepTreeContext {
+	return &contextTree{tree: tree}
+}
+
+// NewTreeContext 同NewTree，返回DepTreeContext 不支持的配置记录日志后返回nil
+func NewTreeContext(config map[string]interface{}) DepTreeContext {
+	tree := NewTree(config)
+	if tree == nil {
+		return nil
+	}
+	return WithContext(tree)
+}
+
+// contextTree WithContext的实现 每次调用前检查ctx，再在绑定ctx的副本上执行
+type contextTree struct {
+	tree DepTree
+}
+
+// bind 检查ctx并返回绑定ctx的DepTree
+func (self *contextTree) bind(ctx context.Context, op string) (DepTree, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &Error{Kind: ErrCanceled, Op: op, Err: err}
+	}
+	return BindContext(self.tree, ctx), nil
+}
+
+// contextError ctx取消或已超过截止时间时，将返回的错误转换为ErrCanceled
+// ldap请求超时或连接被关闭时后端返回的是网络错误，需据ctx判断
+func contextError(ctx context.Context, op string, err *error) {
+	if *err == nil {
+		return
+	}
+	cause := ctx.Err()
+	if deadline, ok := ctx.Deadline(); ok && cause == nil && !time.Now().Before(deadline) {
+		cause = context.DeadlineExceeded
+	}
+	if cause == nil {
+		return
+	}
+	*err = &Error{Kind: ErrCanceled, Op: op, Err: cause}
+}
+
+func (self *contextTree) AddOrgNode(ctx context.Context, node OrgNode) (_ string, err error) {
+	tree, err := self.bind(ctx, "AddOrgNode")
+	if err != nil {
+		return "", err
+	}
+	defer contextError(ctx, "AddOrgNode", &err)
+	return tree.AddOrgNode(node)
+}
+
+func (self *contextTree) ModifyOrgNode(ctx context.Context, node OrgNode) (err error) {
+	tree, err := self.bind(ctx, "ModifyOrgNode")
+	if err != nil {
+		return err
+	}
+	defer contextError(ctx, "ModifyOrgNode", &err)
+	return tree.ModifyOrgNode(node)
+}
+
+func (self *contextTree) DelOrgNode(ctx context.Context, mid string, id string) (err error) {
+	tree, err := self.bind(ctx, "DelOrgNode")
+	if err != nil {
+		return err
+	}
+	defer contextError(ctx, "DelOrgNode", &err)
+	return tree.DelOrgNode(mid, id)
+}
+
+func (self *contextTree) ArchiveOrgNode(ctx context.Context, mid string, id string) (err error) {
+	tree, err := self.bind(ctx, "ArchiveOrgNode")
+	if err != nil {
+		return err
+	}
+	defer contextError(ctx, "ArchiveOrgNode", &err)
+	return tree.ArchiveOrgNode(mid, id)
+}
+
+func (self *contextTree) RestoreOrgNode(ctx context.Context, mid string, id string) (err error) {
+	tree, err := self.bind(ctx, "RestoreOrgNode")
+	if err != nil {
+		return err
+	}
+	defer contextError(ctx, "RestoreOrgNode", &err)
+	return tree.RestoreOrgNode(mid, id)
+}
+
+func (self *contextTree) GetArchivedOrgNodes(ctx context.Context, mid string) (_ []ArchivedOrg, err error) {
+	tree, err := self.bind(ctx, "GetArchivedOrgNodes")
+	if err != nil {
+		return nil, err
+	}
+	defer contextError(ctx, "GetArchivedOrgNodes", &err)
+	return tree.GetArchivedOrgNodes(mid)
+}
+
+func (self *contextTree) PurgeArchivedOrgNodes(ctx context.Context, mid string) (_ []string, err error) {
+	tree, err := self.bind(ctx, "PurgeArchivedOrgNodes")
+	if err != nil {
+		return nil, err
+	}
+	defer contextError(ctx, "PurgeArchivedOrgNodes", &err)
+	return tree.PurgeArchivedOrgNodes(mid)
+}
+
+func (self *contextTree) MoveOrgNode(ctx context.Context, mid string, id string, newPid string) (err error) {
+	tree, err := self.bind(ctx, "MoveOrgNode")
+	if err != nil {
+		return err
+	}
+	defer contextError(ctx, "MoveOrgNode", &err)
+	return tree.MoveOrgNode(mid, id, newPid)
+}
+
+func (self *contextTree) MergeOrgNodes(ctx context.Context, mid string, sourceId string, targetId string, opts MergeOptions) (_ *MergeReport, err error) {
+	tree, err := self.bind(ctx, "MergeOrgNodes")
+	if err != nil {
+		return nil, err
+	}
+	defer contextError(ctx, "MergeOrgNodes", &err)
+	return tree.MergeOrgNodes(mid, sourceId, targetId, opts)
+}
+
+func (self *contextTree) ReorderOrgNode(ctx context.Context, mid string, id string, place Placement) (err error) {
+	tree, err := self.bind(ctx, "ReorderOrgNode")
+	if err != nil {
+		return err
+	}
+	defer contextError(ctx, "ReorderOrgNode", &err)
+	return tree.ReorderOrgNode(mid, id, place)
+}
+
+func (self *contextTree) AddLeafNode(ctx context.Context, leaf LeafNode) (err error) {
+	tree, err := self.bind(ctx, "AddLeafNode")
+	if err != nil {
+		return err
+	}
+	defer contextError(ctx, "AddLeafNode", &err)
+	return tree.AddLeafNode(leaf)
+}
+
+func (self *contextTree) ModifyLeafNode(ctx context.Context, leaf LeafNode) (err error) {
+	tree, err := self.bind(ctx, "ModifyLeafNode")
+	if err != nil {
+		return err
+	}
+	defer contextError(ctx, "ModifyLeafNode", &err)
+	return tree.ModifyLeafNode(leaf)
+}
+
+func (self *contextTree) DelLeafNode(ctx context.Context, mid string, pid string, uid string) (err error) {
+	tree, err := self.bind(ctx, "DelLeafNode")
+	if err != nil {
+		return err
+	}
+	defer contextError(ctx, "DelLeafNode", &err)
+	return tree.DelLeafNode(mid, pid, uid)
+}
+
+func (self *contextTree) MoveLeafNode(ctx context.Context, mid string, uid string, fromPid string, toPid string) (err error) {
+	tree, err := self.bind(ctx, "MoveLeafNode")
+	if err != nil {
+		return err
+	}
+	defer contextError(ctx, "MoveLeafNode", &err)
+	return tree.MoveLeafNode(mid, uid, fromPid, toPid)
+}
+
+func (self *contextTree) RenameLeafNode(ctx context.Context, mid string, oldUid string, newUid string) (err error) {
+	tree, err := self.bind(ctx, "RenameLeafNode")
+	if err != nil {
+		return err
+	}
+	defer contextError(ctx, "RenameLeafNode", &err)
+	return tree.RenameLeafNode(mid, oldUid, newUid)
+}
+
+func (self *contextTree) ReorderLeafNode(ctx context.Context, mid string, pid string, uid string, place Placement) (err error) {
+	tree, err := self.bind(ctx, "ReorderLeafNode")
+	if err != nil {
+		return err
+	}
+	defer contextError(ctx, "ReorderLeafNode", &err)
+	return tree.ReorderLeafNode(mid, pid, uid, place)
+}
+
+func (self *contextTree) GetLeafNodes(ctx context.Context, mid string, pid string, uid string) (_ []LeafNode, err error) {
+	tree, err := self.bind(ctx, "GetLeafNodes")
+	if err != nil {
+		return nil, err
+	}
+	defer contextError(ctx, "GetLeafNodes", &err)
+	return tree.GetLeafNodes(mid, pid, uid)
+}
+
+func (self *contextTree) GetLeafNodesByOrg(ctx context.Context, mid string, pid string) (_ []LeafNode, err error) {
+	tree, err := self.bind(ctx, "GetLeafNodesByOrg")
+	if err != nil {
+		return nil, err
+	}
+	defer contextError(ctx, "GetLeafNodesByOrg", &err)
+	return tree.GetLeafNodesByOrg(mid, pid)
+}
+
+func (self *contextTree) GetOrgNode(ctx context.Context, mid string, id string) (_ *OrgNode, err error) {
+	tree, err := self.bind(ctx, "GetOrgNode")
+	if err != nil {
+		return nil, err
+	}
+	defer contextError(ctx, "GetOrgNode", &err)
+	return tree.GetOrgNode(mid, id)
+}
+
+func (self *contextTree) GetOrgNodesByOrg(ctx context.Context, mid string, pid string, dept int) (_ []OrgNode, err error) {
+	tree, err := self.bind(ctx, "GetOrgNodesByOrg")
+	if err != nil {
+		return nil, err
+	}
+	defer contextError(ctx, "GetOrgNodesByOrg", &err)
+	return tree.GetOrgNodesByOrg(mid, pid, dept)
+}
+
+func (self *contextTree) GetSubTree(ctx context.Context, mid string, id string) (_ *OrgTree, err error) {
+	tree, err := self.bind(ctx, "GetSubTree")
+	if err != nil {
+		return nil, err
+	}
+	defer contextError(ctx, "GetSubTree", &err)
+	return tree.GetSubTree(mid, id)
+}
+
+func (self *contextTree) GetUsersByPosition(ctx context.Context, mid string, pid string, positionid string) (_ []LeafNode, err error) {
+	tree, err := self.bind(ctx, "GetUsersByPosition")
+	if err != nil {
+		return nil, err
+	}
+	defer contextError(ctx, "GetUsersByPosition", &err)
+	return tree.GetUsersByPosition(mid, pid, positionid)
+}
+
+func (self *contextTree) GetParents(ctx context.Context, mid string, id string) (_ []OrgNode, err error) {
+	tree, err := self.bind(ctx, "GetParents")
+	if err != nil {
+		return nil, err
+	}
+	defer contextError(ctx, "GetParents", &err)
+	return tree.GetParents(mid, id)
+}
+
+func (self *contextTree) GetStatistics(ctx context.Context, mid string, id string) (_ *OrgStats, err error) {
+	tree, err := self.bind(ctx, "GetStatistics")
+	if err != nil {
+		return nil, err
+	}
+	defer contextError(ctx, "GetStatistics", &err)
+	return tree.GetStatistics(mid, id)
+}
+
+func (self *contextTree) GetMemberships(ctx context.Context, mid string, uid string) (_ []Membership, err error) {
+	tree, err := self.bind(ctx, "GetMemberships")
+	if err != nil {
+		return nil, err
+	}
+	defer contextError(ctx, "GetMemberships", &err)
+	return tree.GetMemberships(mid, uid)
+}
+
+func (self *contextTree) GetLeafNodesByOrgPaged(ctx context.Context, mid string, pid string, pageSize int, cursor string) (_ []LeafNode, _ string, err error) {
+	tree, err := self.bind(ctx, "GetLeafNodesByOrgPaged")
+	if err != nil {
+		return nil, "", err
+	}
+	defer contextError(ctx, "GetLeafNodesByOrgPaged", &err)
+	return tree.GetLeafNodesByOrgPaged(mid, pid, pageSize, cursor)
+}
+
+func (self *contextTree) GetOrgNodesByOrgPaged(ctx context.Context, mid string, pid string, dept int, pageSize int, cursor string) (_ []OrgNode, _ string, err error) {
+	tree, err := self.bind(ctx, "GetOrgNodesByOrgPaged")
+	if err != nil {
+		return nil, "", err
+	}
+	defer contextError(ctx, "GetOrgNodesByOrgPaged", &err)
+	return tree.GetOrgNodesByOrgPaged(mid, pid, dept, pageSize, cursor)
+}
+
+func (self *contextTree) GetUsersByPositionPaged(ctx context.Context, mid string, pid string, positionid string, pageSize int, cursor string) (_ []LeafNode, _ string, err error) {
+	tree, err := self.bind(ctx, "GetUsersByPositionPaged")
+	if err != nil {
+		return nil, "", err
+	}
+	defer contextError(ctx, "GetUsersByPositionPaged", &err)
+	return tree.GetUsersByPositionPaged(mid, pid, positionid, pageSize, cursor)
+}
+
+func (self *contextTree) Search(ctx context.Context, mid string, query SearchQuery) (_ *SearchResult, err error) {
+	tree, err := self.bind(ctx, "Search")
+	if err != nil {
+		return nil, err
+	}
+	defer contextError(ctx, "Search", &err)
+	return tree.Search(mid, query)
+}
+
+func (self *contextTree) AddPosition(ctx context.Context, pos Position) (_ string, err error) {
+	tree, err := self.bind(ctx, "AddPosition")
+	if err != nil {
+		return "", err
+	}
+	defer contextError(ctx, "AddPosition", &err)
+	return tree.AddPosition(pos)
+}
+
+func (self *contextTree) ModifyPosition(ctx context.Context, pos Position) (err error) {
+	tree, err := self.bind(ctx, "ModifyPosition")
+	if err != nil {
+		return err
+	}
+	defer contextError(ctx, "ModifyPosition", &err)
+	return tree.ModifyPosition(pos)
+}
+
+func (self *contextTree) RenamePosition(ctx context.Context, mid string, oldId string, newId string) (err error) {
+	tree, err := self.bind(ctx, "RenamePosition")
+	if err != nil {
+		return err
+	}
+	defer contextError(ctx, "RenamePosition", &err)
+	return tree.RenamePosition(mid, oldId, newId)
+}
+
+func (self *contextTree) DelPosition(ctx context.Context, mid string, id string) (err error) {
+	tree, err := self.bind(ctx, "DelPosition")
+	if err != nil {
+		return err
+	}
+	defer contextError(ctx, "DelPosition", &err)
+	return tree.DelPosition(mid, id)
+}
+
+func (self *contextTree) GetPosition(ctx context.Context, mid string, id string) (_ *Position, err error) {
+	tree, err := self.bind(ctx, "GetPosition")
+	if err != nil {
+		return nil, err
+	}
+	defer contextError(ctx, "GetPosition", &err)
+	return tree.GetPosition(mid, id)
+}
+
+func (self *contextTree) GetPositions(ctx context.Context, mid string) (_ []Position, err error) {
+	tree, err := self.bind(ctx, "GetPositions")
+	if err != nil {
+		return nil, err
+	}
+	defer contextError(ctx, "GetPositions", &err)
+	return tree.GetPositions(mid)
+}
@@ -0,0 +1,183 @@
+package deptree
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// silentListener 接受连接但从不应答，模拟无响应的ldap服务
+func silentListener(t *testing.T) map[string]interface{} {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make(chan net.Conn, 16)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- c
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		close(conns)
+		for c := range conns {
+			c.Close()
+		}
+	})
+	return map[string]interface{}{
+		"Backend":  "ldap",
+		"Host":     "127.0.0.1",
+		"Port":     float64(ln.Addr().(*net.TCPAddr).Port),
+		"Base":     "dc=test",
+		"User":     "cn=admin,dc=test",
+		"Password": "secret",
+	}
+}
+
+// callWithin 以timeout为截止时间调用f，返回错误须为ErrCanceled且不晚于截止时间太久
+func callWithin(t *testing.T, timeout time.Duration, f func(ctx context.Context) error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	err := f(ctx)
+	if d := time.Since(start); d > timeout+time.Second {
+		t.Errorf("returned after %v, deadline %v", d, timeout)
+	}
+	if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want ErrCanceled with DeadlineExceeded", err)
+	}
+}
+
+// 服务接受连接后不应答时，建立连接及绑定受截止时间控制
+func TestLdapContextDialDeadline(t *testing.T) {
+	for _, tlsMode := range []string{LDAP_TLS_NONE, LDAP_TLS_LDAPS, LDAP_TLS_STARTTLS} {
+		config := silentListener(t)
+		config["TLS"] = tlsMode
+		config["InsecureSkipVerify"] = true
+		tree, err := NewTreeE(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		callWithin(t, 200*time.Millisecond, func(ctx context.Context) error {
+			_, err := WithContext(tree).GetOrgNode(ctx, "m", "m")
+			return err
+		})
+	}
+}
+
+// 已建立的连接上请求不应答时受截止时间控制，超时的连接不放回连接池
+func TestLdapContextRequestDeadline(t *testing.T) {
+	srv, tree := newTestLdap(t)
+	seedOrg(srv, "ou=top,dc=test", OrgNode{Mid: "m", Id: "m", Name: "top"})
+	ctxTree := WithContext(tree)
+	if _, err := ctxTree.GetOrgNode(context.Background(), "m", "m"); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&srv.mute, 1)
+	callWithin(t, 200*time.Millisecond, func(ctx context.Context) error {
+		_, err := ctxTree.GetOrgNode(ctx, "m", "m")
+		return err
+	})
+	atomic.StoreInt32(&srv.mute, 0)
+	if n, err := ctxTree.GetOrgNode(context.Background(), "m", "m"); err != nil || n.Name != "top" {
+		t.Errorf("GetOrgNode after deadline = %v, %v", n, err)
+	}
+	if n := len(tree.pool.sem); n != 0 {
+		t.Errorf("%d connections still counted as open", n)
+	}
+}
+
+// 取消ctx中止进行中的请求
+func TestLdapContextCancel(t *testing.T) {
+	srv, tree := newTestLdap(t)
+	seedOrg(srv, "ou=top,dc=test", OrgNode{Mid: "m", Id: "m", Name: "top"})
+	atomic.StoreInt32(&srv.mute, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := WithContext(tree).GetOrgNode(ctx, "m", "m")
+	if !errors.Is(err, ErrCanceled) || !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want ErrCanceled with Canceled", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("returned after %v", d)
+	}
+}
+
+// 调用前ctx已取消时不执行操作
+func TestContextCanceledBeforeCall(t *testing.T) {
+	tree := WithContext(newMemDepTree(map[string]interface{}{}))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := tree.AddOrgNode(ctx, OrgNode{Mid: "m", Name: "top"}); !errors.Is(err, ErrCanceled) {
+		t.Errorf("err = %v, want ErrCanceled", err)
+	}
+	if _, err := tree.GetOrgNode(context.Background(), "m", "m"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("node added by a canceled call: %v", err)
+	}
+	if _, err := tree.AddOrgNode(context.Background(), OrgNode{Mid: "m", Name: "top"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := tree.GetOrgNode(context.Background(), "m", "m"); err != nil || n.Name != "top" {
+		t.Errorf("GetOrgNode = %v, %v", n, err)
+	}
+}
+
+// sql使用驱动的context方法
+func TestSqlContextCanceled(t *testing.T) {
+	tree := newTestSql(t)
+	if _, err := tree.AddOrgNode(OrgNode{Mid: "m", Name: "top"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := BindContext(tree, ctx).GetOrgNode("m", "m"); !errors.Is(err, ErrCanceled) {
+		t.Errorf("err = %v, want ErrCanceled", err)
+	}
+}
+
+// recordSink 保存写入的审计记录
+type recordSink struct {
+	records []AuditRecord
+}
+
+func (self *recordSink) Write(record AuditRecord) error {
+	self.records = append(self.records, record)
+	return nil
+}
+
+// BindContext得到的CacheTree AuditTree与原对象共享缓存及sink
+func TestBindContextSharesState(t *testing.T) {
+	sink := &recordSink{}
+	cache := NewCacheTree(newMemDepTree(map[string]interface{}{}), map[string]interface{}{})
+	tree := NewAuditTree(cache, sink)
+	bound := BindContext(tree, context.Background())
+	if bound == DepTree(tree) {
+		t.Fatal("BindContext returned the same tree")
+	}
+	if _, err := bound.AddOrgNode(OrgNode{Mid: "m", Name: "top"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.records) != 1 || sink.records[0].Op != "AddOrgNode" {
+		t.Errorf("records = %+v", sink.records)
+	}
+	if _, err := bound.GetOrgNode("m", "m"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.GetOrgNode("m", "m"); err != nil {
+		t.Fatal(err)
+	}
+	// 审计记录新增后的状态时缓存未命中，之后两次读取均命中共享的缓存
+	if stats := cache.Stats(); stats.Misses != 1 || stats.Hits != 2 {
+		t.Errorf("cache hits/misses = %d/%d, want 2/1", stats.Hits, stats.Misses)
+	}
+}
@@ -1,22 +1,55 @@
 package deptree
 
+import "log"
+
 // DepTree 组织架构树操作接口
 // 说明：依赖包 - gopkg.in/ldap.v2
 //             - github.com/golibs/uuid
+// 错误：各实现返回*Error，可使用errors.Is(err, ErrNotFound)等判断错误类别
+// 商户或节点不存在时查询方法返回ErrNotFound
 type DepTree interface {
 	// AddOrgNode 新增组织节点 node 节点信息 需包含Mid Pid(顶级节点可省略) Name(同一节点下需保证唯一) 信息 Id可选
 	// 返回节点id
 	AddOrgNode(node OrgNode) (string, error)
-	// ModifyOrgNode 修改组织节点 node需包含完整的Mid Id 信息 只能更新Name信息，传空不更新
+	// ModifyOrgNode 修改组织节点 node需包含完整的Mid Id 信息 只能更新Name Attrs信息，Name传空、Attrs传nil不更新
 	ModifyOrgNode(node OrgNode) error
-	// DelOrgNode 删除组织节点 id-节点ID
+	// DelOrgNode 删除组织节点 id-节点ID 递归永久删除子树及叶子，需保留时使用ArchiveOrgNode
 	DelOrgNode(mid string, id string) error
+	// ArchiveOrgNode 归档组织节点(包含子树及叶子)，归档后各查询方法不再返回，ID、叶子及岗位保持不变
+	// 不能归档顶级节点，归档的ID不能再用于新增节点
+	ArchiveOrgNode(mid string, id string) error
+	// RestoreOrgNode 将归档的子树恢复到原父节点下并排在同级最后
+	// 原父节点已删除或归档时返回ErrNotFound，原父节点下已有同名节点时返回ErrDuplicateName
+	RestoreOrgNode(mid string, id string) error
+	// GetArchivedOrgNodes 取商户内已归档的子树根节点 按归档时间排序
+	GetArchivedOrgNodes(mid string) ([]ArchivedOrg, error)
+	// PurgeArchivedOrgNodes 永久删除超过保留期的归档子树，返回被删除的子树根节点ID
+	PurgeArchivedOrgNodes(mid string) ([]string, error)
+	// MoveOrgNode 移动组织节点(包含子树)到新的父节点newPid下，ID保持不变
+	// 不能移动顶级节点或移动到自身子孙节点下，新父节点下需保证Name唯一
+	MoveOrgNode(mid string, id string, newPid string) error
+	// MergeOrgNodes 将sourceId的子节点(包含子树)及叶子合并到targetId下，之后删除已清空的sourceId
+	// 同名子节点按opts.Strategy处理(MERGE_FAIL时不做任何修改)，两处都有的叶子保留targetId下的并合并岗位
+	// opts.DryRun时只返回报告；targetId不能是sourceId或其子孙节点，不能合并顶级节点
+	// memory sql在同一事务中完成，失败时不返回报告；ldap逐步执行，中途失败时返回报告，Done为已完成的步骤数
+	MergeOrgNodes(mid string, sourceId string, targetId string, opts MergeOptions) (*MergeReport, error)
+	// ReorderOrgNode 调整组织节点在同级中的位置(移到某节点之前/之后或第Index位)，同级节点按新顺序重新编号
+	// 新增或移动的节点排在同级最后，GetSubTree GetOrgNodesByOrg按同级顺序返回
+	ReorderOrgNode(mid string, id string, place Placement) error
 	// AddLeafNode 新增叶子节点
 	AddLeafNode(leaf LeafNode) error
-	// ModifyLeafNode 修改叶子节点
+	// ModifyLeafNode 修改叶子节点 只能更新Positions Attrs信息，传nil不更新
 	ModifyLeafNode(leaf LeafNode) error
 	// DelLeafNode 删除叶子节点 pid-父节点ID uid-uid
 	DelLeafNode(mid string, pid string, uid string) error
+	// MoveLeafNode 将叶子节点从fromPid调动到toPid，保留Sid及Positions，失败时叶子保持在原位置
+	MoveLeafNode(mid string, uid string, fromPid string, toPid string) error
+	// RenameLeafNode 将uid在商户内全部组织节点(包含已归档子树)下的叶子改为newUid，保留Sid Positions Order Attrs
+	// oldUid不存在时返回ErrNotFound，newUid已存在时返回ErrAlreadyExists
+	// ldap无事务，逐个组织节点修改，部分失败时返回的错误包含*PartialError(已完成及失败的组织节点ID)
+	RenameLeafNode(mid string, oldUid string, newUid string) error
+	// ReorderLeafNode 调整叶子在父节点pid下的位置，同级叶子按新顺序重新编号
+	ReorderLeafNode(mid string, pid string, uid string, place Placement) error
 	// GetLeafNodes 根据mid，pid, uid取叶子节点信息
 	GetLeafNodes(mid string, pid string, uid string) ([]LeafNode, error)
 	// GetLeafNodesByOrg 根据组织节点，取所有叶子节点信息
@@ -31,25 +64,75 @@ type DepTree interface {
 	GetUsersByPosition(mid string, pid string, positionid string) ([]LeafNode, error)
 	// GetParents 根据节点id获得全部父节点信息 从近到远
 	GetParents(mid string, id string) ([]OrgNode, error)
+	// GetStatistics 子树统计：各节点的直属及累计叶子数、累计不同uid数、各岗位数量、最大深度
+	// 各实现在后端汇总，不返回叶子明细
+	GetStatistics(mid string, id string) (*OrgStats, error)
+	// GetMemberships 取uid在商户内的全部叶子节点及各自的父节点路径 按叶子Pid排序
+	// 每个商户只需固定次数的后端查询，与所属部门数量无关
+	GetMemberships(mid string, uid string) ([]Membership, error)
+
+	// 分页查询：pageSize为每页数量，cursor首页传空，之后传入上一页返回的游标，返回的游标为空表示已是最后一页
+	// 翻页时需传入与首页相同的其他参数
+
+	// GetLeafNodesByOrgPaged 分页取组织节点下的叶子节点
+	GetLeafNodesByOrgPaged(mid string, pid string, pageSize int, cursor string) ([]LeafNode, string, error)
+	// GetOrgNodesByOrgPaged 分页取组织节点下的节点 dept含义同GetOrgNodesByOrg
+	GetOrgNodesByOrgPaged(mid string, pid string, dept int, pageSize int, cursor string) ([]OrgNode, string, error)
+	// GetUsersByPositionPaged 分页根据岗位查询叶子节点
+	GetUsersByPositionPaged(mid string, pid string, positionid string, pageSize int, cursor string) ([]LeafNode, string, error)
+
+	// Search 搜索组织节点或叶子节点 条件见SearchQuery，Root不存在时返回ErrNotFound
+	Search(mid string, query SearchQuery) (*SearchResult, error)
+
+	// 岗位目录：AddLeafNode ModifyLeafNode中的岗位ID需先定义，否则返回ErrInvalidArgument
+
+	// AddPosition 新增岗位定义 需包含Mid Name(商户内唯一) Id可选，返回岗位ID
+	AddPosition(pos Position) (string, error)
+	// ModifyPosition 修改岗位的Name Level Description Name传空不更新
+	ModifyPosition(pos Position) error
+	// RenamePosition 修改岗位ID 拥有该岗位的叶子节点同步更新
+	RenamePosition(mid string, oldId string, newId string) error
+	// DelPosition 删除岗位定义 同时从拥有该岗位的叶子节点中移除
+	DelPosition(mid string, id string) error
+	// GetPosition 取岗位定义
+	GetPosition(mid string, id string) (*Position, error)
+	// GetPositions 取商户的全部岗位定义 按Level Id排序
+	GetPositions(mid string) ([]Position, error)
 }
 
-// NewTree 目前仅返回ldap结构树对象，扩展视后续需求开发
+// NewTree 根据config["Backend"]返回结构树对象，不支持的配置记录日志后返回nil 需要错误原因时使用NewTreeE
+// 各实现均可配置ArchiveRetentionDays 归档保留天数 默认30
+// "ldap"(默认) - ldap实现，需包含Host Port Base User Password
+// "memory"     - 内存实现，用于单元测试及小规模部署
+// "sql"        - database/sql实现，需包含Driver DSN，驱动需由调用方import注册
 func NewTree(config map[string]interface{}) DepTree {
-	host := config["Host"]
-	port := config["Port"]
-	base := config["Base"]
-	user := config["User"]
-	passwd := config["Password"]
-	if host == nil || port == nil ||
-		base == nil || user == nil ||
-		passwd == nil {
+	tree, err := NewTreeE(config)
+	if err != nil {
+		log.Println(err)
 		return nil
 	}
-	return &ldapDepTree{
-		host:   host.(string),
-		port:   int(port.(float64)),
-		base:   base.(string),
-		user:   user.(string),
-		passwd: passwd.(string),
+	return tree
+}
+
+// NewTreeE 同NewTree，配置错误(缺少参数、Schema TLS证书等无效)返回ErrInvalidArgument，
+// 数据库无法打开或迁移失败返回ErrBackendUnavailable
+func NewTreeE(config map[string]interface{}) (_ DepTree, err error) {
+	defer setOp("NewTree", &err)
+	switch config["Backend"] {
+	case nil, "ldap":
+		tree, err := newLdapDepTree(config)
+		if err != nil {
+			return nil, err
+		}
+		return tree, nil
+	case "memory":
+		return newMemDepTree(config), nil
+	case "sql":
+		tree, err := newSqlDepTree(config)
+		if err != nil {
+			return nil, err
+		}
+		return tree, nil
 	}
+	return nil, newError(ErrInvalidArgument, "", "unsupported backend: %v", config["Backend"])
 }
@@ -0,0 +1,169 @@
+package deptree
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testBackend 一种后端实现 用于各后端结果须一致的测试
+type testBackend struct {
+	name string
+	open func(t *testing.T) DepTree
+}
+
+var testBackends = []testBackend{
+	{"memory", func(t *testing.T) DepTree { return newMemDepTree(map[string]interface{}{}) }},
+	{"sqlite", func(t *testing.T) DepTree { return newTestSql(t) }},
+	{"ldap", func(t *testing.T) DepTree {
+		_, tree := newTestLdap(t)
+		return tree
+	}},
+}
+
+// eachBackend 在每种后端上执行f skip中的后端跳过
+func eachBackend(t *testing.T, f func(t *testing.T, tree DepTree), skip ...string) {
+	for _, b := range testBackends {
+		skipped := false
+		for _, s := range skip {
+			skipped = skipped || s == b.name
+		}
+		if skipped {
+			continue
+		}
+		t.Run(b.name, func(t *testing.T) {
+			f(t, b.open(t))
+		})
+	}
+}
+
+// seedTree 按 id -> pid 顺序新增组织节点(名称与id相同)，顶级节点为mid
+func seedTree(t *testing.T, tree DepTree, mid string, nodes ...[2]string) {
+	t.Helper()
+	if _, err := tree.AddOrgNode(OrgNode{Mid: mid, Name: mid}); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range nodes {
+		if _, err := tree.AddOrgNode(OrgNode{Mid: mid, Pid: n[1], Id: n[0], Name: n[0]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMoveOrgNode(t *testing.T) {
+	eachBackend(t, func(t *testing.T, tree DepTree) {
+		seedTree(t, tree, "m", [2]string{"a", "m"}, [2]string{"b", "m"}, [2]string{"c", "a"},
+			[2]string{"dup", "m"}, [2]string{"x", "b"})
+		if err := tree.AddLeafNode(LeafNode{Mid: "m", Pid: "c", Uid: "u"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.ModifyOrgNode(OrgNode{Mid: "m", Id: "x", Name: "dup"}); err != nil {
+			t.Fatal(err)
+		}
+		cases := []struct {
+			name   string
+			id     string
+			newPid string
+			kind   error
+		}{
+			{"into itself", "a", "a", ErrInvalidArgument},
+			{"into own subtree", "a", "c", ErrInvalidArgument},
+			{"top tree", "m", "a", ErrInvalidArgument},
+			{"name taken", "dup", "b", ErrDuplicateName},
+			{"missing parent", "a", "none", ErrNotFound},
+		}
+		for _, c := range cases {
+			if err := tree.MoveOrgNode("m", c.id, c.newPid); !errors.Is(err, c.kind) {
+				t.Errorf("%s: err = %v, want %v", c.name, err, c.kind)
+			}
+		}
+		if got := parentIds(t, tree, "m", "c"); got != "c,a,m" {
+			t.Errorf("parents of c after failed moves = %s", got)
+		}
+
+		// 移动到原上级不做修改
+		if err := tree.MoveOrgNode("m", "c", "a"); err != nil {
+			t.Errorf("move to the same parent: %v", err)
+		}
+		if err := tree.MoveOrgNode("m", "c", "b"); err != nil {
+			t.Fatal(err)
+		}
+		if got := parentIds(t, tree, "m", "c"); got != "c,b,m" {
+			t.Errorf("parents of c after move = %s", got)
+		}
+		leafs, err := tree.GetLeafNodes("m", "c", "u")
+		if err != nil || len(leafs) != 1 {
+			t.Errorf("leaf of moved node = %v, %v", leafs, err)
+		}
+		if sql, ok := tree.(*sqlDepTree); ok {
+			checkClosure(t, sql, "m")
+		}
+	})
+}
+
+// 服务器返回的DN与拼接的DN写法不同时，移动到原上级不报重名
+func TestLdapMoveOrgNodeDnForms(t *testing.T) {
+	srv, tree := newTestLdap(t)
+	seedOrg(srv, "ou=Top,dc=test", OrgNode{Mid: "m", Id: "m", Name: "Top"})
+	seedOrg(srv, "OU=Sales, OU=TOP,DC=test", OrgNode{Mid: "m", Pid: "m", Id: "sales", Name: "Sales"})
+	seedOrg(srv, "ou=East,OU=SALES,ou=top,dc=test", OrgNode{Mid: "m", Pid: "sales", Id: "east", Name: "East"})
+	if err := tree.MoveOrgNode("m", "sales", "m"); err != nil {
+		t.Errorf("move sales to its parent: %v", err)
+	}
+	if err := tree.MoveOrgNode("m", "east", "sales"); err != nil {
+		t.Errorf("move east to its parent: %v", err)
+	}
+	if err := tree.MoveOrgNode("m", "sales", "east"); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("move into own subtree err = %v, want ErrInvalidArgument", err)
+	}
+}
+
+// 调动后保留Sid Positions Attrs，排在新上级的最后
+func TestMoveLeafNode(t *testing.T) {
+	eachBackend(t, func(t *testing.T, tree DepTree) {
+		seedTree(t, tree, "m", [2]string{"a", "m"}, [2]string{"b", "m"})
+		if _, err := tree.AddPosition(Position{Mid: "m", Id: "p1", Name: "p1"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.AddLeafNode(LeafNode{Mid: "m", Pid: "b", Uid: "x"}); err != nil {
+			t.Fatal(err)
+		}
+		leaf := LeafNode{Mid: "m", Pid: "a", Uid: "u", Sid: "s1", Positions: []string{"p1"},
+			Attrs: map[string][]string{"k": {"v"}}}
+		if err := tree.AddLeafNode(leaf); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.MoveLeafNode("m", "u", "a", "a"); err != nil {
+			t.Errorf("move to the same parent: %v", err)
+		}
+		if err := tree.MoveLeafNode("m", "u", "a", "b"); err != nil {
+			t.Fatal(err)
+		}
+		if leafs, err := tree.GetLeafNodes("m", "a", "u"); err != nil || len(leafs) != 0 {
+			t.Errorf("leaf left under the old parent: %v, %v", leafs, err)
+		}
+		leafs, err := tree.GetLeafNodesByOrg("m", "b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(leafs) != 2 {
+			t.Fatalf("leafs under the new parent = %+v", leafs)
+		}
+		got, other := leafs[0], leafs[1]
+		if got.Uid != "u" {
+			got, other = other, got
+		}
+		if got.Order <= other.Order {
+			t.Errorf("moved leaf order %d, existing leaf order %d", got.Order, other.Order)
+		}
+		if got.Pid != "b" || got.Sid != "s1" || strings.Join(got.Positions, ",") != "p1" || strings.Join(got.Attrs["k"], ",") != "v" {
+			t.Errorf("moved leaf = %+v", got)
+		}
+		if err = tree.MoveLeafNode("m", "u", "a", "b"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("move a missing leaf err = %v, want ErrNotFound", err)
+		}
+		if err = tree.MoveLeafNode("m", "u", "b", "none"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("move to a missing parent err = %v, want ErrNotFound", err)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package deptree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// 错误类别 使用errors.Is判断
+var (
+	ErrNotFound           = errors.New("not found")           // 商户、组织节点或叶子节点不存在
+	ErrAlreadyExists      = errors.New("already exists")      // ID或uid已存在
+	ErrDuplicateName      = errors.New("duplicate name")      // 同级节点名称重复
+	ErrInvalidArgument    = errors.New("invalid argument")    // 参数错误或操作不允许
+	ErrBackendUnavailable = errors.New("backend unavailable") // 后端无法连接、认证失败或繁忙
+	ErrBackend            = errors.New("backend error")       // 其他后端错误
+	ErrCanceled           = errors.New("canceled")            // context已取消或超过截止时间，Err为ctx.Err()
+	ErrPartial            = errors.New("partially failed")    // 涉及多个对象的操作只完成了一部分，Err为*PartialError
+)
+
+// Error DepTree方法返回的结构化错误 使用errors.As获取
+// Kind为上述错误类别之一，Err为后端原始错误(如*ldap.Error)，可能为nil
+type Error struct {
+	Kind error  // 错误类别
+	Op   string // 出错的方法名
+	Msg  string // 错误描述
+	Err  error  // 底层错误
+}
+
+func (self *Error) Error() string {
+	msg := self.Msg
+	if msg == "" {
+		msg = self.Kind.Error()
+	}
+	if self.Op != "" {
+		msg = self.Op + ": " + msg
+	}
+	if self.Err != nil {
+		msg += ": " + self.Err.Error()
+	}
+	return "deptree: " + msg
+}
+
+// Is 支持errors.Is(err, ErrNotFound)等判断
+func (self *Error) Is(target error) bool {
+	return self.Kind == target
+}
+
+// Unwrap 支持errors.As获取底层错误
+func (self *Error) Unwrap() error {
+	return self.Err
+}
+
+// PartialError 无事务的后端(ldap)逐个处理多个对象时的结果 Done为已完成的对象，Failed为失败的对象
+// 全部失败时Error.Kind为第一个失败的类别，否则为ErrPartial
+type PartialError struct {
+	Done   []string         // 已完成的对象
+	Failed []PartialFailure // 失败的对象及原因
+}
+
+// PartialFailure 失败的对象
+type PartialFailure struct {
+	Target string // 对象 如叶子所在的组织节点ID
+	Err    error  // 原因 *Error
+}
+
+func (self *PartialError) Error() string {
+	msg := fmt.Sprintf("%d done, %d failed", len(self.Done), len(self.Failed))
+	for _, f := range self.Failed {
+		msg += fmt.Sprintf("; %s: %v", f.Target, f.Err)
+	}
+	return msg
+}
+
+// partialError 根据逐个处理的结果生成错误 没有失败时返回nil
+func partialError(done []string, failed []PartialFailure) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	kind := ErrPartial
+	var e *Error
+	if len(done) == 0 && errors.As(failed[0].Err, &e) {
+		kind = e.Kind
+	}
+	return &Error{Kind: kind, Err: &PartialError{Done: done, Failed: failed}}
+}
+
+// newError 生成错误
+func newError(kind error, op string, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Op: op, Msg: fmt.Sprintf(format, args...)}
+}
+
+// wrapError 包装后端错误
+func wrapError(kind error, op string, err error) error {
+	return &Error{Kind: kind, Op: op, Err: err}
+}
+
+// errNotFound 节点不存在
+func errNotFound(op string, format string, args ...interface{}) error {
+	return newError(ErrNotFound, op, format, args...)
+}
+
+// isContextError 是否为context取消或超时
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// fillOp 为方法内部生成的错误补充方法名 返回是否为*Error
+func fillOp(err error, op string) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		if e.Op == "" {
+			e.Op = op
+		}
+		return true
+	}
+	return false
+}
+
+// setOp 为返回的错误补充方法名 用法：defer setOp("AddOrgNode", &err)
+func setOp(op string, err *error) {
+	if *err != nil {
+		fillOp(*err, op)
+	}
+}
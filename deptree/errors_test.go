@@ -0,0 +1,30 @@
+package deptree
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPartialError(t *testing.T) {
+	notFound := errNotFound("", "missing")
+	cases := []struct {
+		name   string
+		done   []string
+		failed []PartialFailure
+		kind   error
+	}{
+		{"some done", []string{"a"}, []PartialFailure{{"b", notFound}}, ErrPartial},
+		{"none done", nil, []PartialFailure{{"a", notFound}, {"b", newError(ErrBackend, "", "x")}}, ErrNotFound},
+		{"none done without kind", nil, []PartialFailure{{"a", errors.New("x")}}, ErrPartial},
+	}
+	for _, c := range cases {
+		err := partialError(c.done, c.failed)
+		var partial *PartialError
+		if !errors.Is(err, c.kind) || !errors.As(err, &partial) || len(partial.Failed) != len(c.failed) {
+			t.Errorf("%s: err = %v, want %v with *PartialError", c.name, err, c.kind)
+		}
+	}
+	if err := partialError([]string{"a"}, nil); err != nil {
+		t.Errorf("no failures err = %v", err)
+	}
+}
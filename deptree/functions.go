@@ -0,0 +1,88 @@
+package deptree
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+)
+
+// configInt 读取整型配置 json解析的数字为float64
+func configInt(config map[string]interface{}, key string, def int) int {
+	switch v := config[key].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return def
+}
+
+// buildTree 根据节点列表在内存中组装树形结构
+// nodes 为root子树下的组织节点(可包含root本身) leafs 为子树下的全部叶子
+func buildTree(root OrgNode, nodes []OrgNode, leafs []LeafNode) OrgTree {
+	children := map[string][]OrgNode{}
+	for _, n := range nodes {
+		if n.Id == root.Id {
+			continue
+		}
+		children[n.Pid] = append(children[n.Pid], n)
+	}
+	subleafs := map[string][]LeafNode{}
+	for _, l := range leafs {
+		subleafs[l.Pid] = append(subleafs[l.Pid], l)
+	}
+	return assembleTree(root, children, subleafs)
+}
+
+// assembleTree 递归组装子树 同级节点按顺序排序
+func assembleTree(node OrgNode, children map[string][]OrgNode, leafs map[string][]LeafNode) OrgTree {
+	ret := OrgTree{
+		OrgNode:  node,
+		SubTrees: []OrgTree{},
+		SubLeafs: []LeafNode{},
+	}
+	sortSiblingOrgs(children[node.Id])
+	sortSiblingLeafs(leafs[node.Id])
+	ret.SubLeafs = append(ret.SubLeafs, leafs[node.Id]...)
+	for _, c := range children[node.Id] {
+		ret.SubTrees = append(ret.SubTrees, assembleTree(c, children, leafs))
+	}
+	return ret
+}
+
+// encodeCursor 将分页位置编码为不透明的游标
+func encodeCursor(key ...string) string {
+	buff, _ := json.Marshal(key)
+	return base64.RawURLEncoding.EncodeToString(buff)
+}
+
+// decodeCursor 解析游标 cursor为空时返回nil，格式错误返回ErrInvalidArgument
+func decodeCursor(cursor string, n int) ([]string, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	buff, err := base64.RawURLEncoding.DecodeString(cursor)
+	key := []string{}
+	if err == nil {
+		err = json.Unmarshal(buff, &key)
+	}
+	if err != nil || len(key) != n {
+		return nil, newError(ErrInvalidArgument, "", "invalid cursor: %s", cursor)
+	}
+	return key, nil
+}
+
+// checkPageSize 检查分页大小
+func checkPageSize(pageSize int) error {
+	if pageSize <= 0 {
+		return newError(ErrInvalidArgument, "", "invalid page size: %d", pageSize)
+	}
+	return nil
+}
+
+// sortMemberships 按叶子所在组织节点ID排序
+func sortMemberships(memberships []Membership) {
+	sort.Slice(memberships, func(i, j int) bool {
+		return memberships[i].Leaf.Pid < memberships[j].Leaf.Pid
+	})
+}
@@ -1,10 +1,15 @@
 package deptree
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
-	//"log"
+	"net"
 	"strconv"
+	"strings"
+	"time"
+	//"log"
 
 	//ldap "gopkg.in/ldap.v2"
 	ldap "github.com/go-ldap/ldap"
@@ -17,76 +22,212 @@ type ldapDepTree struct {
 	base   string
 	user   string
 	passwd string
+	tls    string      // 加密方式 LDAP_TLS_*
+	tlsCfg *tls.Config // tls != LDAP_TLS_NONE时有效
+	schema *LdapSchema // 属性映射
+	pool   *ldapPool
+	pager  *ldapPager      // 分页查询会话
+	ctx    context.Context // BindContext绑定的context 可为nil
+
+	archiveOu    string        // 归档容器名称
+	archiveBase  string        // 归档容器dn
+	retention    time.Duration // 归档保留期
+	positionOu   string        // 岗位容器名称
+	positionBase string        // 岗位容器dn
 }
 
-/****************** For Comment *****************
-// LdapOrgMap
-var LdapOrgMap = map[string]string{
-	"l": "Pid",
-	"physicalDeliveryOfficeName": "Name",
-	"businessCategory":           "Type",
-	"street":                     "Mid",
-	"st":                         "Id",
-	"description":                "IsDefault",
+// newLdapDepTree 根据配置生成ldapDepTree 配置不完整或无效返回ErrInvalidArgument
+// ArchiveOu为Base下的归档容器名称 默认deptree-archive
+// PositionOu为Base下的岗位容器名称 默认deptree-positions
+func newLdapDepTree(config map[string]interface{}) (*ldapDepTree, error) {
+	host, _ := config["Host"].(string)
+	port, _ := config["Port"].(float64)
+	base, ok1 := config["Base"].(string)
+	user, ok2 := config["User"].(string)
+	passwd, ok3 := config["Password"].(string)
+	if host == "" || port <= 0 || !ok1 || !ok2 || !ok3 {
+		return nil, newError(ErrInvalidArgument, "", "ldap: Host Port Base User Password are required")
+	}
+	tree := &ldapDepTree{
+		host:   host,
+		port:   int(port),
+		base:   base,
+		user:   user,
+		passwd: passwd,
+	}
+	schema, err := newLdapSchema(config["Schema"])
+	if err != nil {
+		return nil, &Error{Kind: ErrInvalidArgument, Msg: "ldap: invalid Schema", Err: err}
+	}
+	tree.schema = schema
+	tree.tls, _ = config["TLS"].(string)
+	switch tree.tls {
+	case LDAP_TLS_NONE:
+	case LDAP_TLS_LDAPS, LDAP_TLS_STARTTLS:
+		tlsCfg, err := newTLSConfig(config, tree.host)
+		if err != nil {
+			return nil, &Error{Kind: ErrInvalidArgument, Msg: "ldap: invalid TLS config", Err: err}
+		}
+		tree.tlsCfg = tlsCfg
+	default:
+		return nil, newError(ErrInvalidArgument, "", "ldap: unsupported TLS: %s", tree.tls)
+	}
+	tree.archiveOu, _ = config["ArchiveOu"].(string)
+	if tree.archiveOu == "" {
+		tree.archiveOu = "deptree-archive"
+	}
+	tree.archiveBase = dnJoin(dnRdn("ou", tree.archiveOu), tree.base)
+	tree.retention = archiveRetention(config)
+	tree.positionOu, _ = config["PositionOu"].(string)
+	if tree.positionOu == "" {
+		tree.positionOu = "deptree-positions"
+	}
+	tree.positionBase = dnJoin(dnRdn("ou", tree.positionOu), tree.base)
+	tree.pool = newLdapPool(config, tree.dial)
+	tree.pager = newLdapPager(config, tree.pool)
+	return tree, nil
 }
 
-// LdapStaffMap
-var LdapStaffMap = map[string]string{
-	"uid":              "Uid",
-	"employeeNumber":   "Sid",
-	"l":                "Pid",
-	"o":                "Mid",
-	"title":      "Positions",
+// ldapDepTree.withContext 私有函数 返回绑定ctx的副本，共享连接池及分页会话
+func (self *ldapDepTree) withContext(ctx context.Context) DepTree {
+	tree := *self
+	tree.ctx = ctx
+	return &tree
 }
-************************************************/
-
-// 从ldap.entry转化成orgnode
-func ldap2orgnode(entry *ldap.Entry, node *OrgNode) {
-	node.Mid = entry.GetAttributeValue("street")
-	node.Pid = entry.GetAttributeValue("l")
-	node.Id = entry.GetAttributeValue("st")
-	node.Name = entry.GetAttributeValue("ou")
-	node.Type, _ = strconv.Atoi(entry.GetAttributeValue("businessCategory"))
-	node.IsDefault, _ = strconv.ParseBool(entry.GetAttributeValue("description"))
 
+// ldapDepTree.connect 私有函数 从连接池取得已绑定的连接，使用完毕需调用release归还
+// 绑定了context时，截止时间作为连接的请求超时，取消时关闭连接中止进行中的请求
+func (self *ldapDepTree) connect() (*ldap.Conn, error) {
+	return self.pool.get(self.ctx)
 }
 
-// 从ldap.entry转化成leafnode
-func ldap2leafnode(entry *ldap.Entry, node *LeafNode) {
-	node.Sid = entry.GetAttributeValue("employeeNumber")
-	node.Mid = entry.GetAttributeValue("o")
-	node.Pid = entry.GetAttributeValue("l")
-	node.Uid = entry.GetAttributeValue("uid")
-	node.Positions = entry.GetAttributeValues("title")
+// ldapDepTree.connectTree 私有函数 取得连接并查找mid对应的顶级树dn
+// 取得的空闲连接可能已被服务端断开，查找出现网络错误或连接已关闭时换用新建的连接重试一次
+// 返回的连接非nil时(包括出错)需调用release归还
+func (self *ldapDepTree) connectTree(mid string) (*ldap.Conn, string, error) {
+	conn, err := self.connect()
+	if conn == nil {
+		return nil, "", err
+	}
+	tree_dn, err := self.getTopTreeDn(mid, conn)
+	if err != nil && (isNetworkError(err) || conn.IsClosing()) {
+		if conn, err = self.pool.redial(self.ctx, conn); conn == nil {
+			return nil, "", err
+		}
+		tree_dn, err = self.getTopTreeDn(mid, conn)
+	}
+	return conn, tree_dn, err
+}
 
+// ldapDepTree.release 私有函数 归还连接 err为操作返回的错误，网络错误时连接被关闭而不放回连接池
+// 用法：defer self.release(conn, &err)
+func (self *ldapDepTree) release(conn *ldap.Conn, err *error) {
+	self.pool.put(conn, *err)
 }
 
-// ldapDepTree.connect 私有函数 连接ldap服务
-func (self *ldapDepTree) connect() (*ldap.Conn, error) {
-	l, err := ldap.Dial("tcp", fmt.Sprintf("%s:%d", self.host, self.port))
+// ldapDepTree.dial 私有函数 新建连接(按配置加密)并绑定
+// ctx的截止时间作为建立TCP连接及StartTLS Bind的超时，取消时关闭连接中止TLS握手及绑定
+func (self *ldapDepTree) dial(ctx context.Context) (*ldap.Conn, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	addr := fmt.Sprintf("%s:%d", self.host, self.port)
+	var dialer net.Dialer
+	c, err := dialer.DialContext(ctx, "tcp", addr)
 	if err != nil {
-		return nil, err
+		return nil, ldap.NewError(ldap.ErrorNetwork, err)
+	}
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				c.Close()
+			case <-stop:
+			}
+		}()
+	}
+	if self.tls == LDAP_TLS_LDAPS {
+		tc := tls.Client(c, self.tlsCfg)
+		if err = tc.Handshake(); err != nil {
+			c.Close()
+			return nil, ldap.NewError(ldap.ErrorNetwork, err)
+		}
+		c = tc
+	}
+	l := ldap.NewConn(c, self.tls == LDAP_TLS_LDAPS)
+	l.Start()
+	l.SetTimeout(requestTimeout(ctx, 0))
+	if self.tls == LDAP_TLS_STARTTLS {
+		err = l.StartTLS(self.tlsCfg)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
 	}
 	err = l.Bind(self.user, self.passwd)
 	if err != nil {
 		l.Close()
 		return nil, err
 	}
+	l.SetTimeout(0)
 	return l, nil
 }
 
+// ldapError 将ldap错误映射为DepTree错误类别 用法：defer ldapError("AddOrgNode", &err)
+func ldapError(op string, err *error) {
+	if *err == nil || fillOp(*err, op) {
+		return
+	}
+	kind := ErrBackend
+	var e *ldap.Error
+	if isContextError(*err) {
+		kind = ErrCanceled
+	} else if errors.As(*err, &e) {
+		switch e.ResultCode {
+		case ldap.LDAPResultNoSuchObject:
+			kind = ErrNotFound
+		case ldap.LDAPResultEntryAlreadyExists:
+			kind = ErrAlreadyExists
+		case ldap.LDAPResultInvalidDNSyntax, ldap.LDAPResultNamingViolation,
+			ldap.LDAPResultObjectClassViolation, ldap.LDAPResultConstraintViolation,
+			ldap.LDAPResultInvalidAttributeSyntax, ldap.LDAPResultUndefinedAttributeType,
+			ldap.LDAPResultNotAllowedOnNonLeaf, ldap.LDAPResultNotAllowedOnRDN,
+			ldap.ErrorFilterCompile:
+			kind = ErrInvalidArgument
+		case ldap.LDAPResultBusy, ldap.LDAPResultUnavailable,
+			ldap.LDAPResultInvalidCredentials, ldap.LDAPResultTimeLimitExceeded,
+			ldap.ErrorNetwork:
+			kind = ErrBackendUnavailable
+		}
+	} else {
+		var ne net.Error
+		if errors.As(*err, &ne) {
+			kind = ErrBackendUnavailable
+		}
+	}
+	*err = wrapError(kind, op, *err)
+}
+
+// parentDn 取dn的上级dn
+func parentDn(dn string) string {
+	_, parent := dnSplit(dn)
+	return parent
+}
+
 // getTopTree 根据mid获取顶级树的dn
 func (self *ldapDepTree) getTopTreeDn(mid string, conn *ldap.Conn) (string, error) {
 	//log.Println(self.base)
 	searchReq := ldap.NewSearchRequest(self.base, ldap.ScopeSingleLevel,
 		ldap.NeverDerefAliases,
-		0, 0, false, fmt.Sprintf("(&(Objectclass=organizationalUnit)(st=%s))", mid), []string{"dn"}, nil)
+		0, 0, false, self.schema.orgIdFilter(mid), []string{"dn"}, nil)
 	sr, err := conn.Search(searchReq)
 	if err != nil {
 		return "", err
 	}
 	if sr == nil || len(sr.Entries) == 0 {
-		return "", fmt.Errorf("Can't find the top tree with this mid: %s", mid)
+		return "", errNotFound("", "Can't find the top tree with this mid: %s", mid)
 	}
 	return sr.Entries[0].DN, nil
 }
@@ -95,13 +236,13 @@ func (self *ldapDepTree) getTopTreeDn(mid string, conn *ldap.Conn) (string, erro
 func (self *ldapDepTree) getSubTreeDn(tree_dn string, id string, conn *ldap.Conn) (string, error) {
 	searchReq := ldap.NewSearchRequest(tree_dn, ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases,
-		0, 0, false, fmt.Sprintf("(&(Objectclass=organizationalUnit)(st=%s))", id), []string{"dn"}, nil)
+		0, 0, false, self.schema.orgIdFilter(id), []string{"dn"}, nil)
 	sr, err := conn.Search(searchReq)
 	if err != nil {
 		return "", err
 	}
 	if sr == nil || len(sr.Entries) == 0 {
-		return "", fmt.Errorf("Can't find the node with this id: %s", id)
+		return "", errNotFound("", "Can't find the node with this id: %s", id)
 	}
 	return sr.Entries[0].DN, nil
 }
@@ -111,8 +252,11 @@ func (self *ldapDepTree) delTree(dn string, conn *ldap.Conn) error {
 	// 搜索该节点下层的叶子节点
 	searchReq := ldap.NewSearchRequest(dn, ldap.ScopeSingleLevel,
 		ldap.NeverDerefAliases,
-		0, 0, false, "(ObjectClass=posixAccount)", []string{"dn"}, nil)
+		0, 0, false, self.schema.leafFilter(""), []string{"dn"}, nil)
 	sr, err := conn.Search(searchReq)
+	if err != nil {
+		return err
+	}
 	// 删除叶子
 	for _, e := range sr.Entries {
 		delReq := ldap.NewDelRequest(e.DN, nil)
@@ -125,8 +269,11 @@ func (self *ldapDepTree) delTree(dn string, conn *ldap.Conn) error {
 	// 搜索该节点下首层非叶子节点
 	searchReq = ldap.NewSearchRequest(dn, ldap.ScopeSingleLevel,
 		ldap.NeverDerefAliases,
-		0, 0, false, "(ObjectClass=organizationalUnit)", []string{"dn"}, nil)
+		0, 0, false, self.schema.orgFilter(""), []string{"dn"}, nil)
 	sr, err = conn.Search(searchReq)
+	if err != nil {
+		return err
+	}
 	// 删除子节点
 	for _, e := range sr.Entries {
 		err = self.delTree(e.DN, conn)
@@ -140,44 +287,85 @@ func (self *ldapDepTree) delTree(dn string, conn *ldap.Conn) error {
 	return err
 }
 
-// getSubTree 根据ldap节点获取树信息(递归)
-func (self *ldapDepTree) getSubTree(entry *ldap.Entry, conn *ldap.Conn) OrgTree {
-	ret := OrgTree{
-		SubTrees: []OrgTree{},
-		SubLeafs: []LeafNode{},
+// checkOrgId 检查id未被商户树中的组织节点或已归档的组织节点使用
+func (self *ldapDepTree) checkOrgId(mid string, tree_dn string, id string, conn *ldap.Conn) error {
+	for _, base := range []string{tree_dn, self.archiveDn(mid)} {
+		searchReq := ldap.NewSearchRequest(base, ldap.ScopeWholeSubtree,
+			ldap.NeverDerefAliases,
+			0, 0, false, self.schema.orgIdFilter(id), []string{"dn"}, nil)
+		sr, err := conn.Search(searchReq)
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(sr.Entries) > 0 {
+			return newError(ErrAlreadyExists, "", "node already exists with this id: %s", id)
+		}
 	}
-	ldap2orgnode(entry, &ret.OrgNode)
+	return nil
+}
 
-	// 搜索该节点下层的叶子节点
-	searchReq := ldap.NewSearchRequest(entry.DN, ldap.ScopeSingleLevel,
+// getSubTree 根据ldap节点获取树信息
+// 组织节点及叶子各进行一次整棵子树搜索，根据dn确定上下级关系后在内存中组装
+func (self *ldapDepTree) getSubTree(entry *ldap.Entry, conn *ldap.Conn) (OrgTree, error) {
+	root := OrgNode{}
+	self.schema.ldap2orgnode(entry, &root)
+
+	// 搜索该节点下的全部非叶子节点(包含自身)
+	searchReq := ldap.NewSearchRequest(entry.DN, ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases,
-		0, 0, false, "(ObjectClass=posixAccount)",
-		[]string{"uid", "l", "o", "title", "employeeNumber"}, nil)
-	sr, _ := conn.Search(searchReq)
-	// 处理叶子
-	for _, e := range sr.Entries {
-		leaf := LeafNode{}
-		ldap2leafnode(e, &leaf)
-		ret.SubLeafs = append(ret.SubLeafs, leaf)
+		0, 0, false, self.schema.orgFilter(""),
+		self.schema.orgAttrList(), nil)
+	orgs, err := conn.Search(searchReq)
+	if err != nil {
+		return OrgTree{}, err
 	}
-
-	// 搜索该节点下首层非叶子节点
-	searchReq = ldap.NewSearchRequest(entry.DN, ldap.ScopeSingleLevel,
+	// 搜索该节点下的全部叶子节点
+	searchReq = ldap.NewSearchRequest(entry.DN, ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases,
-		0, 0, false, "(ObjectClass=organizationalUnit)",
-		[]string{"l", "ou", "businessCategory", "street", "st", "description"},
-		nil)
-	sr, _ = conn.Search(searchReq)
-	// 处理子节点
-	for _, e := range sr.Entries {
-		subtree := self.getSubTree(e, conn)
-		ret.SubTrees = append(ret.SubTrees, subtree)
+		0, 0, false, self.schema.leafFilter(""),
+		self.schema.leafAttrList(), nil)
+	leafs, err := conn.Search(searchReq)
+	if err != nil {
+		return OrgTree{}, err
+	}
+
+	// dn(规范形式，见dnKey) -> 节点ID
+	root_key := dnKey(entry.DN)
+	ids := map[string]string{root_key: root.Id}
+	nodes := make([]OrgNode, len(orgs.Entries))
+	keys := make([]string, len(orgs.Entries))
+	for i, e := range orgs.Entries {
+		self.schema.ldap2orgnode(e, &nodes[i])
+		keys[i] = dnKey(e.DN)
+		ids[keys[i]] = nodes[i].Id
+	}
+	children := map[string][]OrgNode{}
+	for i, e := range orgs.Entries {
+		if keys[i] == root_key {
+			continue
+		}
+		pid := ids[dnKey(parentDn(e.DN))]
+		children[pid] = append(children[pid], nodes[i])
+	}
+	subleafs := map[string][]LeafNode{}
+	for _, e := range leafs.Entries {
+		leaf := LeafNode{}
+		self.schema.ldap2leafnode(e, &leaf)
+		pid := ids[dnKey(parentDn(e.DN))]
+		subleafs[pid] = append(subleafs[pid], leaf)
 	}
-	return ret
+	return assembleTree(root, children, subleafs), nil
 }
 
 // AddOrgNode 新建组织节点
-func (self *ldapDepTree) AddOrgNode(node OrgNode) (string, error) {
+func (self *ldapDepTree) AddOrgNode(node OrgNode) (_ string, err error) {
+	defer ldapError("AddOrgNode", &err)
+	if node.Attrs, err = checkAttrs(node.Attrs); err != nil {
+		return "", err
+	}
 	// 获取ID
 	id := node.Id
 	mid := node.Mid
@@ -189,11 +377,18 @@ func (self *ldapDepTree) AddOrgNode(node OrgNode) (string, error) {
 	if conn == nil {
 		return "", err
 	}
-	defer conn.Close()
+	defer self.release(conn, &err)
 
 	if pid == "" {
 		//插入顶级节点(ID使用传入的mid)
-		dn = fmt.Sprintf("ou=%s,%s", name, self.base)
+		_, err = self.getTopTreeDn(mid, conn)
+		if err == nil {
+			return "", newError(ErrAlreadyExists, "", "top tree already exists with this mid: %s", mid)
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return "", err
+		}
+		dn = dnJoin(self.schema.orgRdn(name), self.base)
 		id = mid
 	} else {
 		// 搜索mid对应的树
@@ -214,24 +409,23 @@ func (self *ldapDepTree) AddOrgNode(node OrgNode) (string, error) {
 		// 生成dn
 		if id == "" {
 			id = GetId()
-		}
-		dn = fmt.Sprintf("ou=%s,%s", name, parent_dn)
-		if err != nil {
+		} else if err = self.checkOrgId(mid, tree_dn, id, conn); err != nil {
 			return "", err
 		}
+		dn = dnJoin(self.schema.orgRdn(name), parent_dn)
+		if node.Order == 0 {
+			node.Order, err = self.nextOrder(parent_dn, self.schema.orgFilter(""), self.schema.orgAttr("Order"), conn)
+			if err != nil {
+				return "", err
+			}
+		}
 	}
 	// 插入
-	addReq := ldap.NewAddRequest(dn)
-	addReq.Attribute("Objectclass", []string{"organizationalUnit"})
-	if pid != "" {
-		addReq.Attribute("l", []string{pid})
-	}
-	addReq.Attribute("street", []string{node.Mid})
-	addReq.Attribute("ou", []string{node.Name})
-	addReq.Attribute("businessCategory", []string{strconv.Itoa(node.Type)})
-	addReq.Attribute("st", []string{id})
-	addReq.Attribute("description", []string{strconv.FormatBool(node.IsDefault)})
+	addReq := self.schema.orgAddRequest(dn, node, id)
 	err = conn.Add(addReq)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+		return "", &Error{Kind: ErrDuplicateName, Msg: "node already exists with this name: " + name, Err: err}
+	}
 	if err != nil {
 		return "", err
 	}
@@ -239,25 +433,35 @@ func (self *ldapDepTree) AddOrgNode(node OrgNode) (string, error) {
 }
 
 // ModifyOrgNode 修改组织信息
-func (self *ldapDepTree) ModifyOrgNode(node OrgNode) error {
+func (self *ldapDepTree) ModifyOrgNode(node OrgNode) (err error) {
+	defer ldapError("ModifyOrgNode", &err)
 	id := node.Id
 	mid := node.Mid
 	if id == "" || mid == "" {
-		return fmt.Errorf("invalid id or mid [%s,%s]", id, mid)
+		return newError(ErrInvalidArgument, "", "invalid id or mid [%s,%s]", id, mid)
+	}
+	if node.Attrs, err = checkAttrs(node.Attrs); err != nil {
+		return err
 	}
 	conn, err := self.connect()
 	if conn == nil {
 		return err
 	}
-	defer conn.Close()
+	defer self.release(conn, &err)
 
 	var dn string // 待更新节点路径标识
 	if id == mid {
 		// 顶级节点
-		dn = fmt.Sprintf("ou=%s,%s", node.Name, self.base)
+		dn, err = self.getTopTreeDn(mid, conn)
+		if err != nil {
+			return err
+		}
 	} else {
 		// 搜索mid对应的树
 		tree_dn, err := self.getTopTreeDn(mid, conn)
+		if err != nil {
+			return err
+		}
 		// 获得需更新节点的dn
 		dn, err = self.getSubTreeDn(tree_dn, id, conn)
 		if err != nil {
@@ -265,22 +469,74 @@ func (self *ldapDepTree) ModifyOrgNode(node OrgNode) error {
 		}
 	}
 
-	newdn := fmt.Sprintf("ou=%s", node.Name)
-	modDNReq := ldap.NewModifyDNRequest(dn, newdn, true, "")
-	err = conn.ModifyDN(modDNReq)
+	if node.Name != "" {
+		newdn := self.schema.orgRdn(node.Name)
+		modDNReq := ldap.NewModifyDNRequest(dn, newdn, true, "")
+		err = conn.ModifyDN(modDNReq)
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) {
+			return &Error{Kind: ErrDuplicateName, Msg: "node already exists with this name: " + node.Name, Err: err}
+		}
+		if err != nil {
+			return err
+		}
+		dn = dnJoin(newdn, parentDn(dn))
+	}
+	// Name映射的属性不是RDN属性时需单独更新
+	modReq := ldap.NewModifyRequest(dn)
+	replaced := false
+	if node.Name != "" {
+		for _, attr := range self.schema.OrgAttrs["Name"] {
+			if attr != self.schema.OrgRdn {
+				modReq.Replace(attr, []string{node.Name})
+				replaced = true
+			}
+		}
+	}
+	if node.Attrs != nil {
+		if err = self.modifyAttrs(modReq, dn, self.schema.OrgAttrs["Attrs"], node.Attrs, conn); err != nil {
+			return err
+		}
+		replaced = true
+	}
+	if replaced {
+		err = conn.Modify(modReq)
+	}
 	return err
 }
 
+// modifyAttrs 向修改请求中加入扩展属性 配置了AttrClass且条目缺少该objectClass时一并补充
+func (self *ldapDepTree) modifyAttrs(modReq *ldap.ModifyRequest, dn string, mapped []string,
+	attrs map[string][]string, conn *ldap.Conn) error {
+	if self.schema.AttrClass != "" && len(attrs) > 0 {
+		searchReq := ldap.NewSearchRequest(dn, ldap.ScopeBaseObject,
+			ldap.NeverDerefAliases,
+			0, 0, false, "(objectClass=*)", []string{"objectClass"}, nil)
+		sr, err := conn.Search(searchReq)
+		if err != nil {
+			return err
+		}
+		if len(sr.Entries) > 0 && !isObjectClass(sr.Entries[0], self.schema.AttrClass) {
+			modReq.Add("objectClass", []string{self.schema.AttrClass})
+		}
+	}
+	values := encodeAttrs(attrs)
+	for _, attr := range mapped {
+		modReq.Replace(attr, values)
+	}
+	return nil
+}
+
 // DelOrgNode 删除组织信息
-func (self *ldapDepTree) DelOrgNode(mid string, id string) error {
+func (self *ldapDepTree) DelOrgNode(mid string, id string) (err error) {
+	defer ldapError("DelOrgNode", &err)
 	if id == "" || mid == "" {
-		return fmt.Errorf("invalid id or mid [%s,%s]", id, mid)
+		return newError(ErrInvalidArgument, "", "invalid id or mid [%s,%s]", id, mid)
 	}
 	conn, err := self.connect()
 	if conn == nil {
 		return err
 	}
-	defer conn.Close()
+	defer self.release(conn, &err)
 
 	var dn string // 待更新节点路径标识
 	// 搜索mid对应的树
@@ -295,24 +551,113 @@ func (self *ldapDepTree) DelOrgNode(mid string, id string) error {
 	}
 
 	err = self.delTree(dn, conn)
+	if err != nil || id != mid {
+		return err
+	}
+	if err = self.delPositions(mid, conn); err != nil {
+		return err
+	}
+	return self.delArchives(mid, conn)
+}
+
+// MoveOrgNode 移动组织节点(包含子树)到新的父节点下
+func (self *ldapDepTree) MoveOrgNode(mid string, id string, newPid string) (err error) {
+	defer ldapError("MoveOrgNode", &err)
+	if id == "" || mid == "" || newPid == "" {
+		return newError(ErrInvalidArgument, "", "invalid id or mid or newPid [%s,%s,%s]", id, mid, newPid)
+	}
+	if id == mid {
+		return newError(ErrInvalidArgument, "", "can't move the top tree: %s", mid)
+	}
+	conn, tree_dn, err := self.connectTree(mid)
+	if conn == nil {
+		return err
+	}
+	defer self.release(conn, &err)
+	if err != nil {
+		return err
+	}
+	// 获得需移动节点及新父节点的dn
+	dn, err := self.getSubTreeDn(tree_dn, id, conn)
+	if err != nil {
+		return err
+	}
+	parent_dn := tree_dn
+	if newPid != mid {
+		parent_dn, err = self.getSubTreeDn(tree_dn, newPid, conn)
+		if err != nil {
+			return err
+		}
+	}
+	old_parent_dn := parentDn(dn)
+	// 服务端返回的dn与拼接的dn写法可能不同，规范化后比较
+	if dnKey(parent_dn) == dnKey(old_parent_dn) {
+		return nil
+	}
+	if dnKey(parent_dn) == dnKey(dn) || dnIsUnder(parent_dn, dn) {
+		return newError(ErrInvalidArgument, "", "can't move node %s into its own subtree", id)
+	}
+
+	// 新父节点下不能存在同名节点
+	searchReq := ldap.NewSearchRequest(dn, ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0, 0, false, self.schema.orgFilter(""), self.schema.orgAttrList(), nil)
+	sr, err := conn.Search(searchReq)
+	if err != nil {
+		return err
+	}
+	if len(sr.Entries) == 0 {
+		return errNotFound("", "Can't find the node with this id: %s", id)
+	}
+	node := OrgNode{}
+	self.schema.ldap2orgnode(sr.Entries[0], &node)
+	searchReq = ldap.NewSearchRequest(parent_dn, ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false, self.schema.orgFieldFilter("Name", node.Name), []string{"dn"}, nil)
+	sr, err = conn.Search(searchReq)
+	if err != nil {
+		return err
+	}
+	if len(sr.Entries) > 0 {
+		return newError(ErrDuplicateName, "", "node already exists with this name: %s", node.Name)
+	}
+	order, err := self.nextOrder(parent_dn, self.schema.orgFilter(""), self.schema.orgAttr("Order"), conn)
+	if err != nil {
+		return err
+	}
+
+	rdn := self.schema.orgRdn(node.Name)
+	modDNReq := ldap.NewModifyDNRequest(dn, rdn, true, parent_dn)
+	err = conn.ModifyDN(modDNReq)
+	if err != nil {
+		return err
+	}
+	// 更新父节点及排序属性，失败时移回原位置
+	modReq := ldap.NewModifyRequest(dnJoin(rdn, parent_dn))
+	for _, attr := range self.schema.OrgAttrs["Pid"] {
+		modReq.Replace(attr, []string{newPid})
+	}
+	for _, attr := range self.schema.OrgAttrs["Order"] {
+		modReq.Replace(attr, []string{strconv.Itoa(order)})
+	}
+	err = conn.Modify(modReq)
+	if err != nil {
+		conn.ModifyDN(ldap.NewModifyDNRequest(dnJoin(rdn, parent_dn), rdn, true, old_parent_dn))
+	}
 	return err
 }
 
 // AddLeafNode 新增叶子节点(角色)
-func (self *ldapDepTree) AddLeafNode(leaf LeafNode) error {
+func (self *ldapDepTree) AddLeafNode(leaf LeafNode) (err error) {
+	defer ldapError("AddLeafNode", &err)
 	mid := leaf.Mid
 	pid := leaf.Pid
 	uid := leaf.Uid
-	sid := leaf.Sid
-	positions := leaf.Positions
-	conn, err := self.connect()
+	conn, tree_dn, err := self.connectTree(mid)
 	if conn == nil {
 		return err
 	}
-	defer conn.Close()
-
-	// 搜索mid对应的树
-	tree_dn, err := self.getTopTreeDn(mid, conn)
+	defer self.release(conn, &err)
 	if err != nil {
 		return err
 	}
@@ -326,49 +671,46 @@ func (self *ldapDepTree) AddLeafNode(leaf LeafNode) error {
 			return err
 		}
 	}
-	// 生成dn
-	dn := fmt.Sprintf("cn=%s,%s", uid, parent_dn)
-
-	addReq := ldap.NewAddRequest(dn)
-	addReq.Attribute("Objectclass", []string{"inetOrgPerson", "posixAccount"})
-	addReq.Attribute("sn", []string{uid})
-	addReq.Attribute("uid", []string{uid})
-	addReq.Attribute("uidNumber", []string{"0"})
-	addReq.Attribute("employeeNumber", []string{sid})
-	addReq.Attribute("cn", []string{uid})
-	addReq.Attribute("homeDirectory", []string{"/"})
-	addReq.Attribute("gidNumber", []string{"0"})
-	addReq.Attribute("l", []string{pid})
-	addReq.Attribute("o", []string{mid})
-	addReq.Attribute("street", []string{mid})
-	// 如果包含角色数据
-	if positions != nil {
-		addReq.Attribute("title", positions)
+	if err = self.checkPositionIds(mid, leaf.Positions, conn); err != nil {
+		return err
+	}
+	if leaf.Attrs, err = checkAttrs(leaf.Attrs); err != nil {
+		return err
 	}
+	if leaf.Order == 0 {
+		leaf.Order, err = self.nextOrder(parent_dn, self.schema.leafFilter(""), self.schema.leafAttr("Order"), conn)
+		if err != nil {
+			return err
+		}
+	}
+	// 生成dn
+	dn := dnJoin(self.schema.leafRdn(uid), parent_dn)
 
+	addReq := self.schema.leafAddRequest(dn, leaf)
 	err = conn.Add(addReq)
 	return err
 
 }
 
-// ModifyLeafNode 修改叶子节点(角色信息) attrs -- {"positions":[]string{r1,r2,r3}}
-func (self *ldapDepTree) ModifyLeafNode(leaf LeafNode) error {
+// ModifyLeafNode 修改叶子节点(角色信息及扩展属性)
+func (self *ldapDepTree) ModifyLeafNode(leaf LeafNode) (err error) {
+	defer ldapError("ModifyLeafNode", &err)
 	mid := leaf.Mid
 	pid := leaf.Pid
 	uid := leaf.Uid
 	positions := leaf.Positions
-	if positions == nil {
-		// 如果无修改角色列表，则直接返回
+	if positions == nil && leaf.Attrs == nil {
+		// 如果无修改角色列表及扩展属性，则直接返回
 		return nil
 	}
-	conn, err := self.connect()
+	if leaf.Attrs, err = checkAttrs(leaf.Attrs); err != nil {
+		return err
+	}
+	conn, tree_dn, err := self.connectTree(mid)
 	if conn == nil {
 		return err
 	}
-	defer conn.Close()
-
-	// 搜索mid对应的树
-	tree_dn, err := self.getTopTreeDn(mid, conn)
+	defer self.release(conn, &err)
 	if err != nil {
 		return err
 	}
@@ -382,11 +724,23 @@ func (self *ldapDepTree) ModifyLeafNode(leaf LeafNode) error {
 			return err
 		}
 	}
+	if err = self.checkPositionIds(mid, positions, conn); err != nil {
+		return err
+	}
 	// 生成dn
-	dn := fmt.Sprintf("cn=%s,%s", uid, parent_dn)
+	dn := dnJoin(self.schema.leafRdn(uid), parent_dn)
 
 	modReq := ldap.NewModifyRequest(dn)
-	modReq.Replace("title", positions)
+	if positions != nil {
+		for _, attr := range self.schema.LeafAttrs["Positions"] {
+			modReq.Replace(attr, positions)
+		}
+	}
+	if leaf.Attrs != nil {
+		if err = self.modifyAttrs(modReq, dn, self.schema.LeafAttrs["Attrs"], leaf.Attrs, conn); err != nil {
+			return err
+		}
+	}
 	err = conn.Modify(modReq)
 	return err
 
@@ -395,15 +749,13 @@ func (self *ldapDepTree) ModifyLeafNode(leaf LeafNode) error {
 // DelStaff 删除员工(角色)
 func (self *ldapDepTree) DelLeafNode(mid string,
 	pid string,
-	uid string) error {
-	conn, err := self.connect()
+	uid string) (err error) {
+	defer ldapError("DelLeafNode", &err)
+	conn, tree_dn, err := self.connectTree(mid)
 	if conn == nil {
 		return err
 	}
-	defer conn.Close()
-
-	// 搜索mid对应的树
-	tree_dn, err := self.getTopTreeDn(mid, conn)
+	defer self.release(conn, &err)
 	if err != nil {
 		return err
 	}
@@ -418,23 +770,169 @@ func (self *ldapDepTree) DelLeafNode(mid string,
 		}
 	}
 	// 生成dn
-	dn := fmt.Sprintf("cn=%s,%s", uid, parent_dn)
+	dn := dnJoin(self.schema.leafRdn(uid), parent_dn)
 
 	delReq := ldap.NewDelRequest(dn, nil)
 	err = conn.Del(delReq)
 	return err
 }
 
+// MoveLeafNode 调动叶子节点 移动entry后更新父节点属性，失败时移回原位置
+func (self *ldapDepTree) MoveLeafNode(mid string, uid string, fromPid string, toPid string) (err error) {
+	defer ldapError("MoveLeafNode", &err)
+	conn, tree_dn, err := self.connectTree(mid)
+	if conn == nil {
+		return err
+	}
+	defer self.release(conn, &err)
+	if err != nil {
+		return err
+	}
+	// 根据树dn搜索原父节点及新父节点dn
+	from_dn, to_dn := tree_dn, tree_dn
+	if fromPid != mid {
+		from_dn, err = self.getSubTreeDn(tree_dn, fromPid, conn)
+		if err != nil {
+			return err
+		}
+	}
+	if toPid != mid {
+		to_dn, err = self.getSubTreeDn(tree_dn, toPid, conn)
+		if err != nil {
+			return err
+		}
+	}
+	rdn := self.schema.leafRdn(uid)
+	// 确认叶子存在
+	searchReq := ldap.NewSearchRequest(dnJoin(rdn, from_dn), ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		0, 0, false, self.schema.leafFilter(""), []string{"dn"}, nil)
+	_, err = conn.Search(searchReq)
+	if err != nil {
+		return err
+	}
+	if dnKey(from_dn) == dnKey(to_dn) {
+		return nil
+	}
+	order, err := self.nextOrder(to_dn, self.schema.leafFilter(""), self.schema.leafAttr("Order"), conn)
+	if err != nil {
+		return err
+	}
+
+	modDNReq := ldap.NewModifyDNRequest(dnJoin(rdn, from_dn), rdn, true, to_dn)
+	err = conn.ModifyDN(modDNReq)
+	if err != nil {
+		return err
+	}
+	modReq := ldap.NewModifyRequest(dnJoin(rdn, to_dn))
+	for _, attr := range self.schema.LeafAttrs["Pid"] {
+		modReq.Replace(attr, []string{toPid})
+	}
+	for _, attr := range self.schema.LeafAttrs["Order"] {
+		modReq.Replace(attr, []string{strconv.Itoa(order)})
+	}
+	err = conn.Modify(modReq)
+	if err != nil {
+		conn.ModifyDN(ldap.NewModifyDNRequest(dnJoin(rdn, to_dn), rdn, true, from_dn))
+	}
+	return err
+}
+
+// RenameLeafNode 修改uid在商户内全部叶子(包含归档容器中的叶子)的uid
+// 逐个组织节点修改RDN后更新Uid映射的属性，单个节点失败时移回原RDN并继续处理其他节点
+func (self *ldapDepTree) RenameLeafNode(mid string, oldUid string, newUid string) (err error) {
+	defer ldapError("RenameLeafNode", &err)
+	if oldUid == "" || newUid == "" {
+		return newError(ErrInvalidArgument, "", "invalid uid [%s,%s]", oldUid, newUid)
+	}
+	conn, tree_dn, err := self.connectTree(mid)
+	if conn == nil {
+		return err
+	}
+	defer self.release(conn, &err)
+	if err != nil {
+		return err
+	}
+	leafs, err := self.searchLeafsByUid(tree_dn, mid, oldUid, conn)
+	if err != nil {
+		return err
+	}
+	if len(leafs) == 0 {
+		return errNotFound("", "Can't find the leaf with this uid: %s", oldUid)
+	}
+	if oldUid == newUid {
+		return nil
+	}
+	exists, err := self.searchLeafsByUid(tree_dn, mid, newUid, conn)
+	if err != nil {
+		return err
+	}
+	if len(exists) > 0 {
+		return newError(ErrAlreadyExists, "", "leaf already exists with this uid: %s", newUid)
+	}
+
+	rdn := self.schema.leafRdn(newUid)
+	done := []string{}
+	failed := []PartialFailure{}
+	for _, e := range leafs {
+		pid := e.GetAttributeValue(self.schema.leafAttr("Pid"))
+		err := self.renameLeaf(e.DN, rdn, newUid, conn)
+		if err != nil {
+			ldapError("", &err)
+			failed = append(failed, PartialFailure{Target: pid, Err: err})
+			continue
+		}
+		done = append(done, pid)
+	}
+	return partialError(done, failed)
+}
+
+// searchLeafsByUid 搜索商户树及归档容器中uid对应的叶子
+func (self *ldapDepTree) searchLeafsByUid(tree_dn string, mid string, uid string, conn *ldap.Conn) ([]*ldap.Entry, error) {
+	ret := []*ldap.Entry{}
+	for _, base := range []string{tree_dn, self.archiveDn(mid)} {
+		searchReq := ldap.NewSearchRequest(base, ldap.ScopeWholeSubtree,
+			ldap.NeverDerefAliases,
+			0, 0, false, self.schema.leafFieldFilter("Uid", uid),
+			[]string{self.schema.leafAttr("Pid")}, nil)
+		sr, err := conn.Search(searchReq)
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) && base != tree_dn {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, sr.Entries...)
+	}
+	return ret, nil
+}
+
+// renameLeaf 修改叶子的RDN及Uid映射的属性 更新属性失败时移回原RDN
+func (self *ldapDepTree) renameLeaf(dn string, rdn string, uid string, conn *ldap.Conn) error {
+	old_rdn, parent_dn := dnSplit(dn)
+	err := conn.ModifyDN(ldap.NewModifyDNRequest(dn, rdn, true, ""))
+	if err != nil {
+		return err
+	}
+	modReq := ldap.NewModifyRequest(dnJoin(rdn, parent_dn))
+	for _, attr := range self.schema.LeafAttrs["Uid"] {
+		modReq.Replace(attr, []string{uid})
+	}
+	err = conn.Modify(modReq)
+	if err != nil {
+		conn.ModifyDN(ldap.NewModifyDNRequest(dnJoin(rdn, parent_dn), old_rdn, true, ""))
+	}
+	return err
+}
+
 // GetLeafNodes
-func (self *ldapDepTree) GetLeafNodes(mid string, oid string, uid string) ([]LeafNode, error) {
-	conn, err := self.connect()
+func (self *ldapDepTree) GetLeafNodes(mid string, oid string, uid string) (_ []LeafNode, err error) {
+	defer ldapError("GetLeafNodes", &err)
+	conn, tree_dn, err := self.connectTree(mid)
 	if conn == nil {
 		return nil, err
 	}
-	defer conn.Close()
-
-	// 搜索mid对应的树
-	tree_dn, err := self.getTopTreeDn(mid, conn)
+	defer self.release(conn, &err)
 	if err != nil {
 		return nil, err
 	}
@@ -442,20 +940,23 @@ func (self *ldapDepTree) GetLeafNodes(mid string, oid string, uid string) ([]Lea
 	// 根据oid搜索该树下的orgnode
 	searchReq := ldap.NewSearchRequest(tree_dn, ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases,
-		0, 0, false, fmt.Sprintf("(&(ObjectClass=organizationalUnit)(st=%s))", oid),
-		[]string{"l", "ou", "businessCategory", "street", "st", "description"},
+		0, 0, false, self.schema.orgIdFilter(oid),
+		self.schema.orgAttrList(),
 		nil)
 	sr, err := conn.Search(searchReq)
-	if err != nil || len(sr.Entries) <= 0 {
+	if err != nil {
 		return nil, err
 	}
+	if len(sr.Entries) == 0 {
+		return nil, errNotFound("", "Can't find the node with this id: %s", oid)
+	}
 	org_dn := sr.Entries[0].DN
 
 	// 根据uid搜索该树下的全部结果集
 	searchReq = ldap.NewSearchRequest(org_dn, ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases,
-		0, 0, false, fmt.Sprintf("(&(ObjectClass=posixAccount)(uid=%s))", uid),
-		[]string{"uid", "l", "o", "title", "employeeNumber"}, nil)
+		0, 0, false, self.schema.leafFieldFilter("Uid", uid),
+		self.schema.leafAttrList(), nil)
 	sr, err = conn.Search(searchReq)
 	if err != nil {
 		return nil, err
@@ -464,42 +965,43 @@ func (self *ldapDepTree) GetLeafNodes(mid string, oid string, uid string) ([]Lea
 	ret := []LeafNode{}
 	for _, e := range sr.Entries {
 		oneleaf := LeafNode{}
-		ldap2leafnode(e, &oneleaf)
+		self.schema.ldap2leafnode(e, &oneleaf)
 		ret = append(ret, oneleaf)
 	}
 	return ret, nil
 }
 
 // GetLeafNodesByOrg
-func (self *ldapDepTree) GetLeafNodesByOrg(mid string, oid string) ([]LeafNode, error) {
-	conn, err := self.connect()
+func (self *ldapDepTree) GetLeafNodesByOrg(mid string, oid string) (_ []LeafNode, err error) {
+	defer ldapError("GetLeafNodesByOrg", &err)
+	conn, tree_dn, err := self.connectTree(mid)
 	if conn == nil {
 		return nil, err
 	}
-	defer conn.Close()
-
-	// 搜索mid对应的树
-	tree_dn, err := self.getTopTreeDn(mid, conn)
+	defer self.release(conn, &err)
 	if err != nil {
 		return nil, err
 	}
 	// 根据oid搜索该树下的orgnode
 	searchReq := ldap.NewSearchRequest(tree_dn, ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases,
-		0, 0, false, fmt.Sprintf("(&(ObjectClass=organizationalUnit)(st=%s))", oid),
-		[]string{"l", "ou", "businessCategory", "street", "st", "description"},
+		0, 0, false, self.schema.orgIdFilter(oid),
+		self.schema.orgAttrList(),
 		nil)
 	sr, err := conn.Search(searchReq)
-	if err != nil || len(sr.Entries) <= 0 {
+	if err != nil {
 		return nil, err
 	}
+	if len(sr.Entries) == 0 {
+		return nil, errNotFound("", "Can't find the node with this id: %s", oid)
+	}
 	org_dn := sr.Entries[0].DN
 
 	// 搜索该org下的全部leafnode
 	searchReq = ldap.NewSearchRequest(org_dn, ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases,
-		0, 0, false, "(ObjectClass=posixAccount)",
-		[]string{"uid", "l", "o", "title", "employeeNumber"}, nil)
+		0, 0, false, self.schema.leafFilter(""),
+		self.schema.leafAttrList(), nil)
 	sr, err = conn.Search(searchReq)
 	if err != nil {
 		return nil, err
@@ -509,64 +1011,66 @@ func (self *ldapDepTree) GetLeafNodesByOrg(mid string, oid string) ([]LeafNode,
 	for _, e := range sr.Entries {
 
 		oneleaf := LeafNode{}
-		ldap2leafnode(e, &oneleaf)
+		self.schema.ldap2leafnode(e, &oneleaf)
 		ret = append(ret, oneleaf)
 	}
 	return ret, nil
 }
 
 // GetOrgNode
-func (self *ldapDepTree) GetOrgNode(mid string, id string) (*OrgNode, error) {
-	conn, err := self.connect()
+func (self *ldapDepTree) GetOrgNode(mid string, id string) (_ *OrgNode, err error) {
+	defer ldapError("GetOrgNode", &err)
+	conn, tree_dn, err := self.connectTree(mid)
 	if conn == nil {
 		return nil, err
 	}
-	defer conn.Close()
-
-	// 搜索mid对应的树
-	tree_dn, err := self.getTopTreeDn(mid, conn)
+	defer self.release(conn, &err)
 	if err != nil {
 		return nil, err
 	}
 	// 根据id搜索该树下的全部结果集
 	searchReq := ldap.NewSearchRequest(tree_dn, ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases,
-		0, 0, false, fmt.Sprintf("(&(ObjectClass=organizationalUnit)(st=%s))", id),
-		[]string{"l", "ou", "businessCategory", "street", "st", "description"},
+		0, 0, false, self.schema.orgIdFilter(id),
+		self.schema.orgAttrList(),
 		nil)
 	sr, err := conn.Search(searchReq)
-	if err != nil || len(sr.Entries) <= 0 {
+	if err != nil {
 		return nil, err
 	}
+	if len(sr.Entries) == 0 {
+		return nil, errNotFound("", "Can't find the node with this id: %s", id)
+	}
 
 	org := OrgNode{}
-	ldap2orgnode(sr.Entries[0], &org)
+	self.schema.ldap2orgnode(sr.Entries[0], &org)
 	return &org, nil
 }
 
-// GetOrgNodesByOrg
-func (self *ldapDepTree) GetOrgNodesByOrg(mid string, oid string, dept int) ([]OrgNode, error) {
-	conn, err := self.connect()
+// GetOrgNodesByOrg dept==1时仅取下一级节点，否则取整棵子树(包含自身) 同级按顺序排列
+func (self *ldapDepTree) GetOrgNodesByOrg(mid string, oid string, dept int) (_ []OrgNode, err error) {
+	defer ldapError("GetOrgNodesByOrg", &err)
+	conn, tree_dn, err := self.connectTree(mid)
 	if conn == nil {
 		return nil, err
 	}
-	defer conn.Close()
-
-	// 搜索mid对应的树
-	tree_dn, err := self.getTopTreeDn(mid, conn)
+	defer self.release(conn, &err)
 	if err != nil {
 		return nil, err
 	}
 	// 根据oid搜索该树下的orgnode
 	searchReq := ldap.NewSearchRequest(tree_dn, ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases,
-		0, 0, false, fmt.Sprintf("(&(ObjectClass=organizationalUnit)(st=%s))", oid),
-		[]string{"l", "ou", "businessCategory", "street", "st", "description"},
+		0, 0, false, self.schema.orgIdFilter(oid),
+		self.schema.orgAttrList(),
 		nil)
 	sr, err := conn.Search(searchReq)
-	if err != nil || len(sr.Entries) <= 0 {
+	if err != nil {
 		return nil, err
 	}
+	if len(sr.Entries) == 0 {
+		return nil, errNotFound("", "Can't find the node with this id: %s", oid)
+	}
 	org_dn := sr.Entries[0].DN
 
 	// 搜索该org下的全部orgnode
@@ -576,8 +1080,8 @@ func (self *ldapDepTree) GetOrgNodesByOrg(mid string, oid string, dept int) ([]O
 	}
 	searchReq = ldap.NewSearchRequest(org_dn, sign,
 		ldap.NeverDerefAliases,
-		0, 0, false, "(ObjectClass=organizationalUnit)",
-		[]string{"l", "ou", "businessCategory", "street", "st", "description"}, nil)
+		0, 0, false, self.schema.orgFilter(""),
+		self.schema.orgAttrList(), nil)
 	sr, err = conn.Search(searchReq)
 	if err != nil {
 		return nil, err
@@ -587,50 +1091,61 @@ func (self *ldapDepTree) GetOrgNodesByOrg(mid string, oid string, dept int) ([]O
 	for _, e := range sr.Entries {
 
 		oneorg := OrgNode{}
-		ldap2orgnode(e, &oneorg)
+		self.schema.ldap2orgnode(e, &oneorg)
 		ret = append(ret, oneorg)
 	}
-	return ret, nil
+	if dept == 1 {
+		sortSiblingOrgs(ret)
+		return ret, nil
+	}
+	return orderOrgNodes(oid, ret), nil
 }
 
-// GetSubTree 取树形结构
-func (self *ldapDepTree) GetSubTree(mid string, id string) (*OrgTree, error) {
-	conn, err := self.connect()
+// GetSubTree 取树形结构 搜索次数固定，与子树规模无关
+func (self *ldapDepTree) GetSubTree(mid string, id string) (_ *OrgTree, err error) {
+	defer ldapError("GetSubTree", &err)
+	conn, tree_dn, err := self.connectTree(mid)
 	if conn == nil {
 		return nil, err
 	}
-	defer conn.Close()
-
-	// 搜索mid对应的树
-	tree_dn, err := self.getTopTreeDn(mid, conn)
+	defer self.release(conn, &err)
 	if err != nil {
 		return nil, err
 	}
+	return self.getSubTreeById(tree_dn, id, conn)
+}
+
+// getSubTreeById 根据id取树下的子树
+func (self *ldapDepTree) getSubTreeById(tree_dn string, id string, conn *ldap.Conn) (*OrgTree, error) {
 	// 根据id搜索该树下的组织节点
 	searchReq := ldap.NewSearchRequest(tree_dn, ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases,
-		0, 0, false, fmt.Sprintf("(&(ObjectClass=organizationalUnit)(st=%s))", id),
-		[]string{"l", "ou", "businessCategory", "street", "st", "description"},
+		0, 0, false, self.schema.orgIdFilter(id),
+		self.schema.orgAttrList(),
 		nil)
 	sr, err := conn.Search(searchReq)
-	if err != nil || len(sr.Entries) <= 0 {
+	if err != nil {
 		return nil, err
 	}
+	if len(sr.Entries) == 0 {
+		return nil, errNotFound("", "Can't find the node with this id: %s", id)
+	}
 	// 根据命中的节点取出子树
-	subtree := self.getSubTree(sr.Entries[0], conn)
+	subtree, err := self.getSubTree(sr.Entries[0], conn)
+	if err != nil {
+		return nil, err
+	}
 	return &subtree, nil
 }
 
 // GetUsersByPosition 根据角色查询UID列表
-func (self *ldapDepTree) GetUsersByPosition(mid string, pid string, positionid string) ([]LeafNode, error) {
-	conn, err := self.connect()
+func (self *ldapDepTree) GetUsersByPosition(mid string, pid string, positionid string) (_ []LeafNode, err error) {
+	defer ldapError("GetUsersByPosition", &err)
+	conn, tree_dn, err := self.connectTree(mid)
 	if conn == nil {
 		return nil, err
 	}
-	defer conn.Close()
-
-	// 搜索mid对应的树
-	tree_dn, err := self.getTopTreeDn(mid, conn)
+	defer self.release(conn, &err)
 	if err != nil {
 		return nil, err
 	}
@@ -649,8 +1164,8 @@ func (self *ldapDepTree) GetUsersByPosition(mid string, pid string, positionid s
 	// 根据uid搜索该树下的全部结果集
 	searchReq := ldap.NewSearchRequest(parent_dn, ldap.ScopeWholeSubtree,
 		ldap.NeverDerefAliases,
-		0, 0, false, fmt.Sprintf("(&(ObjectClass=posixAccount)(title=%s))", positionid),
-		[]string{"uid", "l", "o", "title", "employeeNumber"}, nil)
+		0, 0, false, self.schema.leafFieldFilter("Positions", positionid),
+		self.schema.leafAttrList(), nil)
 	sr, err := conn.Search(searchReq)
 	if err != nil {
 		return nil, err
@@ -659,43 +1174,43 @@ func (self *ldapDepTree) GetUsersByPosition(mid string, pid string, positionid s
 	ret := []LeafNode{}
 	for _, e := range sr.Entries {
 		oneleaf := LeafNode{}
-		ldap2leafnode(e, &oneleaf)
+		self.schema.ldap2leafnode(e, &oneleaf)
 		ret = append(ret, oneleaf)
 	}
 	return ret, nil
 }
 
 // GetParents 根据节点id获得全部父节点信息(路径) 从近到远
-func (self *ldapDepTree) GetParents(mid string, id string) ([]OrgNode, error) {
+func (self *ldapDepTree) GetParents(mid string, id string) (_ []OrgNode, err error) {
+	defer ldapError("GetParents", &err)
 	nodelist := []OrgNode{}
 
-	conn, err := self.connect()
+	conn, tree_dn, err := self.connectTree(mid)
 	if conn == nil {
 		return nil, err
 	}
-	defer conn.Close()
-
-	// 搜索mid对应的树
-	tree_dn, err := self.getTopTreeDn(mid, conn)
+	defer self.release(conn, &err)
 	if err != nil {
 		return nil, err
 	}
 	// 根据id搜索该树下的组织节点
 	searchid := id
 	for {
-		log.Println(searchid)
 		searchReq := ldap.NewSearchRequest(tree_dn, ldap.ScopeWholeSubtree,
 			ldap.NeverDerefAliases,
-			0, 0, false, fmt.Sprintf("(&(ObjectClass=organizationalUnit)(st=%s))", searchid),
-			[]string{"l", "ou", "businessCategory", "street", "st", "description"},
+			0, 0, false, self.schema.orgIdFilter(searchid),
+			self.schema.orgAttrList(),
 			nil)
 		sr, err := conn.Search(searchReq)
-		if err != nil || len(sr.Entries) <= 0 {
+		if err != nil {
 			return nil, err
 		}
+		if len(sr.Entries) == 0 {
+			return nil, errNotFound("", "Can't find the node with this id: %s", searchid)
+		}
 		entry := sr.Entries[0]
 		node := OrgNode{}
-		ldap2orgnode(entry, &node)
+		self.schema.ldap2orgnode(entry, &node)
 		nodelist = append(nodelist, node)
 		if node.Id == mid || node.Pid == "" {
 			// 已经搜索到根目录，退出循环
@@ -706,3 +1221,132 @@ func (self *ldapDepTree) GetParents(mid string, id string) ([]OrgNode, error) {
 	return nodelist, nil
 
 }
+
+// Search 在子树中搜索组织节点或叶子
+// 条件转换为ldap过滤条件由服务端筛选，排序及分页在取回结果后完成
+func (self *ldapDepTree) Search(mid string, query SearchQuery) (_ *SearchResult, err error) {
+	defer ldapError("Search", &err)
+	if err = checkSearchQuery(mid, &query); err != nil {
+		return nil, err
+	}
+	conn, tree_dn, err := self.connectTree(mid)
+	if conn == nil {
+		return nil, err
+	}
+	defer self.release(conn, &err)
+	if err != nil {
+		return nil, err
+	}
+	root_dn, err := self.getSubTreeDn(tree_dn, query.Root, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	// 组织节点 搜索叶子时用于限制叶子所在的组织节点
+	orgs := []OrgNode{}
+	if query.Target == SEARCH_ORG || query.hasOrgCond() {
+		searchReq := ldap.NewSearchRequest(root_dn, ldap.ScopeWholeSubtree,
+			ldap.NeverDerefAliases,
+			0, 0, false, self.schema.orgSearchFilter(&query),
+			self.schema.orgAttrList(), nil)
+		sr, err := conn.Search(searchReq)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range sr.Entries {
+			oneorg := OrgNode{}
+			self.schema.ldap2orgnode(e, &oneorg)
+			// 服务端匹配规则可能不同，按统一规则再次筛选
+			if query.matchOrg(&oneorg) {
+				orgs = append(orgs, oneorg)
+			}
+		}
+		if query.Target == SEARCH_ORG {
+			return query.orgResult(orgs), nil
+		}
+	}
+	pids := map[string]bool{}
+	for _, o := range orgs {
+		pids[o.Id] = true
+	}
+
+	searchReq := ldap.NewSearchRequest(root_dn, ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, 0, false, self.schema.leafSearchFilter(&query),
+		self.schema.leafAttrList(), nil)
+	sr, err := conn.Search(searchReq)
+	if err != nil {
+		return nil, err
+	}
+	leafs := []LeafNode{}
+	for _, e := range sr.Entries {
+		oneleaf := LeafNode{}
+		self.schema.ldap2leafnode(e, &oneleaf)
+		if query.hasOrgCond() && !pids[oneleaf.Pid] {
+			continue
+		}
+		if query.matchLeaf(&oneleaf) {
+			leafs = append(leafs, oneleaf)
+		}
+	}
+	return query.leafResult(leafs), nil
+}
+
+// GetMemberships 取uid在商户内的全部叶子节点及各自的父节点路径
+// 一次搜索取回商户下的全部组织节点及uid对应的叶子，根据dn组装父节点路径
+func (self *ldapDepTree) GetMemberships(mid string, uid string) (_ []Membership, err error) {
+	defer ldapError("GetMemberships", &err)
+	conn, tree_dn, err := self.connectTree(mid)
+	if conn == nil {
+		return nil, err
+	}
+	defer self.release(conn, &err)
+	if err != nil {
+		return nil, err
+	}
+	attrs := append(append(self.schema.orgAttrList(), self.schema.leafAttrList()...), "objectClass")
+	searchReq := ldap.NewSearchRequest(tree_dn, ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, 0, false, filterOr(self.schema.orgFilter(""), self.schema.leafFieldFilter("Uid", uid)),
+		attrs, nil)
+	sr, err := conn.Search(searchReq)
+	if err != nil {
+		return nil, err
+	}
+	orgs := map[string]OrgNode{} // dn(规范形式) -> 组织节点
+	leafs := []*ldap.Entry{}
+	for _, e := range sr.Entries {
+		if isObjectClass(e, self.schema.LeafClass) {
+			leafs = append(leafs, e)
+			continue
+		}
+		node := OrgNode{}
+		self.schema.ldap2orgnode(e, &node)
+		orgs[dnKey(e.DN)] = node
+	}
+	ret := []Membership{}
+	for _, e := range leafs {
+		m := Membership{Parents: []OrgNode{}}
+		self.schema.ldap2leafnode(e, &m.Leaf)
+		for dn := parentDn(e.DN); dn != ""; dn = parentDn(dn) {
+			node, ok := orgs[dnKey(dn)]
+			if !ok {
+				break
+			}
+			m.Parents = append(m.Parents, node)
+		}
+		ret = append(ret, m)
+	}
+	sortMemberships(ret)
+	return ret, nil
+}
+
+// isObjectClass 判断条目是否属于objectClass
+func isObjectClass(entry *ldap.Entry, class string) bool {
+	for _, c := range entry.GetAttributeValues("objectClass") {
+		if strings.EqualFold(c, class) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,296 @@
+package deptree
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	ldap "github.com/go-ldap/ldap"
+)
+
+// newTestLdap 启动内存ldap服务并返回连接该服务的ldapDepTree
+func newTestLdap(t testing.TB) (*fakeLdap, *ldapDepTree) {
+	return newTestLdapWith(t, nil)
+}
+
+// newTestLdapWith 同newTestLdap，extra覆盖默认配置
+func newTestLdapWith(t testing.TB, extra map[string]interface{}) (*fakeLdap, *ldapDepTree) {
+	srv := newFakeLdap(t, nil)
+	srv.add("dc=test", []string{"objectClass", "domain"}, []string{"dc", "test"})
+	config := srv.config()
+	for k, v := range extra {
+		config[k] = v
+	}
+	tree, err := newLdapDepTree(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, tree
+}
+
+// seedOrg 按默认schema直接写入组织节点
+func seedOrg(srv *fakeLdap, dn string, node OrgNode) {
+	srv.add(dn,
+		[]string{"objectClass", "organizationalUnit"},
+		[]string{"ou", node.Name},
+		[]string{"street", node.Mid},
+		[]string{"l", node.Pid},
+		[]string{"st", node.Id},
+		[]string{"businessCategory", strconv.Itoa(node.Type)},
+		[]string{"description", strconv.FormatBool(node.IsDefault)},
+		[]string{"postalCode", strconv.Itoa(node.Order)})
+}
+
+// seedLeaf 按默认schema直接写入叶子节点
+func seedLeaf(srv *fakeLdap, dn string, leaf LeafNode) {
+	srv.add(dn,
+		[]string{"objectClass", "inetOrgPerson", "posixAccount"},
+		[]string{"cn", leaf.Uid},
+		[]string{"uid", leaf.Uid},
+		[]string{"sn", leaf.Uid},
+		[]string{"o", leaf.Mid},
+		[]string{"street", leaf.Mid},
+		[]string{"l", leaf.Pid},
+		[]string{"postalCode", strconv.Itoa(leaf.Order)})
+}
+
+// treeParents 子树中 组织节点ID/叶子uid -> 上级组织节点ID
+func treeParents(tree *OrgTree) map[string]string {
+	ret := map[string]string{}
+	var walk func(t *OrgTree)
+	walk = func(t *OrgTree) {
+		for _, l := range t.SubLeafs {
+			ret[l.Uid] = t.Id
+		}
+		for i := range t.SubTrees {
+			ret[t.SubTrees[i].Id] = t.Id
+			walk(&t.SubTrees[i])
+		}
+	}
+	walk(tree)
+	return ret
+}
+
+// 服务器返回的DN与拼接的DN大小写、空格、转义方式不同时仍能正确组装子树
+func TestLdapGetSubTreeDnForms(t *testing.T) {
+	srv, tree := newTestLdap(t)
+	seedOrg(srv, "ou=Top,dc=test", OrgNode{Mid: "m", Id: "m", Name: "Top"})
+	seedOrg(srv, "OU=Sales, ou=Top,DC=test", OrgNode{Mid: "m", Pid: "m", Id: "sales", Name: "Sales"})
+	seedOrg(srv, "ou=East,OU=SALES,ou=top,dc=test", OrgNode{Mid: "m", Pid: "sales", Id: "east", Name: "East"})
+	seedOrg(srv, `ou=R\2CD,ou=Top,dc=test`, OrgNode{Mid: "m", Pid: "m", Id: "rd", Name: "R,D"})
+	seedLeaf(srv, "cn=u1, ou=East, ou=Sales, ou=Top, dc=test", LeafNode{Mid: "m", Pid: "east", Uid: "u1"})
+	seedLeaf(srv, `cn=u2,ou=r\,d,ou=Top,dc=test`, LeafNode{Mid: "m", Pid: "rd", Uid: "u2"})
+	seedLeaf(srv, "cn=u3,ou=top,dc=test", LeafNode{Mid: "m", Pid: "m", Uid: "u3"})
+
+	sub, err := tree.GetSubTree("m", "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"sales": "m", "east": "sales", "rd": "m", "u1": "east", "u2": "rd", "u3": "m"}
+	got := treeParents(sub)
+	if len(got) != len(want) {
+		t.Fatalf("parents = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("parent of %s = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestDnKey(t *testing.T) {
+	same := [][]string{
+		{"ou=Sales,dc=test", "OU=sales, DC=Test"},
+		{`ou=R\,D,dc=test`, `ou=r\2cd,dc=test`},
+		{`cn=a+sn=b,dc=test`, `SN=B+CN=A,dc=test`},
+	}
+	for _, c := range same {
+		if dnKey(c[0]) != dnKey(c[1]) {
+			t.Errorf("dnKey(%q) = %q, dnKey(%q) = %q", c[0], dnKey(c[0]), c[1], dnKey(c[1]))
+		}
+	}
+	if dnKey("ou=a,ou=b,dc=test") == dnKey("ou=a\\,ou=b,dc=test") {
+		t.Error("escaped comma must not split the rdn")
+	}
+}
+
+// seedBenchTree 写入depth层 每层fanout个子节点 每个节点leafs个叶子的树
+func seedBenchTree(srv *fakeLdap, depth, fanout, leafs int) {
+	seedOrg(srv, "ou=top,dc=test", OrgNode{Mid: "m", Id: "m", Name: "top"})
+	var seed func(dn, id string, level int)
+	seed = func(dn, id string, level int) {
+		for i := 0; i < leafs; i++ {
+			uid := fmt.Sprintf("%s-u%d", id, i)
+			seedLeaf(srv, dnJoin(dnRdn("cn", uid), dn), LeafNode{Mid: "m", Pid: id, Uid: uid})
+		}
+		if level == depth {
+			return
+		}
+		for i := 0; i < fanout; i++ {
+			cid := fmt.Sprintf("%s.%d", id, i)
+			cdn := dnJoin(dnRdn("ou", cid), dn)
+			seedOrg(srv, cdn, OrgNode{Mid: "m", Pid: id, Id: cid, Name: cid})
+			seed(cdn, cid, level+1)
+		}
+	}
+	seed("ou=top,dc=test", "m", 0)
+}
+
+// perLevelSubTree 逐层搜索组装子树，即改为整棵子树搜索之前的做法，用于基准对比
+func perLevelSubTree(self *ldapDepTree, entry *ldap.Entry, conn *ldap.Conn) (OrgTree, error) {
+	ret := OrgTree{SubTrees: []OrgTree{}, SubLeafs: []LeafNode{}}
+	self.schema.ldap2orgnode(entry, &ret.OrgNode)
+	searchReq := ldap.NewSearchRequest(entry.DN, ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false, self.schema.leafFilter(""), self.schema.leafAttrList(), nil)
+	sr, err := conn.Search(searchReq)
+	if err != nil {
+		return ret, err
+	}
+	for _, e := range sr.Entries {
+		leaf := LeafNode{}
+		self.schema.ldap2leafnode(e, &leaf)
+		ret.SubLeafs = append(ret.SubLeafs, leaf)
+	}
+	searchReq = ldap.NewSearchRequest(entry.DN, ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false, self.schema.orgFilter(""), self.schema.orgAttrList(), nil)
+	sr, err = conn.Search(searchReq)
+	if err != nil {
+		return ret, err
+	}
+	for _, e := range sr.Entries {
+		sub, err := perLevelSubTree(self, e, conn)
+		if err != nil {
+			return ret, err
+		}
+		ret.SubTrees = append(ret.SubTrees, sub)
+	}
+	return ret, nil
+}
+
+// BenchmarkLdapGetSubTree 整棵子树搜索(2次请求)与逐层搜索(每个组织节点2次请求)的对比
+func BenchmarkLdapGetSubTree(b *testing.B) {
+	srv, tree := newTestLdap(b)
+	seedBenchTree(srv, 3, 5, 3)
+	conn, err := tree.connect()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tree.pool.put(conn, nil)
+	sr, err := conn.Search(ldap.NewSearchRequest("ou=top,dc=test", ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases, 0, 0, false, tree.schema.orgFilter(""), tree.schema.orgAttrList(), nil))
+	if err != nil || len(sr.Entries) != 1 {
+		b.Fatal("top entry not found", err)
+	}
+	root := sr.Entries[0]
+
+	b.Run("subtree", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := tree.getSubTree(root, conn); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("per-level", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := perLevelSubTree(tree, root, conn); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// 指定的Id已被现有或已归档的组织节点使用时返回ErrAlreadyExists
+func TestLdapAddOrgNodeIdInUse(t *testing.T) {
+	srv, tree := newTestLdap(t)
+	seedOrg(srv, "ou=top,dc=test", OrgNode{Mid: "m", Id: "m", Name: "top"})
+	seedOrg(srv, "ou=live,ou=top,dc=test", OrgNode{Mid: "m", Pid: "m", Id: "a", Name: "live"})
+	srv.add("ou=deptree-archive,dc=test", []string{"ou", "deptree-archive"})
+	srv.add("ou=m,ou=deptree-archive,dc=test", []string{"ou", "m"})
+	srv.add("ou=b,ou=m,ou=deptree-archive,dc=test", []string{"ou", "b"}, []string{"description", "0"})
+	seedOrg(srv, "ou=gone,ou=b,ou=m,ou=deptree-archive,dc=test", OrgNode{Mid: "m", Pid: "m", Id: "b", Name: "gone"})
+
+	for _, id := range []string{"a", "b"} {
+		_, err := tree.AddOrgNode(OrgNode{Mid: "m", Pid: "m", Id: id, Name: "new-" + id})
+		if !errors.Is(err, ErrAlreadyExists) {
+			t.Errorf("AddOrgNode(id %s) err = %v, want ErrAlreadyExists", id, err)
+		}
+	}
+	if _, err := tree.AddOrgNode(OrgNode{Mid: "m", Name: "other"}); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("AddOrgNode(top) err = %v, want ErrAlreadyExists", err)
+	}
+	id, err := tree.AddOrgNode(OrgNode{Mid: "m", Pid: "m", Id: "c", Name: "new-c"})
+	if err != nil || id != "c" {
+		t.Fatalf("AddOrgNode(id c) = %q, %v", id, err)
+	}
+	if srv.get("ou=new-c,ou=top,dc=test") == nil {
+		t.Error("node c not added")
+	}
+}
+
+// 更新属性失败时叶子移回原位置
+func TestLdapMoveLeafNodeRollback(t *testing.T) {
+	srv, tree := newTestLdap(t)
+	seedOrg(srv, "ou=top,dc=test", OrgNode{Mid: "m", Id: "m", Name: "top"})
+	seedOrg(srv, "ou=a,ou=top,dc=test", OrgNode{Mid: "m", Pid: "m", Id: "a", Name: "a"})
+	seedOrg(srv, "ou=b,ou=top,dc=test", OrgNode{Mid: "m", Pid: "m", Id: "b", Name: "b"})
+	seedLeaf(srv, "cn=u,ou=a,ou=top,dc=test", LeafNode{Mid: "m", Pid: "a", Uid: "u", Order: 1})
+	atomic.StoreInt32(&srv.failMod, 1)
+	if err := tree.MoveLeafNode("m", "u", "a", "b"); err == nil {
+		t.Fatal("move succeeded although the attribute update failed")
+	}
+	if srv.get("cn=u,ou=b,ou=top,dc=test") != nil {
+		t.Error("leaf left under the new parent")
+	}
+	e := srv.get("cn=u,ou=a,ou=top,dc=test")
+	if e == nil {
+		t.Fatal("leaf not moved back")
+	}
+	if pid := e.values("l"); len(pid) != 1 || pid[0] != "a" {
+		t.Errorf("pid = %v, want [a]", pid)
+	}
+	if leafs, err := tree.GetLeafNodes("m", "a", "u"); err != nil || len(leafs) != 1 {
+		t.Errorf("GetLeafNodes after rollback = %v, %v", leafs, err)
+	}
+}
+
+// 部分组织节点修改失败时返回ErrPartial及各节点的结果，全部失败时Kind为第一个失败的原因
+func TestLdapRenameLeafNodePartial(t *testing.T) {
+	srv, tree := newTestLdap(t)
+	seedOrg(srv, "ou=top,dc=test", OrgNode{Mid: "m", Id: "m", Name: "top"})
+	seedOrg(srv, "ou=a,ou=top,dc=test", OrgNode{Mid: "m", Pid: "m", Id: "a", Name: "a"})
+	seedOrg(srv, "ou=b,ou=top,dc=test", OrgNode{Mid: "m", Pid: "m", Id: "b", Name: "b"})
+	seedLeaf(srv, "cn=u,ou=a,ou=top,dc=test", LeafNode{Mid: "m", Pid: "a", Uid: "u"})
+	seedLeaf(srv, "cn=u,ou=b,ou=top,dc=test", LeafNode{Mid: "m", Pid: "b", Uid: "u"})
+
+	// 按dn顺序先处理a
+	atomic.StoreInt32(&srv.failMod, 1)
+	err := tree.RenameLeafNode("m", "u", "v")
+	var partial *PartialError
+	if !errors.Is(err, ErrPartial) || !errors.As(err, &partial) {
+		t.Fatalf("err = %v, want ErrPartial with *PartialError", err)
+	}
+	if fmt.Sprint(partial.Done) != "[b]" || len(partial.Failed) != 1 || partial.Failed[0].Target != "a" ||
+		!errors.Is(partial.Failed[0].Err, ErrInvalidArgument) {
+		t.Errorf("done %v failed %+v", partial.Done, partial.Failed)
+	}
+	if srv.get("cn=u,ou=a,ou=top,dc=test") == nil || srv.get("cn=v,ou=b,ou=top,dc=test") == nil {
+		t.Errorf("entries = %v", srv.under("dc=test"))
+	}
+
+	// 全部失败时没有完成的节点
+	atomic.StoreInt32(&srv.failMod, 1)
+	err = tree.RenameLeafNode("m", "u", "w")
+	if errors.Is(err, ErrPartial) || !errors.Is(err, ErrInvalidArgument) || !errors.As(err, &partial) {
+		t.Fatalf("err = %v, want ErrInvalidArgument with *PartialError", err)
+	}
+	if len(partial.Done) != 0 || len(partial.Failed) != 1 {
+		t.Errorf("done %v failed %+v", partial.Done, partial.Failed)
+	}
+	if srv.get("cn=u,ou=a,ou=top,dc=test") == nil {
+		t.Errorf("entries = %v", srv.under("dc=test"))
+	}
+}
@@ -0,0 +1,279 @@
+package deptree
+
+import (
+	"strconv"
+	"time"
+
+	ldap "github.com/go-ldap/ldap"
+)
+
+// ldap归档结构 ArchiveOu容器(默认deptree-archive)位于Base下，不存在时自动创建，其名称不能用作商户顶级节点名称
+// ou=<商户ID>,ou=<ArchiveOu>,<Base>             商户的归档容器
+// ou=<子树根节点ID>,ou=<商户ID>,...             一次归档，description为归档时间(unix秒)
+// <子树根节点RDN>,ou=<子树根节点ID>,...         移入的子树(包含叶子)
+
+// ldapArchiveClass 归档容器的objectClass
+const ldapArchiveClass = "organizationalUnit"
+
+// archiveDn 商户的归档容器dn
+func (self *ldapDepTree) archiveDn(mid string) string {
+	return dnJoin(dnRdn("ou", mid), self.archiveBase)
+}
+
+// addOuEntry 新增归档、岗位容器(description为空)或归档条目 容器已存在时忽略
+func (self *ldapDepTree) addOuEntry(dn string, ou string, description string, conn *ldap.Conn) error {
+	addReq := ldap.NewAddRequest(dn)
+	addReq.Attribute("objectClass", []string{ldapArchiveClass})
+	addReq.Attribute("ou", []string{ou})
+	if description != "" {
+		addReq.Attribute("description", []string{description})
+	}
+	err := conn.Add(addReq)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultEntryAlreadyExists) && description == "" {
+		return nil
+	}
+	return err
+}
+
+// searchArchives 取商户归档容器下的归档条目 容器不存在时返回空
+func (self *ldapDepTree) searchArchives(mid string, conn *ldap.Conn) ([]*ldap.Entry, error) {
+	searchReq := ldap.NewSearchRequest(self.archiveDn(mid), ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false, filterEq("objectClass", ldapArchiveClass), []string{"ou", "description"}, nil)
+	sr, err := conn.Search(searchReq)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sr.Entries, nil
+}
+
+// delArchive 删除一次归档(包含子树) dn为归档条目
+func (self *ldapDepTree) delArchive(dn string, conn *ldap.Conn) error {
+	searchReq := ldap.NewSearchRequest(dn, ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false, self.schema.orgFilter(""), []string{"dn"}, nil)
+	sr, err := conn.Search(searchReq)
+	if err != nil {
+		return err
+	}
+	for _, e := range sr.Entries {
+		if err = self.delTree(e.DN, conn); err != nil {
+			return err
+		}
+	}
+	return conn.Del(ldap.NewDelRequest(dn, nil))
+}
+
+// delArchives 删除商户的全部归档及归档容器
+func (self *ldapDepTree) delArchives(mid string, conn *ldap.Conn) error {
+	entries, err := self.searchArchives(mid, conn)
+	if err != nil || entries == nil {
+		return err
+	}
+	for _, e := range entries {
+		if err = self.delArchive(e.DN, conn); err != nil {
+			return err
+		}
+	}
+	return conn.Del(ldap.NewDelRequest(self.archiveDn(mid), nil))
+}
+
+// replaceArchivedPositions 替换归档子树中叶子的岗位
+func (self *ldapDepTree) replaceArchivedPositions(mid string, oldId string, newId string, conn *ldap.Conn) error {
+	err := self.replaceLeafPositions(self.archiveDn(mid), oldId, newId, conn)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil
+	}
+	return err
+}
+
+// ArchiveOrgNode 归档组织节点 子树整体移动到商户的归档容器下
+func (self *ldapDepTree) ArchiveOrgNode(mid string, id string) (err error) {
+	defer ldapError("ArchiveOrgNode", &err)
+	if id == "" || mid == "" {
+		return newError(ErrInvalidArgument, "", "invalid id or mid [%s,%s]", id, mid)
+	}
+	if id == mid {
+		return newError(ErrInvalidArgument, "", "can't archive the top tree: %s", mid)
+	}
+	conn, tree_dn, err := self.connectTree(mid)
+	if conn == nil {
+		return err
+	}
+	defer self.release(conn, &err)
+	if err != nil {
+		return err
+	}
+	// 获得需归档节点的dn
+	dn, err := self.getSubTreeDn(tree_dn, id, conn)
+	if err != nil {
+		return err
+	}
+
+	if err = self.addOuEntry(self.archiveBase, self.archiveOu, "", conn); err != nil {
+		return err
+	}
+	if err = self.addOuEntry(self.archiveDn(mid), mid, "", conn); err != nil {
+		return err
+	}
+	archive_dn := dnJoin(dnRdn("ou", id), self.archiveDn(mid))
+	at := strconv.FormatInt(archiveNow().Unix(), 10)
+	if err = self.addOuEntry(archive_dn, id, at, conn); err != nil {
+		return err
+	}
+	rdn, _ := dnSplit(dn)
+	err = conn.ModifyDN(ldap.NewModifyDNRequest(dn, rdn, true, archive_dn))
+	if err != nil {
+		conn.Del(ldap.NewDelRequest(archive_dn, nil))
+	}
+	return err
+}
+
+// RestoreOrgNode 将归档的子树移回原父节点下
+func (self *ldapDepTree) RestoreOrgNode(mid string, id string) (err error) {
+	defer ldapError("RestoreOrgNode", &err)
+	if id == "" || mid == "" {
+		return newError(ErrInvalidArgument, "", "invalid id or mid [%s,%s]", id, mid)
+	}
+	conn, tree_dn, err := self.connectTree(mid)
+	if conn == nil {
+		return err
+	}
+	defer self.release(conn, &err)
+	if err != nil {
+		return err
+	}
+	archive_dn := dnJoin(dnRdn("ou", id), self.archiveDn(mid))
+	searchReq := ldap.NewSearchRequest(archive_dn, ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false, self.schema.orgIdFilter(id), self.schema.orgAttrList(), nil)
+	sr, err := conn.Search(searchReq)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return err
+	}
+	if err != nil || len(sr.Entries) == 0 {
+		return errNotFound("", "Can't find the archived node with this id: %s", id)
+	}
+	dn := sr.Entries[0].DN
+	node := OrgNode{}
+	self.schema.ldap2orgnode(sr.Entries[0], &node)
+
+	parent_dn := tree_dn
+	if node.Pid != mid {
+		parent_dn, err = self.getSubTreeDn(tree_dn, node.Pid, conn)
+		if err != nil {
+			return err
+		}
+	}
+	// 原父节点下不能存在同名节点
+	searchReq = ldap.NewSearchRequest(parent_dn, ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false, self.schema.orgFieldFilter("Name", node.Name), []string{"dn"}, nil)
+	sr, err = conn.Search(searchReq)
+	if err != nil {
+		return err
+	}
+	if len(sr.Entries) > 0 {
+		return newError(ErrDuplicateName, "", "node already exists with this name: %s", node.Name)
+	}
+	order, err := self.nextOrder(parent_dn, self.schema.orgFilter(""), self.schema.orgAttr("Order"), conn)
+	if err != nil {
+		return err
+	}
+
+	rdn, _ := dnSplit(dn)
+	err = conn.ModifyDN(ldap.NewModifyDNRequest(dn, rdn, true, parent_dn))
+	if err != nil {
+		return err
+	}
+	// 更新排序属性，失败时移回归档容器
+	modReq := ldap.NewModifyRequest(dnJoin(rdn, parent_dn))
+	for _, attr := range self.schema.OrgAttrs["Order"] {
+		modReq.Replace(attr, []string{strconv.Itoa(order)})
+	}
+	err = conn.Modify(modReq)
+	if err != nil {
+		conn.ModifyDN(ldap.NewModifyDNRequest(dnJoin(rdn, parent_dn), rdn, true, archive_dn))
+		return err
+	}
+	return conn.Del(ldap.NewDelRequest(archive_dn, nil))
+}
+
+// GetArchivedOrgNodes 取已归档的子树根节点
+func (self *ldapDepTree) GetArchivedOrgNodes(mid string) (_ []ArchivedOrg, err error) {
+	defer ldapError("GetArchivedOrgNodes", &err)
+	conn, err := self.connect()
+	if conn == nil {
+		return nil, err
+	}
+	defer self.release(conn, &err)
+
+	return self.archived(mid, conn)
+}
+
+// archived 商户的归档列表 归档条目及子树根节点各一次搜索
+func (self *ldapDepTree) archived(mid string, conn *ldap.Conn) ([]ArchivedOrg, error) {
+	if _, err := self.getTopTreeDn(mid, conn); err != nil {
+		return nil, err
+	}
+	entries, err := self.searchArchives(mid, conn)
+	if err != nil {
+		return nil, err
+	}
+	ret := []ArchivedOrg{}
+	if len(entries) == 0 {
+		return ret, nil
+	}
+	// 归档条目dn(规范形式) -> 归档时间
+	times := map[string]time.Time{}
+	conds := []string{}
+	for _, e := range entries {
+		at, _ := strconv.ParseInt(e.GetAttributeValue("description"), 10, 64)
+		times[dnKey(e.DN)] = time.Unix(at, 0)
+		conds = append(conds, filterEq(self.schema.orgAttr("Id"), e.GetAttributeValue("ou")))
+	}
+	searchReq := ldap.NewSearchRequest(self.archiveDn(mid), ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, 0, false, self.schema.orgFilter(filterOr(conds...)), self.schema.orgAttrList(), nil)
+	sr, err := conn.Search(searchReq)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range sr.Entries {
+		at, ok := times[dnKey(parentDn(e.DN))]
+		if !ok {
+			continue
+		}
+		node := OrgNode{}
+		self.schema.ldap2orgnode(e, &node)
+		ret = append(ret, newArchivedOrg(node, at, self.retention))
+	}
+	sortArchived(ret)
+	return ret, nil
+}
+
+// PurgeArchivedOrgNodes 删除超过保留期的归档子树 ldap无事务，中途失败时已删除的归档不恢复
+func (self *ldapDepTree) PurgeArchivedOrgNodes(mid string) (_ []string, err error) {
+	defer ldapError("PurgeArchivedOrgNodes", &err)
+	conn, err := self.connect()
+	if conn == nil {
+		return nil, err
+	}
+	defer self.release(conn, &err)
+
+	list, err := self.archived(mid, conn)
+	if err != nil {
+		return nil, err
+	}
+	ids := expiredArchives(list, archiveNow())
+	for _, id := range ids {
+		err = self.delArchive(dnJoin(dnRdn("ou", id), self.archiveDn(mid)), conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,767 @@
+package deptree
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// 测试用的内存ldap服务，实现Bind Search(支持paged results control) Add Del Modify ModifyDN StartTLS及Unbind
+// 不校验schema及权限，过滤条件支持and or not = >= <= =* 及子串匹配，比较不区分大小写
+
+// ber编码的class及ldap使用的tag
+const (
+	berUniversal   = 0x00
+	berApplication = 0x40
+	berContext     = 0x80
+	berConstructed = 0x20
+
+	berTagBoolean     = 0x01
+	berTagInteger     = 0x02
+	berTagOctetString = 0x04
+	berTagEnumerated  = 0x0a
+	berTagSequence    = 0x10
+	berTagSet         = 0x11
+)
+
+// ldap协议操作
+const (
+	ldapOpBind        = 0
+	ldapOpBindResp    = 1
+	ldapOpUnbind      = 2
+	ldapOpSearch      = 3
+	ldapOpSearchEntry = 4
+	ldapOpSearchDone  = 5
+	ldapOpModify      = 6
+	ldapOpModifyResp  = 7
+	ldapOpAdd         = 8
+	ldapOpAddResp     = 9
+	ldapOpDel         = 10
+	ldapOpDelResp     = 11
+	ldapOpModDN       = 12
+	ldapOpModDNResp   = 13
+	ldapOpAbandon     = 16
+	ldapOpExtended    = 23
+	ldapOpExtendResp  = 24
+
+	ldapOidStartTLS = "1.3.6.1.4.1.1466.20037"
+	ldapOidPaging   = "1.2.840.113556.1.4.319"
+)
+
+// berPacket 一个ber元素 constructed时使用children，否则使用data
+type berPacket struct {
+	class       byte
+	constructed bool
+	tag         byte
+	data        []byte
+	children    []*berPacket
+}
+
+// berRead 读取一个ber元素 只支持单字节tag及定长编码
+func berRead(r io.Reader) (*berPacket, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	length := int(head[1])
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 {
+			return nil, fmt.Errorf("ber: unsupported length")
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		length = 0
+		for _, b := range buf {
+			length = length<<8 | int(b)
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	p := &berPacket{class: head[0] & 0xc0, constructed: head[0]&berConstructed != 0, tag: head[0] & 0x1f}
+	if !p.constructed {
+		p.data = body
+		return p, nil
+	}
+	reader := strings.NewReader(string(body))
+	for reader.Len() > 0 {
+		child, err := berRead(reader)
+		if err != nil {
+			return nil, err
+		}
+		p.children = append(p.children, child)
+	}
+	return p, nil
+}
+
+// bytes 编码
+func (self *berPacket) bytes() []byte {
+	body := self.data
+	if self.constructed {
+		body = nil
+		for _, c := range self.children {
+			body = append(body, c.bytes()...)
+		}
+	}
+	id := self.class | self.tag
+	if self.constructed {
+		id |= berConstructed
+	}
+	out := []byte{id}
+	if n := len(body); n < 0x80 {
+		out = append(out, byte(n))
+	} else {
+		size := []byte{}
+		for ; n > 0; n >>= 8 {
+			size = append([]byte{byte(n)}, size...)
+		}
+		out = append(append(out, 0x80|byte(len(size))), size...)
+	}
+	return append(out, body...)
+}
+
+func (self *berPacket) child(i int) *berPacket {
+	if i < len(self.children) {
+		return self.children[i]
+	}
+	return &berPacket{}
+}
+
+func (self *berPacket) str() string {
+	return string(self.data)
+}
+
+func (self *berPacket) int() int64 {
+	var v int64
+	for i, b := range self.data {
+		if i == 0 && b&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(b)
+	}
+	return v
+}
+
+func (self *berPacket) bool() bool {
+	return len(self.data) > 0 && self.data[0] != 0
+}
+
+func berCons(class byte, tag byte, children ...*berPacket) *berPacket {
+	return &berPacket{class: class, constructed: true, tag: tag, children: children}
+}
+
+func berStr(s string) *berPacket {
+	return &berPacket{tag: berTagOctetString, data: []byte(s)}
+}
+
+func berInt(tag byte, v int64) *berPacket {
+	data := []byte{}
+	for {
+		data = append([]byte{byte(v)}, data...)
+		if v >= -0x80 && v < 0x80 {
+			break
+		}
+		v >>= 8
+	}
+	return &berPacket{tag: tag, data: data}
+}
+
+// fakeEntry 条目 attrs为小写属性名 -> 属性
+type fakeEntry struct {
+	dn    string
+	attrs map[string]*fakeAttr
+}
+
+type fakeAttr struct {
+	name string
+	vals []string
+}
+
+// fakeLdap 内存ldap服务
+type fakeLdap struct {
+	ln       net.Listener
+	startTLS *tls.Config // 非nil时支持StartTLS
+
+	lock    sync.Mutex
+	entries map[string]*fakeEntry // dnKey -> 条目
+	conns   map[net.Conn]bool
+
+	dials    int32 // 累计建立的连接数
+	searches int32 // 累计搜索次数
+	drop     int32 // 大于0时后续的drop次搜索不应答直接断开连接
+	mute     int32 // 非0时搜索不应答也不断开，模拟半开的连接或无响应的服务
+	failMod  int32 // 大于0时后续的failMod次修改属性请求返回错误
+
+	sizeLimit int // 大于0时不分页的搜索最多返回的条目数，超出时返回sizeLimitExceeded
+}
+
+// newFakeLdap 启动服务 ldaps非nil时监听TLS
+func newFakeLdap(t testing.TB, ldaps *tls.Config) *fakeLdap {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ldaps != nil {
+		ln = tls.NewListener(ln, ldaps)
+	}
+	self := &fakeLdap{ln: ln, entries: map[string]*fakeEntry{}, conns: map[net.Conn]bool{}}
+	go self.serve()
+	t.Cleanup(self.close)
+	return self
+}
+
+func (self *fakeLdap) port() int {
+	return self.ln.Addr().(*net.TCPAddr).Port
+}
+
+// config 连接该服务的NewTree配置
+func (self *fakeLdap) config() map[string]interface{} {
+	return map[string]interface{}{
+		"Backend":  "ldap",
+		"Host":     "127.0.0.1",
+		"Port":     float64(self.port()),
+		"Base":     "dc=test",
+		"User":     "cn=admin,dc=test",
+		"Password": "secret",
+	}
+}
+
+func (self *fakeLdap) close() {
+	self.ln.Close()
+	self.dropConns()
+}
+
+// dropConns 断开全部已建立的连接，模拟服务重启
+func (self *fakeLdap) dropConns() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for c := range self.conns {
+		c.Close()
+	}
+}
+
+// add 直接写入条目 attrs为 属性名 值...
+func (self *fakeLdap) add(dn string, attrs ...[]string) {
+	e := &fakeEntry{dn: dn, attrs: map[string]*fakeAttr{}}
+	for _, a := range attrs {
+		e.add(a[0], a[1:]...)
+	}
+	self.lock.Lock()
+	self.entries[dnKey(dn)] = e
+	self.lock.Unlock()
+}
+
+// get 按dn取条目 不存在时返回nil
+func (self *fakeLdap) get(dn string) *fakeEntry {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.entries[dnKey(dn)]
+}
+
+// under 返回base下(不含base)的全部条目dn
+func (self *fakeLdap) under(base string) []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	ret := []string{}
+	key := dnKey(base)
+	for k, e := range self.entries {
+		if strings.HasSuffix(k, ","+key) {
+			ret = append(ret, e.dn)
+		}
+	}
+	return ret
+}
+
+func (self *fakeEntry) add(name string, vals ...string) {
+	a := self.attrs[strings.ToLower(name)]
+	if a == nil {
+		a = &fakeAttr{name: name}
+		self.attrs[strings.ToLower(name)] = a
+	}
+	for _, v := range vals {
+		if !a.has(v) {
+			a.vals = append(a.vals, v)
+		}
+	}
+}
+
+func (self *fakeEntry) values(name string) []string {
+	if a := self.attrs[strings.ToLower(name)]; a != nil {
+		return a.vals
+	}
+	return nil
+}
+
+func (self *fakeAttr) has(v string) bool {
+	for _, val := range self.vals {
+		if strings.EqualFold(val, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *fakeLdap) serve() {
+	for {
+		c, err := self.ln.Accept()
+		if err != nil {
+			return
+		}
+		atomic.AddInt32(&self.dials, 1)
+		self.lock.Lock()
+		self.conns[c] = true
+		self.lock.Unlock()
+		go self.handle(c)
+	}
+}
+
+// handle 顺序处理一个连接上的请求
+func (self *fakeLdap) handle(c net.Conn) {
+	defer func() {
+		self.lock.Lock()
+		delete(self.conns, c)
+		self.lock.Unlock()
+		c.Close()
+	}()
+	reader := bufio.NewReader(c)
+	for {
+		msg, err := berRead(reader)
+		if err != nil || len(msg.children) < 2 {
+			return
+		}
+		id := msg.child(0).int()
+		op := msg.child(1)
+		send := func(p *berPacket, controls ...*berPacket) error {
+			resp := berCons(berUniversal, berTagSequence, berInt(berTagInteger, id), p)
+			if len(controls) > 0 {
+				resp.children = append(resp.children, berCons(berContext, 0, controls...))
+			}
+			_, err := c.Write(resp.bytes())
+			return err
+		}
+		switch op.tag {
+		case ldapOpBind:
+			err = send(ldapResult(ldapOpBindResp, 0, ""))
+		case ldapOpUnbind:
+			return
+		case ldapOpAbandon:
+		case ldapOpSearch:
+			atomic.AddInt32(&self.searches, 1)
+			if countDown(&self.drop) {
+				return
+			}
+			if atomic.LoadInt32(&self.mute) != 0 {
+				break
+			}
+			err = self.search(op, msg.child(2), send)
+		case ldapOpAdd:
+			err = send(self.addEntry(op))
+		case ldapOpDel:
+			err = send(self.delEntry(op))
+		case ldapOpModify:
+			if countDown(&self.failMod) {
+				err = send(ldapResult(ldapOpModifyResp, 19, "constraint violation"))
+				break
+			}
+			err = send(self.modify(op))
+		case ldapOpModDN:
+			err = send(self.modifyDN(op))
+		case ldapOpExtended:
+			if op.child(0).str() != ldapOidStartTLS || self.startTLS == nil {
+				err = send(ldapResult(ldapOpExtendResp, 2, "unsupported extended operation"))
+				break
+			}
+			if err = send(ldapResult(ldapOpExtendResp, 0, "")); err != nil {
+				return
+			}
+			tc := tls.Server(c, self.startTLS)
+			if tc.Handshake() != nil {
+				return
+			}
+			self.lock.Lock()
+			delete(self.conns, c)
+			self.conns[tc] = true
+			self.lock.Unlock()
+			c = tc
+			reader = bufio.NewReader(c)
+		default:
+			err = send(ldapResult(op.tag+1, 53, "unsupported operation"))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// countDown 计数大于0时减一并返回true 用于模拟接下来若干次请求的故障
+func countDown(n *int32) bool {
+	for {
+		v := atomic.LoadInt32(n)
+		if v <= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(n, v, v-1) {
+			return true
+		}
+	}
+}
+
+// ldapResult LDAPResult应答
+func ldapResult(op byte, code int64, msg string) *berPacket {
+	return berCons(berApplication, op, berInt(berTagEnumerated, code), berStr(""), berStr(msg))
+}
+
+// search 搜索 请求带paged results control时按dn排序分页，cookie为下一页的偏移
+func (self *fakeLdap) search(op *berPacket, controls *berPacket, send func(*berPacket, ...*berPacket) error) error {
+	base := dnKey(op.child(0).str())
+	scope := op.child(1).int()
+	filter := op.child(6)
+	wanted := []string{}
+	for _, a := range op.child(7).children {
+		wanted = append(wanted, a.str())
+	}
+
+	self.lock.Lock()
+	if _, ok := self.entries[base]; !ok {
+		self.lock.Unlock()
+		return send(ldapResult(ldapOpSearchDone, 32, "no such object"))
+	}
+	keys := []string{}
+	results := map[string]*berPacket{}
+	for key, e := range self.entries {
+		switch scope {
+		case 0:
+			if key != base {
+				continue
+			}
+		case 1:
+			if !strings.HasSuffix(key, ","+base) {
+				continue
+			}
+			if _, parent := dnSplit(key[:len(key)-len(base)-1]); parent != "" {
+				continue
+			}
+		default:
+			if key != base && !strings.HasSuffix(key, ","+base) {
+				continue
+			}
+		}
+		if !e.match(filter) {
+			continue
+		}
+		keys = append(keys, key)
+		results[key] = e.packet(wanted)
+	}
+	self.lock.Unlock()
+	sort.Strings(keys)
+	var paging *berPacket
+	size, offset := int64(len(keys)), int64(0)
+	for _, c := range controls.children {
+		if c.child(0).str() != ldapOidPaging {
+			continue
+		}
+		value, err := berRead(strings.NewReader(c.children[len(c.children)-1].str()))
+		if err != nil {
+			return err
+		}
+		size = value.child(0).int()
+		offset, _ = strconv.ParseInt(value.child(1).str(), 10, 64)
+		paging = c
+	}
+	if offset > int64(len(keys)) {
+		offset = int64(len(keys))
+	}
+	end := offset + size
+	if end > int64(len(keys)) {
+		end = int64(len(keys))
+	}
+	for _, key := range keys[offset:end] {
+		if err := send(results[key]); err != nil {
+			return err
+		}
+	}
+	if paging == nil {
+		if self.sizeLimit > 0 && len(keys) > self.sizeLimit {
+			return send(ldapResult(ldapOpSearchDone, 4, "size limit exceeded"))
+		}
+		return send(ldapResult(ldapOpSearchDone, 0, ""))
+	}
+	cookie := ""
+	if size > 0 && end < int64(len(keys)) {
+		cookie = strconv.FormatInt(end, 10)
+	}
+	value := berCons(berUniversal, berTagSequence, berInt(berTagInteger, 0), berStr(cookie))
+	return send(ldapResult(ldapOpSearchDone, 0, ""),
+		berCons(berUniversal, berTagSequence, berStr(ldapOidPaging), berStr(string(value.bytes()))))
+}
+
+// match 计算过滤条件
+func (self *fakeEntry) match(f *berPacket) bool {
+	switch f.tag {
+	case 0:
+		for _, c := range f.children {
+			if !self.match(c) {
+				return false
+			}
+		}
+		return true
+	case 1:
+		for _, c := range f.children {
+			if self.match(c) {
+				return true
+			}
+		}
+		return false
+	case 2:
+		return !self.match(f.child(0))
+	case 3, 5, 6:
+		want := strings.ToLower(f.child(1).str())
+		for _, v := range self.values(f.child(0).str()) {
+			v = strings.ToLower(v)
+			if f.tag == 3 && v == want || f.tag == 5 && v >= want || f.tag == 6 && v <= want {
+				return true
+			}
+		}
+		return false
+	case 4:
+		for _, v := range self.values(f.child(0).str()) {
+			if matchSubstrings(strings.ToLower(v), f.child(1).children) {
+				return true
+			}
+		}
+		return false
+	case 7:
+		return len(self.values(f.str())) > 0
+	}
+	return false
+}
+
+// matchSubstrings 子串匹配 initial[0] any[1] final[2]
+func matchSubstrings(v string, parts []*berPacket) bool {
+	for _, p := range parts {
+		s := strings.ToLower(p.str())
+		switch p.tag {
+		case 0:
+			if !strings.HasPrefix(v, s) {
+				return false
+			}
+			v = v[len(s):]
+		case 1:
+			i := strings.Index(v, s)
+			if i < 0 {
+				return false
+			}
+			v = v[i+len(s):]
+		case 2:
+			if !strings.HasSuffix(v, s) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// packet SearchResultEntry wanted为空或包含*时返回全部属性，属性名使用请求中的写法
+func (self *fakeEntry) packet(wanted []string) *berPacket {
+	attrs := berCons(berUniversal, berTagSequence)
+	appendAttr := func(name string, vals []string) {
+		set := berCons(berUniversal, berTagSet)
+		for _, v := range vals {
+			set.children = append(set.children, berStr(v))
+		}
+		attrs.children = append(attrs.children, berCons(berUniversal, berTagSequence, berStr(name), set))
+	}
+	all := len(wanted) == 0
+	for _, w := range wanted {
+		all = all || w == "*"
+	}
+	if all {
+		for _, a := range self.attrs {
+			appendAttr(a.name, a.vals)
+		}
+	} else {
+		for _, w := range wanted {
+			if vals := self.values(w); len(vals) > 0 {
+				appendAttr(w, vals)
+			}
+		}
+	}
+	return berCons(berApplication, ldapOpSearchEntry, berStr(self.dn), attrs)
+}
+
+func (self *fakeLdap) addEntry(op *berPacket) *berPacket {
+	dn := op.child(0).str()
+	key := dnKey(dn)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, ok := self.entries[key]; ok {
+		return ldapResult(ldapOpAddResp, 68, "entry already exists")
+	}
+	if _, ok := self.entries[dnKey(parentDn(dn))]; !ok {
+		return ldapResult(ldapOpAddResp, 32, "no such object")
+	}
+	e := &fakeEntry{dn: dn, attrs: map[string]*fakeAttr{}}
+	for _, a := range op.child(1).children {
+		vals := []string{}
+		for _, v := range a.child(1).children {
+			vals = append(vals, v.str())
+		}
+		e.add(a.child(0).str(), vals...)
+	}
+	self.entries[key] = e
+	return ldapResult(ldapOpAddResp, 0, "")
+}
+
+func (self *fakeLdap) delEntry(op *berPacket) *berPacket {
+	key := dnKey(op.str())
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if _, ok := self.entries[key]; !ok {
+		return ldapResult(ldapOpDelResp, 32, "no such object")
+	}
+	for k := range self.entries {
+		if strings.HasSuffix(k, ","+key) {
+			return ldapResult(ldapOpDelResp, 66, "not allowed on non-leaf")
+		}
+	}
+	delete(self.entries, key)
+	return ldapResult(ldapOpDelResp, 0, "")
+}
+
+func (self *fakeLdap) modify(op *berPacket) *berPacket {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	e, ok := self.entries[dnKey(op.child(0).str())]
+	if !ok {
+		return ldapResult(ldapOpModifyResp, 32, "no such object")
+	}
+	for _, change := range op.child(1).children {
+		name := change.child(1).child(0).str()
+		vals := []string{}
+		for _, v := range change.child(1).child(1).children {
+			vals = append(vals, v.str())
+		}
+		switch change.child(0).int() {
+		case 0:
+			e.add(name, vals...)
+		case 1:
+			a := e.attrs[strings.ToLower(name)]
+			if a == nil {
+				return ldapResult(ldapOpModifyResp, 16, "no such attribute")
+			}
+			if len(vals) == 0 {
+				delete(e.attrs, strings.ToLower(name))
+				continue
+			}
+			keep := []string{}
+			for _, v := range a.vals {
+				if !(&fakeAttr{vals: vals}).has(v) {
+					keep = append(keep, v)
+				}
+			}
+			a.vals = keep
+			if len(keep) == 0 {
+				delete(e.attrs, strings.ToLower(name))
+			}
+		case 2:
+			delete(e.attrs, strings.ToLower(name))
+			if len(vals) > 0 {
+				e.add(name, vals...)
+			}
+		}
+	}
+	return ldapResult(ldapOpModifyResp, 0, "")
+}
+
+// modifyDN 改名或移动条目 连同子孙条目一起移动
+func (self *fakeLdap) modifyDN(op *berPacket) *berPacket {
+	dn := op.child(0).str()
+	rdn := op.child(1).str()
+	parent := parentDn(dn)
+	if len(op.children) > 3 {
+		parent = op.child(3).str()
+	}
+	key := dnKey(dn)
+	newDn := dnJoin(rdn, parent)
+	newKey := dnKey(newDn)
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	e, ok := self.entries[key]
+	if !ok {
+		return ldapResult(ldapOpModDNResp, 32, "no such object")
+	}
+	if _, ok := self.entries[dnKey(parent)]; !ok {
+		return ldapResult(ldapOpModDNResp, 32, "no such object")
+	}
+	if _, ok := self.entries[newKey]; ok && newKey != key {
+		return ldapResult(ldapOpModDNResp, 68, "entry already exists")
+	}
+	moved := map[string]*fakeEntry{}
+	for k, sub := range self.entries {
+		if k != key && !strings.HasSuffix(k, ","+key) {
+			continue
+		}
+		delete(self.entries, k)
+		rdns := []string{}
+		for d := sub.dn; dnKey(d) != key; d = parentDn(d) {
+			r, _ := dnSplit(d)
+			rdns = append(rdns, r)
+		}
+		moved[k] = sub
+		sub.dn = strings.Join(append(rdns, newDn), ",")
+	}
+	for _, sub := range moved {
+		self.entries[dnKey(sub.dn)] = sub
+	}
+	if op.child(2).bool() {
+		old, _ := dnSplit(dn)
+		if i := strings.Index(old, "="); i > 0 {
+			if a := e.attrs[strings.ToLower(old[:i])]; a != nil {
+				keep := []string{}
+				for _, v := range a.vals {
+					if !strings.EqualFold(dnRdn(old[:i], v), old) {
+						keep = append(keep, v)
+					}
+				}
+				a.vals = keep
+			}
+		}
+	}
+	if i := strings.Index(rdn, "="); i > 0 {
+		if parsed, err := parseRdnValue(rdn); err == nil {
+			e.add(rdn[:i], parsed)
+		}
+	}
+	return ldapResult(ldapOpModDNResp, 0, "")
+}
+
+// parseRdnValue 取出单值RDN的值(去除转义)
+func parseRdnValue(rdn string) (string, error) {
+	i := strings.Index(rdn, "=")
+	if i < 0 {
+		return "", errors.New("invalid rdn")
+	}
+	buf := strings.Builder{}
+	v := rdn[i+1:]
+	for j := 0; j < len(v); j++ {
+		if v[j] == '\\' && j+1 < len(v) {
+			j++
+		}
+		buf.WriteByte(v[j])
+	}
+	return buf.String(), nil
+}
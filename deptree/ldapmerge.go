@@ -0,0 +1,171 @@
+package deptree
+
+import (
+	"strconv"
+	"strings"
+
+	ldap "github.com/go-ldap/ldap"
+)
+
+// MergeOrgNodes 合并组织节点 ldap无事务，按报告中的步骤逐步执行，
+// 中途失败时返回报告及错误，report.Done为已完成的步骤数
+// RDN不区分大小写，同级名称及uid按小写比较，MERGE_FAIL在执行前即可发现冲突
+func (self *ldapDepTree) MergeOrgNodes(mid string, sourceId string, targetId string, opts MergeOptions) (report *MergeReport, err error) {
+	defer ldapError("MergeOrgNodes", &err)
+	conn, tree_dn, err := self.connectTree(mid)
+	if conn == nil {
+		return nil, err
+	}
+	defer self.release(conn, &err)
+	if err != nil {
+		return nil, err
+	}
+	source, err := self.getSubTreeById(tree_dn, sourceId, conn)
+	if err != nil {
+		return nil, err
+	}
+	target, err := self.getSubTreeById(tree_dn, targetId, conn)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkMerge(mid, sourceId, targetId, source, target, opts); err != nil {
+		return nil, err
+	}
+	report, err = newMergeReport(source, target, opts, strings.ToLower)
+	if err != nil || opts.DryRun {
+		return report, err
+	}
+	err = runMerge(report, &ldapMerge{tree: self, conn: conn, mid: mid, tree_dn: tree_dn})
+	return report, err
+}
+
+// ldapMerge ldap实现的合并操作
+type ldapMerge struct {
+	tree    *ldapDepTree
+	conn    *ldap.Conn
+	mid     string
+	tree_dn string
+}
+
+// orgDn 组织节点的dn
+func (self *ldapMerge) orgDn(id string) (string, error) {
+	if id == self.mid {
+		return self.tree_dn, nil
+	}
+	return self.tree.getSubTreeDn(self.tree_dn, id, self.conn)
+}
+
+func (self *ldapMerge) moveOrg(id string, pid string, name string) error {
+	schema := self.tree.schema
+	dn, err := self.orgDn(id)
+	if err != nil {
+		return err
+	}
+	parent_dn, err := self.orgDn(pid)
+	if err != nil {
+		return err
+	}
+	rdn, old_parent_dn := dnSplit(dn)
+	if name != "" {
+		rdn = schema.orgRdn(name)
+	}
+	// Name映射的属性不是RDN属性时需单独更新
+	modReq := ldap.NewModifyRequest(dnJoin(rdn, parent_dn))
+	if name != "" {
+		for _, attr := range schema.OrgAttrs["Name"] {
+			modReq.Replace(attr, []string{name})
+		}
+	}
+	newSup := ""
+	moved := dnKey(parent_dn) != dnKey(old_parent_dn)
+	if name == "" && !moved {
+		return nil
+	}
+	if moved {
+		order, err := self.tree.nextOrder(parent_dn, schema.orgFilter(""), schema.orgAttr("Order"), self.conn)
+		if err != nil {
+			return err
+		}
+		newSup = parent_dn
+		for _, attr := range schema.OrgAttrs["Pid"] {
+			modReq.Replace(attr, []string{pid})
+		}
+		for _, attr := range schema.OrgAttrs["Order"] {
+			modReq.Replace(attr, []string{strconv.Itoa(order)})
+		}
+	}
+	err = self.conn.ModifyDN(ldap.NewModifyDNRequest(dn, rdn, true, newSup))
+	if err != nil {
+		return err
+	}
+	// 更新属性失败时移回原位置
+	err = self.conn.Modify(modReq)
+	if err != nil {
+		old_rdn, _ := dnSplit(dn)
+		self.conn.ModifyDN(ldap.NewModifyDNRequest(dnJoin(rdn, parent_dn), old_rdn, true, old_parent_dn))
+	}
+	return err
+}
+
+func (self *ldapMerge) moveLeaf(uid string, from string, to string) error {
+	schema := self.tree.schema
+	from_dn, err := self.orgDn(from)
+	if err != nil {
+		return err
+	}
+	to_dn, err := self.orgDn(to)
+	if err != nil {
+		return err
+	}
+	order, err := self.tree.nextOrder(to_dn, schema.leafFilter(""), schema.leafAttr("Order"), self.conn)
+	if err != nil {
+		return err
+	}
+	rdn := schema.leafRdn(uid)
+	err = self.conn.ModifyDN(ldap.NewModifyDNRequest(dnJoin(rdn, from_dn), rdn, true, to_dn))
+	if err != nil {
+		return err
+	}
+	modReq := ldap.NewModifyRequest(dnJoin(rdn, to_dn))
+	for _, attr := range schema.LeafAttrs["Pid"] {
+		modReq.Replace(attr, []string{to})
+	}
+	for _, attr := range schema.LeafAttrs["Order"] {
+		modReq.Replace(attr, []string{strconv.Itoa(order)})
+	}
+	err = self.conn.Modify(modReq)
+	if err != nil {
+		self.conn.ModifyDN(ldap.NewModifyDNRequest(dnJoin(rdn, to_dn), rdn, true, from_dn))
+	}
+	return err
+}
+
+func (self *ldapMerge) mergeLeaf(uid string, from string, to string, positions []string) error {
+	schema := self.tree.schema
+	from_dn, err := self.orgDn(from)
+	if err != nil {
+		return err
+	}
+	to_dn, err := self.orgDn(to)
+	if err != nil {
+		return err
+	}
+	rdn := schema.leafRdn(uid)
+	modReq := ldap.NewModifyRequest(dnJoin(rdn, to_dn))
+	for _, attr := range schema.LeafAttrs["Positions"] {
+		modReq.Replace(attr, positions)
+	}
+	if err = self.conn.Modify(modReq); err != nil {
+		return err
+	}
+	return self.conn.Del(ldap.NewDelRequest(dnJoin(rdn, from_dn), nil))
+}
+
+// delOrg 节点已清空，仍有下级条目时返回错误而不是递归删除
+func (self *ldapMerge) delOrg(id string) error {
+	dn, err := self.orgDn(id)
+	if err != nil {
+		return err
+	}
+	return self.conn.Del(ldap.NewDelRequest(dn, nil))
+}
@@ -0,0 +1,88 @@
+package deptree
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// seedMergeLdap 写入与mergeFixture相同的结构 source下另有排在前面的不冲突子节点Extra
+func seedMergeLdap(srv *fakeLdap) {
+	seedOrg(srv, "ou=top,dc=test", OrgNode{Mid: "m", Id: "m", Name: "top"})
+	seedOrg(srv, "ou=Target,ou=top,dc=test", OrgNode{Mid: "m", Pid: "m", Id: "t", Name: "Target"})
+	seedOrg(srv, "ou=Sales,ou=Target,ou=top,dc=test", OrgNode{Mid: "m", Pid: "t", Id: "ts", Name: "Sales"})
+	seedLeaf(srv, "cn=Alice,ou=Target,ou=top,dc=test", LeafNode{Mid: "m", Pid: "t", Uid: "Alice"})
+	seedOrg(srv, "ou=Source,ou=top,dc=test", OrgNode{Mid: "m", Pid: "m", Id: "s", Name: "Source"})
+	seedOrg(srv, "ou=Extra,ou=Source,ou=top,dc=test", OrgNode{Mid: "m", Pid: "s", Id: "se", Name: "Extra", Order: 0})
+	seedOrg(srv, "ou=sales,ou=Source,ou=top,dc=test", OrgNode{Mid: "m", Pid: "s", Id: "ss", Name: "sales", Order: 1})
+	seedLeaf(srv, "cn=alice,ou=Source,ou=top,dc=test", LeafNode{Mid: "m", Pid: "s", Uid: "alice"})
+}
+
+func entryDns(srv *fakeLdap) string {
+	dns := srv.under("dc=test")
+	for i := range dns {
+		dns[i] = dnKey(dns[i])
+	}
+	sort.Strings(dns)
+	return strings.Join(dns, "; ")
+}
+
+// 名称只有大小写不同时ldap视为冲突，MERGE_FAIL不做任何修改
+func TestLdapMergeFailCaseInsensitive(t *testing.T) {
+	srv, tree := newTestLdap(t)
+	seedMergeLdap(srv)
+	before := entryDns(srv)
+	_, err := tree.MergeOrgNodes("m", "s", "t", MergeOptions{Strategy: MERGE_FAIL})
+	if !errors.Is(err, ErrDuplicateName) {
+		t.Fatalf("err = %v, want ErrDuplicateName", err)
+	}
+	if after := entryDns(srv); after != before {
+		t.Errorf("entries changed:\n%s\n%s", before, after)
+	}
+}
+
+func TestLdapMergeRecursiveCaseInsensitive(t *testing.T) {
+	srv, tree := newTestLdap(t)
+	seedMergeLdap(srv)
+	report, err := tree.MergeOrgNodes("m", "s", "t", MergeOptions{Strategy: MERGE_RECURSIVE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Done != len(report.Steps) {
+		t.Errorf("done %d of %d steps", report.Done, len(report.Steps))
+	}
+	want := "cn=alice,ou=target,ou=top,dc=test; ou=extra,ou=target,ou=top,dc=test; " +
+		"ou=sales,ou=target,ou=top,dc=test; ou=target,ou=top,dc=test; ou=top,dc=test"
+	if got := entryDns(srv); got != want {
+		t.Errorf("entries = %s, want %s", got, want)
+	}
+}
+
+// 上级DN写法与拼接的DN不同时，原位改名不视为移动，排序及上级不变
+func TestLdapMergeMoveOrgRenameInPlace(t *testing.T) {
+	srv, tree := newTestLdap(t)
+	seedOrg(srv, "ou=top,dc=test", OrgNode{Mid: "m", Id: "m", Name: "top"})
+	seedOrg(srv, "OU=Target, OU=TOP,DC=test", OrgNode{Mid: "m", Pid: "m", Id: "t", Name: "Target"})
+	seedOrg(srv, "ou=Source,OU=TARGET,ou=top,dc=test", OrgNode{Mid: "m", Pid: "t", Id: "s", Name: "Source", Order: 0})
+	seedOrg(srv, "ou=Other,ou=target,ou=top,dc=test", OrgNode{Mid: "m", Pid: "t", Id: "o", Name: "Other", Order: 1})
+	conn, tree_dn, err := tree.connectTree("m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.release(conn, &err)
+	exec := &ldapMerge{tree: tree, conn: conn, mid: "m", tree_dn: tree_dn}
+	if err = exec.moveOrg("s", "t", "Source (1)"); err != nil {
+		t.Fatal(err)
+	}
+	e := srv.get("ou=Source (1),ou=Target,ou=top,dc=test")
+	if e == nil {
+		t.Fatalf("renamed entry not found: %v", srv.under("dc=test"))
+	}
+	if order := e.values("postalCode"); len(order) != 1 || order[0] != "0" {
+		t.Errorf("order = %v, want [0]", order)
+	}
+	if name := e.values("ou"); len(name) != 1 || name[0] != "Source (1)" {
+		t.Errorf("name = %v", name)
+	}
+}
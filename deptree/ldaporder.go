@@ -0,0 +1,139 @@
+package deptree
+
+import (
+	"strconv"
+
+	ldap "github.com/go-ldap/ldap"
+)
+
+// nextOrder 父节点下新节点的排序序号 filter为组织节点或叶子的过滤条件，attr为排序属性
+func (self *ldapDepTree) nextOrder(parent_dn string, filter string, attr string, conn *ldap.Conn) (int, error) {
+	searchReq := ldap.NewSearchRequest(parent_dn, ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false, filter, []string{attr}, nil)
+	sr, err := conn.Search(searchReq)
+	if err != nil {
+		return 0, err
+	}
+	order := 0
+	for _, e := range sr.Entries {
+		if n, _ := strconv.Atoi(e.GetAttributeValue(attr)); n > order {
+			order = n
+		}
+	}
+	return order + 1, nil
+}
+
+// renumber 按新顺序重新编号同级节点 只修改序号有变化的条目
+// dns为 组织节点ID或叶子uid -> dn，orders为原序号，ldap无事务，中途失败时已修改的条目不回滚
+func (self *ldapDepTree) renumber(order []string, dns map[string]string, orders map[string]int,
+	attrs []string, conn *ldap.Conn) error {
+	for i, k := range order {
+		if orders[k] == i+1 {
+			continue
+		}
+		modReq := ldap.NewModifyRequest(dns[k])
+		for _, attr := range attrs {
+			modReq.Replace(attr, []string{strconv.Itoa(i + 1)})
+		}
+		if err := conn.Modify(modReq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReorderOrgNode 调整组织节点在同级中的位置 同级节点按新顺序重新编号
+func (self *ldapDepTree) ReorderOrgNode(mid string, id string, place Placement) (err error) {
+	defer ldapError("ReorderOrgNode", &err)
+	if id == "" || mid == "" {
+		return newError(ErrInvalidArgument, "", "invalid id or mid [%s,%s]", id, mid)
+	}
+	if id == mid {
+		return newError(ErrInvalidArgument, "", "can't reorder the top tree: %s", mid)
+	}
+	conn, tree_dn, err := self.connectTree(mid)
+	if conn == nil {
+		return err
+	}
+	defer self.release(conn, &err)
+	if err != nil {
+		return err
+	}
+	dn, err := self.getSubTreeDn(tree_dn, id, conn)
+	if err != nil {
+		return err
+	}
+	searchReq := ldap.NewSearchRequest(parentDn(dn), ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false, self.schema.orgFilter(""),
+		self.schema.orgAttrList(), nil)
+	sr, err := conn.Search(searchReq)
+	if err != nil {
+		return err
+	}
+	siblings := make([]OrgNode, len(sr.Entries))
+	dns := map[string]string{}
+	for i, e := range sr.Entries {
+		self.schema.ldap2orgnode(e, &siblings[i])
+		dns[siblings[i].Id] = e.DN
+	}
+	sortSiblingOrgs(siblings)
+	ids := []string{}
+	orders := map[string]int{}
+	for _, n := range siblings {
+		ids = append(ids, n.Id)
+		orders[n.Id] = n.Order
+	}
+	order, err := placeSibling(ids, id, place)
+	if err != nil {
+		return err
+	}
+	return self.renumber(order, dns, orders, self.schema.OrgAttrs["Order"], conn)
+}
+
+// ReorderLeafNode 调整叶子在同级中的位置 同级叶子按新顺序重新编号
+func (self *ldapDepTree) ReorderLeafNode(mid string, pid string, uid string, place Placement) (err error) {
+	defer ldapError("ReorderLeafNode", &err)
+	conn, tree_dn, err := self.connectTree(mid)
+	if conn == nil {
+		return err
+	}
+	defer self.release(conn, &err)
+	if err != nil {
+		return err
+	}
+	parent_dn := tree_dn
+	if pid != mid {
+		parent_dn, err = self.getSubTreeDn(tree_dn, pid, conn)
+		if err != nil {
+			return err
+		}
+	}
+	searchReq := ldap.NewSearchRequest(parent_dn, ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false, self.schema.leafFilter(""),
+		self.schema.leafAttrList(), nil)
+	sr, err := conn.Search(searchReq)
+	if err != nil {
+		return err
+	}
+	siblings := make([]LeafNode, len(sr.Entries))
+	dns := map[string]string{}
+	for i, e := range sr.Entries {
+		self.schema.ldap2leafnode(e, &siblings[i])
+		dns[siblings[i].Uid] = e.DN
+	}
+	sortSiblingLeafs(siblings)
+	uids := []string{}
+	orders := map[string]int{}
+	for _, l := range siblings {
+		uids = append(uids, l.Uid)
+		orders[l.Uid] = l.Order
+	}
+	order, err := placeSibling(uids, uid, place)
+	if err != nil {
+		return err
+	}
+	return self.renumber(order, dns, orders, self.schema.LeafAttrs["Order"], conn)
+}
@@ -0,0 +1,237 @@
+package deptree
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	ldap "github.com/go-ldap/ldap"
+)
+
+// ldapPager 使用paged results control(RFC 2696)的分页查询
+// 服务端的cookie与连接绑定，翻页期间该连接不归还连接池，
+// 查询到最后一页、出错或超过timeout未继续翻页时释放
+// 进行中的会话数不超过maxSessions，超过时新的分页查询直接失败，不占用其他操作所需的连接
+// 游标只在本进程内有效(会话保存在内存中，多实例部署时翻页请求需到达同一实例)，且只能使用一次：
+// 每次翻页返回新的游标，已使用的游标失效
+type ldapPager struct {
+	lock     sync.Mutex
+	sessions map[string]*ldapPageSession // 游标 -> 会话
+	slots    chan struct{}               // 会话数信号量 第一页查询前占用，会话结束时释放
+	timeout  time.Duration
+	pool     *ldapPool
+}
+
+// ldapPageSession 进行中的分页查询
+type ldapPageSession struct {
+	conn    *ldap.Conn
+	req     *ldap.SearchRequest
+	paging  *ldap.ControlPaging
+	expires time.Time
+}
+
+// newLdapPager 根据配置生成分页器
+// PageTimeout     翻页间隔超时(秒) 默认300
+// PageMaxSessions 同时进行的分页会话数 默认为连接池大小的一半，最多为连接池大小减1(至少为1)
+func newLdapPager(config map[string]interface{}, pool *ldapPool) *ldapPager {
+	size := cap(pool.sem)
+	max := configInt(config, "PageMaxSessions", size/2)
+	if max >= size {
+		max = size - 1
+	}
+	if max <= 0 {
+		max = 1
+	}
+	return &ldapPager{
+		sessions: map[string]*ldapPageSession{},
+		slots:    make(chan struct{}, max),
+		timeout:  time.Duration(configInt(config, "PageTimeout", 300)) * time.Second,
+		pool:     pool,
+	}
+}
+
+// acquire 为新的分页查询占用一个会话数 会话数已满时返回ErrBackendUnavailable
+// 需在取得连接之前调用，以免分页会话占满连接池
+func (self *ldapPager) acquire() error {
+	self.expire()
+	select {
+	case self.slots <- struct{}{}:
+		return nil
+	default:
+		return newError(ErrBackendUnavailable, "", "ldap pager: too many paged searches in progress (%d)", cap(self.slots))
+	}
+}
+
+// done 会话结束 释放占用的会话数
+func (self *ldapPager) done() {
+	<-self.slots
+}
+
+// first 查询第一页 需已调用acquire，conn由分页器接管，调用方不能再归还
+func (self *ldapPager) first(ctx context.Context, conn *ldap.Conn, req *ldap.SearchRequest, pageSize int) ([]*ldap.Entry, string, error) {
+	s := &ldapPageSession{
+		conn:   conn,
+		req:    req,
+		paging: ldap.NewControlPaging(uint32(pageSize)),
+	}
+	req.Controls = append(req.Controls, s.paging)
+	return self.search(ctx, s)
+}
+
+// next 根据游标查询下一页 本页查询受ctx控制
+func (self *ldapPager) next(ctx context.Context, cursor string, pageSize int) ([]*ldap.Entry, string, error) {
+	self.expire()
+	self.lock.Lock()
+	s := self.sessions[cursor]
+	delete(self.sessions, cursor)
+	self.lock.Unlock()
+	if s == nil {
+		return nil, "", newError(ErrInvalidArgument, "", "invalid or expired cursor: %s", cursor)
+	}
+	s.paging.PagingSize = uint32(pageSize)
+	self.pool.watch(ctx, s.conn)
+	return self.search(ctx, s)
+}
+
+// search 查询一页 还有后续页时保存会话并返回新游标
+// 保存前解除ctx对连接的控制，翻页间隔由timeout控制
+func (self *ldapPager) search(ctx context.Context, s *ldapPageSession) ([]*ldap.Entry, string, error) {
+	sr, err := s.conn.Search(s.req)
+	if err != nil {
+		self.pool.discard(s.conn)
+		self.done()
+		return nil, "", err
+	}
+	var cookie []byte
+	if c, ok := ldap.FindControl(sr.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging); ok {
+		cookie = c.Cookie
+	}
+	if len(cookie) == 0 {
+		self.pool.put(s.conn, nil)
+		self.done()
+		return sr.Entries, "", nil
+	}
+	if !self.pool.unwatch(s.conn) {
+		self.pool.discard(s.conn)
+		self.done()
+		return nil, "", ctx.Err()
+	}
+	s.paging.SetCookie(cookie)
+	s.expires = time.Now().Add(self.timeout)
+	cursor := GetId()
+	self.lock.Lock()
+	self.sessions[cursor] = s
+	self.lock.Unlock()
+	return sr.Entries, cursor, nil
+}
+
+// expire 放弃超时的会话 通知服务端释放结果集后归还连接
+func (self *ldapPager) expire() {
+	now := time.Now()
+	expired := []*ldapPageSession{}
+	self.lock.Lock()
+	for cursor, s := range self.sessions {
+		if now.After(s.expires) {
+			expired = append(expired, s)
+			delete(self.sessions, cursor)
+		}
+	}
+	self.lock.Unlock()
+	for _, s := range expired {
+		s.paging.PagingSize = 0
+		if _, err := s.conn.Search(s.req); err != nil {
+			self.pool.discard(s.conn)
+		} else {
+			self.pool.put(s.conn, nil)
+		}
+		self.done()
+	}
+}
+
+// pagedSearch 分页搜索oid对应节点下的条目 cursor为空时由build根据节点dn生成搜索请求
+func (self *ldapDepTree) pagedSearch(mid string, oid string, pageSize int, cursor string,
+	build func(org_dn string) *ldap.SearchRequest) ([]*ldap.Entry, string, error) {
+	if err := checkPageSize(pageSize); err != nil {
+		return nil, "", err
+	}
+	if cursor != "" {
+		return self.pager.next(self.ctx, cursor, pageSize)
+	}
+	if err := self.pager.acquire(); err != nil {
+		return nil, "", err
+	}
+	conn, tree_dn, err := self.connectTree(mid)
+	if conn == nil {
+		self.pager.done()
+		return nil, "", err
+	}
+	if err != nil {
+		self.release(conn, &err)
+		self.pager.done()
+		return nil, "", err
+	}
+	org_dn, err := self.getSubTreeDn(tree_dn, oid, conn)
+	if err != nil {
+		self.release(conn, &err)
+		self.pager.done()
+		return nil, "", err
+	}
+	return self.pager.first(self.ctx, conn, build(org_dn), pageSize)
+}
+
+// pagedLeafs 分页搜索叶子节点
+func (self *ldapDepTree) pagedLeafs(mid string, oid string, pageSize int, cursor string, filter string) ([]LeafNode, string, error) {
+	entries, next, err := self.pagedSearch(mid, oid, pageSize, cursor, func(org_dn string) *ldap.SearchRequest {
+		return ldap.NewSearchRequest(org_dn, ldap.ScopeWholeSubtree,
+			ldap.NeverDerefAliases,
+			0, 0, false, filter,
+			self.schema.leafAttrList(), nil)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	ret := []LeafNode{}
+	for _, e := range entries {
+		oneleaf := LeafNode{}
+		self.schema.ldap2leafnode(e, &oneleaf)
+		ret = append(ret, oneleaf)
+	}
+	return ret, next, nil
+}
+
+// GetLeafNodesByOrgPaged 分页取组织节点下的叶子节点
+func (self *ldapDepTree) GetLeafNodesByOrgPaged(mid string, oid string, pageSize int, cursor string) (_ []LeafNode, _ string, err error) {
+	defer ldapError("GetLeafNodesByOrgPaged", &err)
+	return self.pagedLeafs(mid, oid, pageSize, cursor, self.schema.leafFilter(""))
+}
+
+// GetUsersByPositionPaged 分页根据岗位查询叶子节点
+func (self *ldapDepTree) GetUsersByPositionPaged(mid string, pid string, positionid string, pageSize int, cursor string) (_ []LeafNode, _ string, err error) {
+	defer ldapError("GetUsersByPositionPaged", &err)
+	return self.pagedLeafs(mid, pid, pageSize, cursor, self.schema.leafFieldFilter("Positions", positionid))
+}
+
+// GetOrgNodesByOrgPaged 分页取组织节点下的节点
+func (self *ldapDepTree) GetOrgNodesByOrgPaged(mid string, oid string, dept int, pageSize int, cursor string) (_ []OrgNode, _ string, err error) {
+	defer ldapError("GetOrgNodesByOrgPaged", &err)
+	entries, next, err := self.pagedSearch(mid, oid, pageSize, cursor, func(org_dn string) *ldap.SearchRequest {
+		sign := ldap.ScopeWholeSubtree
+		if dept == 1 {
+			sign = ldap.ScopeSingleLevel
+		}
+		return ldap.NewSearchRequest(org_dn, sign,
+			ldap.NeverDerefAliases,
+			0, 0, false, self.schema.orgFilter(""),
+			self.schema.orgAttrList(), nil)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	ret := []OrgNode{}
+	for _, e := range entries {
+		oneorg := OrgNode{}
+		self.schema.ldap2orgnode(e, &oneorg)
+		ret = append(ret, oneorg)
+	}
+	return ret, next, nil
+}
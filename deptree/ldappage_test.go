@@ -0,0 +1,106 @@
+package deptree
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// seedPagedLeafs 顶级节点m下n个叶子
+func seedPagedLeafs(srv *fakeLdap, n int) {
+	seedOrg(srv, "ou=top,dc=test", OrgNode{Mid: "m", Id: "m", Name: "top"})
+	for i := 0; i < n; i++ {
+		uid := fmt.Sprintf("u%d", i)
+		seedLeaf(srv, "cn="+uid+",ou=top,dc=test", LeafNode{Mid: "m", Pid: "m", Uid: uid})
+	}
+}
+
+// 逐页取完全部叶子，已使用的游标失效
+func TestLdapPagedSearch(t *testing.T) {
+	srv, tree := newTestLdap(t)
+	seedPagedLeafs(srv, 5)
+	seen := map[string]bool{}
+	cursor, pages := "", 0
+	for {
+		leafs, next, err := tree.GetLeafNodesByOrgPaged("m", "m", 2, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range leafs {
+			seen[l.Uid] = true
+		}
+		pages++
+		if next == "" {
+			break
+		}
+		if cursor != "" {
+			if _, _, err = tree.GetLeafNodesByOrgPaged("m", "m", 2, cursor); !errors.Is(err, ErrInvalidArgument) {
+				t.Errorf("reused cursor err = %v, want ErrInvalidArgument", err)
+			}
+		}
+		cursor = next
+	}
+	if len(seen) != 5 || pages != 3 {
+		t.Errorf("got %d leafs in %d pages, want 5 in 3", len(seen), pages)
+	}
+	if n := len(tree.pager.slots); n != 0 {
+		t.Errorf("%d paged sessions still counted", n)
+	}
+	if n := len(tree.pool.sem); n != 0 {
+		t.Errorf("%d connections still counted as open", n)
+	}
+}
+
+// 分页会话数达到上限时新的分页查询直接失败，其他操作仍可取得连接
+func TestLdapPagedSessionLimit(t *testing.T) {
+	srv, tree := newTestLdapWith(t, map[string]interface{}{"PoolSize": float64(3), "PoolWaitTimeout": float64(1)})
+	seedPagedLeafs(srv, 5)
+	if n := cap(tree.pager.slots); n != 1 {
+		t.Fatalf("max sessions = %d, want 1", n)
+	}
+	_, cursor, err := tree.GetLeafNodesByOrgPaged("m", "m", 2, "")
+	if err != nil || cursor == "" {
+		t.Fatalf("first page = %q, %v", cursor, err)
+	}
+	start := time.Now()
+	if _, _, err = tree.GetLeafNodesByOrgPaged("m", "m", 2, ""); !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("second session err = %v, want ErrBackendUnavailable", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("second session failed after %v, want fail fast", d)
+	}
+	if _, err = tree.GetOrgNode("m", "m"); err != nil {
+		t.Errorf("GetOrgNode during paged search = %v", err)
+	}
+	// 翻到最后一页后释放会话
+	for cursor != "" {
+		if _, cursor, err = tree.GetLeafNodesByOrgPaged("m", "m", 2, cursor); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err = tree.GetLeafNodesByOrgPaged("m", "m", 10, ""); err != nil {
+		t.Errorf("paged search after the session ended = %v", err)
+	}
+}
+
+// 超时的会话在取得连接之前释放，PoolSize为1时新的分页查询也能取得连接
+func TestLdapPagedExpireBeforeConnect(t *testing.T) {
+	srv, tree := newTestLdapWith(t, map[string]interface{}{"PoolSize": float64(1), "PoolWaitTimeout": float64(1)})
+	seedPagedLeafs(srv, 5)
+	_, cursor, err := tree.GetLeafNodesByOrgPaged("m", "m", 2, "")
+	if err != nil || cursor == "" {
+		t.Fatalf("first page = %q, %v", cursor, err)
+	}
+	tree.pager.lock.Lock()
+	tree.pager.sessions[cursor].expires = time.Now().Add(-time.Second)
+	tree.pager.lock.Unlock()
+
+	leafs, _, err := tree.GetLeafNodesByOrgPaged("m", "m", 10, "")
+	if err != nil || len(leafs) != 5 {
+		t.Errorf("paged search after expiry = %d leafs, %v", len(leafs), err)
+	}
+	if _, _, err = tree.GetLeafNodesByOrgPaged("m", "m", 2, cursor); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("expired cursor err = %v, want ErrInvalidArgument", err)
+	}
+}
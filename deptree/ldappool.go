@@ -0,0 +1,249 @@
+package deptree
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	ldap "github.com/go-ldap/ldap"
+)
+
+// ldapPool 已绑定ldap连接的连接池
+// 同时打开的连接数不超过size，空闲超过idleTimeout的连接被关闭，
+// 空闲超过checkInterval的连接取出时先做健康检查，检查失败则重新建立连接
+// 已关闭或出现网络错误的连接不放回池中
+type ldapPool struct {
+	lock          sync.Mutex
+	idle          []*pooledConn // 空闲连接 后进先出
+	sem           chan struct{} // 打开连接数信号量
+	maxIdle       int
+	idleTimeout   time.Duration
+	checkInterval time.Duration
+	checkTimeout  time.Duration                                 // 健康检查的请求超时
+	waitTimeout   time.Duration                                 // 等待可用连接的超时时间 0为一直等待
+	dial          func(ctx context.Context) (*ldap.Conn, error) // 新建连接 建立连接及绑定受ctx控制
+	watches       sync.Map                                      // *ldap.Conn -> 停止context监视的函数，见watch
+}
+
+// pooledConn 池中的空闲连接
+type pooledConn struct {
+	conn  *ldap.Conn
+	since time.Time // 放回池中的时间
+}
+
+// newLdapPool 根据配置生成连接池
+// PoolSize          最大连接数 默认10
+// PoolMaxIdle       最大空闲连接数 默认等于PoolSize
+// PoolIdleTimeout   空闲连接超时关闭(秒) 默认300
+// PoolCheckInterval 空闲超过该时间的连接使用前做健康检查(秒) 默认30
+// PoolCheckTimeout  健康检查的请求超时(秒) 默认5
+// PoolWaitTimeout   等待可用连接超时(秒) 默认0一直等待
+func newLdapPool(config map[string]interface{}, dial func(ctx context.Context) (*ldap.Conn, error)) *ldapPool {
+	size := configInt(config, "PoolSize", 10)
+	if size <= 0 {
+		size = 10
+	}
+	maxIdle := configInt(config, "PoolMaxIdle", size)
+	if maxIdle > size {
+		maxIdle = size
+	}
+	return &ldapPool{
+		sem:           make(chan struct{}, size),
+		maxIdle:       maxIdle,
+		idleTimeout:   time.Duration(configInt(config, "PoolIdleTimeout", 300)) * time.Second,
+		checkInterval: time.Duration(configInt(config, "PoolCheckInterval", 30)) * time.Second,
+		checkTimeout:  time.Duration(configInt(config, "PoolCheckTimeout", 5)) * time.Second,
+		waitTimeout:   time.Duration(configInt(config, "PoolWaitTimeout", 0)) * time.Second,
+		dial:          dial,
+	}
+}
+
+// get 取出一个可用连接，没有空闲连接时新建连接 ctx可为nil，否则等待连接及之后的请求受ctx控制(见watch)
+func (self *ldapPool) get(ctx context.Context) (*ldap.Conn, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var timeout <-chan time.Time
+	if self.waitTimeout > 0 {
+		timer := time.NewTimer(self.waitTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case self.sem <- struct{}{}:
+	case <-timeout:
+		return nil, newError(ErrBackendUnavailable, "", "ldap pool: wait for connection timeout")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	conn, err := self.take(ctx)
+	if err != nil {
+		<-self.sem
+		return nil, err
+	}
+	self.watch(ctx, conn)
+	return conn, nil
+}
+
+// take 取出空闲连接或新建连接 需已占用信号量 健康检查及新建连接不超过ctx的截止时间
+func (self *ldapPool) take(ctx context.Context) (*ldap.Conn, error) {
+	for {
+		pc := self.pop()
+		if pc == nil {
+			break
+		}
+		if pc.conn.IsClosing() {
+			continue
+		}
+		idle := time.Since(pc.since)
+		if self.idleTimeout > 0 && idle > self.idleTimeout {
+			pc.conn.Close()
+			continue
+		}
+		if idle > self.checkInterval && !ping(pc.conn, requestTimeout(ctx, self.checkTimeout)) {
+			pc.conn.Close()
+			continue
+		}
+		return pc.conn, nil
+	}
+	return self.dial(ctx)
+}
+
+// requestTimeout 请求超时 ctx有截止时间且早于timeout时使用截止时间 timeout为0表示不限
+func requestTimeout(ctx context.Context, timeout time.Duration) time.Duration {
+	if ctx == nil {
+		return timeout
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := time.Until(deadline); timeout == 0 || left < timeout {
+			if left <= 0 {
+				// SetTimeout(0)为不限，已过截止时间时使用最小超时
+				left = time.Nanosecond
+			}
+			return left
+		}
+	}
+	return timeout
+}
+
+// watch 使连接上的请求受ctx控制：截止时间设为请求超时，取消时关闭连接以中止进行中的请求
+// 归还或交给分页器保存前需调用unwatch
+func (self *ldapPool) watch(ctx context.Context, conn *ldap.Conn) {
+	if ctx == nil || ctx.Done() == nil {
+		return
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetTimeout(time.Until(deadline))
+	}
+	stop := make(chan struct{})
+	fired := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			fired <- true
+		case <-stop:
+			fired <- false
+		}
+	}()
+	self.watches.Store(conn, func() bool {
+		close(stop)
+		return !<-fired
+	})
+}
+
+// unwatch 停止ctx对连接的控制并恢复请求超时 返回false表示连接已因ctx取消被关闭
+func (self *ldapPool) unwatch(conn *ldap.Conn) bool {
+	v, ok := self.watches.LoadAndDelete(conn)
+	if !ok {
+		return true
+	}
+	conn.SetTimeout(0)
+	return v.(func() bool)()
+}
+
+// put 归还连接 err为使用连接时最后的错误
+// 连接已关闭、出现网络错误或空闲连接已满时直接关闭
+func (self *ldapPool) put(conn *ldap.Conn, err error) {
+	if conn == nil {
+		return
+	}
+	if !self.unwatch(conn) {
+		<-self.sem
+		return
+	}
+	if conn.IsClosing() || isNetworkError(err) {
+		conn.Close()
+		<-self.sem
+		return
+	}
+	self.lock.Lock()
+	if len(self.idle) < self.maxIdle {
+		self.idle = append(self.idle, &pooledConn{conn: conn, since: time.Now()})
+		conn = nil
+	}
+	self.lock.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+	<-self.sem
+}
+
+// pop 取出最近归还的空闲连接
+func (self *ldapPool) pop() *pooledConn {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	n := len(self.idle)
+	if n == 0 {
+		return nil
+	}
+	pc := self.idle[n-1]
+	self.idle = self.idle[:n-1]
+	return pc
+}
+
+// ping 健康检查 读取RootDSE timeout为客户端等待应答的超时，半开的连接超时后视为失效
+func ping(conn *ldap.Conn, timeout time.Duration) bool {
+	searchReq := ldap.NewSearchRequest("", ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		1, 5, false, "(objectClass=*)", []string{"1.1"}, nil)
+	conn.SetTimeout(timeout)
+	defer conn.SetTimeout(0)
+	_, err := conn.Search(searchReq)
+	return err == nil
+}
+
+// redial 关闭出现网络错误的连接，保留占用的信号量新建连接，新连接同样受ctx控制
+func (self *ldapPool) redial(ctx context.Context, conn *ldap.Conn) (*ldap.Conn, error) {
+	self.unwatch(conn)
+	conn.Close()
+	if ctx != nil && ctx.Err() != nil {
+		<-self.sem
+		return nil, ctx.Err()
+	}
+	conn, err := self.dial(ctx)
+	if err != nil {
+		<-self.sem
+		return nil, err
+	}
+	self.watch(ctx, conn)
+	return conn, nil
+}
+
+// isNetworkError 是否为连接断开、请求超时等网络错误，出现后连接不能再使用
+func isNetworkError(err error) bool {
+	var e *ldap.Error
+	return errors.As(err, &e) && e.ResultCode == ldap.ErrorNetwork
+}
+
+// discard 关闭出错的连接并释放占用
+func (self *ldapPool) discard(conn *ldap.Conn) {
+	if conn == nil {
+		return
+	}
+	self.unwatch(conn)
+	conn.Close()
+	<-self.sem
+}
@@ -0,0 +1,147 @@
+package deptree
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ldap "github.com/go-ldap/ldap"
+)
+
+func idleCount(pool *ldapPool) int {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	return len(pool.idle)
+}
+
+// clearIdle 关闭全部空闲连接
+func clearIdle(pool *ldapPool) {
+	for pc := pool.pop(); pc != nil; pc = pool.pop() {
+		pc.conn.Close()
+	}
+}
+
+// 出现网络错误或已关闭的连接不放回连接池
+func TestLdapPoolPutDiscards(t *testing.T) {
+	_, tree := newTestLdap(t)
+	pool := tree.pool
+	netErr := ldap.NewError(ldap.ErrorNetwork, errors.New("connection closed"))
+	cases := []struct {
+		name string
+		err  error
+		prep func(conn *ldap.Conn)
+		keep bool
+	}{
+		{"ok", nil, nil, true},
+		{"result error", ldap.NewError(ldap.LDAPResultNoSuchObject, errors.New("no such object")), nil, true},
+		{"network error", netErr, nil, false},
+		{"wrapped network error", wrapError(ErrBackendUnavailable, "op", netErr), nil, false},
+		{"closing", nil, func(conn *ldap.Conn) { conn.Close() }, false},
+	}
+	for _, c := range cases {
+		clearIdle(pool)
+		conn, err := pool.get(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.prep != nil {
+			c.prep(conn)
+		}
+		pool.put(conn, c.err)
+		if kept := idleCount(pool) == 1; kept != c.keep {
+			t.Errorf("%s: kept = %v, want %v", c.name, kept, c.keep)
+		}
+		if n := len(pool.sem); n != 0 {
+			t.Errorf("%s: %d connections still counted as open", c.name, n)
+		}
+	}
+}
+
+// 取出空闲连接时跳过已关闭的连接
+func TestLdapPoolTakeSkipsClosing(t *testing.T) {
+	srv, tree := newTestLdap(t)
+	pool := tree.pool
+	conn, err := pool.get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.put(conn, nil)
+	srv.dropConns()
+	deadline := time.Now().Add(time.Second)
+	for !conn.IsClosing() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	again, err := pool.get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.put(again, nil)
+	if again == conn {
+		t.Error("got the closed connection")
+	}
+	if n := atomic.LoadInt32(&srv.dials); n != 2 {
+		t.Errorf("dials = %d, want 2", n)
+	}
+}
+
+// 查找顶级树出现网络错误时新建连接重试一次
+func TestLdapRetryOnNetworkError(t *testing.T) {
+	srv, tree := newTestLdap(t)
+	seedOrg(srv, "ou=top,dc=test", OrgNode{Mid: "m", Id: "m", Name: "top"})
+	if _, err := tree.GetOrgNode("m", "m"); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&srv.drop, 1)
+	n, err := tree.GetOrgNode("m", "m")
+	if err != nil || n.Id != "m" {
+		t.Fatalf("GetOrgNode after drop = %v, %v", n, err)
+	}
+	if d := atomic.LoadInt32(&srv.dials); d != 2 {
+		t.Errorf("dials = %d, want 2", d)
+	}
+	if c := idleCount(tree.pool); c != 1 {
+		t.Errorf("idle = %d, want 1", c)
+	}
+
+	// 只重试一次，失败的连接均被关闭
+	atomic.StoreInt32(&srv.drop, 2)
+	if _, err = tree.GetOrgNode("m", "m"); err == nil {
+		t.Error("GetOrgNode succeeded with both connections dropped")
+	}
+	if c := idleCount(tree.pool); c != 0 {
+		t.Errorf("idle = %d, want 0", c)
+	}
+	if n := len(tree.pool.sem); n != 0 {
+		t.Errorf("%d connections still counted as open", n)
+	}
+	if _, err = tree.GetOrgNode("m", "m"); err != nil {
+		t.Errorf("GetOrgNode after failures = %v", err)
+	}
+}
+
+// 健康检查在checkTimeout内未应答的连接被关闭并新建连接
+func TestLdapPoolPingTimeout(t *testing.T) {
+	srv, tree := newTestLdapWith(t, map[string]interface{}{"PoolCheckInterval": float64(0)})
+	pool := tree.pool
+	pool.checkTimeout = 100 * time.Millisecond
+	conn, err := pool.get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.put(conn, nil)
+	atomic.StoreInt32(&srv.mute, 1)
+	start := time.Now()
+	again, err := pool.get(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.put(again, nil)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("get took %v with an unresponsive idle connection", d)
+	}
+	if again == conn || atomic.LoadInt32(&srv.dials) != 2 {
+		t.Errorf("unresponsive connection reused, dials = %d", atomic.LoadInt32(&srv.dials))
+	}
+}
@@ -0,0 +1,354 @@
+package deptree
+
+import (
+	"errors"
+	"strconv"
+
+	ldap "github.com/go-ldap/ldap"
+)
+
+// ldap岗位结构 PositionOu容器(默认deptree-positions)位于Base下，不存在时自动创建，其名称不能用作商户顶级节点名称
+// 避免岗位RDN(默认cn=<岗位ID>)与顶级节点下叶子的RDN(cn=<uid>)冲突，组织树内的子树搜索也不会命中岗位
+// ou=<商户ID>,ou=<PositionOu>,<Base>   商户的岗位容器，新增岗位时自动创建
+// <岗位RDN>,ou=<商户ID>,...            岗位
+
+// positionDn 商户的岗位容器dn
+func (self *ldapDepTree) positionDn(mid string) string {
+	return dnJoin(dnRdn("ou", mid), self.positionBase)
+}
+
+// getPositionEntry 根据岗位ID取商户的岗位条目
+func (self *ldapDepTree) getPositionEntry(mid string, id string, conn *ldap.Conn) (*ldap.Entry, error) {
+	entries, err := self.searchPositionEntries(mid, self.schema.positionFieldFilter("Id", id), conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errNotFound("", "Can't find the position with this id: %s", id)
+	}
+	return entries[0], nil
+}
+
+// searchPositionEntries 搜索商户岗位容器下的岗位条目 容器不存在时返回空
+func (self *ldapDepTree) searchPositionEntries(mid string, filter string, conn *ldap.Conn) ([]*ldap.Entry, error) {
+	searchReq := ldap.NewSearchRequest(self.positionDn(mid), ldap.ScopeSingleLevel,
+		ldap.NeverDerefAliases,
+		0, 0, false, filter,
+		self.schema.positionAttrList(), nil)
+	sr, err := conn.Search(searchReq)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sr.Entries, nil
+}
+
+// searchPositions 搜索商户的岗位
+func (self *ldapDepTree) searchPositions(mid string, filter string, conn *ldap.Conn) ([]Position, error) {
+	entries, err := self.searchPositionEntries(mid, filter, conn)
+	if err != nil {
+		return nil, err
+	}
+	ret := []Position{}
+	for _, e := range entries {
+		pos := Position{}
+		self.schema.ldap2position(e, &pos)
+		ret = append(ret, pos)
+	}
+	return ret, nil
+}
+
+// checkPositionIds 检查岗位均已定义
+func (self *ldapDepTree) checkPositionIds(mid string, positions []string, conn *ldap.Conn) error {
+	if len(positions) == 0 {
+		return nil
+	}
+	defined, err := self.searchPositions(mid, self.schema.positionIdsFilter(positions), conn)
+	if err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	for _, pos := range defined {
+		known[pos.Id] = true
+	}
+	return checkPositionIds(positions, func(id string) bool {
+		return known[id]
+	})
+}
+
+// checkPositionName 判断商户内是否存在其他同名岗位
+func (self *ldapDepTree) checkPositionName(mid string, id string, name string, conn *ldap.Conn) error {
+	same, err := self.searchPositions(mid, self.schema.positionFieldFilter("Name", name), conn)
+	if err != nil {
+		return err
+	}
+	for _, pos := range same {
+		if pos.Id != id {
+			return newError(ErrDuplicateName, "", "position already exists with this name: %s", name)
+		}
+	}
+	return nil
+}
+
+// replaceLeafPositions 将商户内叶子的岗位oldId替换为newId，newId为空时移除
+// ldap无事务，中途失败时已修改的叶子不回滚
+func (self *ldapDepTree) replaceLeafPositions(tree_dn string, oldId string, newId string, conn *ldap.Conn) error {
+	searchReq := ldap.NewSearchRequest(tree_dn, ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, 0, false, self.schema.leafFieldFilter("Positions", oldId),
+		self.schema.leafAttrList(), nil)
+	sr, err := conn.Search(searchReq)
+	if err != nil {
+		return err
+	}
+	for _, e := range sr.Entries {
+		leaf := LeafNode{}
+		self.schema.ldap2leafnode(e, &leaf)
+		positions, changed := replacePosition(leaf.Positions, oldId, newId)
+		if !changed {
+			continue
+		}
+		modReq := ldap.NewModifyRequest(e.DN)
+		for _, attr := range self.schema.LeafAttrs["Positions"] {
+			modReq.Replace(attr, positions)
+		}
+		if err = conn.Modify(modReq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addPositionContainer 新增商户的岗位容器 已存在时忽略
+func (self *ldapDepTree) addPositionContainer(mid string, conn *ldap.Conn) error {
+	if err := self.addOuEntry(self.positionBase, self.positionOu, "", conn); err != nil {
+		return err
+	}
+	return self.addOuEntry(self.positionDn(mid), mid, "", conn)
+}
+
+// delPositions 删除商户的全部岗位及岗位容器(删除顶级节点时)
+func (self *ldapDepTree) delPositions(mid string, conn *ldap.Conn) error {
+	entries, err := self.searchPositionEntries(mid, filterEq("objectClass", self.schema.PositionClass), conn)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err = conn.Del(ldap.NewDelRequest(e.DN, nil)); err != nil {
+			return err
+		}
+	}
+	err = conn.Del(ldap.NewDelRequest(self.positionDn(mid), nil))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil
+	}
+	return err
+}
+
+// AddPosition 新增岗位定义 条目保存在商户的岗位容器下
+func (self *ldapDepTree) AddPosition(pos Position) (_ string, err error) {
+	defer ldapError("AddPosition", &err)
+	if pos.Mid == "" || pos.Name == "" {
+		return "", newError(ErrInvalidArgument, "", "invalid mid or name [%s,%s]", pos.Mid, pos.Name)
+	}
+	conn, err := self.connect()
+	if conn == nil {
+		return "", err
+	}
+	defer self.release(conn, &err)
+
+	_, err = self.getTopTreeDn(pos.Mid, conn)
+	if err != nil {
+		return "", err
+	}
+	if pos.Id == "" {
+		pos.Id = GetId()
+	} else {
+		_, err = self.getPositionEntry(pos.Mid, pos.Id, conn)
+		if err == nil {
+			return "", newError(ErrAlreadyExists, "", "position already exists with this id: %s", pos.Id)
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return "", err
+		}
+	}
+	if err = self.checkPositionName(pos.Mid, pos.Id, pos.Name, conn); err != nil {
+		return "", err
+	}
+	if err = self.addPositionContainer(pos.Mid, conn); err != nil {
+		return "", err
+	}
+	dn := dnJoin(self.schema.positionRdn(pos.Id), self.positionDn(pos.Mid))
+	if err = conn.Add(self.schema.positionAddRequest(dn, pos)); err != nil {
+		return "", err
+	}
+	return pos.Id, nil
+}
+
+// ModifyPosition 修改岗位定义
+func (self *ldapDepTree) ModifyPosition(pos Position) (err error) {
+	defer ldapError("ModifyPosition", &err)
+	conn, err := self.connect()
+	if conn == nil {
+		return err
+	}
+	defer self.release(conn, &err)
+
+	_, err = self.getTopTreeDn(pos.Mid, conn)
+	if err != nil {
+		return err
+	}
+	entry, err := self.getPositionEntry(pos.Mid, pos.Id, conn)
+	if err != nil {
+		return err
+	}
+	values := map[string][]string{
+		"Level":       {strconv.Itoa(pos.Level)},
+		"Description": {},
+	}
+	if pos.Description != "" {
+		values["Description"] = []string{pos.Description}
+	}
+	if pos.Name != "" {
+		if err = self.checkPositionName(pos.Mid, pos.Id, pos.Name, conn); err != nil {
+			return err
+		}
+		values["Name"] = []string{pos.Name}
+	}
+	modReq := ldap.NewModifyRequest(entry.DN)
+	for _, field := range []string{"Name", "Level", "Description"} {
+		v, ok := values[field]
+		if !ok {
+			continue
+		}
+		for _, attr := range self.schema.PositionAttrs[field] {
+			if attr != self.schema.PositionRdn {
+				modReq.Replace(attr, v)
+			}
+		}
+	}
+	return conn.Modify(modReq)
+}
+
+// RenamePosition 修改岗位ID 同步更新拥有该岗位的叶子
+func (self *ldapDepTree) RenamePosition(mid string, oldId string, newId string) (err error) {
+	defer ldapError("RenamePosition", &err)
+	if newId == "" {
+		return newError(ErrInvalidArgument, "", "invalid new position id")
+	}
+	conn, tree_dn, err := self.connectTree(mid)
+	if conn == nil {
+		return err
+	}
+	defer self.release(conn, &err)
+	if err != nil {
+		return err
+	}
+	entry, err := self.getPositionEntry(mid, oldId, conn)
+	if err != nil {
+		return err
+	}
+	if oldId == newId {
+		return nil
+	}
+	_, err = self.getPositionEntry(mid, newId, conn)
+	if err == nil {
+		return newError(ErrAlreadyExists, "", "position already exists with this id: %s", newId)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	rdn := self.schema.positionRdn(newId)
+	err = conn.ModifyDN(ldap.NewModifyDNRequest(entry.DN, rdn, true, ""))
+	if err != nil {
+		return err
+	}
+	// Id映射的属性不是RDN属性时需单独更新
+	modReq := ldap.NewModifyRequest(dnJoin(rdn, self.positionDn(mid)))
+	replaced := false
+	for _, attr := range self.schema.PositionAttrs["Id"] {
+		if attr != self.schema.PositionRdn {
+			modReq.Replace(attr, []string{newId})
+			replaced = true
+		}
+	}
+	if replaced {
+		if err = conn.Modify(modReq); err != nil {
+			return err
+		}
+	}
+	if err = self.replaceLeafPositions(tree_dn, oldId, newId, conn); err != nil {
+		return err
+	}
+	return self.replaceArchivedPositions(mid, oldId, newId, conn)
+}
+
+// DelPosition 删除岗位定义 先从叶子中移除该岗位再删除条目
+func (self *ldapDepTree) DelPosition(mid string, id string) (err error) {
+	defer ldapError("DelPosition", &err)
+	conn, tree_dn, err := self.connectTree(mid)
+	if conn == nil {
+		return err
+	}
+	defer self.release(conn, &err)
+	if err != nil {
+		return err
+	}
+	entry, err := self.getPositionEntry(mid, id, conn)
+	if err != nil {
+		return err
+	}
+	if err = self.replaceLeafPositions(tree_dn, id, "", conn); err != nil {
+		return err
+	}
+	if err = self.replaceArchivedPositions(mid, id, "", conn); err != nil {
+		return err
+	}
+	return conn.Del(ldap.NewDelRequest(entry.DN, nil))
+}
+
+// GetPosition 取岗位定义
+func (self *ldapDepTree) GetPosition(mid string, id string) (_ *Position, err error) {
+	defer ldapError("GetPosition", &err)
+	conn, err := self.connect()
+	if conn == nil {
+		return nil, err
+	}
+	defer self.release(conn, &err)
+
+	_, err = self.getTopTreeDn(mid, conn)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := self.getPositionEntry(mid, id, conn)
+	if err != nil {
+		return nil, err
+	}
+	pos := Position{}
+	self.schema.ldap2position(entry, &pos)
+	return &pos, nil
+}
+
+// GetPositions 取商户的全部岗位定义 按级别、ID排序
+func (self *ldapDepTree) GetPositions(mid string) (_ []Position, err error) {
+	defer ldapError("GetPositions", &err)
+	conn, err := self.connect()
+	if conn == nil {
+		return nil, err
+	}
+	defer self.release(conn, &err)
+
+	_, err = self.getTopTreeDn(mid, conn)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := self.searchPositions(mid, filterEq("objectClass", self.schema.PositionClass), conn)
+	if err != nil {
+		return nil, err
+	}
+	sortPositions(ret)
+	return ret, nil
+}
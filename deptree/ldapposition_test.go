@@ -0,0 +1,59 @@
+package deptree
+
+import (
+	"errors"
+	"testing"
+)
+
+// 岗位ID与顶级节点下叶子的uid相同时互不影响，组织树的查询不返回岗位及岗位容器
+func TestLdapPositionContainer(t *testing.T) {
+	srv, tree := newTestLdap(t)
+	seedOrg(srv, "ou=top,dc=test", OrgNode{Mid: "m", Id: "m", Name: "top"})
+
+	if _, err := tree.AddPosition(Position{Mid: "m", Id: "u1", Name: "Manager"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.AddLeafNode(LeafNode{Mid: "m", Pid: "m", Uid: "u1", Positions: []string{"u1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if srv.get("cn=u1,ou=m,ou=deptree-positions,dc=test") == nil {
+		t.Error("position not stored in the position container")
+	}
+	if srv.get("cn=u1,ou=top,dc=test") == nil {
+		t.Error("leaf not stored under the top tree")
+	}
+
+	sub, err := tree.GetSubTree("m", "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sub.SubTrees) != 0 || len(sub.SubLeafs) != 1 {
+		t.Errorf("subtree has %d orgs %d leafs, want 0 1", len(sub.SubTrees), len(sub.SubLeafs))
+	}
+	positions, err := tree.GetPositions("m")
+	if err != nil || len(positions) != 1 || positions[0].Name != "Manager" {
+		t.Errorf("GetPositions = %v, %v", positions, err)
+	}
+
+	if err = tree.RenamePosition("m", "u1", "boss"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tree.GetPosition("m", "boss"); err != nil {
+		t.Error(err)
+	}
+	leafs, err := tree.GetLeafNodesByOrg("m", "m")
+	if err != nil || len(leafs) != 1 || len(leafs[0].Positions) != 1 || leafs[0].Positions[0] != "boss" {
+		t.Errorf("leafs after rename = %v, %v", leafs, err)
+	}
+
+	// 删除顶级节点时一并删除岗位容器
+	if err = tree.DelOrgNode("m", "m"); err != nil {
+		t.Fatal(err)
+	}
+	if srv.get("ou=m,ou=deptree-positions,dc=test") != nil {
+		t.Error("position container not removed with the top tree")
+	}
+	if _, err = tree.GetPositions("m"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetPositions after delete err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,167 @@
+package deptree
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	ldap "github.com/go-ldap/ldap"
+)
+
+// ldap过滤条件及DN的构造，调用方传入的值必须经由此处转义后才能拼接
+
+// escapeFilterValue 按RFC 4515转义过滤条件中的值
+// NUL ( ) * \ 及非法UTF-8字节转义为\XX，中文等合法UTF-8字符保持原样
+func escapeFilterValue(value string) string {
+	buf := strings.Builder{}
+	for i := 0; i < len(value); {
+		r, size := utf8.DecodeRuneInString(value[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&buf, "\\%02x", value[i])
+		case r == 0 || r == '(' || r == ')' || r == '*' || r == '\\':
+			fmt.Fprintf(&buf, "\\%02x", r)
+		default:
+			buf.WriteString(value[i : i+size])
+		}
+		i += size
+	}
+	return buf.String()
+}
+
+// filterEq 等值条件 (attr=value)
+func filterEq(attr string, value string) string {
+	return "(" + attr + "=" + escapeFilterValue(value) + ")"
+}
+
+// filterMatch 按匹配方式MATCH_*生成条件 前缀(attr=value*) 包含(attr=*value*)
+func filterMatch(attr string, value string, match int) string {
+	switch match {
+	case MATCH_PREFIX:
+		return "(" + attr + "=" + escapeFilterValue(value) + "*)"
+	case MATCH_SUBSTRING:
+		return "(" + attr + "=*" + escapeFilterValue(value) + "*)"
+	}
+	return filterEq(attr, value)
+}
+
+// filterAnd 与条件 忽略空条件，只有一个条件时直接返回该条件
+func filterAnd(filters ...string) string {
+	return filterJoin("&", filters)
+}
+
+// filterOr 或条件 忽略空条件，只有一个条件时直接返回该条件
+func filterOr(filters ...string) string {
+	return filterJoin("|", filters)
+}
+
+// filterJoin 组合条件
+func filterJoin(op string, filters []string) string {
+	conds := make([]string, 0, len(filters))
+	for _, f := range filters {
+		if f != "" {
+			conds = append(conds, f)
+		}
+	}
+	switch len(conds) {
+	case 0:
+		return ""
+	case 1:
+		return conds[0]
+	}
+	return "(" + op + strings.Join(conds, "") + ")"
+}
+
+// escapeDNValue 按RFC 4514转义RDN中的属性值
+// 开头的空格或#、结尾的空格及 " + , ; < > = \ 前加\，NUL及非法UTF-8字节转义为\XX
+func escapeDNValue(value string) string {
+	buf := strings.Builder{}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(value[i:])
+			if r == utf8.RuneError && size == 1 {
+				fmt.Fprintf(&buf, "\\%02x", c)
+			} else {
+				buf.WriteString(value[i : i+size])
+				i += size - 1
+			}
+			continue
+		}
+		switch {
+		case c == 0:
+			buf.WriteString("\\00")
+		case c == '"' || c == '+' || c == ',' || c == ';' || c == '<' || c == '>' || c == '=' || c == '\\',
+			c == ' ' && (i == 0 || i == len(value)-1),
+			c == '#' && i == 0:
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+// dnRdn 生成RDN attr=value
+func dnRdn(attr string, value string) string {
+	return attr + "=" + escapeDNValue(value)
+}
+
+// dnJoin 拼接RDN与上级DN
+func dnJoin(rdn string, parent string) string {
+	if parent == "" {
+		return rdn
+	}
+	return rdn + "," + parent
+}
+
+// dnSplit 在第一个未转义的逗号处拆分DN 返回RDN及上级DN
+func dnSplit(dn string) (string, string) {
+	quoted := false
+	for i := 0; i < len(dn); i++ {
+		switch dn[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case ',', ';':
+			if !quoted {
+				return dn[:i], strings.TrimLeft(dn[i+1:], " ")
+			}
+		}
+	}
+	return dn, ""
+}
+
+// dnIsUnder 判断dn是否为ancestor的子孙
+func dnIsUnder(dn string, ancestor string) bool {
+	key := dnKey(ancestor)
+	for _, parent := dnSplit(dn); parent != ""; _, parent = dnSplit(parent) {
+		if dnKey(parent) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// dnKey DN的规范形式，用于比较及作为map的键
+// 服务器返回的DN与拼接的DN在大小写、逗号后空格及转义方式(\2C与\,)上可能不同，
+// 解析后属性名及值转为小写并按escapeDNValue重新转义；无法解析时使用小写的原值
+func dnKey(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(dn)
+	}
+	rdns := make([]string, len(parsed.RDNs))
+	for i, rdn := range parsed.RDNs {
+		attrs := make([]string, len(rdn.Attributes))
+		for j, attr := range rdn.Attributes {
+			attrs[j] = dnRdn(strings.ToLower(attr.Type), strings.ToLower(attr.Value))
+		}
+		sort.Strings(attrs)
+		rdns[i] = strings.Join(attrs, "+")
+	}
+	return strings.Join(rdns, ",")
+}
@@ -0,0 +1,120 @@
+package deptree
+
+import (
+	"testing"
+
+	ldap "github.com/go-ldap/ldap"
+)
+
+// 含有过滤条件及DN特殊字符的名称
+var hostileNames = []string{
+	"*", "(", ")", "\\", "\x00", ",", "+", "=", "#lead", " lead", "trail ",
+	"a*)(uid=*", "R&D, Sales", "x\\2Cy", "\"q\"", "<a>;b", "中文部门", "\xff\xfe",
+}
+
+func TestEscapeFilterValue(t *testing.T) {
+	cases := []struct {
+		in, out string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{"*", `\2a`},
+		{"(", `\28`},
+		{")", `\29`},
+		{"\\", `\5c`},
+		{"\x00", `\00`},
+		{"a*)(uid=*", `a\2a\29\28uid=\2a`},
+		{",+=# ", ",+=# "},
+		{"中文", "中文"},
+		{"\xff", `\ff`},
+	}
+	for _, c := range cases {
+		if got := escapeFilterValue(c.in); got != c.out {
+			t.Errorf("escapeFilterValue(%q) = %q, want %q", c.in, got, c.out)
+		}
+	}
+	// 转义后的条件必须能被解析，且值保持原样
+	for _, name := range hostileNames {
+		filter := filterEq("ou", name)
+		if _, err := ldap.CompileFilter(filter); err != nil {
+			t.Errorf("filterEq(ou, %q) = %q: %v", name, filter, err)
+		}
+	}
+}
+
+func TestEscapeDNValue(t *testing.T) {
+	cases := []struct {
+		in, out string
+	}{
+		{"", ""},
+		{"plain", "plain"},
+		{",", `\,`},
+		{"+", `\+`},
+		{"=", `\=`},
+		{"\\", `\\`},
+		{"\x00", `\00`},
+		{`"q"`, `\"q\"`},
+		{"<a>;b", `\<a\>\;b`},
+		{"#lead", `\#lead`},
+		{"a#b", "a#b"},
+		{" lead", `\ lead`},
+		{"trail ", `trail\ `},
+		{"in side", "in side"},
+		{"*()", "*()"},
+		{"中文", "中文"},
+		{"\xffa", `\ffa`},
+	}
+	for _, c := range cases {
+		if got := escapeDNValue(c.in); got != c.out {
+			t.Errorf("escapeDNValue(%q) = %q, want %q", c.in, got, c.out)
+		}
+	}
+	// 转义后的RDN解析回来的值与原值一致
+	for _, name := range hostileNames {
+		dn := dnJoin(dnRdn("ou", name), "dc=test")
+		parsed, err := ldap.ParseDN(dn)
+		if err != nil {
+			t.Errorf("ParseDN(%q): %v", dn, err)
+			continue
+		}
+		if len(parsed.RDNs) != 2 || len(parsed.RDNs[0].Attributes) != 1 {
+			t.Errorf("ParseDN(%q) split into %d rdns", dn, len(parsed.RDNs))
+			continue
+		}
+		if got := parsed.RDNs[0].Attributes[0].Value; got != name {
+			t.Errorf("ParseDN(%q) value = %q, want %q", dn, got, name)
+		}
+	}
+}
+
+func TestDnSplit(t *testing.T) {
+	cases := []struct {
+		dn, rdn, parent string
+	}{
+		{"", "", ""},
+		{"dc=test", "dc=test", ""},
+		{"ou=a,dc=test", "ou=a", "dc=test"},
+		{"ou=a, dc=test", "ou=a", "dc=test"},
+		{"ou=a;dc=test", "ou=a", "dc=test"},
+		{`ou=a\,b,dc=test`, `ou=a\,b`, "dc=test"},
+		{`ou=a\\,dc=test`, `ou=a\\`, "dc=test"},
+		{`ou=a\2Cb,dc=test`, `ou=a\2Cb`, "dc=test"},
+		{`ou="a,b",dc=test`, `ou="a,b"`, "dc=test"},
+		{`ou=a\+b+cn=c,dc=test`, `ou=a\+b+cn=c`, "dc=test"},
+		{`ou=\ a\ ,dc=test`, `ou=\ a\ `, "dc=test"},
+	}
+	for _, c := range cases {
+		rdn, parent := dnSplit(c.dn)
+		if rdn != c.rdn || parent != c.parent {
+			t.Errorf("dnSplit(%q) = %q, %q, want %q, %q", c.dn, rdn, parent, c.rdn, c.parent)
+		}
+	}
+	// 拼接后拆分得到原RDN及上级DN
+	for _, name := range hostileNames {
+		rdn := dnRdn("ou", name)
+		gotRdn, gotParent := dnSplit(dnJoin(rdn, "ou=p,dc=test"))
+		if gotRdn != rdn || gotParent != "ou=p,dc=test" {
+			t.Errorf("dnSplit(dnJoin(%q)) = %q, %q", rdn, gotRdn, gotParent)
+		}
+	}
+}
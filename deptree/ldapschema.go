@@ -0,0 +1,430 @@
+package deptree
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	ldap "github.com/go-ldap/ldap"
+)
+
+// LdapSchema ldap属性映射 通过config["Schema"]配置，未配置的项使用默认值
+// OrgAttrs/LeafAttrs 为 节点字段 -> ldap属性列表，读取使用第一个属性，写入全部属性
+// 组织节点字段：Mid Pid Id Name Type IsDefault Order Attrs 叶子节点字段：Mid Pid Sid Uid Positions Order Attrs
+// 岗位字段：Mid Id Name Level Description 岗位条目保存在Base下PositionOu容器的商户子容器中
+// 扩展属性Attrs以 名称=值 的多值属性保存，值中的\及$按RFC 4517转义为\5C \24
+type LdapSchema struct {
+	OrgClass     string              // 搜索组织节点使用的objectClass
+	OrgClasses   []string            // 新增组织节点写入的objectClass
+	OrgRdn       string              // 组织节点RDN属性，取值为Name
+	OrgAttrs     map[string][]string // 组织节点属性映射
+	LeafClass    string              // 搜索叶子节点使用的objectClass
+	LeafClasses  []string            // 新增叶子节点写入的objectClass
+	LeafRdn      string              // 叶子节点RDN属性，取值为Uid
+	LeafAttrs    map[string][]string // 叶子节点属性映射
+	LeafDefaults map[string][]string // 新增叶子节点时附加写入的固定属性
+
+	PositionClass   string              // 搜索岗位使用的objectClass
+	PositionClasses []string            // 新增岗位写入的objectClass
+	PositionRdn     string              // 岗位RDN属性，取值为Id
+	PositionAttrs   map[string][]string // 岗位属性映射
+
+	AttrClass string // 保存扩展属性的辅助objectClass 为空时Attrs映射的属性需为组织节点及叶子objectClass允许的属性
+}
+
+// defaultLdapSchema 默认属性映射
+func defaultLdapSchema() *LdapSchema {
+	return &LdapSchema{
+		OrgClass:   "organizationalUnit",
+		OrgClasses: []string{"organizationalUnit"},
+		OrgRdn:     "ou",
+		OrgAttrs: map[string][]string{
+			"Mid":       {"street"},
+			"Pid":       {"l"},
+			"Id":        {"st"},
+			"Name":      {"ou"},
+			"Type":      {"businessCategory"},
+			"IsDefault": {"description"},
+			"Order":     {"postalCode"},
+			"Attrs":     {"postalAddress"},
+		},
+		LeafClass:   "posixAccount",
+		LeafClasses: []string{"inetOrgPerson", "posixAccount"},
+		LeafRdn:     "cn",
+		LeafAttrs: map[string][]string{
+			"Mid":       {"o", "street"},
+			"Pid":       {"l"},
+			"Sid":       {"employeeNumber"},
+			"Uid":       {"uid", "cn", "sn"},
+			"Positions": {"title"},
+			"Order":     {"postalCode"},
+			"Attrs":     {"postalAddress"},
+		},
+		LeafDefaults: map[string][]string{
+			"uidNumber":     {"0"},
+			"gidNumber":     {"0"},
+			"homeDirectory": {"/"},
+		},
+		PositionClass:   "organizationalRole",
+		PositionClasses: []string{"organizationalRole"},
+		PositionRdn:     "cn",
+		PositionAttrs: map[string][]string{
+			"Mid":         {"street"},
+			"Id":          {"cn"},
+			"Name":        {"ou"},
+			"Level":       {"postalCode"},
+			"Description": {"description"},
+		},
+	}
+}
+
+// newLdapSchema 以默认映射为基础合并配置 c可为LdapSchema或json对象
+func newLdapSchema(c interface{}) (*LdapSchema, error) {
+	schema := defaultLdapSchema()
+	if c == nil {
+		return schema, nil
+	}
+	buff, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	custom := LdapSchema{}
+	if err = json.Unmarshal(buff, &custom); err != nil {
+		return nil, err
+	}
+	if custom.OrgClass != "" {
+		schema.OrgClass = custom.OrgClass
+	}
+	if custom.OrgClasses != nil {
+		schema.OrgClasses = custom.OrgClasses
+	}
+	if custom.OrgRdn != "" {
+		schema.OrgRdn = custom.OrgRdn
+	}
+	if custom.LeafClass != "" {
+		schema.LeafClass = custom.LeafClass
+	}
+	if custom.LeafClasses != nil {
+		schema.LeafClasses = custom.LeafClasses
+	}
+	if custom.LeafRdn != "" {
+		schema.LeafRdn = custom.LeafRdn
+	}
+	if custom.LeafDefaults != nil {
+		schema.LeafDefaults = custom.LeafDefaults
+	}
+	if custom.PositionClass != "" {
+		schema.PositionClass = custom.PositionClass
+	}
+	if custom.PositionClasses != nil {
+		schema.PositionClasses = custom.PositionClasses
+	}
+	if custom.PositionRdn != "" {
+		schema.PositionRdn = custom.PositionRdn
+	}
+	if custom.AttrClass != "" {
+		schema.AttrClass = custom.AttrClass
+	}
+	for k, v := range custom.PositionAttrs {
+		schema.PositionAttrs[k] = v
+	}
+	for k, v := range custom.OrgAttrs {
+		schema.OrgAttrs[k] = v
+	}
+	for k, v := range custom.LeafAttrs {
+		schema.LeafAttrs[k] = v
+	}
+	for _, k := range []string{"Mid", "Pid", "Id", "Name", "Type", "IsDefault", "Order", "Attrs"} {
+		if len(schema.OrgAttrs[k]) == 0 {
+			return nil, fmt.Errorf("ldap schema: missing org attribute for %s", k)
+		}
+	}
+	for _, k := range []string{"Mid", "Pid", "Sid", "Uid", "Positions", "Order", "Attrs"} {
+		if len(schema.LeafAttrs[k]) == 0 {
+			return nil, fmt.Errorf("ldap schema: missing leaf attribute for %s", k)
+		}
+	}
+	for _, k := range []string{"Mid", "Id", "Name", "Level", "Description"} {
+		if len(schema.PositionAttrs[k]) == 0 {
+			return nil, fmt.Errorf("ldap schema: missing position attribute for %s", k)
+		}
+	}
+	return schema, nil
+}
+
+// orgAttr 组织节点字段对应的读取属性
+func (self *LdapSchema) orgAttr(field string) string {
+	return self.OrgAttrs[field][0]
+}
+
+// leafAttr 叶子节点字段对应的读取属性
+func (self *LdapSchema) leafAttr(field string) string {
+	return self.LeafAttrs[field][0]
+}
+
+// orgAttrList 搜索组织节点时返回的属性
+func (self *LdapSchema) orgAttrList() []string {
+	return []string{self.orgAttr("Mid"), self.orgAttr("Pid"), self.orgAttr("Id"),
+		self.orgAttr("Name"), self.orgAttr("Type"), self.orgAttr("IsDefault"), self.orgAttr("Order"),
+		self.orgAttr("Attrs")}
+}
+
+// positionAttr 岗位字段对应的读取属性
+func (self *LdapSchema) positionAttr(field string) string {
+	return self.PositionAttrs[field][0]
+}
+
+// leafAttrList 搜索叶子节点时返回的属性
+func (self *LdapSchema) leafAttrList() []string {
+	return []string{self.leafAttr("Mid"), self.leafAttr("Pid"), self.leafAttr("Sid"),
+		self.leafAttr("Uid"), self.leafAttr("Positions"), self.leafAttr("Order"), self.leafAttr("Attrs")}
+}
+
+// positionAttrList 搜索岗位时返回的属性
+func (self *LdapSchema) positionAttrList() []string {
+	return []string{self.positionAttr("Mid"), self.positionAttr("Id"), self.positionAttr("Name"),
+		self.positionAttr("Level"), self.positionAttr("Description")}
+}
+
+// orgFilter 组织节点过滤条件 cond为已转义的附加条件
+func (self *LdapSchema) orgFilter(cond string) string {
+	return filterAnd(filterEq("objectClass", self.OrgClass), cond)
+}
+
+// orgIdFilter 根据id搜索组织节点的过滤条件
+func (self *LdapSchema) orgIdFilter(id string) string {
+	return self.orgFieldFilter("Id", id)
+}
+
+// orgFieldFilter 根据组织节点字段值搜索的过滤条件
+func (self *LdapSchema) orgFieldFilter(field string, value string) string {
+	return self.orgFilter(filterEq(self.orgAttr(field), value))
+}
+
+// leafFilter 叶子节点过滤条件 cond为已转义的附加条件
+func (self *LdapSchema) leafFilter(cond string) string {
+	return filterAnd(filterEq("objectClass", self.LeafClass), cond)
+}
+
+// leafFieldFilter 根据叶子节点字段值搜索的过滤条件
+func (self *LdapSchema) leafFieldFilter(field string, value string) string {
+	return self.leafFilter(filterEq(self.leafAttr(field), value))
+}
+
+// positionFieldFilter 根据岗位字段值搜索的过滤条件
+func (self *LdapSchema) positionFieldFilter(field string, value string) string {
+	return filterAnd(filterEq("objectClass", self.PositionClass), filterEq(self.positionAttr(field), value))
+}
+
+// positionIdsFilter 根据岗位ID列表搜索的过滤条件
+func (self *LdapSchema) positionIdsFilter(ids []string) string {
+	conds := []string{}
+	for _, id := range ids {
+		conds = append(conds, filterEq(self.positionAttr("Id"), id))
+	}
+	return filterAnd(filterEq("objectClass", self.PositionClass), filterOr(conds...))
+}
+
+// orgSearchFilter 搜索组织节点的过滤条件 对应SearchQuery的Name Types 搜索组织节点时包含Attrs
+func (self *LdapSchema) orgSearchFilter(q *SearchQuery) string {
+	cond := ""
+	if q.Name != "" {
+		cond = filterMatch(self.orgAttr("Name"), q.Name, q.NameMatch)
+	}
+	types := []string{}
+	for _, t := range q.Types {
+		types = append(types, filterEq(self.orgAttr("Type"), strconv.Itoa(t)))
+	}
+	attrs := ""
+	if q.Target == SEARCH_ORG {
+		attrs = self.attrsFilter(self.orgAttr("Attrs"), q.Attrs)
+	}
+	return self.orgFilter(filterAnd(cond, filterOr(types...), attrs))
+}
+
+// attrsFilter 扩展属性条件 attr为保存扩展属性的ldap属性
+func (self *LdapSchema) attrsFilter(attr string, attrs map[string]string) string {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	conds := []string{}
+	for _, name := range names {
+		conds = append(conds, filterEq(attr, encodeAttr(name, attrs[name])))
+	}
+	return filterAnd(conds...)
+}
+
+// leafSearchFilter 搜索叶子节点的过滤条件 对应SearchQuery的Uid Sid Positions
+func (self *LdapSchema) leafSearchFilter(q *SearchQuery) string {
+	conds := []string{}
+	if q.Uid != "" {
+		conds = append(conds, filterMatch(self.leafAttr("Uid"), q.Uid, q.UidMatch))
+	}
+	if q.Sid != "" {
+		conds = append(conds, filterEq(self.leafAttr("Sid"), q.Sid))
+	}
+	positions := []string{}
+	for _, p := range q.Positions {
+		positions = append(positions, filterEq(self.leafAttr("Positions"), p))
+	}
+	if q.PositionMode == POSITION_ANY {
+		conds = append(conds, filterOr(positions...))
+	} else {
+		conds = append(conds, positions...)
+	}
+	conds = append(conds, self.attrsFilter(self.leafAttr("Attrs"), q.Attrs))
+	return self.leafFilter(filterAnd(conds...))
+}
+
+// orgRdn 组织节点的RDN 名称按RFC 4514转义
+func (self *LdapSchema) orgRdn(name string) string {
+	return dnRdn(self.OrgRdn, name)
+}
+
+// leafRdn 叶子节点的RDN uid按RFC 4514转义
+func (self *LdapSchema) leafRdn(uid string) string {
+	return dnRdn(self.LeafRdn, uid)
+}
+
+// positionRdn 岗位的RDN id按RFC 4514转义
+func (self *LdapSchema) positionRdn(id string) string {
+	return dnRdn(self.PositionRdn, id)
+}
+
+// 从ldap.entry转化成orgnode
+func (self *LdapSchema) ldap2orgnode(entry *ldap.Entry, node *OrgNode) {
+	node.Mid = entry.GetAttributeValue(self.orgAttr("Mid"))
+	node.Pid = entry.GetAttributeValue(self.orgAttr("Pid"))
+	node.Id = entry.GetAttributeValue(self.orgAttr("Id"))
+	node.Name = entry.GetAttributeValue(self.orgAttr("Name"))
+	node.Type, _ = strconv.Atoi(entry.GetAttributeValue(self.orgAttr("Type")))
+	node.IsDefault, _ = strconv.ParseBool(entry.GetAttributeValue(self.orgAttr("IsDefault")))
+	node.Order, _ = strconv.Atoi(entry.GetAttributeValue(self.orgAttr("Order")))
+	node.Attrs = decodeAttrs(entry.GetAttributeValues(self.orgAttr("Attrs")))
+}
+
+// 从ldap.entry转化成leafnode
+func (self *LdapSchema) ldap2leafnode(entry *ldap.Entry, node *LeafNode) {
+	node.Sid = entry.GetAttributeValue(self.leafAttr("Sid"))
+	node.Mid = entry.GetAttributeValue(self.leafAttr("Mid"))
+	node.Pid = entry.GetAttributeValue(self.leafAttr("Pid"))
+	node.Uid = entry.GetAttributeValue(self.leafAttr("Uid"))
+	node.Positions = entry.GetAttributeValues(self.leafAttr("Positions"))
+	node.Order, _ = strconv.Atoi(entry.GetAttributeValue(self.leafAttr("Order")))
+	node.Attrs = decodeAttrs(entry.GetAttributeValues(self.leafAttr("Attrs")))
+}
+
+// 从ldap.entry转化成position
+func (self *LdapSchema) ldap2position(entry *ldap.Entry, pos *Position) {
+	pos.Mid = entry.GetAttributeValue(self.positionAttr("Mid"))
+	pos.Id = entry.GetAttributeValue(self.positionAttr("Id"))
+	pos.Name = entry.GetAttributeValue(self.positionAttr("Name"))
+	pos.Level, _ = strconv.Atoi(entry.GetAttributeValue(self.positionAttr("Level")))
+	pos.Description = entry.GetAttributeValue(self.positionAttr("Description"))
+}
+
+// positionAddRequest 生成新增岗位请求 Description为空时不写入
+func (self *LdapSchema) positionAddRequest(dn string, pos Position) *ldap.AddRequest {
+	addReq := ldap.NewAddRequest(dn)
+	addReq.Attribute("objectClass", self.PositionClasses)
+	values := map[string][]string{
+		"Mid":   {pos.Mid},
+		"Id":    {pos.Id},
+		"Name":  {pos.Name},
+		"Level": {strconv.Itoa(pos.Level)},
+	}
+	if pos.Description != "" {
+		values["Description"] = []string{pos.Description}
+	}
+	written := self.addAttributes(addReq, self.PositionAttrs, values)
+	if !written[self.PositionRdn] {
+		addReq.Attribute(self.PositionRdn, []string{pos.Id})
+	}
+	return addReq
+}
+
+// orgAddRequest 生成新增组织节点请求 id为最终使用的节点ID
+func (self *LdapSchema) orgAddRequest(dn string, node OrgNode, id string) *ldap.AddRequest {
+	addReq := ldap.NewAddRequest(dn)
+	addReq.Attribute("objectClass", self.objectClasses(self.OrgClasses, node.Attrs))
+	values := map[string][]string{
+		"Mid":       {node.Mid},
+		"Id":        {id},
+		"Name":      {node.Name},
+		"Type":      {strconv.Itoa(node.Type)},
+		"IsDefault": {strconv.FormatBool(node.IsDefault)},
+		"Order":     {strconv.Itoa(node.Order)},
+	}
+	if node.Pid != "" {
+		values["Pid"] = []string{node.Pid}
+	}
+	if len(node.Attrs) > 0 {
+		values["Attrs"] = encodeAttrs(node.Attrs)
+	}
+	written := self.addAttributes(addReq, self.OrgAttrs, values)
+	if !written[self.OrgRdn] {
+		addReq.Attribute(self.OrgRdn, []string{node.Name})
+	}
+	return addReq
+}
+
+// leafAddRequest 生成新增叶子节点请求
+func (self *LdapSchema) leafAddRequest(dn string, leaf LeafNode) *ldap.AddRequest {
+	addReq := ldap.NewAddRequest(dn)
+	addReq.Attribute("objectClass", self.objectClasses(self.LeafClasses, leaf.Attrs))
+	values := map[string][]string{
+		"Mid":   {leaf.Mid},
+		"Pid":   {leaf.Pid},
+		"Sid":   {leaf.Sid},
+		"Uid":   {leaf.Uid},
+		"Order": {strconv.Itoa(leaf.Order)},
+	}
+	// 如果包含岗位数据
+	if leaf.Positions != nil {
+		values["Positions"] = leaf.Positions
+	}
+	if len(leaf.Attrs) > 0 {
+		values["Attrs"] = encodeAttrs(leaf.Attrs)
+	}
+	written := self.addAttributes(addReq, self.LeafAttrs, values)
+	if !written[self.LeafRdn] {
+		written[self.LeafRdn] = true
+		addReq.Attribute(self.LeafRdn, []string{leaf.Uid})
+	}
+	for attr, v := range self.LeafDefaults {
+		if !written[attr] {
+			addReq.Attribute(attr, v)
+		}
+	}
+	return addReq
+}
+
+// objectClasses 新增条目写入的objectClass 有扩展属性且配置了AttrClass时附加辅助objectClass
+func (self *LdapSchema) objectClasses(classes []string, attrs map[string][]string) []string {
+	if self.AttrClass == "" || len(attrs) == 0 {
+		return classes
+	}
+	return append(append([]string{}, classes...), self.AttrClass)
+}
+
+// addAttributes 按映射写入属性，同一属性只写入一次，返回已写入的属性
+func (self *LdapSchema) addAttributes(addReq *ldap.AddRequest, attrs map[string][]string, values map[string][]string) map[string]bool {
+	written := map[string]bool{}
+	for _, field := range []string{"Mid", "Pid", "Id", "Sid", "Uid", "Name", "Type", "IsDefault", "Positions",
+		"Level", "Description", "Order", "Attrs"} {
+		v, ok := values[field]
+		if !ok {
+			continue
+		}
+		for _, attr := range attrs[field] {
+			if !written[attr] {
+				written[attr] = true
+				addReq.Attribute(attr, v)
+			}
+		}
+	}
+	return written
+}
@@ -0,0 +1,57 @@
+package deptree
+
+import (
+	ldap "github.com/go-ldap/ldap"
+)
+
+// ldapStatsPageSize 统计时分页搜索的每页条目数 避免子树较大时超过服务端的size limit
+var ldapStatsPageSize uint32 = 500
+
+// GetStatistics 子树统计 组织节点及叶子各一次分页的子树搜索，叶子只取Pid Uid Positions属性
+func (self *ldapDepTree) GetStatistics(mid string, id string) (_ *OrgStats, err error) {
+	defer ldapError("GetStatistics", &err)
+	conn, tree_dn, err := self.connectTree(mid)
+	if conn == nil {
+		return nil, err
+	}
+	defer self.release(conn, &err)
+	if err != nil {
+		return nil, err
+	}
+	org_dn := tree_dn
+	if id != mid {
+		org_dn, err = self.getSubTreeDn(tree_dn, id, conn)
+		if err != nil {
+			return nil, err
+		}
+	}
+	searchReq := ldap.NewSearchRequest(org_dn, ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, 0, false, self.schema.orgFilter(""),
+		[]string{self.schema.orgAttr("Id"), self.schema.orgAttr("Pid"), self.schema.orgAttr("Name"), self.schema.orgAttr("Order")}, nil)
+	sr, err := conn.SearchWithPaging(searchReq, ldapStatsPageSize)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []OrgNode{}
+	for _, e := range sr.Entries {
+		node := OrgNode{}
+		self.schema.ldap2orgnode(e, &node)
+		nodes = append(nodes, node)
+	}
+	searchReq = ldap.NewSearchRequest(org_dn, ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, 0, false, self.schema.leafFilter(""),
+		[]string{self.schema.leafAttr("Pid"), self.schema.leafAttr("Uid"), self.schema.leafAttr("Positions")}, nil)
+	sr, err = conn.SearchWithPaging(searchReq, ldapStatsPageSize)
+	if err != nil {
+		return nil, err
+	}
+	leafs := []LeafNode{}
+	for _, e := range sr.Entries {
+		leaf := LeafNode{}
+		self.schema.ldap2leafnode(e, &leaf)
+		leafs = append(leafs, leaf)
+	}
+	return newOrgStats(id, nodes, leafs), nil
+}
@@ -0,0 +1,53 @@
+package deptree
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// ldap连接加密方式 config["TLS"]
+const (
+	LDAP_TLS_NONE     = ""         // 明文
+	LDAP_TLS_LDAPS    = "ldaps"    // 直接建立TLS连接(通常为636端口)
+	LDAP_TLS_STARTTLS = "starttls" // 明文连接后通过StartTLS升级
+)
+
+// newTLSConfig 根据配置生成tls.Config
+// CAFile             PEM格式的CA证书包，为空使用系统证书
+// CertFile KeyFile   客户端证书及私钥
+// ServerName         证书校验使用的主机名，默认为Host
+// InsecureSkipVerify 跳过证书校验，仅用于测试环境
+func newTLSConfig(config map[string]interface{}, host string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: host,
+	}
+	if name, _ := config["ServerName"].(string); name != "" {
+		tlsConfig.ServerName = name
+	}
+	if skip, _ := config["InsecureSkipVerify"].(bool); skip {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if caFile, _ := config["CAFile"].(string); caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificate in CAFile: %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	certFile, _ := config["CertFile"].(string)
+	keyFile, _ := config["KeyFile"].(string)
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,187 @@
+package deptree
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA 测试用CA file为PEM格式的CA证书文件，server为127.0.0.1的服务端证书配置
+type testCA struct {
+	file   string
+	server *tls.Config
+}
+
+// newTestCA 生成CA及由其签发的127.0.0.1服务端证书
+func newTestCA(t *testing.T) *testCA {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "deptree test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	if err = ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{
+		file: file,
+		server: &tls.Config{Certificates: []tls.Certificate{{
+			Certificate: [][]byte{der},
+			PrivateKey:  key,
+		}}},
+	}
+}
+
+// newTLSTree 按config连接srv 并写入顶级节点m
+func newTLSTree(t *testing.T, srv *fakeLdap, extra map[string]interface{}) DepTree {
+	srv.add("dc=test", []string{"objectClass", "domain"}, []string{"dc", "test"})
+	seedOrg(srv, "ou=top,dc=test", OrgNode{Mid: "m", Id: "m", Name: "top"})
+	config := srv.config()
+	for k, v := range extra {
+		config[k] = v
+	}
+	tree, err := NewTreeE(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestLdapTLS(t *testing.T) {
+	ca := newTestCA(t)
+	other := newTestCA(t)
+	cases := []struct {
+		name   string
+		tls    string
+		caFile string
+		ok     bool
+	}{
+		{"ldaps", LDAP_TLS_LDAPS, ca.file, true},
+		{"starttls", LDAP_TLS_STARTTLS, ca.file, true},
+		{"ldaps untrusted ca", LDAP_TLS_LDAPS, other.file, false},
+		{"starttls untrusted ca", LDAP_TLS_STARTTLS, other.file, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var srv *fakeLdap
+			if c.tls == LDAP_TLS_LDAPS {
+				srv = newFakeLdap(t, ca.server)
+			} else {
+				srv = newFakeLdap(t, nil)
+				srv.startTLS = ca.server
+			}
+			tree := newTLSTree(t, srv, map[string]interface{}{"TLS": c.tls, "CAFile": c.caFile})
+			n, err := tree.GetOrgNode("m", "m")
+			if c.ok {
+				if err != nil || n.Name != "top" {
+					t.Errorf("GetOrgNode = %v, %v", n, err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrBackendUnavailable) || !strings.Contains(err.Error(), "certificate") {
+				t.Errorf("err = %v, want ErrBackendUnavailable certificate error", err)
+			}
+		})
+	}
+}
+
+// 明文服务不接受StartTLS时连接失败
+func TestLdapStartTLSUnsupported(t *testing.T) {
+	ca := newTestCA(t)
+	srv := newFakeLdap(t, nil)
+	tree := newTLSTree(t, srv, map[string]interface{}{"TLS": LDAP_TLS_STARTTLS, "CAFile": ca.file})
+	if _, err := tree.GetOrgNode("m", "m"); err == nil {
+		t.Error("connected without StartTLS")
+	}
+}
+
+// 配置无效时NewTreeE返回ErrInvalidArgument，NewTree返回nil
+func TestNewTreeEInvalidConfig(t *testing.T) {
+	bad := filepath.Join(t.TempDir(), "bad.pem")
+	if err := ioutil.WriteFile(bad, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	base := func(extra map[string]interface{}) map[string]interface{} {
+		config := map[string]interface{}{
+			"Host": "127.0.0.1", "Port": float64(389), "Base": "dc=test",
+			"User": "cn=admin,dc=test", "Password": "secret",
+		}
+		for k, v := range extra {
+			config[k] = v
+		}
+		return config
+	}
+	cases := []struct {
+		name   string
+		config map[string]interface{}
+	}{
+		{"missing host", map[string]interface{}{"Port": float64(389)}},
+		{"port type", base(map[string]interface{}{"Port": "389"})},
+		{"unknown tls", base(map[string]interface{}{"TLS": "ssl"})},
+		{"invalid ca", base(map[string]interface{}{"TLS": LDAP_TLS_LDAPS, "CAFile": bad})},
+		{"missing ca", base(map[string]interface{}{"TLS": LDAP_TLS_STARTTLS, "CAFile": bad + ".missing"})},
+		{"missing cert", base(map[string]interface{}{"TLS": LDAP_TLS_LDAPS, "CertFile": bad, "KeyFile": bad})},
+		{"invalid schema", base(map[string]interface{}{"Schema": "orgClass"})},
+		{"sql without dsn", map[string]interface{}{"Backend": "sql", "Driver": "sqlite3"}},
+		{"unknown backend", map[string]interface{}{"Backend": "redis"}},
+	}
+	for _, c := range cases {
+		tree, err := NewTreeE(c.config)
+		if tree != nil || !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%s: NewTreeE = %v, %v, want ErrInvalidArgument", c.name, tree, err)
+		}
+		if NewTree(c.config) != nil {
+			t.Errorf("%s: NewTree returned a tree", c.name)
+		}
+	}
+	_, err := NewTreeE(base(map[string]interface{}{"TLS": LDAP_TLS_STARTTLS, "CAFile": bad + ".missing"}))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing ca err = %v, want the underlying fs.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,66 @@
+package deptree
+
+// ManagerLevel 一级管理者
+type ManagerLevel struct {
+	Org      OrgNode    // 管理者所在的组织节点
+	Managers []LeafNode // 该节点下直接拥有管理岗位的叶子
+}
+
+// ResolveManagers 解析uid的汇报链，可用于任意DepTree实现
+// 从uid所在部门pid开始按GetParents的顺序向上查找，每个存在管理者的组织节点为一级，
+// 管理者为直接挂在该节点下且拥有positions中任一岗位的叶子(不包含uid本人)
+// pid为空时uid需只属于一个部门；levels为返回的最大级数，<=0时返回全部
+// 先GetMemberships，再按路径逐级GetLeafNodesByOrg，取满levels级即停止
+func ResolveManagers(tree DepTree, mid string, uid string, pid string, positions []string, levels int) (_ []ManagerLevel, err error) {
+	defer setOp("ResolveManagers", &err)
+	if len(positions) == 0 {
+		return nil, newError(ErrInvalidArgument, "", "no manager positions")
+	}
+	memberships, err := tree.GetMemberships(mid, uid)
+	if err != nil {
+		return nil, err
+	}
+	var member *Membership
+	for i := range memberships {
+		if pid == "" || memberships[i].Leaf.Pid == pid {
+			if member != nil {
+				return nil, newError(ErrInvalidArgument, "", "uid %s belongs to several departments, pid is required", uid)
+			}
+			member = &memberships[i]
+		}
+	}
+	if member == nil {
+		return nil, errNotFound("", "Can't find the leaf with this uid: %s", uid)
+	}
+
+	ret := []ManagerLevel{}
+	for _, org := range member.Parents {
+		if levels > 0 && len(ret) >= levels {
+			break
+		}
+		leafs, err := tree.GetLeafNodesByOrg(mid, org.Id)
+		if err != nil {
+			return nil, err
+		}
+		managers := []LeafNode{}
+		for _, l := range leafs {
+			if l.Pid == org.Id && l.Uid != uid && hasAnyPosition(&l, positions) {
+				managers = append(managers, l)
+			}
+		}
+		if len(managers) > 0 {
+			ret = append(ret, ManagerLevel{Org: org, Managers: managers})
+		}
+	}
+	return ret, nil
+}
+
+// hasAnyPosition 判断叶子是否拥有positions中任一岗位
+func hasAnyPosition(leaf *LeafNode, positions []string) bool {
+	for _, p := range positions {
+		if hasPosition(leaf, p) {
+			return true
+		}
+	}
+	return false
+}
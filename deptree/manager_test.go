@@ -0,0 +1,102 @@
+package deptree
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// countingTree 统计GetLeafNodesByOrg的调用次数
+type countingTree struct {
+	DepTree
+	calls int
+}
+
+func (self *countingTree) GetLeafNodesByOrg(mid string, pid string) ([]LeafNode, error) {
+	self.calls++
+	return self.DepTree.GetLeafNodesByOrg(mid, pid)
+}
+
+// managerIds 每级为 组织节点ID:管理者uid列表(排序后)
+func managerIds(levels []ManagerLevel) string {
+	ret := []string{}
+	for _, l := range levels {
+		uids := []string{}
+		for _, m := range l.Managers {
+			uids = append(uids, m.Uid)
+		}
+		sort.Strings(uids)
+		ret = append(ret, l.Org.Id+":"+strings.Join(uids, ","))
+	}
+	return strings.Join(ret, " ")
+}
+
+func TestResolveManagers(t *testing.T) {
+	eachBackend(t, func(t *testing.T, tree DepTree) {
+		seedTree(t, tree, "m", [2]string{"a", "m"}, [2]string{"b", "a"}, [2]string{"c", "b"})
+		for _, id := range []string{"mgr", "lead", "staff"} {
+			if _, err := tree.AddPosition(Position{Mid: "m", Id: id, Name: id}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, l := range []LeafNode{
+			{Pid: "c", Uid: "u", Positions: []string{"mgr"}},
+			{Pid: "a", Uid: "u", Positions: []string{"mgr"}},
+			{Pid: "b", Uid: "bm", Positions: []string{"mgr"}},
+			{Pid: "a", Uid: "am", Positions: []string{"staff", "lead"}},
+			{Pid: "a", Uid: "as", Positions: []string{"staff"}},
+			{Pid: "m", Uid: "mm", Positions: []string{"mgr"}},
+		} {
+			l.Mid = "m"
+			if err := tree.AddLeafNode(l); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cases := []struct {
+			name      string
+			pid       string
+			positions []string
+			levels    int
+			want      string
+		}{
+			// c下只有本人，a下的本人不计入，b下的管理者不属于a
+			{"all levels", "c", []string{"mgr", "lead"}, 0, "b:bm a:am m:mm"},
+			{"levels", "c", []string{"mgr", "lead"}, 2, "b:bm a:am"},
+			{"one position", "c", []string{"lead"}, 0, "a:am"},
+			{"other department", "a", []string{"mgr", "lead"}, 0, "a:am m:mm"},
+		}
+		for _, c := range cases {
+			got, err := ResolveManagers(tree, "m", "u", c.pid, c.positions, c.levels)
+			if err != nil {
+				t.Errorf("%s: %v", c.name, err)
+				continue
+			}
+			if ids := managerIds(got); ids != c.want {
+				t.Errorf("%s: managers = %s, want %s", c.name, ids, c.want)
+			}
+		}
+
+		if _, err := ResolveManagers(tree, "m", "u", "", []string{"mgr"}, 0); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("several departments without pid err = %v, want ErrInvalidArgument", err)
+		}
+		if got, err := ResolveManagers(tree, "m", "bm", "", []string{"mgr", "lead"}, 0); err != nil || managerIds(got) != "a:am,u m:mm" {
+			t.Errorf("single department without pid = %s, %v", managerIds(got), err)
+		}
+		if _, err := ResolveManagers(tree, "m", "none", "", []string{"mgr"}, 0); !errors.Is(err, ErrNotFound) {
+			t.Errorf("unknown uid err = %v, want ErrNotFound", err)
+		}
+		if _, err := ResolveManagers(tree, "m", "u", "c", nil, 0); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("no positions err = %v, want ErrInvalidArgument", err)
+		}
+
+		// 取满levels级后不再查询上级
+		counting := &countingTree{DepTree: tree}
+		if got, err := ResolveManagers(counting, "m", "u", "c", []string{"mgr"}, 1); err != nil || managerIds(got) != "b:bm" {
+			t.Errorf("one level = %s, %v", managerIds(got), err)
+		}
+		if counting.calls != 2 {
+			t.Errorf("GetLeafNodesByOrg called %d times, want 2", counting.calls)
+		}
+	})
+}
@@ -0,0 +1,1252 @@
+package deptree
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memDepTree DepTree的内存实现，用于单元测试及小规模部署，通过NewTree获得
+// 行为与ldap实现保持一致：顶级节点以mid为ID、同级名称唯一、递归删除
+type memDepTree struct {
+	lock      sync.RWMutex
+	trees     map[string]*memOrg                // mid -> 顶级节点
+	positions map[string]map[string]*Position   // mid -> 岗位ID -> 岗位定义
+	archives  map[string]map[string]*memArchive // mid -> 子树根节点ID -> 归档的子树
+	retention time.Duration                     // 归档保留期
+}
+
+// memArchive 归档的子树 已从原父节点移除
+type memArchive struct {
+	org *memOrg
+	at  time.Time
+}
+
+// memOrg 内存中的组织节点
+type memOrg struct {
+	node     OrgNode
+	parent   *memOrg
+	children []*memOrg
+	leafs    []*LeafNode
+}
+
+func newMemDepTree(config map[string]interface{}) *memDepTree {
+	return &memDepTree{
+		trees:     map[string]*memOrg{},
+		positions: map[string]map[string]*Position{},
+		archives:  map[string]map[string]*memArchive{},
+		retention: archiveRetention(config),
+	}
+}
+
+// find 在子树中查找id对应的组织节点（包含自身）
+func (self *memOrg) find(id string) *memOrg {
+	if self.node.Id == id {
+		return self
+	}
+	for _, c := range self.children {
+		if n := c.find(id); n != nil {
+			return n
+		}
+	}
+	return nil
+}
+
+// findLeaf 查找本节点下uid对应的叶子
+func (self *memOrg) findLeaf(uid string) int {
+	for i, l := range self.leafs {
+		if l.Uid == uid {
+			return i
+		}
+	}
+	return -1
+}
+
+// hasChild 判断子节点中是否存在同名节点
+func (self *memOrg) hasChild(name string) bool {
+	for _, c := range self.children {
+		if c.node.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// detach 从父节点的子节点中移除
+func (self *memOrg) detach() {
+	children := self.parent.children
+	for i, c := range children {
+		if c == self {
+			self.parent.children = append(children[:i:i], children[i+1:]...)
+			return
+		}
+	}
+}
+
+// nextOrder 新子节点的排序序号
+func (self *memOrg) nextOrder() int {
+	order := 0
+	for _, c := range self.children {
+		if c.node.Order > order {
+			order = c.node.Order
+		}
+	}
+	return order + 1
+}
+
+// nextLeafOrder 新叶子的排序序号
+func (self *memOrg) nextLeafOrder() int {
+	order := 0
+	for _, l := range self.leafs {
+		if l.Order > order {
+			order = l.Order
+		}
+	}
+	return order + 1
+}
+
+// sortChildren 子节点及叶子按同级顺序排序
+func (self *memOrg) sortChildren() {
+	sort.SliceStable(self.children, func(i, j int) bool {
+		return orgOrderLess(&self.children[i].node, &self.children[j].node)
+	})
+	sort.SliceStable(self.leafs, func(i, j int) bool {
+		return leafOrderLess(self.leafs[i], self.leafs[j])
+	})
+}
+
+// walk 先序遍历子树（包含自身）
+func (self *memOrg) walk(f func(*memOrg)) {
+	f(self)
+	for _, c := range self.children {
+		c.walk(f)
+	}
+}
+
+// tree 转化成OrgTree
+func (self *memOrg) tree() OrgTree {
+	ret := OrgTree{
+		OrgNode:  self.org(),
+		SubTrees: []OrgTree{},
+		SubLeafs: []LeafNode{},
+	}
+	for _, l := range self.leafs {
+		ret.SubLeafs = append(ret.SubLeafs, copyLeaf(l))
+	}
+	for _, c := range self.children {
+		ret.SubTrees = append(ret.SubTrees, c.tree())
+	}
+	return ret
+}
+
+// org 复制组织节点信息，避免外部修改内部数据
+func (self *memOrg) org() OrgNode {
+	return copyOrg(&self.node)
+}
+
+// copyOrg 复制组织节点
+func copyOrg(node *OrgNode) OrgNode {
+	ret := *node
+	ret.Attrs = copyAttrs(node.Attrs)
+	return ret
+}
+
+// copyLeaf 复制叶子节点，避免外部修改内部数据
+func copyLeaf(leaf *LeafNode) LeafNode {
+	ret := *leaf
+	if leaf.Positions != nil {
+		ret.Positions = append([]string{}, leaf.Positions...)
+	}
+	ret.Attrs = copyAttrs(leaf.Attrs)
+	return ret
+}
+
+// getNode 根据mid和id获得节点，pid==mid时返回顶级节点
+func (self *memDepTree) getNode(mid string, id string) (*memOrg, error) {
+	top, ok := self.trees[mid]
+	if !ok {
+		return nil, errNotFound("", "Can't find the top tree with this mid: %s", mid)
+	}
+	n := top.find(id)
+	if n == nil {
+		return nil, errNotFound("", "Can't find the node with this id: %s", id)
+	}
+	return n, nil
+}
+
+// AddOrgNode 新建组织节点
+func (self *memDepTree) AddOrgNode(node OrgNode) (_ string, err error) {
+	defer setOp("AddOrgNode", &err)
+	if node.Attrs, err = checkAttrs(node.Attrs); err != nil {
+		return "", err
+	}
+	node.Attrs = copyAttrs(node.Attrs)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if node.Pid == "" {
+		//插入顶级节点(ID使用传入的mid)
+		if _, ok := self.trees[node.Mid]; ok {
+			return "", newError(ErrAlreadyExists, "", "top tree already exists with this mid: %s", node.Mid)
+		}
+		for _, t := range self.trees {
+			if t.node.Name == node.Name {
+				return "", newError(ErrDuplicateName, "", "node already exists with this name: %s", node.Name)
+			}
+		}
+		node.Id = node.Mid
+		self.trees[node.Mid] = &memOrg{node: node}
+		return node.Id, nil
+	}
+
+	parent, err := self.getNode(node.Mid, node.Pid)
+	if err != nil {
+		return "", err
+	}
+	if parent.hasChild(node.Name) {
+		return "", newError(ErrDuplicateName, "", "node already exists with this name: %s", node.Name)
+	}
+	if node.Id == "" {
+		node.Id = GetId()
+	} else if self.trees[node.Mid].find(node.Id) != nil || self.findArchived(node.Mid, node.Id) != nil {
+		return "", newError(ErrAlreadyExists, "", "node already exists with this id: %s", node.Id)
+	}
+	if node.Order == 0 {
+		node.Order = parent.nextOrder()
+	}
+	parent.children = append(parent.children, &memOrg{node: node, parent: parent})
+	parent.sortChildren()
+	return node.Id, nil
+}
+
+// ModifyOrgNode 修改组织信息
+func (self *memDepTree) ModifyOrgNode(node OrgNode) (err error) {
+	defer setOp("ModifyOrgNode", &err)
+	id := node.Id
+	mid := node.Mid
+	if id == "" || mid == "" {
+		return newError(ErrInvalidArgument, "", "invalid id or mid [%s,%s]", id, mid)
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if node.Attrs, err = checkAttrs(node.Attrs); err != nil {
+		return err
+	}
+	n, err := self.getNode(mid, id)
+	if err != nil {
+		return err
+	}
+	if node.Name != "" && node.Name != n.node.Name {
+		if n.parent != nil && n.parent.hasChild(node.Name) {
+			return newError(ErrDuplicateName, "", "node already exists with this name: %s", node.Name)
+		}
+		if n.parent == nil {
+			for _, t := range self.trees {
+				if t.node.Name == node.Name {
+					return newError(ErrDuplicateName, "", "node already exists with this name: %s", node.Name)
+				}
+			}
+		}
+		n.node.Name = node.Name
+	}
+	if node.Attrs != nil {
+		n.node.Attrs = copyAttrs(node.Attrs)
+	}
+	return nil
+}
+
+// DelOrgNode 删除组织信息(递归)
+func (self *memDepTree) DelOrgNode(mid string, id string) (err error) {
+	defer setOp("DelOrgNode", &err)
+	if id == "" || mid == "" {
+		return newError(ErrInvalidArgument, "", "invalid id or mid [%s,%s]", id, mid)
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	n, err := self.getNode(mid, id)
+	if err != nil {
+		return err
+	}
+	if n.parent == nil {
+		delete(self.trees, mid)
+		delete(self.positions, mid)
+		delete(self.archives, mid)
+		return nil
+	}
+	n.detach()
+	return nil
+}
+
+// MoveOrgNode 移动组织节点到新的父节点下
+func (self *memDepTree) MoveOrgNode(mid string, id string, newPid string) (err error) {
+	defer setOp("MoveOrgNode", &err)
+	if id == "" || mid == "" || newPid == "" {
+		return newError(ErrInvalidArgument, "", "invalid id or mid or newPid [%s,%s,%s]", id, mid, newPid)
+	}
+	if id == mid {
+		return newError(ErrInvalidArgument, "", "can't move the top tree: %s", mid)
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	n, err := self.getNode(mid, id)
+	if err != nil {
+		return err
+	}
+	parent, err := self.getNode(mid, newPid)
+	if err != nil {
+		return err
+	}
+	if parent == n.parent {
+		return nil
+	}
+	if n.find(newPid) != nil {
+		return newError(ErrInvalidArgument, "", "can't move node %s into its own subtree", id)
+	}
+	if parent.hasChild(n.node.Name) {
+		return newError(ErrDuplicateName, "", "node already exists with this name: %s", n.node.Name)
+	}
+	n.detach()
+	n.parent = parent
+	n.node.Pid = newPid
+	n.node.Order = parent.nextOrder()
+	parent.children = append(parent.children, n)
+	return nil
+}
+
+// MergeOrgNodes 合并组织节点 在同一锁内生成并执行全部步骤
+func (self *memDepTree) MergeOrgNodes(mid string, sourceId string, targetId string, opts MergeOptions) (_ *MergeReport, err error) {
+	defer setOp("MergeOrgNodes", &err)
+	if opts.DryRun {
+		self.lock.RLock()
+		defer self.lock.RUnlock()
+	} else {
+		self.lock.Lock()
+		defer self.lock.Unlock()
+	}
+
+	source, err := self.getNode(mid, sourceId)
+	if err != nil {
+		return nil, err
+	}
+	target, err := self.getNode(mid, targetId)
+	if err != nil {
+		return nil, err
+	}
+	sourceTree, targetTree := source.tree(), target.tree()
+	if err = checkMerge(mid, sourceId, targetId, &sourceTree, &targetTree, opts); err != nil {
+		return nil, err
+	}
+	report, err := newMergeReport(&sourceTree, &targetTree, opts, sameKey)
+	if err != nil || opts.DryRun {
+		return report, err
+	}
+	if err = runMerge(report, &memMerge{tree: self, mid: mid}); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// memMerge 内存实现的合并操作 调用方已持有锁
+type memMerge struct {
+	tree *memDepTree
+	mid  string
+}
+
+func (self *memMerge) moveOrg(id string, pid string, name string) error {
+	n, err := self.tree.getNode(self.mid, id)
+	if err != nil {
+		return err
+	}
+	parent, err := self.tree.getNode(self.mid, pid)
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		n.node.Name = name
+	}
+	if parent == n.parent {
+		return nil
+	}
+	n.detach()
+	n.parent = parent
+	n.node.Pid = pid
+	n.node.Order = parent.nextOrder()
+	parent.children = append(parent.children, n)
+	return nil
+}
+
+func (self *memMerge) moveLeaf(uid string, from string, to string) error {
+	src, dst, i, err := self.leaf(uid, from, to)
+	if err != nil {
+		return err
+	}
+	leaf := src.leafs[i]
+	src.leafs = append(src.leafs[:i:i], src.leafs[i+1:]...)
+	leaf.Pid = to
+	leaf.Order = dst.nextLeafOrder()
+	dst.leafs = append(dst.leafs, leaf)
+	return nil
+}
+
+func (self *memMerge) mergeLeaf(uid string, from string, to string, positions []string) error {
+	src, dst, i, err := self.leaf(uid, from, to)
+	if err != nil {
+		return err
+	}
+	j := dst.findLeaf(uid)
+	if j < 0 {
+		return errNotFound("", "Can't find the leaf with this uid: %s", uid)
+	}
+	dst.leafs[j].Positions = append([]string{}, positions...)
+	src.leafs = append(src.leafs[:i:i], src.leafs[i+1:]...)
+	return nil
+}
+
+// leaf 取叶子所在的原节点、目标节点及叶子位置
+func (self *memMerge) leaf(uid string, from string, to string) (*memOrg, *memOrg, int, error) {
+	src, err := self.tree.getNode(self.mid, from)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	dst, err := self.tree.getNode(self.mid, to)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+	i := src.findLeaf(uid)
+	if i < 0 {
+		return nil, nil, -1, errNotFound("", "Can't find the leaf with this uid: %s", uid)
+	}
+	return src, dst, i, nil
+}
+
+func (self *memMerge) delOrg(id string) error {
+	n, err := self.tree.getNode(self.mid, id)
+	if err != nil {
+		return err
+	}
+	n.detach()
+	return nil
+}
+
+// findArchived 在商户的归档子树中查找组织节点
+func (self *memDepTree) findArchived(mid string, id string) *memOrg {
+	for _, a := range self.archives[mid] {
+		if n := a.org.find(id); n != nil {
+			return n
+		}
+	}
+	return nil
+}
+
+// ArchiveOrgNode 归档组织节点 子树从父节点移除后整体保存
+func (self *memDepTree) ArchiveOrgNode(mid string, id string) (err error) {
+	defer setOp("ArchiveOrgNode", &err)
+	if id == "" || mid == "" {
+		return newError(ErrInvalidArgument, "", "invalid id or mid [%s,%s]", id, mid)
+	}
+	if id == mid {
+		return newError(ErrInvalidArgument, "", "can't archive the top tree: %s", mid)
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	n, err := self.getNode(mid, id)
+	if err != nil {
+		return err
+	}
+	n.detach()
+	n.parent = nil
+	if self.archives[mid] == nil {
+		self.archives[mid] = map[string]*memArchive{}
+	}
+	self.archives[mid][id] = &memArchive{org: n, at: archiveNow()}
+	return nil
+}
+
+// RestoreOrgNode 恢复归档的子树到原父节点下
+func (self *memDepTree) RestoreOrgNode(mid string, id string) (err error) {
+	defer setOp("RestoreOrgNode", &err)
+	if id == "" || mid == "" {
+		return newError(ErrInvalidArgument, "", "invalid id or mid [%s,%s]", id, mid)
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	a, ok := self.archives[mid][id]
+	if !ok {
+		return errNotFound("", "Can't find the archived node with this id: %s", id)
+	}
+	n := a.org
+	parent, err := self.getNode(mid, n.node.Pid)
+	if err != nil {
+		return err
+	}
+	if parent.hasChild(n.node.Name) {
+		return newError(ErrDuplicateName, "", "node already exists with this name: %s", n.node.Name)
+	}
+	n.parent = parent
+	n.node.Order = parent.nextOrder()
+	parent.children = append(parent.children, n)
+	delete(self.archives[mid], id)
+	return nil
+}
+
+// GetArchivedOrgNodes 取已归档的子树根节点
+func (self *memDepTree) GetArchivedOrgNodes(mid string) (_ []ArchivedOrg, err error) {
+	defer setOp("GetArchivedOrgNodes", &err)
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	return self.archived(mid)
+}
+
+// archived 商户的归档列表 需持有锁
+func (self *memDepTree) archived(mid string) ([]ArchivedOrg, error) {
+	if _, ok := self.trees[mid]; !ok {
+		return nil, errNotFound("", "Can't find the top tree with this mid: %s", mid)
+	}
+	ret := []ArchivedOrg{}
+	for _, a := range self.archives[mid] {
+		ret = append(ret, newArchivedOrg(a.org.org(), a.at, self.retention))
+	}
+	sortArchived(ret)
+	return ret, nil
+}
+
+// PurgeArchivedOrgNodes 删除超过保留期的归档子树
+func (self *memDepTree) PurgeArchivedOrgNodes(mid string) (_ []string, err error) {
+	defer setOp("PurgeArchivedOrgNodes", &err)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	list, err := self.archived(mid)
+	if err != nil {
+		return nil, err
+	}
+	ids := expiredArchives(list, archiveNow())
+	for _, id := range ids {
+		delete(self.archives[mid], id)
+	}
+	return ids, nil
+}
+
+// AddLeafNode 新增叶子节点
+func (self *memDepTree) AddLeafNode(leaf LeafNode) (err error) {
+	defer setOp("AddLeafNode", &err)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	parent, err := self.getNode(leaf.Mid, leaf.Pid)
+	if err != nil {
+		return err
+	}
+	if parent.findLeaf(leaf.Uid) >= 0 {
+		return newError(ErrAlreadyExists, "", "leaf already exists with this uid: %s", leaf.Uid)
+	}
+	if err = self.checkPositionIds(leaf.Mid, leaf.Positions); err != nil {
+		return err
+	}
+	if leaf.Attrs, err = checkAttrs(leaf.Attrs); err != nil {
+		return err
+	}
+	l := copyLeaf(&leaf)
+	if l.Order == 0 {
+		l.Order = parent.nextLeafOrder()
+	}
+	parent.leafs = append(parent.leafs, &l)
+	parent.sortChildren()
+	return nil
+}
+
+// ModifyLeafNode 修改叶子节点(岗位信息及扩展属性)
+func (self *memDepTree) ModifyLeafNode(leaf LeafNode) (err error) {
+	defer setOp("ModifyLeafNode", &err)
+	if leaf.Positions == nil && leaf.Attrs == nil {
+		// 如果无修改岗位列表及扩展属性，则直接返回
+		return nil
+	}
+	if leaf.Attrs, err = checkAttrs(leaf.Attrs); err != nil {
+		return err
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	parent, err := self.getNode(leaf.Mid, leaf.Pid)
+	if err != nil {
+		return err
+	}
+	i := parent.findLeaf(leaf.Uid)
+	if i < 0 {
+		return errNotFound("", "Can't find the leaf with this uid: %s", leaf.Uid)
+	}
+	if err = self.checkPositionIds(leaf.Mid, leaf.Positions); err != nil {
+		return err
+	}
+	if leaf.Positions != nil {
+		parent.leafs[i].Positions = append([]string{}, leaf.Positions...)
+	}
+	if leaf.Attrs != nil {
+		parent.leafs[i].Attrs = copyAttrs(leaf.Attrs)
+	}
+	return nil
+}
+
+// DelLeafNode 删除叶子节点
+func (self *memDepTree) DelLeafNode(mid string, pid string, uid string) (err error) {
+	defer setOp("DelLeafNode", &err)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	parent, err := self.getNode(mid, pid)
+	if err != nil {
+		return err
+	}
+	i := parent.findLeaf(uid)
+	if i < 0 {
+		return errNotFound("", "Can't find the leaf with this uid: %s", uid)
+	}
+	parent.leafs = append(parent.leafs[:i:i], parent.leafs[i+1:]...)
+	return nil
+}
+
+// MoveLeafNode 调动叶子节点
+func (self *memDepTree) MoveLeafNode(mid string, uid string, fromPid string, toPid string) (err error) {
+	defer setOp("MoveLeafNode", &err)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	from, err := self.getNode(mid, fromPid)
+	if err != nil {
+		return err
+	}
+	to, err := self.getNode(mid, toPid)
+	if err != nil {
+		return err
+	}
+	i := from.findLeaf(uid)
+	if i < 0 {
+		return errNotFound("", "Can't find the leaf with this uid: %s", uid)
+	}
+	if from == to {
+		return nil
+	}
+	if to.findLeaf(uid) >= 0 {
+		return newError(ErrAlreadyExists, "", "leaf already exists with this uid: %s", uid)
+	}
+	leaf := from.leafs[i]
+	from.leafs = append(from.leafs[:i:i], from.leafs[i+1:]...)
+	leaf.Pid = toPid
+	leaf.Order = to.nextLeafOrder()
+	to.leafs = append(to.leafs, leaf)
+	return nil
+}
+
+// RenameLeafNode 修改uid在商户内全部叶子(包含已归档子树中的叶子)的uid
+func (self *memDepTree) RenameLeafNode(mid string, oldUid string, newUid string) (err error) {
+	defer setOp("RenameLeafNode", &err)
+	if oldUid == "" || newUid == "" {
+		return newError(ErrInvalidArgument, "", "invalid uid [%s,%s]", oldUid, newUid)
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	top, err := self.getNode(mid, mid)
+	if err != nil {
+		return err
+	}
+	roots := []*memOrg{top}
+	for _, a := range self.archives[mid] {
+		roots = append(roots, a.org)
+	}
+	leafs := []*LeafNode{}
+	exists := false
+	for _, r := range roots {
+		r.walk(func(o *memOrg) {
+			if i := o.findLeaf(oldUid); i >= 0 {
+				leafs = append(leafs, o.leafs[i])
+			}
+			if o.findLeaf(newUid) >= 0 {
+				exists = true
+			}
+		})
+	}
+	if len(leafs) == 0 {
+		return errNotFound("", "Can't find the leaf with this uid: %s", oldUid)
+	}
+	if oldUid == newUid {
+		return nil
+	}
+	if exists {
+		return newError(ErrAlreadyExists, "", "leaf already exists with this uid: %s", newUid)
+	}
+	for _, l := range leafs {
+		l.Uid = newUid
+	}
+	return nil
+}
+
+// ReorderOrgNode 调整组织节点在同级中的位置 同级节点按新顺序重新编号
+func (self *memDepTree) ReorderOrgNode(mid string, id string, place Placement) (err error) {
+	defer setOp("ReorderOrgNode", &err)
+	if id == "" || mid == "" {
+		return newError(ErrInvalidArgument, "", "invalid id or mid [%s,%s]", id, mid)
+	}
+	if id == mid {
+		return newError(ErrInvalidArgument, "", "can't reorder the top tree: %s", mid)
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	n, err := self.getNode(mid, id)
+	if err != nil {
+		return err
+	}
+	parent := n.parent
+	ids := []string{}
+	index := map[string]*memOrg{}
+	for _, c := range parent.children {
+		ids = append(ids, c.node.Id)
+		index[c.node.Id] = c
+	}
+	order, err := placeSibling(ids, id, place)
+	if err != nil {
+		return err
+	}
+	for i, cid := range order {
+		parent.children[i] = index[cid]
+		parent.children[i].node.Order = i + 1
+	}
+	return nil
+}
+
+// ReorderLeafNode 调整叶子在同级中的位置 同级叶子按新顺序重新编号
+func (self *memDepTree) ReorderLeafNode(mid string, pid string, uid string, place Placement) (err error) {
+	defer setOp("ReorderLeafNode", &err)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	parent, err := self.getNode(mid, pid)
+	if err != nil {
+		return err
+	}
+	uids := []string{}
+	index := map[string]*LeafNode{}
+	for _, l := range parent.leafs {
+		uids = append(uids, l.Uid)
+		index[l.Uid] = l
+	}
+	order, err := placeSibling(uids, uid, place)
+	if err != nil {
+		return err
+	}
+	for i, u := range order {
+		parent.leafs[i] = index[u]
+		parent.leafs[i].Order = i + 1
+	}
+	return nil
+}
+
+// GetLeafNodes 取oid子树下uid对应的全部叶子
+func (self *memDepTree) GetLeafNodes(mid string, oid string, uid string) (_ []LeafNode, err error) {
+	defer setOp("GetLeafNodes", &err)
+	return self.collectLeafs(mid, oid, func(l *LeafNode) bool {
+		return l.Uid == uid
+	})
+}
+
+// GetLeafNodesByOrg 取oid子树下的全部叶子
+func (self *memDepTree) GetLeafNodesByOrg(mid string, oid string) (_ []LeafNode, err error) {
+	defer setOp("GetLeafNodesByOrg", &err)
+	return self.collectLeafs(mid, oid, func(l *LeafNode) bool {
+		return true
+	})
+}
+
+// GetUsersByPosition 根据岗位查询子树下的叶子
+func (self *memDepTree) GetUsersByPosition(mid string, pid string, positionid string) (_ []LeafNode, err error) {
+	defer setOp("GetUsersByPosition", &err)
+	return self.collectLeafs(mid, pid, func(l *LeafNode) bool {
+		return hasPosition(l, positionid)
+	})
+}
+
+// hasPosition 判断叶子是否拥有岗位
+func hasPosition(leaf *LeafNode, positionid string) bool {
+	for _, p := range leaf.Positions {
+		if p == positionid {
+			return true
+		}
+	}
+	return false
+}
+
+// collectLeafs 遍历子树，收集满足条件的叶子
+func (self *memDepTree) collectLeafs(mid string, oid string, match func(*LeafNode) bool) ([]LeafNode, error) {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	n, err := self.getNode(mid, oid)
+	if err != nil {
+		return nil, err
+	}
+	ret := []LeafNode{}
+	n.walk(func(o *memOrg) {
+		for _, l := range o.leafs {
+			if match(l) {
+				ret = append(ret, copyLeaf(l))
+			}
+		}
+	})
+	return ret, nil
+}
+
+// GetOrgNode 取组织节点信息
+func (self *memDepTree) GetOrgNode(mid string, id string) (_ *OrgNode, err error) {
+	defer setOp("GetOrgNode", &err)
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	n, err := self.getNode(mid, id)
+	if err != nil {
+		return nil, err
+	}
+	org := n.org()
+	return &org, nil
+}
+
+// GetOrgNodesByOrg dept==1时仅取下一级节点，否则取整棵子树(包含自身) 同级按顺序排列
+func (self *memDepTree) GetOrgNodesByOrg(mid string, oid string, dept int) (_ []OrgNode, err error) {
+	defer setOp("GetOrgNodesByOrg", &err)
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	n, err := self.getNode(mid, oid)
+	if err != nil {
+		return nil, err
+	}
+	ret := []OrgNode{}
+	if dept == 1 {
+		for _, c := range n.children {
+			ret = append(ret, c.org())
+		}
+		return ret, nil
+	}
+	n.walk(func(o *memOrg) {
+		ret = append(ret, o.org())
+	})
+	return ret, nil
+}
+
+// GetSubTree 取树形结构
+func (self *memDepTree) GetSubTree(mid string, id string) (_ *OrgTree, err error) {
+	defer setOp("GetSubTree", &err)
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	n, err := self.getNode(mid, id)
+	if err != nil {
+		return nil, err
+	}
+	subtree := n.tree()
+	return &subtree, nil
+}
+
+// GetParents 根据节点id获得全部父节点信息(路径，包含自身) 从近到远
+func (self *memDepTree) GetParents(mid string, id string) (_ []OrgNode, err error) {
+	defer setOp("GetParents", &err)
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	n, err := self.getNode(mid, id)
+	if err != nil {
+		return nil, err
+	}
+	nodelist := []OrgNode{}
+	for ; n != nil; n = n.parent {
+		nodelist = append(nodelist, n.org())
+	}
+	return nodelist, nil
+}
+
+// GetLeafNodesByOrgPaged 分页取子树下的叶子 按Pid、Uid排序
+func (self *memDepTree) GetLeafNodesByOrgPaged(mid string, oid string, pageSize int, cursor string) (_ []LeafNode, _ string, err error) {
+	defer setOp("GetLeafNodesByOrgPaged", &err)
+	leafs, err := self.collectLeafs(mid, oid, func(l *LeafNode) bool {
+		return true
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return pageLeafs(leafs, pageSize, cursor)
+}
+
+// GetUsersByPositionPaged 分页根据岗位查询子树下的叶子 按Pid、Uid排序
+func (self *memDepTree) GetUsersByPositionPaged(mid string, pid string, positionid string, pageSize int, cursor string) (_ []LeafNode, _ string, err error) {
+	defer setOp("GetUsersByPositionPaged", &err)
+	leafs, err := self.collectLeafs(mid, pid, func(l *LeafNode) bool {
+		return hasPosition(l, positionid)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return pageLeafs(leafs, pageSize, cursor)
+}
+
+// pageLeafs 对叶子排序后取出cursor之后的一页 游标为最后一个叶子的Pid、Uid
+func pageLeafs(leafs []LeafNode, pageSize int, cursor string) ([]LeafNode, string, error) {
+	if err := checkPageSize(pageSize); err != nil {
+		return nil, "", err
+	}
+	after, err := decodeCursor(cursor, 2)
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Slice(leafs, func(i, j int) bool {
+		if leafs[i].Pid != leafs[j].Pid {
+			return leafs[i].Pid < leafs[j].Pid
+		}
+		return leafs[i].Uid < leafs[j].Uid
+	})
+	ret := []LeafNode{}
+	for _, l := range leafs {
+		if after != nil && (l.Pid < after[0] || l.Pid == after[0] && l.Uid <= after[1]) {
+			continue
+		}
+		if len(ret) == pageSize {
+			last := ret[pageSize-1]
+			return ret, encodeCursor(last.Pid, last.Uid), nil
+		}
+		ret = append(ret, l)
+	}
+	return ret, "", nil
+}
+
+// memOrgKey 组织节点分页排序键 层级、名称、ID
+type memOrgKey struct {
+	depth int
+	name  string
+	id    string
+}
+
+func (self memOrgKey) less(other memOrgKey) bool {
+	if self.depth != other.depth {
+		return self.depth < other.depth
+	}
+	if self.name != other.name {
+		return self.name < other.name
+	}
+	return self.id < other.id
+}
+
+// GetOrgNodesByOrgPaged 分页取组织节点 按层级、名称、ID排序
+func (self *memDepTree) GetOrgNodesByOrgPaged(mid string, oid string, dept int, pageSize int, cursor string) (_ []OrgNode, _ string, err error) {
+	defer setOp("GetOrgNodesByOrgPaged", &err)
+	if err = checkPageSize(pageSize); err != nil {
+		return nil, "", err
+	}
+	after, err := decodeCursor(cursor, 3)
+	if err != nil {
+		return nil, "", err
+	}
+	var afterKey *memOrgKey
+	if after != nil {
+		depth, err := strconv.Atoi(after[0])
+		if err != nil {
+			return nil, "", newError(ErrInvalidArgument, "", "invalid cursor: %s", cursor)
+		}
+		afterKey = &memOrgKey{depth, after[1], after[2]}
+	}
+
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	n, err := self.getNode(mid, oid)
+	if err != nil {
+		return nil, "", err
+	}
+	nodes := []OrgNode{}
+	keys := []memOrgKey{}
+	var visit func(o *memOrg, depth int)
+	visit = func(o *memOrg, depth int) {
+		if dept != 1 || depth == 1 {
+			nodes = append(nodes, o.org())
+			keys = append(keys, memOrgKey{depth, o.node.Name, o.node.Id})
+		}
+		if dept == 1 && depth == 1 {
+			return
+		}
+		for _, c := range o.children {
+			visit(c, depth+1)
+		}
+	}
+	visit(n, 0)
+	order := make([]int, len(nodes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return keys[order[i]].less(keys[order[j]])
+	})
+	ret := []OrgNode{}
+	var last memOrgKey
+	for _, i := range order {
+		if afterKey != nil && !afterKey.less(keys[i]) {
+			continue
+		}
+		if len(ret) == pageSize {
+			return ret, encodeCursor(strconv.Itoa(last.depth), last.name, last.id), nil
+		}
+		ret = append(ret, nodes[i])
+		last = keys[i]
+	}
+	return ret, "", nil
+}
+
+// Search 在子树中搜索组织节点或叶子
+func (self *memDepTree) Search(mid string, query SearchQuery) (_ *SearchResult, err error) {
+	defer setOp("Search", &err)
+	if err = checkSearchQuery(mid, &query); err != nil {
+		return nil, err
+	}
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	n, err := self.getNode(mid, query.Root)
+	if err != nil {
+		return nil, err
+	}
+	orgs := []OrgNode{}
+	leafs := []LeafNode{}
+	n.walk(func(o *memOrg) {
+		if !query.matchOrg(&o.node) {
+			return
+		}
+		if query.Target == SEARCH_ORG {
+			orgs = append(orgs, o.org())
+			return
+		}
+		for _, l := range o.leafs {
+			if query.matchLeaf(l) {
+				leafs = append(leafs, copyLeaf(l))
+			}
+		}
+	})
+	if query.Target == SEARCH_ORG {
+		return query.orgResult(orgs), nil
+	}
+	return query.leafResult(leafs), nil
+}
+
+// checkPositionIds 检查岗位均已定义
+func (self *memDepTree) checkPositionIds(mid string, positions []string) error {
+	return checkPositionIds(positions, func(id string) bool {
+		_, ok := self.positions[mid][id]
+		return ok
+	})
+}
+
+// getPosition 取岗位定义
+func (self *memDepTree) getPosition(mid string, id string) (*Position, error) {
+	if _, ok := self.trees[mid]; !ok {
+		return nil, errNotFound("", "Can't find the top tree with this mid: %s", mid)
+	}
+	pos, ok := self.positions[mid][id]
+	if !ok {
+		return nil, errNotFound("", "Can't find the position with this id: %s", id)
+	}
+	return pos, nil
+}
+
+// hasPositionName 判断商户内是否存在同名岗位
+func (self *memDepTree) hasPositionName(mid string, name string) bool {
+	for _, pos := range self.positions[mid] {
+		if pos.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AddPosition 新增岗位定义
+func (self *memDepTree) AddPosition(pos Position) (_ string, err error) {
+	defer setOp("AddPosition", &err)
+	if pos.Mid == "" || pos.Name == "" {
+		return "", newError(ErrInvalidArgument, "", "invalid mid or name [%s,%s]", pos.Mid, pos.Name)
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if _, ok := self.trees[pos.Mid]; !ok {
+		return "", errNotFound("", "Can't find the top tree with this mid: %s", pos.Mid)
+	}
+	if pos.Id == "" {
+		pos.Id = GetId()
+	} else if _, ok := self.positions[pos.Mid][pos.Id]; ok {
+		return "", newError(ErrAlreadyExists, "", "position already exists with this id: %s", pos.Id)
+	}
+	if self.hasPositionName(pos.Mid, pos.Name) {
+		return "", newError(ErrDuplicateName, "", "position already exists with this name: %s", pos.Name)
+	}
+	if self.positions[pos.Mid] == nil {
+		self.positions[pos.Mid] = map[string]*Position{}
+	}
+	self.positions[pos.Mid][pos.Id] = &pos
+	return pos.Id, nil
+}
+
+// ModifyPosition 修改岗位定义
+func (self *memDepTree) ModifyPosition(pos Position) (err error) {
+	defer setOp("ModifyPosition", &err)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	old, err := self.getPosition(pos.Mid, pos.Id)
+	if err != nil {
+		return err
+	}
+	if pos.Name != "" && pos.Name != old.Name {
+		if self.hasPositionName(pos.Mid, pos.Name) {
+			return newError(ErrDuplicateName, "", "position already exists with this name: %s", pos.Name)
+		}
+		old.Name = pos.Name
+	}
+	old.Level = pos.Level
+	old.Description = pos.Description
+	return nil
+}
+
+// RenamePosition 修改岗位ID 同步更新拥有该岗位的叶子
+func (self *memDepTree) RenamePosition(mid string, oldId string, newId string) (err error) {
+	defer setOp("RenamePosition", &err)
+	if newId == "" {
+		return newError(ErrInvalidArgument, "", "invalid new position id")
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	pos, err := self.getPosition(mid, oldId)
+	if err != nil {
+		return err
+	}
+	if oldId == newId {
+		return nil
+	}
+	if _, ok := self.positions[mid][newId]; ok {
+		return newError(ErrAlreadyExists, "", "position already exists with this id: %s", newId)
+	}
+	delete(self.positions[mid], oldId)
+	pos.Id = newId
+	self.positions[mid][newId] = pos
+	self.replacePosition(mid, oldId, newId)
+	return nil
+}
+
+// DelPosition 删除岗位定义 同时从拥有该岗位的叶子中移除
+func (self *memDepTree) DelPosition(mid string, id string) (err error) {
+	defer setOp("DelPosition", &err)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if _, err = self.getPosition(mid, id); err != nil {
+		return err
+	}
+	delete(self.positions[mid], id)
+	self.replacePosition(mid, id, "")
+	return nil
+}
+
+// replacePosition 替换商户内全部叶子(包含已归档子树中的叶子)的岗位
+func (self *memDepTree) replacePosition(mid string, oldId string, newId string) {
+	replace := func(o *memOrg) {
+		for _, l := range o.leafs {
+			if positions, changed := replacePosition(l.Positions, oldId, newId); changed {
+				l.Positions = positions
+			}
+		}
+	}
+	self.trees[mid].walk(replace)
+	for _, a := range self.archives[mid] {
+		a.org.walk(replace)
+	}
+}
+
+// GetPosition 取岗位定义
+func (self *memDepTree) GetPosition(mid string, id string) (_ *Position, err error) {
+	defer setOp("GetPosition", &err)
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	pos, err := self.getPosition(mid, id)
+	if err != nil {
+		return nil, err
+	}
+	ret := *pos
+	return &ret, nil
+}
+
+// GetPositions 取商户的全部岗位定义 按级别、ID排序
+func (self *memDepTree) GetPositions(mid string) (_ []Position, err error) {
+	defer setOp("GetPositions", &err)
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	if _, ok := self.trees[mid]; !ok {
+		return nil, errNotFound("", "Can't find the top tree with this mid: %s", mid)
+	}
+	ret := []Position{}
+	for _, pos := range self.positions[mid] {
+		ret = append(ret, *pos)
+	}
+	sortPositions(ret)
+	return ret, nil
+}
+
+// GetStatistics 子树统计
+func (self *memDepTree) GetStatistics(mid string, id string) (_ *OrgStats, err error) {
+	defer setOp("GetStatistics", &err)
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	n, err := self.getNode(mid, id)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []OrgNode{}
+	leafs := []LeafNode{}
+	n.walk(func(o *memOrg) {
+		nodes = append(nodes, o.node)
+		for _, l := range o.leafs {
+			leafs = append(leafs, *l)
+		}
+	})
+	return newOrgStats(id, nodes, leafs), nil
+}
+
+// GetMemberships 取uid在商户内的全部叶子节点及各自的父节点路径
+func (self *memDepTree) GetMemberships(mid string, uid string) (_ []Membership, err error) {
+	defer setOp("GetMemberships", &err)
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+
+	n, err := self.getNode(mid, mid)
+	if err != nil {
+		return nil, err
+	}
+	ret := []Membership{}
+	n.walk(func(o *memOrg) {
+		i := o.findLeaf(uid)
+		if i < 0 {
+			return
+		}
+		m := Membership{Leaf: copyLeaf(o.leafs[i]), Parents: []OrgNode{}}
+		for p := o; p != nil; p = p.parent {
+			m.Parents = append(m.Parents, p.org())
+		}
+		ret = append(ret, m)
+	})
+	sortMemberships(ret)
+	return ret, nil
+}
@@ -0,0 +1,193 @@
+package deptree
+
+import (
+	"errors"
+	"testing"
+)
+
+// newTestMem 顶级节点m下 a -> b 两级组织节点，b下叶子u1
+func newTestMem(t *testing.T) *memDepTree {
+	tree := newMemDepTree(map[string]interface{}{})
+	if _, err := tree.AddOrgNode(OrgNode{Mid: "m", Name: "top"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []OrgNode{{Mid: "m", Pid: "m", Id: "a", Name: "a"}, {Mid: "m", Pid: "a", Id: "b", Name: "b"}} {
+		if _, err := tree.AddOrgNode(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.AddLeafNode(LeafNode{Mid: "m", Pid: "b", Uid: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestMemAddOrgNode(t *testing.T) {
+	tree := newTestMem(t)
+	if _, err := tree.AddOrgNode(OrgNode{Mid: "m", Name: "other"}); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("second top err = %v, want ErrAlreadyExists", err)
+	}
+	if _, err := tree.AddOrgNode(OrgNode{Mid: "m", Pid: "m", Name: "a"}); !errors.Is(err, ErrDuplicateName) {
+		t.Errorf("duplicate name err = %v, want ErrDuplicateName", err)
+	}
+	if _, err := tree.AddOrgNode(OrgNode{Mid: "m", Pid: "m", Id: "b", Name: "c"}); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("duplicate id err = %v, want ErrAlreadyExists", err)
+	}
+	if _, err := tree.AddOrgNode(OrgNode{Mid: "m", Pid: "x", Name: "c"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing parent err = %v, want ErrNotFound", err)
+	}
+	id, err := tree.AddOrgNode(OrgNode{Mid: "m", Pid: "a", Name: "c"})
+	if err != nil || id == "" {
+		t.Fatalf("AddOrgNode = %q, %v", id, err)
+	}
+	n, err := tree.GetOrgNode("m", id)
+	if err != nil || n.Pid != "a" || n.Name != "c" || n.Order != 2 {
+		t.Errorf("GetOrgNode = %+v, %v", n, err)
+	}
+	if err = tree.AddLeafNode(LeafNode{Mid: "m", Pid: "b", Uid: "u1"}); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("duplicate leaf err = %v, want ErrAlreadyExists", err)
+	}
+}
+
+func TestMemModifyOrgNode(t *testing.T) {
+	tree := newTestMem(t)
+	if _, err := tree.AddOrgNode(OrgNode{Mid: "m", Pid: "m", Id: "c", Name: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.ModifyOrgNode(OrgNode{Mid: "m", Id: "c", Name: "a"}); !errors.Is(err, ErrDuplicateName) {
+		t.Errorf("rename to sibling name err = %v, want ErrDuplicateName", err)
+	}
+	if err := tree.ModifyOrgNode(OrgNode{Mid: "m", Id: "x", Name: "x"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing node err = %v, want ErrNotFound", err)
+	}
+	if err := tree.ModifyOrgNode(OrgNode{Mid: "m", Name: "x"}); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("empty id err = %v, want ErrInvalidArgument", err)
+	}
+	attrs := map[string][]string{"code": {"c1"}}
+	if err := tree.ModifyOrgNode(OrgNode{Mid: "m", Id: "c", Name: "sales", Attrs: attrs}); err != nil {
+		t.Fatal(err)
+	}
+	attrs["code"][0] = "changed"
+	n, err := tree.GetOrgNode("m", "c")
+	if err != nil || n.Name != "sales" || n.Attrs["code"][0] != "c1" {
+		t.Errorf("GetOrgNode = %+v, %v", n, err)
+	}
+	// 不传名称及扩展属性时保持不变
+	if err = tree.ModifyOrgNode(OrgNode{Mid: "m", Id: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ = tree.GetOrgNode("m", "c"); n.Name != "sales" || len(n.Attrs) != 1 {
+		t.Errorf("GetOrgNode after empty modify = %+v", n)
+	}
+}
+
+func TestMemDelOrgNode(t *testing.T) {
+	tree := newTestMem(t)
+	if err := tree.DelOrgNode("m", "a"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if _, err := tree.GetOrgNode("m", id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetOrgNode(%s) err = %v, want ErrNotFound", id, err)
+		}
+	}
+	if leafs, err := tree.GetLeafNodes("m", "m", "u1"); err != nil || len(leafs) != 0 {
+		t.Errorf("leafs of deleted subtree = %v, %v", leafs, err)
+	}
+	if err := tree.DelOrgNode("m", "a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("delete again err = %v, want ErrNotFound", err)
+	}
+	// 删除顶级节点后可重新创建
+	if err := tree.DelOrgNode("m", "m"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.AddOrgNode(OrgNode{Mid: "m", Name: "top"}); err != nil {
+		t.Errorf("add top after delete err = %v", err)
+	}
+}
+
+func TestMemLeafNode(t *testing.T) {
+	tree := newTestMem(t)
+	if _, err := tree.AddPosition(Position{Mid: "m", Id: "p1", Name: "manager"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.ModifyLeafNode(LeafNode{Mid: "m", Pid: "b", Uid: "u1", Positions: []string{"p2"}}); err == nil {
+		t.Error("undefined position accepted")
+	}
+	if err := tree.ModifyLeafNode(LeafNode{Mid: "m", Pid: "b", Uid: "u1", Positions: []string{"p1"}}); err != nil {
+		t.Fatal(err)
+	}
+	leafs, err := tree.GetUsersByPosition("m", "m", "p1")
+	if err != nil || len(leafs) != 1 || leafs[0].Uid != "u1" {
+		t.Errorf("GetUsersByPosition = %v, %v", leafs, err)
+	}
+	if err = tree.DelLeafNode("m", "b", "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = tree.DelLeafNode("m", "b", "u1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("delete again err = %v, want ErrNotFound", err)
+	}
+	if leafs, _ = tree.GetLeafNodesByOrg("m", "m"); len(leafs) != 0 {
+		t.Errorf("leafs after delete = %v", leafs)
+	}
+}
+
+func TestMemGetSubTree(t *testing.T) {
+	tree := newTestMem(t)
+	if _, err := tree.AddOrgNode(OrgNode{Mid: "m", Pid: "m", Id: "c", Name: "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.AddLeafNode(LeafNode{Mid: "m", Pid: "m", Uid: "u2"}); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := tree.GetSubTree("m", "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a": "m", "b": "a", "c": "m", "u1": "b", "u2": "m"}
+	got := treeParents(sub)
+	if len(got) != len(want) {
+		t.Fatalf("parents = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("parent of %s = %q, want %q", k, got[k], v)
+		}
+	}
+	if sub.SubTrees[0].Id != "a" || sub.SubTrees[1].Id != "c" {
+		t.Errorf("children order = %s,%s", sub.SubTrees[0].Id, sub.SubTrees[1].Id)
+	}
+	// 返回的是副本
+	sub.SubTrees[0].SubTrees[0].SubLeafs[0].Uid = "changed"
+	if sub, _ = tree.GetSubTree("m", "b"); sub.SubLeafs[0].Uid != "u1" {
+		t.Error("GetSubTree result shares state with the tree")
+	}
+	if _, err = tree.GetSubTree("m", "x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing node err = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemGetParents(t *testing.T) {
+	tree := newTestMem(t)
+	if got := parentIds(t, tree, "m", "b"); got != "b,a,m" {
+		t.Errorf("parents of b = %s", got)
+	}
+	if got := parentIds(t, tree, "m", "m"); got != "m" {
+		t.Errorf("parents of top = %s", got)
+	}
+	if err := tree.MoveOrgNode("m", "b", "m"); err != nil {
+		t.Fatal(err)
+	}
+	if got := parentIds(t, tree, "m", "b"); got != "b,m" {
+		t.Errorf("parents of b after move = %s", got)
+	}
+	if err := tree.MoveOrgNode("m", "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.MoveOrgNode("m", "b", "a"); err == nil {
+		t.Error("moved a node under its own descendant")
+	}
+	if _, err := tree.GetParents("m", "x"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing node err = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,190 @@
+package deptree
+
+import "strconv"
+
+// 合并：先根据源节点及目标节点的子树生成全部步骤(同名冲突在此时处理，MERGE_FAIL时不做任何修改)，
+// 再由各实现按顺序执行；memory sql在同一锁或事务中完成，ldap逐步执行
+
+// mergeExecutor 各实现执行合并步骤的操作
+type mergeExecutor interface {
+	// moveOrg 将组织节点移动到pid下(排在最后) name非空时同时改名 pid为当前父节点时只改名
+	moveOrg(id string, pid string, name string) error
+	// moveLeaf 调动叶子 排在最后
+	moveLeaf(uid string, from string, to string) error
+	// mergeLeaf 更新to下叶子的岗位并删除from下的叶子
+	mergeLeaf(uid string, from string, to string, positions []string) error
+	// delOrg 删除已清空的组织节点
+	delOrg(id string) error
+}
+
+// checkMerge 检查合并参数 target不能在source子树中
+func checkMerge(mid string, sourceId string, targetId string, source *OrgTree, target *OrgTree, opts MergeOptions) error {
+	if opts.Strategy < MERGE_FAIL || opts.Strategy > MERGE_RECURSIVE {
+		return newError(ErrInvalidArgument, "", "invalid merge strategy: %d", opts.Strategy)
+	}
+	if sourceId == mid {
+		return newError(ErrInvalidArgument, "", "can't merge the top tree: %s", mid)
+	}
+	if sourceId == targetId || source.find(targetId) != nil {
+		return newError(ErrInvalidArgument, "", "can't merge node %s into its own subtree", sourceId)
+	}
+	return nil
+}
+
+// find 在子树中查找组织节点（包含自身）
+func (self *OrgTree) find(id string) *OrgTree {
+	if self.Id == id {
+		return self
+	}
+	for i := range self.SubTrees {
+		if t := self.SubTrees[i].find(id); t != nil {
+			return t
+		}
+	}
+	return nil
+}
+
+// mergeKey 比较同级名称及uid是否重复使用的键 由各实现按后端的比较规则传入
+// memory sql区分大小写使用sameKey，ldap的RDN(ou cn)不区分大小写使用strings.ToLower
+type mergeKey func(string) string
+
+// sameKey 区分大小写的比较
+func sameKey(s string) string {
+	return s
+}
+
+// planMerge 生成将source合并到target的步骤 source被清空后删除
+func planMerge(source *OrgTree, target *OrgTree, strategy int, key mergeKey) ([]MergeStep, error) {
+	names := map[string]*OrgTree{}
+	for i := range target.SubTrees {
+		if c := &target.SubTrees[i]; c.Id != source.Id {
+			names[key(c.Name)] = c
+		}
+	}
+	steps := []MergeStep{}
+	// source是target的子节点时，先让出名称，避免与移出的同名子节点冲突
+	if source.Pid == target.Id {
+		for _, c := range source.SubTrees {
+			if key(c.Name) == key(source.Name) {
+				name := uniqueName(source.Name, names, source.SubTrees, key)
+				steps = append(steps, MergeStep{Action: MERGE_MOVE_ORG, Id: source.Id, From: target.Id, To: target.Id, Name: name})
+				names[key(name)] = source
+				break
+			}
+		}
+	}
+	for i := range source.SubTrees {
+		c := &source.SubTrees[i]
+		exist, ok := names[key(c.Name)]
+		if !ok {
+			steps = append(steps, MergeStep{Action: MERGE_MOVE_ORG, Id: c.Id, From: source.Id, To: target.Id})
+			names[key(c.Name)] = c
+			continue
+		}
+		switch strategy {
+		case MERGE_RENAME:
+			name := uniqueName(c.Name, names, nil, key)
+			steps = append(steps, MergeStep{Action: MERGE_MOVE_ORG, Id: c.Id, From: source.Id, To: target.Id, Name: name})
+			names[key(name)] = c
+		case MERGE_RECURSIVE:
+			steps = append(steps, MergeStep{Action: MERGE_MERGE_ORG, Id: c.Id, From: source.Id, To: exist.Id})
+			sub, err := planMerge(c, exist, strategy, key)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, sub...)
+		default:
+			return nil, newError(ErrDuplicateName, "", "node already exists with this name: %s", c.Name)
+		}
+	}
+	leafs := map[string]*LeafNode{}
+	for i := range target.SubLeafs {
+		leafs[key(target.SubLeafs[i].Uid)] = &target.SubLeafs[i]
+	}
+	for _, l := range source.SubLeafs {
+		exist, ok := leafs[key(l.Uid)]
+		if !ok {
+			steps = append(steps, MergeStep{Action: MERGE_MOVE_LEAF, Id: l.Uid, From: source.Id, To: target.Id})
+			continue
+		}
+		steps = append(steps, MergeStep{Action: MERGE_MERGE_LEAF, Id: l.Uid, From: source.Id, To: target.Id,
+			Positions: mergePositions(exist.Positions, l.Positions)})
+	}
+	steps = append(steps, MergeStep{Action: MERGE_DEL_ORG, Id: source.Id, From: source.Pid})
+	return steps, nil
+}
+
+// uniqueName 生成不与names(键为key(名称))及others中名称重复的 名称(n)
+func uniqueName(name string, names map[string]*OrgTree, others []OrgTree, key mergeKey) string {
+	for n := 2; ; n++ {
+		ret := name + "(" + strconv.Itoa(n) + ")"
+		if _, ok := names[key(ret)]; ok {
+			continue
+		}
+		used := false
+		for _, o := range others {
+			if key(o.Name) == key(ret) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			return ret
+		}
+	}
+}
+
+// mergePositions 合并岗位 保持target中的顺序，source中新增的依次追加
+func mergePositions(target []string, source []string) []string {
+	ret := append([]string{}, target...)
+	for _, p := range source {
+		found := false
+		for _, t := range ret {
+			if t == p {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// newMergeReport 生成合并报告 key见mergeKey
+func newMergeReport(source *OrgTree, target *OrgTree, opts MergeOptions, key mergeKey) (*MergeReport, error) {
+	steps, err := planMerge(source, target, opts.Strategy, key)
+	if err != nil {
+		return nil, err
+	}
+	return &MergeReport{
+		SourceId: source.Id,
+		TargetId: target.Id,
+		Strategy: opts.Strategy,
+		DryRun:   opts.DryRun,
+		Steps:    steps,
+	}, nil
+}
+
+// runMerge 按顺序执行报告中的步骤 report.Done记录已执行的步骤数
+func runMerge(report *MergeReport, exec mergeExecutor) error {
+	for _, s := range report.Steps {
+		var err error
+		switch s.Action {
+		case MERGE_MOVE_ORG:
+			err = exec.moveOrg(s.Id, s.To, s.Name)
+		case MERGE_MOVE_LEAF:
+			err = exec.moveLeaf(s.Id, s.From, s.To)
+		case MERGE_MERGE_LEAF:
+			err = exec.mergeLeaf(s.Id, s.From, s.To, s.Positions)
+		case MERGE_DEL_ORG:
+			err = exec.delOrg(s.Id)
+		}
+		if err != nil {
+			return err
+		}
+		report.Done++
+	}
+	return nil
+}
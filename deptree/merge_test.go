@@ -0,0 +1,75 @@
+package deptree
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// mergeFixture target下有子节点Sales及叶子Alice，source下有子节点sales及叶子alice
+func mergeFixture() (*OrgTree, *OrgTree) {
+	target := &OrgTree{
+		OrgNode:  OrgNode{Mid: "m", Pid: "m", Id: "t", Name: "Target"},
+		SubTrees: []OrgTree{{OrgNode: OrgNode{Mid: "m", Pid: "t", Id: "ts", Name: "Sales"}}},
+		SubLeafs: []LeafNode{{Mid: "m", Pid: "t", Uid: "Alice", Positions: []string{"p1"}}},
+	}
+	source := &OrgTree{
+		OrgNode:  OrgNode{Mid: "m", Pid: "m", Id: "s", Name: "Source"},
+		SubTrees: []OrgTree{{OrgNode: OrgNode{Mid: "m", Pid: "s", Id: "ss", Name: "sales"}}},
+		SubLeafs: []LeafNode{{Mid: "m", Pid: "s", Uid: "alice", Positions: []string{"p2"}}},
+	}
+	return source, target
+}
+
+func stepActions(steps []MergeStep) string {
+	actions := []string{}
+	for _, s := range steps {
+		actions = append(actions, s.Action+":"+s.Id+">"+s.To+s.Name)
+	}
+	return strings.Join(actions, " ")
+}
+
+func TestPlanMergeKey(t *testing.T) {
+	cases := []struct {
+		name     string
+		strategy int
+		key      mergeKey
+		want     string
+		err      error
+	}{
+		{"exact fail", MERGE_FAIL, sameKey,
+			"MoveOrg:ss>t MoveLeaf:alice>t DelOrg:s>", nil},
+		{"fold fail", MERGE_FAIL, strings.ToLower, "", ErrDuplicateName},
+		{"fold rename", MERGE_RENAME, strings.ToLower,
+			"MoveOrg:ss>tsales(2) MergeLeaf:alice>t DelOrg:s>", nil},
+		{"fold recursive", MERGE_RECURSIVE, strings.ToLower,
+			"MergeOrg:ss>ts DelOrg:ss> MergeLeaf:alice>t DelOrg:s>", nil},
+	}
+	for _, c := range cases {
+		source, target := mergeFixture()
+		steps, err := planMerge(source, target, c.strategy, c.key)
+		if c.err != nil {
+			if !errors.Is(err, c.err) {
+				t.Errorf("%s: err = %v, want %v", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got := stepActions(steps); got != c.want {
+			t.Errorf("%s: steps = %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestUniqueNameKey(t *testing.T) {
+	names := map[string]*OrgTree{"a(2)": nil}
+	if got := uniqueName("A", names, []OrgTree{{OrgNode: OrgNode{Name: "A(3)"}}}, strings.ToLower); got != "A(4)" {
+		t.Errorf("uniqueName = %s, want A(4)", got)
+	}
+	if got := uniqueName("A", names, nil, sameKey); got != "A(2)" {
+		t.Errorf("uniqueName = %s, want A(2)", got)
+	}
+}
@@ -1,22 +1,28 @@
 package deptree
 
+import "time"
+
 // Node 组织节点
 type OrgNode struct {
-	Mid       string // 商户ID
-	Pid       string // 父节点ID
-	Id        string // ID
-	Type      int    // 1-商户 2-分公司 3-部门
-	Name      string // 名称
-	IsDefault bool   // 是否默认生成
+	Mid       string              // 商户ID
+	Pid       string              // 父节点ID
+	Id        string              // ID
+	Type      int                 // 1-商户 2-分公司 3-部门
+	Name      string              // 名称
+	IsDefault bool                // 是否默认生成
+	Order     int                 // 同级排序序号 新增时为0则排在同级最后
+	Attrs     map[string][]string // 扩展属性 名称 -> 值列表，修改时为nil则不修改
 }
 
 // Leaf 叶节点
 type LeafNode struct {
-	Mid       string   // 商户ID
-	Pid       string   // 父节点ID
-	Sid       string   // staff id
-	Uid       string   // uid
-	Positions []string // 岗位ID列表
+	Mid       string              // 商户ID
+	Pid       string              // 父节点ID
+	Sid       string              // staff id
+	Uid       string              // uid
+	Positions []string            // 岗位ID列表
+	Order     int                 // 同级排序序号 新增时为0则排在同级最后
+	Attrs     map[string][]string // 扩展属性 名称 -> 值列表，修改时为nil则不修改
 }
 
 // OrgTree组织树
@@ -29,3 +35,156 @@ type OrgTree struct {
 var TYPE_SHOP int = 1
 var TYPE_SUBCOM int = 2
 var TYPE_DEP int = 3
+
+// 搜索目标
+const (
+	SEARCH_ORG  = 0 // 搜索组织节点
+	SEARCH_LEAF = 1 // 搜索叶子节点
+)
+
+// 名称、uid匹配方式 均不区分大小写
+const (
+	MATCH_EXACT     = 0 // 完全相同
+	MATCH_PREFIX    = 1 // 前缀
+	MATCH_SUBSTRING = 2 // 包含
+)
+
+// 岗位匹配方式
+const (
+	POSITION_ALL = 0 // 拥有全部岗位
+	POSITION_ANY = 1 // 拥有任一岗位
+)
+
+// SearchQuery 搜索条件 空值的条件不做限制
+// 搜索叶子节点时Name Types用于限制叶子所在的组织节点
+type SearchQuery struct {
+	Target       int               // SEARCH_ORG SEARCH_LEAF
+	Root         string            // 子树根节点ID(包含自身) 空为整个商户
+	Name         string            // 组织节点名称
+	NameMatch    int               // 名称匹配方式 MATCH_*
+	Types        []int             // 组织节点类型 TYPE_SHOP TYPE_SUBCOM TYPE_DEP 满足任一即可
+	Uid          string            // 叶子uid
+	UidMatch     int               // uid匹配方式 MATCH_*
+	Sid          string            // 叶子staff id 完全相同
+	Positions    []string          // 岗位ID列表
+	PositionMode int               // 岗位匹配方式 POSITION_*
+	Attrs        map[string]string // 搜索目标的扩展属性 名称 -> 值，需全部满足，属性的任一值相同即可
+	SortBy       string            // 排序字段 组织节点：Name(默认) Id Type 叶子节点：Uid(默认) Sid Pid
+	Desc         bool              // 是否倒序
+	Offset       int               // 跳过的结果数
+	Limit        int               // 返回的最大结果数 0为不限制
+}
+
+// SearchResult 搜索结果 根据Target填充Orgs或Leafs
+type SearchResult struct {
+	Orgs  []OrgNode
+	Leafs []LeafNode
+	Total int // 分页前的结果总数
+}
+
+// Position 岗位定义 LeafNode.Positions中的岗位ID需先在商户的岗位目录中定义
+type Position struct {
+	Mid         string // 商户ID
+	Id          string // 岗位ID
+	Name        string // 名称 同一商户内唯一
+	Level       int    // 级别
+	Description string // 描述
+}
+
+// Membership 用户在一个组织节点下的叶子及该组织节点的路径
+type Membership struct {
+	Leaf    LeafNode  // 叶子节点
+	Parents []OrgNode // 叶子所在组织节点的全部父节点(包含自身) 从近到远，同GetParents
+}
+
+// 数据权限范围规则
+const (
+	SCOPE_SELF     = 0 // 仅本人
+	SCOPE_DEP      = 1 // 所在部门 不含下级部门
+	SCOPE_DEP_TREE = 2 // 所在部门及全部下级部门
+	SCOPE_BRANCH   = 3 // 所在部门向上最近的分公司(TYPE_SUBCOM)子树 没有分公司时为整个商户
+	SCOPE_MERCHANT = 4 // 整个商户
+)
+
+// Placement 节点在同级中的目标位置 Before After Index按顺序取第一个有效的
+type Placement struct {
+	Before string // 移到该同级节点(组织节点ID或叶子uid)之前
+	After  string // 移到该同级节点之后
+	Index  int    // 移到第Index位(从0开始) 超出范围时移到最后
+}
+
+// ArchivedOrg 已归档的组织节点子树 OrgNode为子树根节点，Pid为归档前的父节点
+type ArchivedOrg struct {
+	OrgNode
+	ArchivedAt time.Time // 归档时间
+	PurgeAt    time.Time // 超过保留期可被清除的时间
+}
+
+// 合并组织节点时同名子节点的处理方式
+const (
+	MERGE_FAIL      = 0 // 返回ErrDuplicateName，不做修改
+	MERGE_RENAME    = 1 // 移动后改名为 名称(2) 名称(3)...
+	MERGE_RECURSIVE = 2 // 递归合并到目标下的同名节点
+)
+
+// MergeOptions 合并选项
+type MergeOptions struct {
+	Strategy int  // 同名子节点的处理方式 MERGE_*
+	DryRun   bool // 只生成报告不修改
+}
+
+// 合并步骤的动作
+const (
+	MERGE_MOVE_ORG   = "MoveOrg"   // 子节点(包含子树)移动到To下，Name非空时同时改名
+	MERGE_MERGE_ORG  = "MergeOrg"  // 同名子节点Id递归合并到To，之后的步骤为其内容
+	MERGE_MOVE_LEAF  = "MoveLeaf"  // 叶子从From调动到To
+	MERGE_MERGE_LEAF = "MergeLeaf" // To下已有同uid叶子，岗位合并为Positions后删除From下的叶子
+	MERGE_DEL_ORG    = "DelOrg"    // 删除已清空的节点
+)
+
+// MergeStep 合并的一个步骤 叶子的Id为uid
+type MergeStep struct {
+	Action    string   // MERGE_MOVE_ORG等
+	Id        string   // 组织节点ID或叶子uid
+	From      string   // 原父节点ID
+	To        string   // 新父节点ID 或合并到的节点ID
+	Name      string   // 改名后的名称
+	Positions []string // 合并后的岗位
+}
+
+// MergeReport 合并报告 按执行顺序列出步骤
+type MergeReport struct {
+	SourceId string
+	TargetId string
+	Strategy int
+	DryRun   bool
+	Steps    []MergeStep
+	Done     int // 已执行的步骤数 ldap中途失败时可据此确定已完成的部分
+}
+
+// OrgStats 子树统计 见GetStatistics
+type OrgStats struct {
+	Id        string                   // 子树根节点ID
+	Nodes     []NodeStats              // 子树中的组织节点(包含根节点) 先序遍历，同级按顺序
+	Positions map[string]PositionCount // 岗位ID -> 子树中拥有该岗位的数量
+	Leafs     int                      // 叶子总数 同一uid在多个节点下分别计数
+	Uids      int                      // 不同uid数
+	MaxDepth  int                      // 最大深度 根节点为0
+}
+
+// NodeStats 组织节点的统计
+type NodeStats struct {
+	Id     string
+	Pid    string
+	Name   string
+	Depth  int // 相对子树根节点的深度
+	Direct int // 直属叶子数
+	Total  int // 包含子孙节点的叶子数
+	Uids   int // 包含子孙节点的不同uid数
+}
+
+// PositionCount 岗位的统计
+type PositionCount struct {
+	Leafs int // 拥有该岗位的叶子数
+	Uids  int // 拥有该岗位的不同uid数
+}
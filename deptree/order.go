@@ -0,0 +1,110 @@
+package deptree
+
+import "sort"
+
+// orgOrderLess 同级组织节点顺序 按Order、名称、ID
+func orgOrderLess(a *OrgNode, b *OrgNode) bool {
+	if a.Order != b.Order {
+		return a.Order < b.Order
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return a.Id < b.Id
+}
+
+// leafOrderLess 同级叶子顺序 按Order、uid
+func leafOrderLess(a *LeafNode, b *LeafNode) bool {
+	if a.Order != b.Order {
+		return a.Order < b.Order
+	}
+	return a.Uid < b.Uid
+}
+
+// sortSiblingOrgs 同级组织节点排序
+func sortSiblingOrgs(nodes []OrgNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return orgOrderLess(&nodes[i], &nodes[j])
+	})
+}
+
+// sortSiblingLeafs 同级叶子排序
+func sortSiblingLeafs(leafs []LeafNode) {
+	sort.SliceStable(leafs, func(i, j int) bool {
+		return leafOrderLess(&leafs[i], &leafs[j])
+	})
+}
+
+// orderOrgNodes 将root子树下的组织节点按先序遍历排列，同级按顺序排序
+// nodes可包含root本身，不在子树中的节点被忽略
+func orderOrgNodes(root string, nodes []OrgNode) []OrgNode {
+	children := map[string][]OrgNode{}
+	ret := []OrgNode{}
+	for _, n := range nodes {
+		if n.Id == root {
+			ret = append(ret, n)
+			continue
+		}
+		children[n.Pid] = append(children[n.Pid], n)
+	}
+	for _, c := range children {
+		sortSiblingOrgs(c)
+	}
+	var walk func(id string)
+	walk = func(id string) {
+		for _, c := range children[id] {
+			ret = append(ret, c)
+			walk(c.Id)
+		}
+	}
+	walk(root)
+	return ret
+}
+
+// placeSibling 计算id移动到place后的同级顺序 ids为当前顺序(包含id)
+func placeSibling(ids []string, id string, place Placement) ([]string, error) {
+	rest := make([]string, 0, len(ids))
+	found := false
+	for _, s := range ids {
+		if s == id {
+			found = true
+		} else {
+			rest = append(rest, s)
+		}
+	}
+	if !found {
+		return nil, errNotFound("", "Can't find the node or leaf with this id: %s", id)
+	}
+	index := place.Index
+	if place.Before != "" || place.After != "" {
+		target := place.Before
+		if target == "" {
+			target = place.After
+		}
+		if target == id {
+			return nil, newError(ErrInvalidArgument, "", "can't place node %s relative to itself", id)
+		}
+		index = -1
+		for i, s := range rest {
+			if s == target {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, errNotFound("", "Can't find the sibling with this id: %s", target)
+		}
+		if place.Before == "" {
+			index++
+		}
+	}
+	if index < 0 {
+		return nil, newError(ErrInvalidArgument, "", "invalid index: %d", index)
+	}
+	if index > len(rest) {
+		index = len(rest)
+	}
+	ret := append([]string{}, rest[:index]...)
+	ret = append(ret, id)
+	return append(ret, rest[index:]...), nil
+}
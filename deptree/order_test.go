@@ -0,0 +1,132 @@
+package deptree
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPlaceSibling(t *testing.T) {
+	ids := []string{"a", "b", "c", "d"}
+	cases := []struct {
+		id    string
+		place Placement
+		want  string
+		kind  error
+	}{
+		{"a", Placement{Before: "c"}, "b,a,c,d", nil},
+		{"d", Placement{Before: "a"}, "d,a,b,c", nil},
+		{"d", Placement{After: "b"}, "a,b,d,c", nil},
+		{"a", Placement{After: "d"}, "b,c,d,a", nil},
+		{"c", Placement{Index: 0}, "c,a,b,d", nil},
+		{"a", Placement{Index: 2}, "b,c,a,d", nil},
+		{"b", Placement{Index: 99}, "a,c,d,b", nil},
+		{"b", Placement{}, "b,a,c,d", nil},
+		// Before After Index按顺序取第一个有效的
+		{"d", Placement{Before: "b", After: "a", Index: 3}, "a,d,b,c", nil},
+		{"a", Placement{After: "c", Index: 0}, "b,c,a,d", nil},
+		{"a", Placement{Before: "a"}, "", ErrInvalidArgument},
+		{"a", Placement{After: "a"}, "", ErrInvalidArgument},
+		{"a", Placement{Index: -1}, "", ErrInvalidArgument},
+		{"a", Placement{Before: "x"}, "", ErrNotFound},
+		{"x", Placement{Index: 0}, "", ErrNotFound},
+	}
+	for _, c := range cases {
+		got, err := placeSibling(ids, c.id, c.place)
+		if c.kind != nil {
+			if !errors.Is(err, c.kind) {
+				t.Errorf("place %s %+v: err = %v, want %v", c.id, c.place, err, c.kind)
+			}
+			continue
+		}
+		if err != nil || strings.Join(got, ",") != c.want {
+			t.Errorf("place %s %+v = %v, %v, want %s", c.id, c.place, got, err, c.want)
+		}
+	}
+	if strings.Join(ids, ",") != "a,b,c,d" {
+		t.Errorf("input modified: %v", ids)
+	}
+}
+
+// orgOrders 组织节点的 ID:Order 列表
+func orgOrders(nodes []OrgNode) string {
+	ret := []string{}
+	for _, n := range nodes {
+		ret = append(ret, fmt.Sprintf("%s:%d", n.Id, n.Order))
+	}
+	return strings.Join(ret, " ")
+}
+
+// 调整顺序后同级重新编号，GetSubTree GetOrgNodesByOrg按同级顺序返回(与名称及uid的顺序不同)
+func TestReorderSiblings(t *testing.T) {
+	eachBackend(t, func(t *testing.T, tree DepTree) {
+		seedTree(t, tree, "m")
+		for _, n := range [][3]string{{"a", "m", "z"}, {"b", "m", "y"}, {"c", "m", "x"}, {"a1", "a", "k"}, {"a2", "a", "j"}} {
+			if _, err := tree.AddOrgNode(OrgNode{Mid: "m", Id: n[0], Pid: n[1], Name: n[2]}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, uid := range []string{"w", "v", "u"} {
+			if err := tree.AddLeafNode(LeafNode{Mid: "m", Pid: "m", Uid: uid}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		check := func(step string, children string, preorder string, leafs string) {
+			t.Helper()
+			sub, err := tree.GetSubTree("m", "m")
+			if err != nil {
+				t.Fatal(err)
+			}
+			nodes := []OrgNode{}
+			for _, c := range sub.SubTrees {
+				nodes = append(nodes, c.OrgNode)
+			}
+			uids := []string{}
+			for _, l := range sub.SubLeafs {
+				uids = append(uids, fmt.Sprintf("%s:%d", l.Uid, l.Order))
+			}
+			if got := orgOrders(nodes); got != children {
+				t.Errorf("%s: GetSubTree children = %s, want %s", step, got, children)
+			}
+			if got := strings.Join(uids, " "); got != leafs {
+				t.Errorf("%s: GetSubTree leafs = %s, want %s", step, got, leafs)
+			}
+			direct, err := tree.GetOrgNodesByOrg("m", "m", 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := orgOrders(direct); got != children {
+				t.Errorf("%s: GetOrgNodesByOrg children = %s, want %s", step, got, children)
+			}
+			all, err := tree.GetOrgNodesByOrg("m", "m", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := []string{}
+			for _, n := range all {
+				ids = append(ids, n.Id)
+			}
+			if got := strings.Join(ids, ","); got != preorder {
+				t.Errorf("%s: GetOrgNodesByOrg all = %s, want %s", step, got, preorder)
+			}
+		}
+		check("added", "a:1 b:2 c:3", "m,a,a1,a2,b,c", "w:1 v:2 u:3")
+
+		if err := tree.ReorderOrgNode("m", "c", Placement{Before: "b"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.ReorderOrgNode("m", "a2", Placement{Index: 0}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.ReorderLeafNode("m", "m", "u", Placement{After: "w"}); err != nil {
+			t.Fatal(err)
+		}
+		check("reordered", "a:1 c:2 b:3", "m,a,a2,a1,c,b", "w:1 u:2 v:3")
+
+		if err := tree.ReorderOrgNode("m", "a", Placement{Index: 5}); err != nil {
+			t.Fatal(err)
+		}
+		check("moved last", "c:1 b:2 a:3", "m,c,b,a,a2,a1", "w:1 u:2 v:3")
+	})
+}
@@ -0,0 +1,52 @@
+package deptree
+
+import (
+	"sort"
+)
+
+// checkPositionIds 检查岗位ID均已在岗位目录中定义 known判断岗位是否存在
+func checkPositionIds(positions []string, known func(id string) bool) error {
+	for _, p := range positions {
+		if !known(p) {
+			return newError(ErrInvalidArgument, "", "unknown position: %s", p)
+		}
+	}
+	return nil
+}
+
+// replacePosition 将岗位列表中的oldId替换为newId，newId为空时移除 返回是否有修改
+func replacePosition(positions []string, oldId string, newId string) ([]string, bool) {
+	ret := make([]string, 0, len(positions))
+	changed := false
+	for _, p := range positions {
+		if p != oldId {
+			ret = append(ret, p)
+			continue
+		}
+		changed = true
+		if newId != "" && !contains(ret, newId) && !contains(positions, newId) {
+			ret = append(ret, newId)
+		}
+	}
+	return ret, changed
+}
+
+// contains 判断列表中是否包含s
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// sortPositions 岗位列表按级别、ID排序
+func sortPositions(positions []Position) {
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].Level != positions[j].Level {
+			return positions[i].Level < positions[j].Level
+		}
+		return positions[i].Id < positions[j].Id
+	})
+}
@@ -0,0 +1,196 @@
+package deptree
+
+import (
+	"errors"
+	"sort"
+)
+
+// DataScope 用户按规则可见的数据范围
+type DataScope struct {
+	Mid    string
+	Uid    string
+	Rule   int      // SCOPE_*
+	OrgIds []string // 可见的组织节点ID 已排序
+	Uids   []string // 可见的uid(包含本人) 已排序
+}
+
+// Contains 判断组织节点ID或uid是否可见
+func (self *DataScope) Contains(id string) bool {
+	return containsSorted(self.OrgIds, id) || containsSorted(self.Uids, id)
+}
+
+// containsSorted 在已排序的列表中查找
+func containsSorted(list []string, s string) bool {
+	i := sort.SearchStrings(list, s)
+	return i < len(list) && list[i] == s
+}
+
+// ScopeEvaluator 数据权限范围计算，可用于任意DepTree实现
+// 用户属于多个部门时可见范围为各部门范围的并集
+type ScopeEvaluator struct {
+	tree DepTree
+	rule int
+}
+
+// NewScopeEvaluator 生成范围计算器 rule为SCOPE_* 非法时返回nil
+func NewScopeEvaluator(tree DepTree, rule int) *ScopeEvaluator {
+	if tree == nil || rule < SCOPE_SELF || rule > SCOPE_MERCHANT {
+		return nil
+	}
+	return &ScopeEvaluator{tree: tree, rule: rule}
+}
+
+// memberships 取用户所属的部门 不属于任何部门时返回ErrNotFound
+func (self *ScopeEvaluator) memberships(mid string, uid string) ([]Membership, error) {
+	memberships, err := self.tree.GetMemberships(mid, uid)
+	if err != nil {
+		return nil, err
+	}
+	if len(memberships) == 0 {
+		return nil, errNotFound("", "Can't find the leaf with this uid: %s", uid)
+	}
+	return memberships, nil
+}
+
+// roots 各部门按规则对应的范围根节点
+// SCOPE_DEP时为部门本身(不含子树)，其余为子树根节点
+func (self *ScopeEvaluator) roots(mid string, memberships []Membership) map[string]bool {
+	roots := map[string]bool{}
+	for _, m := range memberships {
+		switch self.rule {
+		case SCOPE_DEP, SCOPE_DEP_TREE:
+			roots[m.Leaf.Pid] = true
+		case SCOPE_BRANCH:
+			branch := m.Parents[len(m.Parents)-1].Id
+			for _, p := range m.Parents {
+				if p.Type == TYPE_SUBCOM {
+					branch = p.Id
+					break
+				}
+			}
+			roots[branch] = true
+		case SCOPE_MERCHANT:
+			roots[mid] = true
+		}
+	}
+	return roots
+}
+
+// inScope 判断路径(从近到远)是否落在范围内
+func (self *ScopeEvaluator) inScope(chain []OrgNode, roots map[string]bool) bool {
+	if len(chain) == 0 {
+		return false
+	}
+	if self.rule == SCOPE_DEP {
+		return roots[chain[0].Id]
+	}
+	for _, p := range chain {
+		if roots[p.Id] {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate 计算用户可见的组织节点及uid
+// 每个范围根节点一次GetSubTree(SCOPE_DEP为GetLeafNodesByOrg)查询
+func (self *ScopeEvaluator) Evaluate(mid string, uid string) (_ *DataScope, err error) {
+	defer setOp("Evaluate", &err)
+	memberships, err := self.memberships(mid, uid)
+	if err != nil {
+		return nil, err
+	}
+	orgs := map[string]bool{}
+	uids := map[string]bool{uid: true}
+	for root := range self.roots(mid, memberships) {
+		if orgs[root] { // 已包含在其他根节点的子树中
+			continue
+		}
+		if self.rule == SCOPE_DEP {
+			leafs, err := self.tree.GetLeafNodesByOrg(mid, root)
+			if err != nil {
+				return nil, err
+			}
+			orgs[root] = true
+			for _, l := range leafs {
+				if l.Pid == root {
+					uids[l.Uid] = true
+				}
+			}
+			continue
+		}
+		tree, err := self.tree.GetSubTree(mid, root)
+		if err != nil {
+			return nil, err
+		}
+		collectScope(tree, orgs, uids)
+	}
+
+	scope := &DataScope{Mid: mid, Uid: uid, Rule: self.rule, OrgIds: []string{}, Uids: []string{}}
+	for id := range orgs {
+		scope.OrgIds = append(scope.OrgIds, id)
+	}
+	for id := range uids {
+		scope.Uids = append(scope.Uids, id)
+	}
+	sort.Strings(scope.OrgIds)
+	sort.Strings(scope.Uids)
+	return scope, nil
+}
+
+// collectScope 收集子树中的组织节点ID及uid
+func collectScope(tree *OrgTree, orgs map[string]bool, uids map[string]bool) {
+	orgs[tree.Id] = true
+	for _, l := range tree.SubLeafs {
+		uids[l.Uid] = true
+	}
+	for i := range tree.SubTrees {
+		collectScope(&tree.SubTrees[i], orgs, uids)
+	}
+}
+
+// CanAccess 判断用户能否访问targetId(组织节点ID或uid，先按组织节点查找)
+// 不展开子树，只需查询用户及目标的父节点路径
+// 目标不存在时返回ErrNotFound
+func (self *ScopeEvaluator) CanAccess(mid string, uid string, targetId string) (_ bool, err error) {
+	defer setOp("CanAccess", &err)
+	memberships, err := self.memberships(mid, uid)
+	if err != nil {
+		return false, err
+	}
+	if targetId == uid {
+		return true, nil
+	}
+
+	// 先确认目标存在，SCOPE_SELF对不存在的目标同样返回ErrNotFound
+	var chains [][]OrgNode
+	parents, err := self.tree.GetParents(mid, targetId)
+	switch {
+	case err == nil:
+		chains = append(chains, parents)
+	case errors.Is(err, ErrNotFound):
+		targets, err := self.tree.GetMemberships(mid, targetId)
+		if err != nil {
+			return false, err
+		}
+		if len(targets) == 0 {
+			return false, errNotFound("", "Can't find the org node or leaf with this id: %s", targetId)
+		}
+		for _, t := range targets {
+			chains = append(chains, t.Parents)
+		}
+	default:
+		return false, err
+	}
+
+	if self.rule == SCOPE_SELF {
+		return false, nil
+	}
+	roots := self.roots(mid, memberships)
+	for _, chain := range chains {
+		if self.inScope(chain, roots) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,83 @@
+package deptree
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// seedScope 写入数据权限测试用的组织结构
+// m -> s1(分公司) -> d1 -> d2, m -> s2(分公司) -> d3, m -> d4
+// u属于d1及d3，v在s1，x在d1，y在d2，z在d3，w在d4
+func seedScope(t *testing.T, tree DepTree) {
+	seedTree(t, tree, "m")
+	for _, n := range []OrgNode{
+		{Id: "s1", Pid: "m", Type: TYPE_SUBCOM},
+		{Id: "d1", Pid: "s1", Type: TYPE_DEP},
+		{Id: "d2", Pid: "d1", Type: TYPE_DEP},
+		{Id: "s2", Pid: "m", Type: TYPE_SUBCOM},
+		{Id: "d3", Pid: "s2", Type: TYPE_DEP},
+		{Id: "d4", Pid: "m", Type: TYPE_DEP},
+	} {
+		n.Mid, n.Name = "m", n.Id
+		if _, err := tree.AddOrgNode(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, l := range [][2]string{{"d1", "u"}, {"d3", "u"}, {"s1", "v"}, {"d1", "x"}, {"d2", "y"}, {"d3", "z"}, {"d4", "w"}} {
+		if err := tree.AddLeafNode(LeafNode{Mid: "m", Pid: l[0], Uid: l[1]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestScopeEvaluator(t *testing.T) {
+	eachBackend(t, func(t *testing.T, tree DepTree) {
+		seedScope(t, tree)
+		cases := []struct {
+			rule int
+			uid  string
+			orgs string
+			uids string
+		}{
+			{SCOPE_SELF, "u", "", "u"},
+			{SCOPE_DEP, "u", "d1,d3", "u,x,z"},
+			{SCOPE_DEP_TREE, "u", "d1,d2,d3", "u,x,y,z"},
+			{SCOPE_BRANCH, "u", "d1,d2,d3,s1,s2", "u,v,x,y,z"},
+			{SCOPE_MERCHANT, "u", "d1,d2,d3,d4,m,s1,s2", "u,v,w,x,y,z"},
+			{SCOPE_DEP, "y", "d2", "y"},
+			// 没有分公司时为整个商户
+			{SCOPE_BRANCH, "w", "d1,d2,d3,d4,m,s1,s2", "u,v,w,x,y,z"},
+		}
+		targets := []string{"m", "s1", "d1", "d2", "s2", "d3", "d4", "u", "v", "w", "x", "y", "z"}
+		for _, c := range cases {
+			eval := NewScopeEvaluator(tree, c.rule)
+			scope, err := eval.Evaluate("m", c.uid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if orgs, uids := strings.Join(scope.OrgIds, ","), strings.Join(scope.Uids, ","); orgs != c.orgs || uids != c.uids {
+				t.Errorf("rule %d uid %s: orgs %s uids %s, want %s and %s", c.rule, c.uid, orgs, uids, c.orgs, c.uids)
+			}
+			// CanAccess与Evaluate的结果一致
+			for _, target := range targets {
+				ok, err := eval.CanAccess("m", c.uid, target)
+				if err != nil || ok != scope.Contains(target) {
+					t.Errorf("rule %d uid %s: CanAccess(%s) = %v, %v, want %v", c.rule, c.uid, target, ok, err, scope.Contains(target))
+				}
+			}
+			if _, err = eval.CanAccess("m", c.uid, "none"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("rule %d: CanAccess missing target err = %v, want ErrNotFound", c.rule, err)
+			}
+			if _, err = eval.Evaluate("m", "none"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("rule %d: Evaluate missing uid err = %v, want ErrNotFound", c.rule, err)
+			}
+			if _, err = eval.CanAccess("m", "none", "u"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("rule %d: CanAccess missing uid err = %v, want ErrNotFound", c.rule, err)
+			}
+		}
+	})
+	if NewScopeEvaluator(newMemDepTree(map[string]interface{}{}), SCOPE_MERCHANT+1) != nil {
+		t.Error("evaluator created with an invalid rule")
+	}
+}
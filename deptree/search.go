@@ -0,0 +1,191 @@
+package deptree
+
+import (
+	"sort"
+	"strings"
+)
+
+// 排序字段 -> 比较函数，字段相同时按ID(叶子按Pid Uid)排序
+var (
+	orgSortFields = map[string]func(a *OrgNode, b *OrgNode) bool{
+		"Name": func(a *OrgNode, b *OrgNode) bool {
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			return a.Id < b.Id
+		},
+		"Id": func(a *OrgNode, b *OrgNode) bool {
+			return a.Id < b.Id
+		},
+		"Type": func(a *OrgNode, b *OrgNode) bool {
+			if a.Type != b.Type {
+				return a.Type < b.Type
+			}
+			return a.Id < b.Id
+		},
+	}
+	leafSortFields = map[string]func(a *LeafNode, b *LeafNode) bool{
+		"Uid": func(a *LeafNode, b *LeafNode) bool {
+			if a.Uid != b.Uid {
+				return a.Uid < b.Uid
+			}
+			return a.Pid < b.Pid
+		},
+		"Sid": func(a *LeafNode, b *LeafNode) bool {
+			if a.Sid != b.Sid {
+				return a.Sid < b.Sid
+			}
+			if a.Pid != b.Pid {
+				return a.Pid < b.Pid
+			}
+			return a.Uid < b.Uid
+		},
+		"Pid": func(a *LeafNode, b *LeafNode) bool {
+			if a.Pid != b.Pid {
+				return a.Pid < b.Pid
+			}
+			return a.Uid < b.Uid
+		},
+	}
+)
+
+// checkSearchQuery 检查搜索条件并补充默认值
+func checkSearchQuery(mid string, q *SearchQuery) error {
+	if mid == "" {
+		return newError(ErrInvalidArgument, "", "invalid mid")
+	}
+	if q.Root == "" {
+		q.Root = mid
+	}
+	if q.NameMatch < MATCH_EXACT || q.NameMatch > MATCH_SUBSTRING ||
+		q.UidMatch < MATCH_EXACT || q.UidMatch > MATCH_SUBSTRING {
+		return newError(ErrInvalidArgument, "", "invalid match mode")
+	}
+	if q.PositionMode != POSITION_ALL && q.PositionMode != POSITION_ANY {
+		return newError(ErrInvalidArgument, "", "invalid position mode: %d", q.PositionMode)
+	}
+	if q.Offset < 0 || q.Limit < 0 {
+		return newError(ErrInvalidArgument, "", "invalid offset or limit [%d,%d]", q.Offset, q.Limit)
+	}
+	var ok bool
+	switch q.Target {
+	case SEARCH_ORG:
+		if q.SortBy == "" {
+			q.SortBy = "Name"
+		}
+		_, ok = orgSortFields[q.SortBy]
+	case SEARCH_LEAF:
+		if q.SortBy == "" {
+			q.SortBy = "Uid"
+		}
+		_, ok = leafSortFields[q.SortBy]
+	default:
+		return newError(ErrInvalidArgument, "", "invalid search target: %d", q.Target)
+	}
+	if !ok {
+		return newError(ErrInvalidArgument, "", "invalid sort field: %s", q.SortBy)
+	}
+	return nil
+}
+
+// matchString 按匹配方式比较 不区分大小写
+func matchString(value string, pattern string, match int) bool {
+	value = strings.ToLower(value)
+	pattern = strings.ToLower(pattern)
+	switch match {
+	case MATCH_PREFIX:
+		return strings.HasPrefix(value, pattern)
+	case MATCH_SUBSTRING:
+		return strings.Contains(value, pattern)
+	}
+	return value == pattern
+}
+
+// hasOrgCond 是否包含组织节点条件
+func (self *SearchQuery) hasOrgCond() bool {
+	return self.Name != "" || len(self.Types) > 0
+}
+
+// matchOrg 判断组织节点是否满足Name Types条件 搜索组织节点时还需满足Attrs条件
+func (self *SearchQuery) matchOrg(node *OrgNode) bool {
+	if self.Name != "" && !matchString(node.Name, self.Name, self.NameMatch) {
+		return false
+	}
+	if self.Target == SEARCH_ORG && !matchAttrs(node.Attrs, self.Attrs) {
+		return false
+	}
+	if len(self.Types) == 0 {
+		return true
+	}
+	for _, t := range self.Types {
+		if node.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// matchLeaf 判断叶子是否满足Uid Sid Positions Attrs条件
+func (self *SearchQuery) matchLeaf(leaf *LeafNode) bool {
+	if self.Uid != "" && !matchString(leaf.Uid, self.Uid, self.UidMatch) {
+		return false
+	}
+	if !matchAttrs(leaf.Attrs, self.Attrs) {
+		return false
+	}
+	if self.Sid != "" && leaf.Sid != self.Sid {
+		return false
+	}
+	if len(self.Positions) == 0 {
+		return true
+	}
+	for _, p := range self.Positions {
+		has := hasPosition(leaf, p)
+		if has && self.PositionMode == POSITION_ANY {
+			return true
+		}
+		if !has && self.PositionMode == POSITION_ALL {
+			return false
+		}
+	}
+	return self.PositionMode == POSITION_ALL
+}
+
+// pageRange 分页范围
+func (self *SearchQuery) pageRange(total int) (int, int) {
+	start := self.Offset
+	if start > total {
+		start = total
+	}
+	end := total
+	if self.Limit > 0 && start+self.Limit < end {
+		end = start + self.Limit
+	}
+	return start, end
+}
+
+// orgResult 对组织节点排序分页
+func (self *SearchQuery) orgResult(nodes []OrgNode) *SearchResult {
+	less := orgSortFields[self.SortBy]
+	sort.Slice(nodes, func(i, j int) bool {
+		if self.Desc {
+			return less(&nodes[j], &nodes[i])
+		}
+		return less(&nodes[i], &nodes[j])
+	})
+	start, end := self.pageRange(len(nodes))
+	return &SearchResult{Orgs: append([]OrgNode{}, nodes[start:end]...), Total: len(nodes)}
+}
+
+// leafResult 对叶子排序分页
+func (self *SearchQuery) leafResult(leafs []LeafNode) *SearchResult {
+	less := leafSortFields[self.SortBy]
+	sort.Slice(leafs, func(i, j int) bool {
+		if self.Desc {
+			return less(&leafs[j], &leafs[i])
+		}
+		return less(&leafs[i], &leafs[j])
+	})
+	start, end := self.pageRange(len(leafs))
+	return &SearchResult{Leafs: append([]LeafNode{}, leafs[start:end]...), Total: len(leafs)}
+}
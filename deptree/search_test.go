@@ -0,0 +1,91 @@
+package deptree
+
+import (
+	"strings"
+	"testing"
+)
+
+// seedSearch 写入搜索测试用的数据 名称中包含LIKE的通配符及转义字符
+// m -> a(Sales_East) b(SalesXEast) c(50% Off) d(Dev!Ops) -> e(dev)
+func seedSearch(t *testing.T, tree DepTree) {
+	seedTree(t, tree, "m")
+	for _, n := range []OrgNode{
+		{Id: "a", Pid: "m", Name: "Sales_East", Type: TYPE_SUBCOM},
+		{Id: "b", Pid: "m", Name: "SalesXEast", Type: TYPE_SUBCOM},
+		{Id: "c", Pid: "m", Name: "50% Off", Type: TYPE_DEP},
+		{Id: "d", Pid: "m", Name: "Dev!Ops", Type: TYPE_DEP},
+		{Id: "e", Pid: "d", Name: "dev", Type: TYPE_DEP},
+	} {
+		n.Mid = "m"
+		if _, err := tree.AddOrgNode(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"p1", "p2"} {
+		if _, err := tree.AddPosition(Position{Mid: "m", Id: id, Name: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, l := range []LeafNode{
+		{Pid: "a", Uid: "u1", Sid: "s3", Positions: []string{"p1", "p2"}},
+		{Pid: "b", Uid: "u2", Sid: "s1", Positions: []string{"p1"}},
+		{Pid: "d", Uid: "u3", Sid: "s2", Positions: []string{"p2"}},
+		{Pid: "e", Uid: "U4", Sid: "s4"},
+	} {
+		l.Mid = "m"
+		if err := tree.AddLeafNode(l); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	cases := []struct {
+		name  string
+		query SearchQuery
+		want  string
+		total int
+	}{
+		{"prefix with _", SearchQuery{Name: "sales_", NameMatch: MATCH_PREFIX, SortBy: "Id"}, "a", 1},
+		{"prefix", SearchQuery{Name: "SALES", NameMatch: MATCH_PREFIX, SortBy: "Id"}, "a,b", 2},
+		{"contains _", SearchQuery{Name: "s_e", NameMatch: MATCH_SUBSTRING}, "a", 1},
+		{"contains %", SearchQuery{Name: "%", NameMatch: MATCH_SUBSTRING}, "c", 1},
+		{"prefix with %", SearchQuery{Name: "50%", NameMatch: MATCH_PREFIX}, "c", 1},
+		{"contains escape char", SearchQuery{Name: "!o", NameMatch: MATCH_SUBSTRING}, "d", 1},
+		{"exact ignores case", SearchQuery{Name: "DEV"}, "e", 1},
+		{"types", SearchQuery{Types: []int{TYPE_SUBCOM}, SortBy: "Id"}, "a,b", 2},
+		{"root", SearchQuery{Root: "d", SortBy: "Id"}, "d,e", 2},
+		{"sort desc", SearchQuery{SortBy: "Id", Desc: true}, "m,e,d,c,b,a", 6},
+		{"offset limit", SearchQuery{SortBy: "Id", Offset: 1, Limit: 2}, "b,c", 6},
+		{"offset past end", SearchQuery{SortBy: "Id", Offset: 6, Limit: 2}, "", 6},
+		{"position all", SearchQuery{Target: SEARCH_LEAF, Positions: []string{"p1", "p2"}}, "u1", 1},
+		{"position any", SearchQuery{Target: SEARCH_LEAF, Positions: []string{"p1", "p2"}, PositionMode: POSITION_ANY, SortBy: "Sid"}, "u2,u3,u1", 3},
+		{"uid exact ignores case", SearchQuery{Target: SEARCH_LEAF, Uid: "u4"}, "U4", 1},
+		{"uid prefix", SearchQuery{Target: SEARCH_LEAF, Uid: "U", UidMatch: MATCH_PREFIX, SortBy: "Sid"}, "u2,u3,u1,U4", 4},
+		{"sid", SearchQuery{Target: SEARCH_LEAF, Sid: "s2"}, "u3", 1},
+		{"leaf root", SearchQuery{Target: SEARCH_LEAF, Root: "d", SortBy: "Sid"}, "u3,U4", 2},
+		{"leaf sort desc", SearchQuery{Target: SEARCH_LEAF, SortBy: "Sid", Desc: true}, "U4,u1,u3,u2", 4},
+		{"leaf sort pid", SearchQuery{Target: SEARCH_LEAF, SortBy: "Pid"}, "u1,u2,u3,U4", 4},
+		{"leaf offset limit", SearchQuery{Target: SEARCH_LEAF, SortBy: "Sid", Offset: 1, Limit: 2}, "u3,u1", 4},
+	}
+	eachBackend(t, func(t *testing.T, tree DepTree) {
+		seedSearch(t, tree)
+		for _, c := range cases {
+			res, err := tree.Search("m", c.query)
+			if err != nil {
+				t.Errorf("%s: %v", c.name, err)
+				continue
+			}
+			ids := []string{}
+			for _, n := range res.Orgs {
+				ids = append(ids, n.Id)
+			}
+			for _, l := range res.Leafs {
+				ids = append(ids, l.Uid)
+			}
+			if got := strings.Join(ids, ","); got != c.want || res.Total != c.total {
+				t.Errorf("%s: got %s total %d, want %s total %d", c.name, got, res.Total, c.want, c.total)
+			}
+		}
+	})
+}
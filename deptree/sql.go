@@ -0,0 +1,2222 @@
+package deptree
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlDepTree DepTree的database/sql实现，通过NewTree获得
+// 层级关系使用闭包表(deptree_path)保存，祖先/子孙查询无需递归
+// 驱动需由调用方自行import注册，如 _ "github.com/mattn/go-sqlite3"
+type sqlDepTree struct {
+	db        *sql.DB
+	dollar    bool            // 占位符是否使用$n(postgres)
+	idType    string          // 占位符CAST为ID列时的类型 mysql只接受CHAR，其他使用VARCHAR
+	retention time.Duration   // 归档保留期
+	ctx       context.Context // BindContext绑定的context 可为nil
+	tx        sqlQueryer      // 非nil时查询在该事务中执行 见inTx
+}
+
+// sqlQueryer *sql.DB与*sql.Tx的公共方法
+type sqlQueryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// sqlContextQueryer *sql.DB与*sql.Tx的context方法
+type sqlContextQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// sqlCtxQueryer 以固定的ctx执行查询的sqlQueryer
+type sqlCtxQueryer struct {
+	q   sqlContextQueryer
+	ctx context.Context
+}
+
+func (self sqlCtxQueryer) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return self.q.ExecContext(self.ctx, query, args...)
+}
+
+func (self sqlCtxQueryer) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return self.q.QueryContext(self.ctx, query, args...)
+}
+
+func (self sqlCtxQueryer) QueryRow(query string, args ...interface{}) *sql.Row {
+	return self.q.QueryRowContext(self.ctx, query, args...)
+}
+
+// sqlMigrations 数据库结构迁移脚本，按版本顺序执行，已发布的版本只可追加不可修改
+var sqlMigrations = [][]string{
+	// version 1 组织节点、闭包表、叶子节点及岗位
+	{
+		`CREATE TABLE deptree_org (
+			mid        VARCHAR(64)  NOT NULL,
+			id         VARCHAR(64)  NOT NULL,
+			pid        VARCHAR(64)  NOT NULL,
+			name       VARCHAR(255) NOT NULL,
+			type       INTEGER      NOT NULL,
+			is_default INTEGER      NOT NULL,
+			PRIMARY KEY (mid, id)
+		)`,
+		`CREATE INDEX deptree_org_pid ON deptree_org (mid, pid)`,
+		`CREATE TABLE deptree_path (
+			mid        VARCHAR(64) NOT NULL,
+			ancestor   VARCHAR(64) NOT NULL,
+			descendant VARCHAR(64) NOT NULL,
+			depth      INTEGER     NOT NULL,
+			PRIMARY KEY (mid, ancestor, descendant)
+		)`,
+		`CREATE INDEX deptree_path_descendant ON deptree_path (mid, descendant)`,
+		`CREATE TABLE deptree_leaf (
+			mid VARCHAR(64) NOT NULL,
+			pid VARCHAR(64) NOT NULL,
+			uid VARCHAR(64) NOT NULL,
+			sid VARCHAR(64) NOT NULL,
+			PRIMARY KEY (mid, pid, uid)
+		)`,
+		`CREATE INDEX deptree_leaf_uid ON deptree_leaf (mid, uid)`,
+		`CREATE TABLE deptree_leaf_position (
+			mid      VARCHAR(64) NOT NULL,
+			pid      VARCHAR(64) NOT NULL,
+			uid      VARCHAR(64) NOT NULL,
+			position VARCHAR(64) NOT NULL,
+			seq      INTEGER     NOT NULL,
+			PRIMARY KEY (mid, pid, uid, position)
+		)`,
+		`CREATE INDEX deptree_leaf_position_position ON deptree_leaf_position (mid, position)`,
+	},
+	// version 2 岗位目录
+	{
+		`CREATE TABLE deptree_position (
+			mid         VARCHAR(64)   NOT NULL,
+			id          VARCHAR(64)   NOT NULL,
+			name        VARCHAR(255)  NOT NULL,
+			level       INTEGER       NOT NULL,
+			description VARCHAR(1024) NOT NULL,
+			PRIMARY KEY (mid, id)
+		)`,
+	},
+	// version 3 同级排序序号
+	{
+		`ALTER TABLE deptree_org ADD COLUMN ord INTEGER NOT NULL DEFAULT 0`,
+		`ALTER TABLE deptree_leaf ADD COLUMN ord INTEGER NOT NULL DEFAULT 0`,
+	},
+	// version 4 扩展属性
+	{
+		`CREATE TABLE deptree_org_attr (
+			mid   VARCHAR(64)  NOT NULL,
+			id    VARCHAR(64)  NOT NULL,
+			name  VARCHAR(64)  NOT NULL,
+			value VARCHAR(255) NOT NULL,
+			seq   INTEGER      NOT NULL,
+			PRIMARY KEY (mid, id, name, seq)
+		)`,
+		`CREATE INDEX deptree_org_attr_value ON deptree_org_attr (mid, name, value)`,
+		`CREATE TABLE deptree_leaf_attr (
+			mid   VARCHAR(64)  NOT NULL,
+			pid   VARCHAR(64)  NOT NULL,
+			uid   VARCHAR(64)  NOT NULL,
+			name  VARCHAR(64)  NOT NULL,
+			value VARCHAR(255) NOT NULL,
+			seq   INTEGER      NOT NULL,
+			PRIMARY KEY (mid, pid, uid, name, seq)
+		)`,
+		`CREATE INDEX deptree_leaf_attr_value ON deptree_leaf_attr (mid, name, value)`,
+	},
+	// version 5 归档 *_archive表与原表结构相同，root为所属归档子树的根节点ID
+	{
+		`CREATE TABLE deptree_archive (
+			mid         VARCHAR(64) NOT NULL,
+			id          VARCHAR(64) NOT NULL,
+			archived_at BIGINT      NOT NULL,
+			PRIMARY KEY (mid, id)
+		)`,
+		`CREATE TABLE deptree_org_archive (
+			root       VARCHAR(64)  NOT NULL,
+			mid        VARCHAR(64)  NOT NULL,
+			id         VARCHAR(64)  NOT NULL,
+			pid        VARCHAR(64)  NOT NULL,
+			name       VARCHAR(255) NOT NULL,
+			type       INTEGER      NOT NULL,
+			is_default INTEGER      NOT NULL,
+			ord        INTEGER      NOT NULL,
+			PRIMARY KEY (mid, id)
+		)`,
+		`CREATE INDEX deptree_org_archive_root ON deptree_org_archive (mid, root)`,
+		`CREATE TABLE deptree_org_attr_archive (
+			root  VARCHAR(64)  NOT NULL,
+			mid   VARCHAR(64)  NOT NULL,
+			id    VARCHAR(64)  NOT NULL,
+			name  VARCHAR(64)  NOT NULL,
+			value VARCHAR(255) NOT NULL,
+			seq   INTEGER      NOT NULL,
+			PRIMARY KEY (mid, id, name, seq)
+		)`,
+		`CREATE INDEX deptree_org_attr_archive_root ON deptree_org_attr_archive (mid, root)`,
+		`CREATE TABLE deptree_path_archive (
+			root       VARCHAR(64) NOT NULL,
+			mid        VARCHAR(64) NOT NULL,
+			ancestor   VARCHAR(64) NOT NULL,
+			descendant VARCHAR(64) NOT NULL,
+			depth      INTEGER     NOT NULL,
+			PRIMARY KEY (mid, ancestor, descendant)
+		)`,
+		`CREATE INDEX deptree_path_archive_root ON deptree_path_archive (mid, root)`,
+		`CREATE TABLE deptree_leaf_archive (
+			root VARCHAR(64) NOT NULL,
+			mid  VARCHAR(64) NOT NULL,
+			pid  VARCHAR(64) NOT NULL,
+			uid  VARCHAR(64) NOT NULL,
+			sid  VARCHAR(64) NOT NULL,
+			ord  INTEGER     NOT NULL,
+			PRIMARY KEY (mid, pid, uid)
+		)`,
+		`CREATE INDEX deptree_leaf_archive_root ON deptree_leaf_archive (mid, root)`,
+		`CREATE TABLE deptree_leaf_position_archive (
+			root     VARCHAR(64) NOT NULL,
+			mid      VARCHAR(64) NOT NULL,
+			pid      VARCHAR(64) NOT NULL,
+			uid      VARCHAR(64) NOT NULL,
+			position VARCHAR(64) NOT NULL,
+			seq      INTEGER     NOT NULL,
+			PRIMARY KEY (mid, pid, uid, position)
+		)`,
+		`CREATE INDEX deptree_leaf_position_archive_root ON deptree_leaf_position_archive (mid, root)`,
+		`CREATE TABLE deptree_leaf_attr_archive (
+			root  VARCHAR(64)  NOT NULL,
+			mid   VARCHAR(64)  NOT NULL,
+			pid   VARCHAR(64)  NOT NULL,
+			uid   VARCHAR(64)  NOT NULL,
+			name  VARCHAR(64)  NOT NULL,
+			value VARCHAR(255) NOT NULL,
+			seq   INTEGER      NOT NULL,
+			PRIMARY KEY (mid, pid, uid, name, seq)
+		)`,
+		`CREATE INDEX deptree_leaf_attr_archive_root ON deptree_leaf_attr_archive (mid, root)`,
+	},
+	// version 6 同级名称唯一索引，checkName之后的并发新增、改名或移动由数据库拒绝 已有重名节点时迁移失败，需先处理
+	{
+		`CREATE UNIQUE INDEX deptree_org_name ON deptree_org (mid, pid, name)`,
+	},
+}
+
+// sqlArchiveTables 归档时整体移动的表 key为按子树节点ID筛选的列
+// deptree_path只归档子树内部的路径，恢复时重新连接到原父节点的祖先
+var sqlArchiveTables = []struct {
+	table string
+	key   string
+	cols  string
+}{
+	{"deptree_org", "id", "mid, id, pid, name, type, is_default, ord"},
+	{"deptree_org_attr", "id", "mid, id, name, value, seq"},
+	{"deptree_path", "ancestor", "mid, ancestor, descendant, depth"},
+	{"deptree_leaf", "pid", "mid, pid, uid, sid, ord"},
+	{"deptree_leaf_position", "pid", "mid, pid, uid, position, seq"},
+	{"deptree_leaf_attr", "pid", "mid, pid, uid, name, value, seq"},
+}
+
+// newSqlDepTree 根据配置生成sqlDepTree 需包含Driver DSN，打开后自动执行迁移
+// 单元测试只覆盖sqlite3；mysql postgres(postgres pgx)按方言处理占位符、CAST类型及唯一索引错误，未经测试
+func newSqlDepTree(config map[string]interface{}) (*sqlDepTree, error) {
+	driver, _ := config["Driver"].(string)
+	dsn, _ := config["DSN"].(string)
+	if driver == "" || dsn == "" {
+		return nil, newError(ErrInvalidArgument, "", "sql: Driver DSN are required")
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, wrapError(ErrInvalidArgument, "", err)
+	}
+	tree := &sqlDepTree{
+		db:        db,
+		dollar:    driver == "postgres" || driver == "pgx",
+		idType:    "VARCHAR(64)",
+		retention: archiveRetention(config),
+	}
+	if driver == "mysql" {
+		tree.idType = "CHAR(64)"
+	}
+	if err = tree.migrate(); err != nil {
+		db.Close()
+		return nil, &Error{Kind: ErrBackendUnavailable, Msg: "sql: migrate failed", Err: err}
+	}
+	return tree, nil
+}
+
+// migrate 执行尚未执行的迁移脚本
+func (self *sqlDepTree) migrate() error {
+	_, err := self.db.Exec(`CREATE TABLE IF NOT EXISTS deptree_migration (
+		version INTEGER NOT NULL PRIMARY KEY
+	)`)
+	if err != nil {
+		return err
+	}
+	var version int
+	err = self.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM deptree_migration`).Scan(&version)
+	if err != nil {
+		return err
+	}
+	for v := version + 1; v <= len(sqlMigrations); v++ {
+		err = self.withTx(func(tx sqlQueryer) error {
+			for _, stmt := range sqlMigrations[v-1] {
+				if _, err := tx.Exec(stmt); err != nil {
+					return fmt.Errorf("migration %d failed: %v", v, err)
+				}
+			}
+			_, err := tx.Exec(self.rebind(`INSERT INTO deptree_migration (version) VALUES (?)`), v)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebind 按驱动转换占位符
+func (self *sqlDepTree) rebind(query string) string {
+	if !self.dollar {
+		return query
+	}
+	buf := strings.Builder{}
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			buf.WriteString("$" + strconv.Itoa(n))
+		} else {
+			buf.WriteRune(c)
+		}
+	}
+	return buf.String()
+}
+
+// withContext 返回绑定ctx的副本 共享连接池
+func (self *sqlDepTree) withContext(ctx context.Context) DepTree {
+	tree := *self
+	tree.ctx = ctx
+	return &tree
+}
+
+// context 绑定的context 未绑定时为context.Background()
+func (self *sqlDepTree) context() context.Context {
+	if self.ctx == nil {
+		return context.Background()
+	}
+	return self.ctx
+}
+
+// conn 事务外的查询 受绑定的context控制
+func (self *sqlDepTree) conn() sqlQueryer {
+	if self.tx != nil {
+		return self.tx
+	}
+	return sqlCtxQueryer{self.db, self.context()}
+}
+
+// inTx 返回查询方法在事务tx中执行的副本 用于在事务中复用subTree等查询
+func (self *sqlDepTree) inTx(tx sqlQueryer) *sqlDepTree {
+	tree := *self
+	tree.tx = tx
+	return &tree
+}
+
+// withTx 在事务中执行f，f返回错误时回滚 事务受绑定的context控制，取消时由驱动回滚
+func (self *sqlDepTree) withTx(f func(tx sqlQueryer) error) error {
+	ctx := self.context()
+	tx, err := self.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = f(sqlCtxQueryer{tx, ctx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqlError 将数据库错误转换为*Error 连接类错误归为ErrBackendUnavailable
+// 用法：defer sqlError("AddOrgNode", &err)
+func sqlError(op string, err *error) {
+	if *err == nil || fillOp(*err, op) {
+		return
+	}
+	kind := ErrBackend
+	var ne net.Error
+	if isContextError(*err) {
+		kind = ErrCanceled
+	} else if errors.Is(*err, driver.ErrBadConn) || errors.Is(*err, sql.ErrConnDone) || errors.As(*err, &ne) {
+		kind = ErrBackendUnavailable
+	} else if isDuplicateName(*err) {
+		kind = ErrDuplicateName
+	}
+	*err = wrapError(kind, op, *err)
+}
+
+// isDuplicateName 是否违反了同级名称唯一索引deptree_org_name
+// 各驱动的错误类型不同，按错误信息判断：mysql postgres包含索引名，sqlite包含列名
+func isDuplicateName(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "deptree_org_name") ||
+		strings.Contains(msg, "deptree_org.mid, deptree_org.pid, deptree_org.name")
+}
+
+// placeholders 生成n个占位符 ?,?,?
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// exists 判断查询结果是否存在
+func (self *sqlDepTree) exists(q sqlQueryer, query string, args ...interface{}) (bool, error) {
+	var n int
+	err := q.QueryRow(self.rebind("SELECT COUNT(*) FROM "+query), args...).Scan(&n)
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// checkTopTree 判断mid对应的顶级树是否存在
+func (self *sqlDepTree) checkTopTree(q sqlQueryer, mid string) error {
+	ok, err := self.exists(q, "deptree_org WHERE mid = ? AND id = ?", mid, mid)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNotFound("", "Can't find the top tree with this mid: %s", mid)
+	}
+	return nil
+}
+
+// checkNode 判断组织节点是否存在
+func (self *sqlDepTree) checkNode(q sqlQueryer, mid string, id string) error {
+	if err := self.checkTopTree(q, mid); err != nil {
+		return err
+	}
+	ok, err := self.exists(q, "deptree_org WHERE mid = ? AND id = ?", mid, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNotFound("", "Can't find the node with this id: %s", id)
+	}
+	return nil
+}
+
+// checkName 判断同级节点中是否存在同名节点 顶级节点在全部商户间唯一
+func (self *sqlDepTree) checkName(q sqlQueryer, mid string, pid string, name string) error {
+	var ok bool
+	var err error
+	if pid == "" {
+		ok, err = self.exists(q, "deptree_org WHERE pid = '' AND name = ?", name)
+	} else {
+		ok, err = self.exists(q, "deptree_org WHERE mid = ? AND pid = ? AND name = ?", mid, pid, name)
+	}
+	if err != nil {
+		return err
+	}
+	if ok {
+		return newError(ErrDuplicateName, "", "node already exists with this name: %s", name)
+	}
+	return nil
+}
+
+// subTreeIds 取子树(包含自身)的全部节点ID
+func (self *sqlDepTree) subTreeIds(q sqlQueryer, mid string, id string) ([]interface{}, error) {
+	rows, err := q.Query(self.rebind(`SELECT descendant FROM deptree_path
+		WHERE mid = ? AND ancestor = ?`), mid, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := []interface{}{}
+	for rows.Next() {
+		var d string
+		if err = rows.Scan(&d); err != nil {
+			return nil, err
+		}
+		ids = append(ids, d)
+	}
+	return ids, rows.Err()
+}
+
+// queryOrgs 查询组织节点及扩展属性 query需返回mid,id,pid,name,type,is_default,ord
+func (self *sqlDepTree) queryOrgs(q sqlQueryer, query string, args ...interface{}) ([]OrgNode, error) {
+	ret, err := self.scanOrgs(q, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err = self.loadOrgAttrs(q, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// scanOrgs 查询组织节点(不含扩展属性)
+func (self *sqlDepTree) scanOrgs(q sqlQueryer, query string, args ...interface{}) ([]OrgNode, error) {
+	rows, err := q.Query(self.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := []OrgNode{}
+	for rows.Next() {
+		node := OrgNode{}
+		var isDefault int
+		err = rows.Scan(&node.Mid, &node.Id, &node.Pid, &node.Name, &node.Type, &isDefault, &node.Order)
+		if err != nil {
+			return nil, err
+		}
+		node.IsDefault = isDefault != 0
+		ret = append(ret, node)
+	}
+	return ret, rows.Err()
+}
+
+// queryLeafs 查询叶子节点、岗位及扩展属性 where为deptree_leaf(别名l)上的条件
+func (self *sqlDepTree) queryLeafs(q sqlQueryer, where string, args ...interface{}) ([]LeafNode, error) {
+	ret, err := self.scanLeafs(q, where, args...)
+	if err != nil {
+		return nil, err
+	}
+	if err = self.loadLeafAttrs(q, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// scanLeafs 查询叶子节点及岗位(不含扩展属性)
+func (self *sqlDepTree) scanLeafs(q sqlQueryer, where string, args ...interface{}) ([]LeafNode, error) {
+	rows, err := q.Query(self.rebind(`SELECT l.mid, l.pid, l.uid, l.sid, l.ord, p.position
+		FROM deptree_leaf l LEFT JOIN deptree_leaf_position p
+		ON p.mid = l.mid AND p.pid = l.pid AND p.uid = l.uid
+		WHERE `+where+`
+		ORDER BY l.pid, l.uid, p.seq`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := []LeafNode{}
+	for rows.Next() {
+		leaf := LeafNode{}
+		var position sql.NullString
+		err = rows.Scan(&leaf.Mid, &leaf.Pid, &leaf.Uid, &leaf.Sid, &leaf.Order, &position)
+		if err != nil {
+			return nil, err
+		}
+		n := len(ret)
+		if n == 0 || ret[n-1].Pid != leaf.Pid || ret[n-1].Uid != leaf.Uid {
+			leaf.Positions = []string{}
+			ret = append(ret, leaf)
+			n++
+		}
+		if position.Valid {
+			ret[n-1].Positions = append(ret[n-1].Positions, position.String)
+		}
+	}
+	return ret, rows.Err()
+}
+
+// insertPositions 写入叶子岗位
+func (self *sqlDepTree) insertPositions(q sqlQueryer, leaf LeafNode) error {
+	for i, p := range leaf.Positions {
+		_, err := q.Exec(self.rebind(`INSERT INTO deptree_leaf_position
+			(mid, pid, uid, position, seq) VALUES (?, ?, ?, ?, ?)`),
+			leaf.Mid, leaf.Pid, leaf.Uid, p, i)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// insertOrgAttrs 写入组织节点扩展属性
+func (self *sqlDepTree) insertOrgAttrs(q sqlQueryer, mid string, id string, attrs map[string][]string) error {
+	for _, name := range attrNames(attrs) {
+		for i, v := range attrs[name] {
+			_, err := q.Exec(self.rebind(`INSERT INTO deptree_org_attr
+				(mid, id, name, value, seq) VALUES (?, ?, ?, ?, ?)`), mid, id, name, v, i)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// insertLeafAttrs 写入叶子扩展属性
+func (self *sqlDepTree) insertLeafAttrs(q sqlQueryer, leaf LeafNode) error {
+	for _, name := range attrNames(leaf.Attrs) {
+		for i, v := range leaf.Attrs[name] {
+			_, err := q.Exec(self.rebind(`INSERT INTO deptree_leaf_attr
+				(mid, pid, uid, name, value, seq) VALUES (?, ?, ?, ?, ?, ?)`), leaf.Mid, leaf.Pid, leaf.Uid, name, v, i)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadOrgAttrs 分批读取组织节点的扩展属性
+func (self *sqlDepTree) loadOrgAttrs(q sqlQueryer, nodes []OrgNode) error {
+	for i := 0; i < len(nodes); i += 100 {
+		batch := nodes[i:]
+		if len(batch) > 100 {
+			batch = batch[:100]
+		}
+		conds := make([]string, len(batch))
+		args := []interface{}{}
+		for j, n := range batch {
+			conds[j] = "(mid = ? AND id = ?)"
+			args = append(args, n.Mid, n.Id)
+		}
+		attrs, err := self.queryAttrs(q, `SELECT mid, id, name, value FROM deptree_org_attr
+			WHERE `+strings.Join(conds, " OR ")+` ORDER BY seq`, args...)
+		if err != nil {
+			return err
+		}
+		for j := range batch {
+			batch[j].Attrs = attrs[batch[j].Mid+"\x00"+batch[j].Id]
+		}
+	}
+	return nil
+}
+
+// loadLeafAttrs 分批读取叶子的扩展属性
+func (self *sqlDepTree) loadLeafAttrs(q sqlQueryer, leafs []LeafNode) error {
+	for i := 0; i < len(leafs); i += 100 {
+		batch := leafs[i:]
+		if len(batch) > 100 {
+			batch = batch[:100]
+		}
+		conds := make([]string, len(batch))
+		args := []interface{}{}
+		for j, l := range batch {
+			conds[j] = "(mid = ? AND pid = ? AND uid = ?)"
+			args = append(args, l.Mid, l.Pid, l.Uid)
+		}
+		attrs, err := self.queryAttrs(q, `SELECT pid, uid, name, value FROM deptree_leaf_attr
+			WHERE `+strings.Join(conds, " OR ")+` ORDER BY seq`, args...)
+		if err != nil {
+			return err
+		}
+		for j := range batch {
+			batch[j].Attrs = attrs[batch[j].Pid+"\x00"+batch[j].Uid]
+		}
+	}
+	return nil
+}
+
+// queryAttrs 查询扩展属性 query需返回两列键及name,value，结果以两列键用\x00连接为索引
+func (self *sqlDepTree) queryAttrs(q sqlQueryer, query string, args ...interface{}) (map[string]map[string][]string, error) {
+	rows, err := q.Query(self.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := map[string]map[string][]string{}
+	for rows.Next() {
+		var k1, k2, name, value string
+		if err = rows.Scan(&k1, &k2, &name, &value); err != nil {
+			return nil, err
+		}
+		key := k1 + "\x00" + k2
+		if ret[key] == nil {
+			ret[key] = map[string][]string{}
+		}
+		ret[key][name] = append(ret[key][name], value)
+	}
+	return ret, rows.Err()
+}
+
+// sqlAttrsWhere 扩展属性条件 table为deptree_org_attr或deptree_leaf_attr，keys为关联条件
+func sqlAttrsWhere(table string, keys string, attrs map[string]string) (string, []interface{}) {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	where := ""
+	args := []interface{}{}
+	for _, name := range names {
+		where += " AND EXISTS (SELECT 1 FROM " + table + " a WHERE " + keys + " AND a.name = ? AND a.value = ?)"
+		args = append(args, name, attrs[name])
+	}
+	return where, args
+}
+
+// nextOrder 父节点pid下新节点的排序序号 table为deptree_org或deptree_leaf
+func (self *sqlDepTree) nextOrder(q sqlQueryer, table string, mid string, pid string) (int, error) {
+	var order int
+	err := q.QueryRow(self.rebind(`SELECT COALESCE(MAX(ord), 0) + 1 FROM `+table+`
+		WHERE mid = ? AND pid = ?`), mid, pid).Scan(&order)
+	return order, err
+}
+
+// renumber 按新顺序重新编号同级节点 key为组织节点的id或叶子的uid
+func (self *sqlDepTree) renumber(q sqlQueryer, table string, key string, mid string, pid string, order []string) error {
+	for i, k := range order {
+		_, err := q.Exec(self.rebind(`UPDATE `+table+` SET ord = ?
+			WHERE mid = ? AND pid = ? AND `+key+` = ?`), i+1, mid, pid, k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddOrgNode 新建组织节点
+func (self *sqlDepTree) AddOrgNode(node OrgNode) (_ string, err error) {
+	defer sqlError("AddOrgNode", &err)
+	if node.Attrs, err = checkAttrs(node.Attrs); err != nil {
+		return "", err
+	}
+	id := node.Id
+	mid := node.Mid
+	pid := node.Pid
+	err = self.withTx(func(tx sqlQueryer) error {
+		if pid == "" {
+			//插入顶级节点(ID使用传入的mid)
+			id = mid
+			ok, err := self.exists(tx, "deptree_org WHERE mid = ? AND id = ?", mid, mid)
+			if err != nil {
+				return err
+			}
+			if ok {
+				return newError(ErrAlreadyExists, "", "top tree already exists with this mid: %s", mid)
+			}
+		} else {
+			if err := self.checkNode(tx, mid, pid); err != nil {
+				return err
+			}
+			if id == "" {
+				id = GetId()
+			} else {
+				for _, table := range []string{"deptree_org", "deptree_org_archive"} {
+					ok, err := self.exists(tx, table+" WHERE mid = ? AND id = ?", mid, id)
+					if err != nil {
+						return err
+					}
+					if ok {
+						return newError(ErrAlreadyExists, "", "node already exists with this id: %s", id)
+					}
+				}
+			}
+		}
+		if err := self.checkName(tx, mid, pid, node.Name); err != nil {
+			return err
+		}
+		isDefault := 0
+		if node.IsDefault {
+			isDefault = 1
+		}
+		order := node.Order
+		if order == 0 && pid != "" {
+			var err error
+			if order, err = self.nextOrder(tx, "deptree_org", mid, pid); err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(self.rebind(`INSERT INTO deptree_org
+			(mid, id, pid, name, type, is_default, ord) VALUES (?, ?, ?, ?, ?, ?, ?)`),
+			mid, id, pid, node.Name, node.Type, isDefault, order)
+		if err != nil {
+			return err
+		}
+		if err = self.insertOrgAttrs(tx, mid, id, node.Attrs); err != nil {
+			return err
+		}
+		// 闭包表：自身 + 父节点的全部祖先
+		_, err = tx.Exec(self.rebind(`INSERT INTO deptree_path
+			(mid, ancestor, descendant, depth) VALUES (?, ?, ?, 0)`), mid, id, id)
+		if err != nil || pid == "" {
+			return err
+		}
+		_, err = tx.Exec(self.rebind(`INSERT INTO deptree_path (mid, ancestor, descendant, depth)
+			SELECT mid, ancestor, CAST(? AS `+self.idType+`), depth + 1 FROM deptree_path
+			WHERE mid = ? AND descendant = ?`), id, mid, pid)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// ModifyOrgNode 修改组织信息
+func (self *sqlDepTree) ModifyOrgNode(node OrgNode) (err error) {
+	defer sqlError("ModifyOrgNode", &err)
+	id := node.Id
+	mid := node.Mid
+	if id == "" || mid == "" {
+		return newError(ErrInvalidArgument, "", "invalid id or mid [%s,%s]", id, mid)
+	}
+	if node.Attrs, err = checkAttrs(node.Attrs); err != nil {
+		return err
+	}
+	return self.withTx(func(tx sqlQueryer) error {
+		nodes, err := self.scanOrgs(tx, `SELECT mid, id, pid, name, type, is_default, ord
+			FROM deptree_org WHERE mid = ? AND id = ?`, mid, id)
+		if err != nil {
+			return err
+		}
+		if len(nodes) == 0 {
+			return errNotFound("", "Can't find the node with this id: %s", id)
+		}
+		if node.Name != "" && node.Name != nodes[0].Name {
+			if err = self.checkName(tx, mid, nodes[0].Pid, node.Name); err != nil {
+				return err
+			}
+			_, err = tx.Exec(self.rebind(`UPDATE deptree_org SET name = ? WHERE mid = ? AND id = ?`),
+				node.Name, mid, id)
+			if err != nil {
+				return err
+			}
+		}
+		if node.Attrs == nil {
+			return nil
+		}
+		_, err = tx.Exec(self.rebind(`DELETE FROM deptree_org_attr WHERE mid = ? AND id = ?`), mid, id)
+		if err != nil {
+			return err
+		}
+		return self.insertOrgAttrs(tx, mid, id, node.Attrs)
+	})
+}
+
+// DelOrgNode 删除组织信息(包含全部子节点及叶子)
+func (self *sqlDepTree) DelOrgNode(mid string, id string) (err error) {
+	defer sqlError("DelOrgNode", &err)
+	if id == "" || mid == "" {
+		return newError(ErrInvalidArgument, "", "invalid id or mid [%s,%s]", id, mid)
+	}
+	return self.withTx(func(tx sqlQueryer) error {
+		if err := self.checkNode(tx, mid, id); err != nil {
+			return err
+		}
+		ids, err := self.subTreeIds(tx, mid, id)
+		if err != nil {
+			return err
+		}
+		if err = self.delSubTree(tx, mid, ids); err != nil {
+			return err
+		}
+		if id != mid {
+			return nil
+		}
+		stmts := []string{`DELETE FROM deptree_position WHERE mid = ?`, `DELETE FROM deptree_archive WHERE mid = ?`}
+		for _, t := range sqlArchiveTables {
+			stmts = append(stmts, `DELETE FROM `+t.table+`_archive WHERE mid = ?`)
+		}
+		for _, stmt := range stmts {
+			if _, err = tx.Exec(self.rebind(stmt), mid); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// delSubTree 删除子树的全部节点、叶子及与祖先的路径 ids为subTreeIds的结果
+func (self *sqlDepTree) delSubTree(q sqlQueryer, mid string, ids []interface{}) error {
+	in := placeholders(len(ids))
+	args := append([]interface{}{mid}, ids...)
+	for _, stmt := range []string{
+		"DELETE FROM deptree_leaf_position WHERE mid = ? AND pid IN (" + in + ")",
+		"DELETE FROM deptree_leaf_attr WHERE mid = ? AND pid IN (" + in + ")",
+		"DELETE FROM deptree_leaf WHERE mid = ? AND pid IN (" + in + ")",
+		"DELETE FROM deptree_org_attr WHERE mid = ? AND id IN (" + in + ")",
+		"DELETE FROM deptree_path WHERE mid = ? AND descendant IN (" + in + ")",
+		"DELETE FROM deptree_org WHERE mid = ? AND id IN (" + in + ")",
+	} {
+		if _, err := q.Exec(self.rebind(stmt), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ArchiveOrgNode 归档组织节点 子树的各表数据在同一事务中移入*_archive表
+func (self *sqlDepTree) ArchiveOrgNode(mid string, id string) (err error) {
+	defer sqlError("ArchiveOrgNode", &err)
+	if id == "" || mid == "" {
+		return newError(ErrInvalidArgument, "", "invalid id or mid [%s,%s]", id, mid)
+	}
+	if id == mid {
+		return newError(ErrInvalidArgument, "", "can't archive the top tree: %s", mid)
+	}
+	return self.withTx(func(tx sqlQueryer) error {
+		if err := self.checkNode(tx, mid, id); err != nil {
+			return err
+		}
+		ids, err := self.subTreeIds(tx, mid, id)
+		if err != nil {
+			return err
+		}
+		in := placeholders(len(ids))
+		args := append([]interface{}{id, mid}, ids...)
+		for _, t := range sqlArchiveTables {
+			_, err = tx.Exec(self.rebind(`INSERT INTO `+t.table+`_archive (root, `+t.cols+`)
+				SELECT ?, `+t.cols+` FROM `+t.table+` WHERE mid = ? AND `+t.key+` IN (`+in+`)`), args...)
+			if err != nil {
+				return err
+			}
+		}
+		if err = self.delSubTree(tx, mid, ids); err != nil {
+			return err
+		}
+		_, err = tx.Exec(self.rebind(`INSERT INTO deptree_archive (mid, id, archived_at) VALUES (?, ?, ?)`),
+			mid, id, archiveNow().Unix())
+		return err
+	})
+}
+
+// RestoreOrgNode 恢复归档的子树 移回原表后将子树连接到原父节点的祖先
+func (self *sqlDepTree) RestoreOrgNode(mid string, id string) (err error) {
+	defer sqlError("RestoreOrgNode", &err)
+	if id == "" || mid == "" {
+		return newError(ErrInvalidArgument, "", "invalid id or mid [%s,%s]", id, mid)
+	}
+	return self.withTx(func(tx sqlQueryer) error {
+		nodes, err := self.scanOrgs(tx, `SELECT mid, id, pid, name, type, is_default, ord
+			FROM deptree_org_archive WHERE mid = ? AND root = ? AND id = ?`, mid, id, id)
+		if err != nil {
+			return err
+		}
+		if len(nodes) == 0 {
+			return errNotFound("", "Can't find the archived node with this id: %s", id)
+		}
+		pid := nodes[0].Pid
+		if err = self.checkNode(tx, mid, pid); err != nil {
+			return err
+		}
+		if err = self.checkName(tx, mid, pid, nodes[0].Name); err != nil {
+			return err
+		}
+		order, err := self.nextOrder(tx, "deptree_org", mid, pid)
+		if err != nil {
+			return err
+		}
+		for _, t := range sqlArchiveTables {
+			_, err = tx.Exec(self.rebind(`INSERT INTO `+t.table+` (`+t.cols+`)
+				SELECT `+t.cols+` FROM `+t.table+`_archive WHERE mid = ? AND root = ?`), mid, id)
+			if err != nil {
+				return err
+			}
+		}
+		if err = self.delArchive(tx, mid, id); err != nil {
+			return err
+		}
+		_, err = tx.Exec(self.rebind(`INSERT INTO deptree_path (mid, ancestor, descendant, depth)
+			SELECT p.mid, p.ancestor, c.descendant, p.depth + c.depth + 1
+			FROM deptree_path p JOIN deptree_path c ON c.mid = p.mid
+			WHERE p.mid = ? AND p.descendant = ? AND c.ancestor = ?`), mid, pid, id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(self.rebind(`UPDATE deptree_org SET ord = ? WHERE mid = ? AND id = ?`), order, mid, id)
+		return err
+	})
+}
+
+// delArchive 删除一个归档子树在*_archive表中的数据
+func (self *sqlDepTree) delArchive(q sqlQueryer, mid string, id string) error {
+	stmts := []string{`DELETE FROM deptree_archive WHERE mid = ? AND id = ?`}
+	for _, t := range sqlArchiveTables {
+		stmts = append(stmts, `DELETE FROM `+t.table+`_archive WHERE mid = ? AND root = ?`)
+	}
+	for _, stmt := range stmts {
+		if _, err := q.Exec(self.rebind(stmt), mid, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetArchivedOrgNodes 取已归档的子树根节点
+func (self *sqlDepTree) GetArchivedOrgNodes(mid string) (_ []ArchivedOrg, err error) {
+	defer sqlError("GetArchivedOrgNodes", &err)
+	return self.archived(self.conn(), mid)
+}
+
+// archived 商户的归档列表 包含根节点的扩展属性
+func (self *sqlDepTree) archived(q sqlQueryer, mid string) ([]ArchivedOrg, error) {
+	if err := self.checkTopTree(q, mid); err != nil {
+		return nil, err
+	}
+	rows, err := q.Query(self.rebind(`SELECT n.mid, n.id, n.pid, n.name, n.type, n.is_default, n.ord, a.archived_at
+		FROM deptree_archive a JOIN deptree_org_archive n ON n.mid = a.mid AND n.id = a.id
+		WHERE a.mid = ?`), mid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := []ArchivedOrg{}
+	args := []interface{}{mid}
+	for rows.Next() {
+		node := OrgNode{}
+		var isDefault int
+		var at int64
+		err = rows.Scan(&node.Mid, &node.Id, &node.Pid, &node.Name, &node.Type, &isDefault, &node.Order, &at)
+		if err != nil {
+			return nil, err
+		}
+		node.IsDefault = isDefault != 0
+		ret = append(ret, newArchivedOrg(node, time.Unix(at, 0), self.retention))
+		args = append(args, node.Id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ret) > 0 {
+		attrs, err := self.queryAttrs(q, `SELECT mid, id, name, value FROM deptree_org_attr_archive
+			WHERE mid = ? AND id IN (`+placeholders(len(ret))+`) ORDER BY seq`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for i := range ret {
+			ret[i].Attrs = attrs[mid+"\x00"+ret[i].Id]
+		}
+	}
+	sortArchived(ret)
+	return ret, nil
+}
+
+// PurgeArchivedOrgNodes 删除超过保留期的归档子树
+func (self *sqlDepTree) PurgeArchivedOrgNodes(mid string) (_ []string, err error) {
+	defer sqlError("PurgeArchivedOrgNodes", &err)
+	var ids []string
+	err = self.withTx(func(tx sqlQueryer) error {
+		list, err := self.archived(tx, mid)
+		if err != nil {
+			return err
+		}
+		ids = expiredArchives(list, archiveNow())
+		for _, id := range ids {
+			if err = self.delArchive(tx, mid, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// MoveOrgNode 移动组织节点到新的父节点下 闭包表中断开子树与原祖先的关系后连接到新祖先
+func (self *sqlDepTree) MoveOrgNode(mid string, id string, newPid string) (err error) {
+	defer sqlError("MoveOrgNode", &err)
+	if id == "" || mid == "" || newPid == "" {
+		return newError(ErrInvalidArgument, "", "invalid id or mid or newPid [%s,%s,%s]", id, mid, newPid)
+	}
+	if id == mid {
+		return newError(ErrInvalidArgument, "", "can't move the top tree: %s", mid)
+	}
+	return self.withTx(func(tx sqlQueryer) error {
+		nodes, err := self.queryOrgs(tx, `SELECT mid, id, pid, name, type, is_default, ord
+			FROM deptree_org WHERE mid = ? AND id = ?`, mid, id)
+		if err != nil {
+			return err
+		}
+		if len(nodes) == 0 {
+			return errNotFound("", "Can't find the node with this id: %s", id)
+		}
+		if err = self.checkNode(tx, mid, newPid); err != nil {
+			return err
+		}
+		if nodes[0].Pid == newPid {
+			return nil
+		}
+		ids, err := self.subTreeIds(tx, mid, id)
+		if err != nil {
+			return err
+		}
+		for _, d := range ids {
+			if d == newPid {
+				return newError(ErrInvalidArgument, "", "can't move node %s into its own subtree", id)
+			}
+		}
+		if err = self.checkName(tx, mid, newPid, nodes[0].Name); err != nil {
+			return err
+		}
+
+		return self.moveSubTree(tx, mid, id, ids, newPid)
+	})
+}
+
+// moveSubTree 将子树移动到newPid下并排在最后 ids为subTreeIds的结果 不做检查
+func (self *sqlDepTree) moveSubTree(q sqlQueryer, mid string, id string, ids []interface{}, newPid string) error {
+	in := placeholders(len(ids))
+	args := append([]interface{}{mid}, ids...)
+	_, err := q.Exec(self.rebind(`DELETE FROM deptree_path WHERE mid = ?
+		AND descendant IN (`+in+`) AND ancestor NOT IN (`+in+`)`), append(args, ids...)...)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(self.rebind(`INSERT INTO deptree_path (mid, ancestor, descendant, depth)
+		SELECT p.mid, p.ancestor, c.descendant, p.depth + c.depth + 1
+		FROM deptree_path p JOIN deptree_path c ON c.mid = p.mid
+		WHERE p.mid = ? AND p.descendant = ? AND c.ancestor = ?`), mid, newPid, id)
+	if err != nil {
+		return err
+	}
+	order, err := self.nextOrder(q, "deptree_org", mid, newPid)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(self.rebind(`UPDATE deptree_org SET pid = ?, ord = ? WHERE mid = ? AND id = ?`),
+		newPid, order, mid, id)
+	return err
+}
+
+// AddLeafNode 新增叶子节点
+func (self *sqlDepTree) AddLeafNode(leaf LeafNode) (err error) {
+	defer sqlError("AddLeafNode", &err)
+	return self.withTx(func(tx sqlQueryer) error {
+		if err := self.checkNode(tx, leaf.Mid, leaf.Pid); err != nil {
+			return err
+		}
+		ok, err := self.exists(tx, "deptree_leaf WHERE mid = ? AND pid = ? AND uid = ?",
+			leaf.Mid, leaf.Pid, leaf.Uid)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return newError(ErrAlreadyExists, "", "leaf already exists with this uid: %s", leaf.Uid)
+		}
+		if err = self.checkPositionIds(tx, leaf.Mid, leaf.Positions); err != nil {
+			return err
+		}
+		if leaf.Attrs, err = checkAttrs(leaf.Attrs); err != nil {
+			return err
+		}
+		order := leaf.Order
+		if order == 0 {
+			if order, err = self.nextOrder(tx, "deptree_leaf", leaf.Mid, leaf.Pid); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec(self.rebind(`INSERT INTO deptree_leaf (mid, pid, uid, sid, ord) VALUES (?, ?, ?, ?, ?)`),
+			leaf.Mid, leaf.Pid, leaf.Uid, leaf.Sid, order)
+		if err != nil {
+			return err
+		}
+		if err = self.insertLeafAttrs(tx, leaf); err != nil {
+			return err
+		}
+		return self.insertPositions(tx, leaf)
+	})
+}
+
+// ModifyLeafNode 修改叶子节点(岗位信息)
+func (self *sqlDepTree) ModifyLeafNode(leaf LeafNode) (err error) {
+	defer sqlError("ModifyLeafNode", &err)
+	if leaf.Positions == nil && leaf.Attrs == nil {
+		// 如果无修改岗位列表及扩展属性，则直接返回
+		return nil
+	}
+	if leaf.Attrs, err = checkAttrs(leaf.Attrs); err != nil {
+		return err
+	}
+	return self.withTx(func(tx sqlQueryer) error {
+		ok, err := self.exists(tx, "deptree_leaf WHERE mid = ? AND pid = ? AND uid = ?",
+			leaf.Mid, leaf.Pid, leaf.Uid)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errNotFound("", "Can't find the leaf with this uid: %s", leaf.Uid)
+		}
+		if leaf.Attrs != nil {
+			_, err = tx.Exec(self.rebind(`DELETE FROM deptree_leaf_attr
+				WHERE mid = ? AND pid = ? AND uid = ?`), leaf.Mid, leaf.Pid, leaf.Uid)
+			if err != nil {
+				return err
+			}
+			if err = self.insertLeafAttrs(tx, leaf); err != nil {
+				return err
+			}
+		}
+		if leaf.Positions == nil {
+			return nil
+		}
+		if err = self.checkPositionIds(tx, leaf.Mid, leaf.Positions); err != nil {
+			return err
+		}
+		_, err = tx.Exec(self.rebind(`DELETE FROM deptree_leaf_position
+			WHERE mid = ? AND pid = ? AND uid = ?`), leaf.Mid, leaf.Pid, leaf.Uid)
+		if err != nil {
+			return err
+		}
+		return self.insertPositions(tx, leaf)
+	})
+}
+
+// DelLeafNode 删除叶子节点
+func (self *sqlDepTree) DelLeafNode(mid string, pid string, uid string) (err error) {
+	defer sqlError("DelLeafNode", &err)
+	return self.withTx(func(tx sqlQueryer) error {
+		res, err := tx.Exec(self.rebind(`DELETE FROM deptree_leaf
+			WHERE mid = ? AND pid = ? AND uid = ?`), mid, pid, uid)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errNotFound("", "Can't find the leaf with this uid: %s", uid)
+		}
+		for _, table := range []string{"deptree_leaf_position", "deptree_leaf_attr"} {
+			_, err = tx.Exec(self.rebind(`DELETE FROM `+table+`
+				WHERE mid = ? AND pid = ? AND uid = ?`), mid, pid, uid)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MoveLeafNode 调动叶子节点 在同一事务中更新叶子及岗位的父节点
+func (self *sqlDepTree) MoveLeafNode(mid string, uid string, fromPid string, toPid string) (err error) {
+	defer sqlError("MoveLeafNode", &err)
+	return self.withTx(func(tx sqlQueryer) error {
+		if err := self.checkNode(tx, mid, toPid); err != nil {
+			return err
+		}
+		ok, err := self.exists(tx, "deptree_leaf WHERE mid = ? AND pid = ? AND uid = ?", mid, fromPid, uid)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errNotFound("", "Can't find the leaf with this uid: %s", uid)
+		}
+		if fromPid == toPid {
+			return nil
+		}
+		ok, err = self.exists(tx, "deptree_leaf WHERE mid = ? AND pid = ? AND uid = ?", mid, toPid, uid)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return newError(ErrAlreadyExists, "", "leaf already exists with this uid: %s", uid)
+		}
+		return self.moveLeaf(tx, mid, uid, fromPid, toPid)
+	})
+}
+
+// moveLeaf 将叶子及其岗位、扩展属性调动到toPid下并排在最后 不做检查
+func (self *sqlDepTree) moveLeaf(q sqlQueryer, mid string, uid string, fromPid string, toPid string) error {
+	order, err := self.nextOrder(q, "deptree_leaf", mid, toPid)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(self.rebind(`UPDATE deptree_leaf SET pid = ?, ord = ?
+		WHERE mid = ? AND pid = ? AND uid = ?`), toPid, order, mid, fromPid, uid)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"deptree_leaf_position", "deptree_leaf_attr"} {
+		_, err = q.Exec(self.rebind(`UPDATE `+table+` SET pid = ?
+			WHERE mid = ? AND pid = ? AND uid = ?`), toPid, mid, fromPid, uid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReorderOrgNode 调整组织节点在同级中的位置 同级节点按新顺序重新编号
+func (self *sqlDepTree) ReorderOrgNode(mid string, id string, place Placement) (err error) {
+	defer sqlError("ReorderOrgNode", &err)
+	if id == "" || mid == "" {
+		return newError(ErrInvalidArgument, "", "invalid id or mid [%s,%s]", id, mid)
+	}
+	if id == mid {
+		return newError(ErrInvalidArgument, "", "can't reorder the top tree: %s", mid)
+	}
+	return self.withTx(func(tx sqlQueryer) error {
+		if err := self.checkNode(tx, mid, id); err != nil {
+			return err
+		}
+		siblings, err := self.queryOrgs(tx, `SELECT mid, id, pid, name, type, is_default, ord
+			FROM deptree_org WHERE mid = ? AND pid = (
+				SELECT pid FROM deptree_org WHERE mid = ? AND id = ?)`, mid, mid, id)
+		if err != nil {
+			return err
+		}
+		sortSiblingOrgs(siblings)
+		ids := []string{}
+		for _, n := range siblings {
+			ids = append(ids, n.Id)
+		}
+		order, err := placeSibling(ids, id, place)
+		if err != nil {
+			return err
+		}
+		return self.renumber(tx, "deptree_org", "id", mid, siblings[0].Pid, order)
+	})
+}
+
+// MergeOrgNodes 合并组织节点 在同一事务中读取子树、生成并执行全部步骤
+func (self *sqlDepTree) MergeOrgNodes(mid string, sourceId string, targetId string, opts MergeOptions) (report *MergeReport, err error) {
+	defer sqlError("MergeOrgNodes", &err)
+	err = self.withTx(func(tx sqlQueryer) error {
+		tree := self.inTx(tx)
+		source, err := tree.subTree(mid, sourceId)
+		if err != nil {
+			return err
+		}
+		target, err := tree.subTree(mid, targetId)
+		if err != nil {
+			return err
+		}
+		if err = checkMerge(mid, sourceId, targetId, source, target, opts); err != nil {
+			return err
+		}
+		report, err = newMergeReport(source, target, opts, sameKey)
+		if err != nil || opts.DryRun {
+			return err
+		}
+		return runMerge(report, &sqlMerge{tree: self, tx: tx, mid: mid})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// sqlMerge sql实现的合并操作 在MergeOrgNodes的事务中执行
+type sqlMerge struct {
+	tree *sqlDepTree
+	tx   sqlQueryer
+	mid  string
+}
+
+func (self *sqlMerge) moveOrg(id string, pid string, name string) error {
+	t := self.tree
+	if name != "" {
+		_, err := self.tx.Exec(t.rebind(`UPDATE deptree_org SET name = ? WHERE mid = ? AND id = ?`), name, self.mid, id)
+		if err != nil {
+			return err
+		}
+	}
+	ok, err := t.exists(self.tx, "deptree_org WHERE mid = ? AND id = ? AND pid = ?", self.mid, id, pid)
+	if err != nil || ok {
+		return err
+	}
+	ids, err := t.subTreeIds(self.tx, self.mid, id)
+	if err != nil {
+		return err
+	}
+	return t.moveSubTree(self.tx, self.mid, id, ids, pid)
+}
+
+func (self *sqlMerge) moveLeaf(uid string, from string, to string) error {
+	return self.tree.moveLeaf(self.tx, self.mid, uid, from, to)
+}
+
+func (self *sqlMerge) mergeLeaf(uid string, from string, to string, positions []string) error {
+	t := self.tree
+	_, err := self.tx.Exec(t.rebind(`DELETE FROM deptree_leaf_position WHERE mid = ? AND pid = ? AND uid = ?`),
+		self.mid, to, uid)
+	if err != nil {
+		return err
+	}
+	err = t.insertPositions(self.tx, LeafNode{Mid: self.mid, Pid: to, Uid: uid, Positions: positions})
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"deptree_leaf_position", "deptree_leaf_attr", "deptree_leaf"} {
+		_, err = self.tx.Exec(t.rebind(`DELETE FROM `+table+` WHERE mid = ? AND pid = ? AND uid = ?`),
+			self.mid, from, uid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (self *sqlMerge) delOrg(id string) error {
+	return self.tree.delSubTree(self.tx, self.mid, []interface{}{id})
+}
+
+// RenameLeafNode 在同一事务中修改uid在商户内全部叶子(包含已归档的叶子)的uid
+func (self *sqlDepTree) RenameLeafNode(mid string, oldUid string, newUid string) (err error) {
+	defer sqlError("RenameLeafNode", &err)
+	if oldUid == "" || newUid == "" {
+		return newError(ErrInvalidArgument, "", "invalid uid [%s,%s]", oldUid, newUid)
+	}
+	return self.withTx(func(tx sqlQueryer) error {
+		if err := self.checkTopTree(tx, mid); err != nil {
+			return err
+		}
+		const where = ` WHERE mid = ? AND uid = ?`
+		found, err := self.exists(tx, "deptree_leaf"+where, mid, oldUid)
+		if err == nil && !found {
+			found, err = self.exists(tx, "deptree_leaf_archive"+where, mid, oldUid)
+		}
+		if err != nil {
+			return err
+		}
+		if !found {
+			return errNotFound("", "Can't find the leaf with this uid: %s", oldUid)
+		}
+		if oldUid == newUid {
+			return nil
+		}
+		for _, table := range []string{"deptree_leaf", "deptree_leaf_archive"} {
+			ok, err := self.exists(tx, table+where, mid, newUid)
+			if err != nil {
+				return err
+			}
+			if ok {
+				return newError(ErrAlreadyExists, "", "leaf already exists with this uid: %s", newUid)
+			}
+		}
+		for _, table := range []string{
+			"deptree_leaf", "deptree_leaf_position", "deptree_leaf_attr",
+			"deptree_leaf_archive", "deptree_leaf_position_archive", "deptree_leaf_attr_archive",
+		} {
+			if _, err = tx.Exec(self.rebind(`UPDATE `+table+` SET uid = ?`+where), newUid, mid, oldUid); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ReorderLeafNode 调整叶子在同级中的位置 同级叶子按新顺序重新编号
+func (self *sqlDepTree) ReorderLeafNode(mid string, pid string, uid string, place Placement) (err error) {
+	defer sqlError("ReorderLeafNode", &err)
+	return self.withTx(func(tx sqlQueryer) error {
+		if err := self.checkNode(tx, mid, pid); err != nil {
+			return err
+		}
+		siblings, err := self.queryLeafs(tx, "l.mid = ? AND l.pid = ?", mid, pid)
+		if err != nil {
+			return err
+		}
+		sortSiblingLeafs(siblings)
+		uids := []string{}
+		for _, l := range siblings {
+			uids = append(uids, l.Uid)
+		}
+		order, err := placeSibling(uids, uid, place)
+		if err != nil {
+			return err
+		}
+		return self.renumber(tx, "deptree_leaf", "uid", mid, pid, order)
+	})
+}
+
+// subTreeLeafs 取oid子树下满足条件的叶子
+func (self *sqlDepTree) subTreeLeafs(mid string, oid string, where string, args ...interface{}) ([]LeafNode, error) {
+	if err := self.checkNode(self.conn(), mid, oid); err != nil {
+		return nil, err
+	}
+	return self.queryLeafs(self.conn(), `l.mid = ? AND l.pid IN (
+		SELECT descendant FROM deptree_path WHERE mid = ? AND ancestor = ?)`+where,
+		append([]interface{}{mid, mid, oid}, args...)...)
+}
+
+// subTreeLeafsPaged 分页取oid子树下满足条件的叶子 按pid、uid排序，游标为最后一个叶子的pid、uid
+// 先按键取出一页的范围，再查询该范围内的叶子及岗位
+func (self *sqlDepTree) subTreeLeafsPaged(mid string, oid string, pageSize int, cursor string,
+	where string, args ...interface{}) ([]LeafNode, string, error) {
+	if err := checkPageSize(pageSize); err != nil {
+		return nil, "", err
+	}
+	after, err := decodeCursor(cursor, 2)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = self.checkNode(self.conn(), mid, oid); err != nil {
+		return nil, "", err
+	}
+	where = `l.mid = ? AND l.pid IN (
+		SELECT descendant FROM deptree_path WHERE mid = ? AND ancestor = ?)` + where
+	args = append([]interface{}{mid, mid, oid}, args...)
+	if after != nil {
+		where += " AND (l.pid > ? OR (l.pid = ? AND l.uid > ?))"
+		args = append(args, after[0], after[0], after[1])
+	}
+	rows, err := self.conn().Query(self.rebind(`SELECT l.pid, l.uid FROM deptree_leaf l
+		WHERE `+where+` ORDER BY l.pid, l.uid LIMIT ?`), append(args, pageSize+1)...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	keys := [][2]string{}
+	for rows.Next() {
+		var key [2]string
+		if err = rows.Scan(&key[0], &key[1]); err != nil {
+			return nil, "", err
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+	if len(keys) == 0 {
+		return []LeafNode{}, "", nil
+	}
+	next := ""
+	if len(keys) > pageSize {
+		keys = keys[:pageSize]
+		next = encodeCursor(keys[pageSize-1][0], keys[pageSize-1][1])
+	}
+	last := keys[len(keys)-1]
+	leafs, err := self.queryLeafs(self.conn(), where+" AND (l.pid < ? OR (l.pid = ? AND l.uid <= ?))",
+		append(args, last[0], last[0], last[1])...)
+	if err != nil {
+		return nil, "", err
+	}
+	return leafs, next, nil
+}
+
+// GetLeafNodes 取oid子树下uid对应的全部叶子
+func (self *sqlDepTree) GetLeafNodes(mid string, oid string, uid string) (_ []LeafNode, err error) {
+	defer sqlError("GetLeafNodes", &err)
+	return self.subTreeLeafs(mid, oid, " AND l.uid = ?", uid)
+}
+
+// GetLeafNodesByOrg 取oid子树下的全部叶子
+func (self *sqlDepTree) GetLeafNodesByOrg(mid string, oid string) (_ []LeafNode, err error) {
+	defer sqlError("GetLeafNodesByOrg", &err)
+	return self.subTreeLeafs(mid, oid, "")
+}
+
+// GetUsersByPosition 根据岗位查询子树下的叶子
+func (self *sqlDepTree) GetUsersByPosition(mid string, pid string, positionid string) (_ []LeafNode, err error) {
+	defer sqlError("GetUsersByPosition", &err)
+	return self.subTreeLeafs(mid, pid, sqlPositionWhere, positionid)
+}
+
+// sqlPositionWhere 叶子拥有指定岗位的条件
+const sqlPositionWhere = ` AND EXISTS (
+	SELECT 1 FROM deptree_leaf_position x
+	WHERE x.mid = l.mid AND x.pid = l.pid AND x.uid = l.uid AND x.position = ?)`
+
+// GetLeafNodesByOrgPaged 分页取oid子树下的叶子
+func (self *sqlDepTree) GetLeafNodesByOrgPaged(mid string, oid string, pageSize int, cursor string) (_ []LeafNode, _ string, err error) {
+	defer sqlError("GetLeafNodesByOrgPaged", &err)
+	return self.subTreeLeafsPaged(mid, oid, pageSize, cursor, "")
+}
+
+// GetUsersByPositionPaged 分页根据岗位查询子树下的叶子
+func (self *sqlDepTree) GetUsersByPositionPaged(mid string, pid string, positionid string, pageSize int, cursor string) (_ []LeafNode, _ string, err error) {
+	defer sqlError("GetUsersByPositionPaged", &err)
+	return self.subTreeLeafsPaged(mid, pid, pageSize, cursor, sqlPositionWhere, positionid)
+}
+
+// GetOrgNode 取组织节点信息
+func (self *sqlDepTree) GetOrgNode(mid string, id string) (_ *OrgNode, err error) {
+	defer sqlError("GetOrgNode", &err)
+	if err := self.checkTopTree(self.conn(), mid); err != nil {
+		return nil, err
+	}
+	nodes, err := self.queryOrgs(self.conn(), `SELECT mid, id, pid, name, type, is_default, ord
+		FROM deptree_org WHERE mid = ? AND id = ?`, mid, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, errNotFound("", "Can't find the node with this id: %s", id)
+	}
+	return &nodes[0], nil
+}
+
+// GetOrgNodesByOrg dept==1时仅取下一级节点，否则取整棵子树(包含自身) 同级按顺序排列
+func (self *sqlDepTree) GetOrgNodesByOrg(mid string, oid string, dept int) (_ []OrgNode, err error) {
+	defer sqlError("GetOrgNodesByOrg", &err)
+	return self.subTreeOrgs(mid, oid, dept)
+}
+
+// subTreeOrgs 取oid子树下的组织节点 dept==1时仅取下一级(按同级顺序)，否则按先序遍历排列
+func (self *sqlDepTree) subTreeOrgs(mid string, oid string, dept int) ([]OrgNode, error) {
+	if err := self.checkNode(self.conn(), mid, oid); err != nil {
+		return nil, err
+	}
+	depth := ""
+	if dept == 1 {
+		depth = " AND p.depth = 1"
+	}
+	nodes, err := self.queryOrgs(self.conn(), `SELECT n.mid, n.id, n.pid, n.name, n.type, n.is_default, n.ord
+		FROM deptree_path p JOIN deptree_org n ON n.mid = p.mid AND n.id = p.descendant
+		WHERE p.mid = ? AND p.ancestor = ?`+depth, mid, oid)
+	if err != nil {
+		return nil, err
+	}
+	if dept == 1 {
+		sortSiblingOrgs(nodes)
+		return nodes, nil
+	}
+	return orderOrgNodes(oid, nodes), nil
+}
+
+// GetOrgNodesByOrgPaged 分页取组织节点 按层级、名称、ID排序，游标为最后一个节点的层级、名称、ID
+func (self *sqlDepTree) GetOrgNodesByOrgPaged(mid string, oid string, dept int, pageSize int, cursor string) (_ []OrgNode, _ string, err error) {
+	defer sqlError("GetOrgNodesByOrgPaged", &err)
+	if err = checkPageSize(pageSize); err != nil {
+		return nil, "", err
+	}
+	after, err := decodeCursor(cursor, 3)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = self.checkNode(self.conn(), mid, oid); err != nil {
+		return nil, "", err
+	}
+	where := ""
+	args := []interface{}{mid, oid}
+	if dept == 1 {
+		where = " AND p.depth = 1"
+	}
+	if after != nil {
+		depth, err := strconv.Atoi(after[0])
+		if err != nil {
+			return nil, "", newError(ErrInvalidArgument, "", "invalid cursor: %s", cursor)
+		}
+		where += " AND (p.depth > ? OR (p.depth = ? AND (n.name > ? OR (n.name = ? AND n.id > ?))))"
+		args = append(args, depth, depth, after[1], after[1], after[2])
+	}
+	nodes, err := self.queryOrgs(self.conn(), `SELECT n.mid, n.id, n.pid, n.name, n.type, n.is_default, n.ord
+		FROM deptree_path p JOIN deptree_org n ON n.mid = p.mid AND n.id = p.descendant
+		WHERE p.mid = ? AND p.ancestor = ?`+where+`
+		ORDER BY p.depth, n.name, n.id LIMIT ?`, append(args, pageSize+1)...)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(nodes) <= pageSize {
+		return nodes, "", nil
+	}
+	nodes = nodes[:pageSize]
+	last := nodes[pageSize-1]
+	var depth int
+	err = self.conn().QueryRow(self.rebind(`SELECT depth FROM deptree_path
+		WHERE mid = ? AND ancestor = ? AND descendant = ?`), mid, oid, last.Id).Scan(&depth)
+	if err != nil {
+		return nil, "", err
+	}
+	return nodes, encodeCursor(strconv.Itoa(depth), last.Name, last.Id), nil
+}
+
+// GetSubTree 取树形结构 一次查询组织节点，一次查询叶子，在内存中组装
+func (self *sqlDepTree) GetSubTree(mid string, id string) (_ *OrgTree, err error) {
+	defer sqlError("GetSubTree", &err)
+	return self.subTree(mid, id)
+}
+
+// subTree 取id对应的子树
+func (self *sqlDepTree) subTree(mid string, id string) (*OrgTree, error) {
+	nodes, err := self.subTreeOrgs(mid, id, 0)
+	if err != nil {
+		return nil, err
+	}
+	leafs, err := self.subTreeLeafs(mid, id, "")
+	if err != nil {
+		return nil, err
+	}
+	subtree := buildTree(nodes[0], nodes, leafs)
+	return &subtree, nil
+}
+
+// GetStatistics 子树统计 通过闭包表分组计数，不读取叶子明细
+func (self *sqlDepTree) GetStatistics(mid string, id string) (_ *OrgStats, err error) {
+	defer sqlError("GetStatistics", &err)
+	q := self.conn()
+	if err = self.checkNode(q, mid, id); err != nil {
+		return nil, err
+	}
+	nodes, err := self.scanOrgs(q, `SELECT n.mid, n.id, n.pid, n.name, n.type, n.is_default, n.ord
+		FROM deptree_path p JOIN deptree_org n ON n.mid = p.mid AND n.id = p.descendant
+		WHERE p.mid = ? AND p.ancestor = ?`, mid, id)
+	if err != nil {
+		return nil, err
+	}
+	ret := newOrgStats(id, nodes, nil)
+	index := map[string]*NodeStats{}
+	for i := range ret.Nodes {
+		index[ret.Nodes[i].Id] = &ret.Nodes[i]
+	}
+	// 直属叶子
+	err = self.groupCounts(q, `SELECT l.pid, COUNT(*), COUNT(DISTINCT l.uid)
+		FROM deptree_path p JOIN deptree_leaf l ON l.mid = p.mid AND l.pid = p.descendant
+		WHERE p.mid = ? AND p.ancestor = ? GROUP BY l.pid`, []interface{}{mid, id}, func(key string, n int, _ int) {
+		if s, ok := index[key]; ok {
+			s.Direct = n
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 各节点包含子孙节点的叶子
+	err = self.groupCounts(q, `SELECT a.ancestor, COUNT(*), COUNT(DISTINCT l.uid)
+		FROM deptree_path r JOIN deptree_path a ON a.mid = r.mid AND a.ancestor = r.descendant
+		JOIN deptree_leaf l ON l.mid = a.mid AND l.pid = a.descendant
+		WHERE r.mid = ? AND r.ancestor = ? GROUP BY a.ancestor`, []interface{}{mid, id}, func(key string, n int, u int) {
+		if s, ok := index[key]; ok {
+			s.Total, s.Uids = n, u
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = self.groupCounts(q, `SELECT lp.position, COUNT(*), COUNT(DISTINCT lp.uid)
+		FROM deptree_path p JOIN deptree_leaf_position lp ON lp.mid = p.mid AND lp.pid = p.descendant
+		WHERE p.mid = ? AND p.ancestor = ? GROUP BY lp.position`, []interface{}{mid, id}, func(key string, n int, u int) {
+		ret.Positions[key] = PositionCount{Leafs: n, Uids: u}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if root, ok := index[id]; ok {
+		ret.Leafs, ret.Uids = root.Total, root.Uids
+	}
+	return ret, nil
+}
+
+// groupCounts 执行 SELECT 分组键, COUNT(*), COUNT(DISTINCT uid) 形式的查询
+func (self *sqlDepTree) groupCounts(q sqlQueryer, query string, args []interface{}, f func(key string, n int, uids int)) error {
+	rows, err := q.Query(self.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var n, uids int
+		if err = rows.Scan(&key, &n, &uids); err != nil {
+			return err
+		}
+		f(key, n, uids)
+	}
+	return rows.Err()
+}
+
+// GetParents 根据节点id获得全部父节点信息(路径，包含自身) 从近到远
+func (self *sqlDepTree) GetParents(mid string, id string) (_ []OrgNode, err error) {
+	defer sqlError("GetParents", &err)
+	if err := self.checkTopTree(self.conn(), mid); err != nil {
+		return nil, err
+	}
+	nodes, err := self.queryOrgs(self.conn(), `SELECT n.mid, n.id, n.pid, n.name, n.type, n.is_default, n.ord
+		FROM deptree_path p JOIN deptree_org n ON n.mid = p.mid AND n.id = p.ancestor
+		WHERE p.mid = ? AND p.descendant = ?
+		ORDER BY p.depth`, mid, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, errNotFound("", "Can't find the node with this id: %s", id)
+	}
+	return nodes, nil
+}
+
+// sqlSortColumns 搜索排序字段对应的列，与orgSortFields leafSortFields一致
+var sqlSortColumns = map[string][]string{
+	"Name": {"n.name", "n.id"},
+	"Id":   {"n.id"},
+	"Type": {"n.type", "n.id"},
+	"Uid":  {"l.uid", "l.pid"},
+	"Sid":  {"l.sid", "l.pid", "l.uid"},
+	"Pid":  {"l.pid", "l.uid"},
+}
+
+// sqlMatch 生成不区分大小写的匹配条件及参数
+func sqlMatch(column string, value string, match int) (string, interface{}) {
+	value = strings.ToLower(value)
+	if match == MATCH_EXACT {
+		return " AND LOWER(" + column + ") = ?", value
+	}
+	value = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(value) + "%"
+	if match == MATCH_SUBSTRING {
+		value = "%" + value
+	}
+	return " AND LOWER(" + column + ") LIKE ? ESCAPE '!'", value
+}
+
+// sqlOrderBy 生成排序及分页子句
+func sqlOrderBy(query SearchQuery) (string, []interface{}) {
+	columns := append([]string{}, sqlSortColumns[query.SortBy]...)
+	if query.Desc {
+		for i := range columns {
+			columns[i] += " DESC"
+		}
+	}
+	if query.Limit == 0 {
+		return " ORDER BY " + strings.Join(columns, ", "), nil
+	}
+	return " ORDER BY " + strings.Join(columns, ", ") + " LIMIT ? OFFSET ?",
+		[]interface{}{query.Limit, query.Offset}
+}
+
+// Search 在子树中搜索组织节点或叶子 条件、排序及分页均在数据库中完成
+func (self *sqlDepTree) Search(mid string, query SearchQuery) (_ *SearchResult, err error) {
+	defer sqlError("Search", &err)
+	if err = checkSearchQuery(mid, &query); err != nil {
+		return nil, err
+	}
+	if err = self.checkNode(self.conn(), mid, query.Root); err != nil {
+		return nil, err
+	}
+	where := ` WHERE n.mid = ? AND n.id IN (
+		SELECT descendant FROM deptree_path WHERE mid = ? AND ancestor = ?)`
+	args := []interface{}{mid, mid, query.Root}
+	if query.Name != "" {
+		cond, v := sqlMatch("n.name", query.Name, query.NameMatch)
+		where += cond
+		args = append(args, v)
+	}
+	if len(query.Types) > 0 {
+		where += " AND n.type IN (" + placeholders(len(query.Types)) + ")"
+		for _, t := range query.Types {
+			args = append(args, t)
+		}
+	}
+	if query.Target == SEARCH_ORG {
+		cond, attrArgs := sqlAttrsWhere("deptree_org_attr", "a.mid = n.mid AND a.id = n.id", query.Attrs)
+		return self.searchOrgs(query, "FROM deptree_org n"+where+cond, append(args, attrArgs...))
+	}
+	cond, attrArgs := sqlAttrsWhere("deptree_leaf_attr", "a.mid = l.mid AND a.pid = l.pid AND a.uid = l.uid", query.Attrs)
+	where += cond
+	args = append(args, attrArgs...)
+
+	if query.Uid != "" {
+		cond, v := sqlMatch("l.uid", query.Uid, query.UidMatch)
+		where += cond
+		args = append(args, v)
+	}
+	if query.Sid != "" {
+		where += " AND l.sid = ?"
+		args = append(args, query.Sid)
+	}
+	if len(query.Positions) > 0 {
+		exists := ` AND EXISTS (SELECT 1 FROM deptree_leaf_position x
+			WHERE x.mid = l.mid AND x.pid = l.pid AND x.uid = l.uid AND x.position `
+		if query.PositionMode == POSITION_ANY {
+			where += exists + "IN (" + placeholders(len(query.Positions)) + "))"
+			for _, p := range query.Positions {
+				args = append(args, p)
+			}
+		} else {
+			for _, p := range query.Positions {
+				where += exists + "= ?)"
+				args = append(args, p)
+			}
+		}
+	}
+	return self.searchLeafs(query, "FROM deptree_leaf l JOIN deptree_org n ON n.mid = l.mid AND n.id = l.pid"+where, args)
+}
+
+// searchOrgs 查询满足条件的组织节点
+func (self *sqlDepTree) searchOrgs(query SearchQuery, from string, args []interface{}) (*SearchResult, error) {
+	ret := &SearchResult{}
+	err := self.conn().QueryRow(self.rebind("SELECT COUNT(*) "+from), args...).Scan(&ret.Total)
+	if err != nil {
+		return nil, err
+	}
+	order, page := sqlOrderBy(query)
+	ret.Orgs, err = self.queryOrgs(self.conn(), "SELECT n.mid, n.id, n.pid, n.name, n.type, n.is_default, n.ord "+from+order,
+		append(args, page...)...)
+	if err != nil {
+		return nil, err
+	}
+	if query.Limit == 0 {
+		start, end := query.pageRange(len(ret.Orgs))
+		ret.Orgs = ret.Orgs[start:end]
+	}
+	return ret, nil
+}
+
+// searchLeafs 查询满足条件的叶子 先按排序分页取出叶子的键，再查询叶子及岗位
+func (self *sqlDepTree) searchLeafs(query SearchQuery, from string, args []interface{}) (*SearchResult, error) {
+	ret := &SearchResult{Leafs: []LeafNode{}}
+	err := self.conn().QueryRow(self.rebind("SELECT COUNT(*) "+from), args...).Scan(&ret.Total)
+	if err != nil {
+		return nil, err
+	}
+	order, page := sqlOrderBy(query)
+	rows, err := self.conn().Query(self.rebind("SELECT l.pid, l.uid "+from+order), append(args, page...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := [][2]string{}
+	for rows.Next() {
+		var key [2]string
+		if err = rows.Scan(&key[0], &key[1]); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if query.Limit == 0 {
+		start, end := query.pageRange(len(keys))
+		keys = keys[start:end]
+	}
+	if len(keys) == 0 {
+		return ret, nil
+	}
+
+	// 分批查询本页叶子及岗位，避免参数过多
+	found := map[[2]string]LeafNode{}
+	for i := 0; i < len(keys); i += 100 {
+		batch := keys[i:]
+		if len(batch) > 100 {
+			batch = batch[:100]
+		}
+		conds := make([]string, len(batch))
+		leafArgs := []interface{}{args[0]}
+		for j, key := range batch {
+			conds[j] = "(l.pid = ? AND l.uid = ?)"
+			leafArgs = append(leafArgs, key[0], key[1])
+		}
+		leafs, err := self.queryLeafs(self.conn(), "l.mid = ? AND ("+strings.Join(conds, " OR ")+")", leafArgs...)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range leafs {
+			found[[2]string{l.Pid, l.Uid}] = l
+		}
+	}
+	for _, key := range keys {
+		if l, ok := found[key]; ok {
+			ret.Leafs = append(ret.Leafs, l)
+		}
+	}
+	return ret, nil
+}
+
+// checkPositionIds 检查岗位均已定义
+func (self *sqlDepTree) checkPositionIds(q sqlQueryer, mid string, positions []string) error {
+	if len(positions) == 0 {
+		return nil
+	}
+	args := []interface{}{mid}
+	for _, p := range positions {
+		args = append(args, p)
+	}
+	rows, err := q.Query(self.rebind(`SELECT id FROM deptree_position
+		WHERE mid = ? AND id IN (`+placeholders(len(positions))+`)`), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	known := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return err
+		}
+		known[id] = true
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	return checkPositionIds(positions, func(id string) bool {
+		return known[id]
+	})
+}
+
+// queryPositions 查询岗位定义 where为deptree_position上的条件
+func (self *sqlDepTree) queryPositions(q sqlQueryer, where string, args ...interface{}) ([]Position, error) {
+	rows, err := q.Query(self.rebind(`SELECT mid, id, name, level, description
+		FROM deptree_position WHERE `+where+` ORDER BY level, id`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := []Position{}
+	for rows.Next() {
+		pos := Position{}
+		if err = rows.Scan(&pos.Mid, &pos.Id, &pos.Name, &pos.Level, &pos.Description); err != nil {
+			return nil, err
+		}
+		ret = append(ret, pos)
+	}
+	return ret, rows.Err()
+}
+
+// checkPosition 判断岗位是否存在
+func (self *sqlDepTree) checkPosition(q sqlQueryer, mid string, id string) error {
+	if err := self.checkTopTree(q, mid); err != nil {
+		return err
+	}
+	ok, err := self.exists(q, "deptree_position WHERE mid = ? AND id = ?", mid, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errNotFound("", "Can't find the position with this id: %s", id)
+	}
+	return nil
+}
+
+// checkPositionName 判断商户内是否存在同名岗位
+func (self *sqlDepTree) checkPositionName(q sqlQueryer, mid string, name string) error {
+	ok, err := self.exists(q, "deptree_position WHERE mid = ? AND name = ?", mid, name)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return newError(ErrDuplicateName, "", "position already exists with this name: %s", name)
+	}
+	return nil
+}
+
+// AddPosition 新增岗位定义
+func (self *sqlDepTree) AddPosition(pos Position) (_ string, err error) {
+	defer sqlError("AddPosition", &err)
+	if pos.Mid == "" || pos.Name == "" {
+		return "", newError(ErrInvalidArgument, "", "invalid mid or name [%s,%s]", pos.Mid, pos.Name)
+	}
+	err = self.withTx(func(tx sqlQueryer) error {
+		if err := self.checkTopTree(tx, pos.Mid); err != nil {
+			return err
+		}
+		if pos.Id == "" {
+			pos.Id = GetId()
+		} else {
+			ok, err := self.exists(tx, "deptree_position WHERE mid = ? AND id = ?", pos.Mid, pos.Id)
+			if err != nil {
+				return err
+			}
+			if ok {
+				return newError(ErrAlreadyExists, "", "position already exists with this id: %s", pos.Id)
+			}
+		}
+		if err := self.checkPositionName(tx, pos.Mid, pos.Name); err != nil {
+			return err
+		}
+		_, err := tx.Exec(self.rebind(`INSERT INTO deptree_position
+			(mid, id, name, level, description) VALUES (?, ?, ?, ?, ?)`),
+			pos.Mid, pos.Id, pos.Name, pos.Level, pos.Description)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return pos.Id, nil
+}
+
+// ModifyPosition 修改岗位定义
+func (self *sqlDepTree) ModifyPosition(pos Position) (err error) {
+	defer sqlError("ModifyPosition", &err)
+	return self.withTx(func(tx sqlQueryer) error {
+		if err := self.checkPosition(tx, pos.Mid, pos.Id); err != nil {
+			return err
+		}
+		if pos.Name != "" {
+			ok, err := self.exists(tx, "deptree_position WHERE mid = ? AND name = ? AND id <> ?",
+				pos.Mid, pos.Name, pos.Id)
+			if err != nil {
+				return err
+			}
+			if ok {
+				return newError(ErrDuplicateName, "", "position already exists with this name: %s", pos.Name)
+			}
+			_, err = tx.Exec(self.rebind(`UPDATE deptree_position SET name = ? WHERE mid = ? AND id = ?`),
+				pos.Name, pos.Mid, pos.Id)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := tx.Exec(self.rebind(`UPDATE deptree_position SET level = ?, description = ?
+			WHERE mid = ? AND id = ?`), pos.Level, pos.Description, pos.Mid, pos.Id)
+		return err
+	})
+}
+
+// RenamePosition 修改岗位ID 在同一事务中更新叶子的岗位
+func (self *sqlDepTree) RenamePosition(mid string, oldId string, newId string) (err error) {
+	defer sqlError("RenamePosition", &err)
+	if newId == "" {
+		return newError(ErrInvalidArgument, "", "invalid new position id")
+	}
+	return self.withTx(func(tx sqlQueryer) error {
+		if err := self.checkPosition(tx, mid, oldId); err != nil {
+			return err
+		}
+		if oldId == newId {
+			return nil
+		}
+		ok, err := self.exists(tx, "deptree_position WHERE mid = ? AND id = ?", mid, newId)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return newError(ErrAlreadyExists, "", "position already exists with this id: %s", newId)
+		}
+		for _, stmt := range []string{
+			`UPDATE deptree_position SET id = ? WHERE mid = ? AND id = ?`,
+			`UPDATE deptree_leaf_position SET position = ? WHERE mid = ? AND position = ?`,
+			`UPDATE deptree_leaf_position_archive SET position = ? WHERE mid = ? AND position = ?`,
+		} {
+			if _, err = tx.Exec(self.rebind(stmt), newId, mid, oldId); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DelPosition 删除岗位定义 在同一事务中从叶子中移除该岗位
+func (self *sqlDepTree) DelPosition(mid string, id string) (err error) {
+	defer sqlError("DelPosition", &err)
+	return self.withTx(func(tx sqlQueryer) error {
+		if err := self.checkPosition(tx, mid, id); err != nil {
+			return err
+		}
+		for _, stmt := range []string{
+			`DELETE FROM deptree_position WHERE mid = ? AND id = ?`,
+			`DELETE FROM deptree_leaf_position WHERE mid = ? AND position = ?`,
+			`DELETE FROM deptree_leaf_position_archive WHERE mid = ? AND position = ?`,
+		} {
+			if _, err := tx.Exec(self.rebind(stmt), mid, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetPosition 取岗位定义
+func (self *sqlDepTree) GetPosition(mid string, id string) (_ *Position, err error) {
+	defer sqlError("GetPosition", &err)
+	if err = self.checkPosition(self.conn(), mid, id); err != nil {
+		return nil, err
+	}
+	positions, err := self.queryPositions(self.conn(), "mid = ? AND id = ?", mid, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(positions) == 0 {
+		return nil, errNotFound("", "Can't find the position with this id: %s", id)
+	}
+	return &positions[0], nil
+}
+
+// GetPositions 取商户的全部岗位定义 按级别、ID排序
+func (self *sqlDepTree) GetPositions(mid string) (_ []Position, err error) {
+	defer sqlError("GetPositions", &err)
+	if err = self.checkTopTree(self.conn(), mid); err != nil {
+		return nil, err
+	}
+	return self.queryPositions(self.conn(), "mid = ?", mid)
+}
+
+// GetMemberships 取uid在商户内的全部叶子节点及各自的父节点路径
+// 一次查询叶子及全部祖先节点，一次查询岗位，扩展属性按批查询
+func (self *sqlDepTree) GetMemberships(mid string, uid string) (_ []Membership, err error) {
+	defer sqlError("GetMemberships", &err)
+	if err = self.checkTopTree(self.conn(), mid); err != nil {
+		return nil, err
+	}
+	rows, err := self.conn().Query(self.rebind(`SELECT l.pid, l.sid, l.ord,
+		n.mid, n.id, n.pid, n.name, n.type, n.is_default, n.ord
+		FROM deptree_leaf l
+		JOIN deptree_path p ON p.mid = l.mid AND p.descendant = l.pid
+		JOIN deptree_org n ON n.mid = p.mid AND n.id = p.ancestor
+		WHERE l.mid = ? AND l.uid = ?
+		ORDER BY l.pid, p.depth`), mid, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := []Membership{}
+	for rows.Next() {
+		var pid, sid string
+		var order, isDefault int
+		node := OrgNode{}
+		err = rows.Scan(&pid, &sid, &order, &node.Mid, &node.Id, &node.Pid, &node.Name, &node.Type, &isDefault, &node.Order)
+		if err != nil {
+			return nil, err
+		}
+		node.IsDefault = isDefault != 0
+		n := len(ret)
+		if n == 0 || ret[n-1].Leaf.Pid != pid {
+			ret = append(ret, Membership{
+				Leaf:    LeafNode{Mid: mid, Pid: pid, Uid: uid, Sid: sid, Order: order, Positions: []string{}},
+				Parents: []OrgNode{},
+			})
+			n++
+		}
+		ret[n-1].Parents = append(ret[n-1].Parents, node)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ret) == 0 {
+		return ret, nil
+	}
+
+	positions, err := self.conn().Query(self.rebind(`SELECT pid, position FROM deptree_leaf_position
+		WHERE mid = ? AND uid = ? ORDER BY pid, seq`), mid, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer positions.Close()
+	index := map[string]int{}
+	for i, m := range ret {
+		index[m.Leaf.Pid] = i
+	}
+	for positions.Next() {
+		var pid, position string
+		if err = positions.Scan(&pid, &position); err != nil {
+			return nil, err
+		}
+		if i, ok := index[pid]; ok {
+			ret[i].Leaf.Positions = append(ret[i].Leaf.Positions, position)
+		}
+	}
+	if err = positions.Err(); err != nil {
+		return nil, err
+	}
+	positions.Close()
+	if err = self.loadMembershipAttrs(ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// loadMembershipAttrs 读取叶子及父节点路径的扩展属性
+func (self *sqlDepTree) loadMembershipAttrs(memberships []Membership) error {
+	leafs := []LeafNode{}
+	parents := []OrgNode{}
+	for _, m := range memberships {
+		leafs = append(leafs, m.Leaf)
+		parents = append(parents, m.Parents...)
+	}
+	if err := self.loadLeafAttrs(self.conn(), leafs); err != nil {
+		return err
+	}
+	if err := self.loadOrgAttrs(self.conn(), parents); err != nil {
+		return err
+	}
+	k := 0
+	for i := range memberships {
+		memberships[i].Leaf.Attrs = leafs[i].Attrs
+		for j := range memberships[i].Parents {
+			memberships[i].Parents[j].Attrs = parents[k].Attrs
+			k++
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package deptree
+
+// newOrgStats 根据子树的组织节点及叶子汇总统计 nodes需包含根节点，叶子按Pid归属
+// memory ldap在取出子树后使用，sql直接在数据库中分组统计
+func newOrgStats(root string, nodes []OrgNode, leafs []LeafNode) *OrgStats {
+	nodes = orderOrgNodes(root, nodes)
+	ret := &OrgStats{
+		Id:        root,
+		Nodes:     make([]NodeStats, len(nodes)),
+		Positions: map[string]PositionCount{},
+	}
+	// 父节点在Nodes中的位置 根节点为-1
+	index := map[string]int{}
+	parents := make([]int, len(nodes))
+	for i, n := range nodes {
+		ret.Nodes[i] = NodeStats{Id: n.Id, Pid: n.Pid, Name: n.Name}
+		index[n.Id] = i
+		parents[i] = -1
+		if p, ok := index[n.Pid]; ok && n.Id != root {
+			parents[i] = p
+			ret.Nodes[i].Depth = ret.Nodes[p].Depth + 1
+		}
+		if ret.Nodes[i].Depth > ret.MaxDepth {
+			ret.MaxDepth = ret.Nodes[i].Depth
+		}
+	}
+	// 各节点包含子孙节点的uid集合
+	uids := make([]map[string]bool, len(nodes))
+	positions := map[string]map[string]bool{}
+	for _, l := range leafs {
+		i, ok := index[l.Pid]
+		if !ok {
+			continue
+		}
+		ret.Nodes[i].Direct++
+		ret.Leafs++
+		for j := i; j >= 0; j = parents[j] {
+			ret.Nodes[j].Total++
+			if uids[j] == nil {
+				uids[j] = map[string]bool{}
+			}
+			uids[j][l.Uid] = true
+		}
+		for _, p := range l.Positions {
+			c := ret.Positions[p]
+			c.Leafs++
+			ret.Positions[p] = c
+			if positions[p] == nil {
+				positions[p] = map[string]bool{}
+			}
+			positions[p][l.Uid] = true
+		}
+	}
+	for i := range ret.Nodes {
+		ret.Nodes[i].Uids = len(uids[i])
+	}
+	for p, set := range positions {
+		c := ret.Positions[p]
+		c.Uids = len(set)
+		ret.Positions[p] = c
+	}
+	if len(ret.Nodes) > 0 {
+		ret.Uids = ret.Nodes[0].Uids
+	}
+	return ret
+}
+
+// copyStats 复制统计结果，避免外部修改缓存数据
+func copyStats(stats *OrgStats) *OrgStats {
+	ret := *stats
+	ret.Nodes = append([]NodeStats{}, stats.Nodes...)
+	ret.Positions = map[string]PositionCount{}
+	for k, v := range stats.Positions {
+		ret.Positions[k] = v
+	}
+	return &ret
+}
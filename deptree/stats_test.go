@@ -0,0 +1,97 @@
+package deptree
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// statsSummary 统计结果的文本形式 节点为 Id:Depth/Direct/Total/Uids
+func statsSummary(stats *OrgStats) string {
+	nodes := []string{}
+	for _, n := range stats.Nodes {
+		nodes = append(nodes, fmt.Sprintf("%s:%d/%d/%d/%d", n.Id, n.Depth, n.Direct, n.Total, n.Uids))
+	}
+	return fmt.Sprintf("%s leafs %d uids %d depth %d p1 %d/%d", strings.Join(nodes, " "),
+		stats.Leafs, stats.Uids, stats.MaxDepth, stats.Positions["p1"].Leafs, stats.Positions["p1"].Uids)
+}
+
+// u同时属于a及b，x不在子树中
+func TestNewOrgStats(t *testing.T) {
+	nodes := []OrgNode{
+		{Id: "c", Pid: "r", Name: "c", Order: 2},
+		{Id: "b", Pid: "a", Name: "b", Order: 1},
+		{Id: "r", Pid: "top", Name: "r"},
+		{Id: "a", Pid: "r", Name: "a", Order: 1},
+	}
+	leafs := []LeafNode{
+		{Pid: "a", Uid: "u", Positions: []string{"p1"}},
+		{Pid: "b", Uid: "u", Positions: []string{"p1"}},
+		{Pid: "b", Uid: "v"},
+		{Pid: "c", Uid: "w", Positions: []string{"p1"}},
+		{Pid: "other", Uid: "x", Positions: []string{"p1"}},
+	}
+	want := "r:0/0/4/3 a:1/1/3/2 b:2/2/2/2 c:1/1/1/1 leafs 4 uids 3 depth 2 p1 3/2"
+	if got := statsSummary(newOrgStats("r", nodes, leafs)); got != want {
+		t.Errorf("stats\n got %s\nwant %s", got, want)
+	}
+	if got := statsSummary(newOrgStats("b", nodes[1:2], leafs)); got != "b:0/2/2/2 leafs 2 uids 2 depth 0 p1 1/1" {
+		t.Errorf("subtree stats = %s", got)
+	}
+}
+
+func TestGetStatistics(t *testing.T) {
+	eachBackend(t, func(t *testing.T, tree DepTree) {
+		seedTree(t, tree, "m", [2]string{"a", "m"}, [2]string{"b", "a"}, [2]string{"c", "m"})
+		if _, err := tree.AddPosition(Position{Mid: "m", Id: "p1", Name: "p1"}); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range []LeafNode{
+			{Pid: "a", Uid: "u", Positions: []string{"p1"}},
+			{Pid: "b", Uid: "u", Positions: []string{"p1"}},
+			{Pid: "b", Uid: "v"},
+			{Pid: "c", Uid: "w"},
+		} {
+			l.Mid = "m"
+			if err := tree.AddLeafNode(l); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cases := []struct {
+			id   string
+			want string
+		}{
+			{"m", "m:0/0/4/3 a:1/1/3/2 b:2/2/2/2 c:1/1/1/1 leafs 4 uids 3 depth 2 p1 2/1"},
+			{"a", "a:0/1/3/2 b:1/2/2/2 leafs 3 uids 2 depth 1 p1 2/1"},
+		}
+		for _, c := range cases {
+			stats, err := tree.GetStatistics("m", c.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := statsSummary(stats); got != c.want {
+				t.Errorf("GetStatistics(%s)\n got %s\nwant %s", c.id, got, c.want)
+			}
+		}
+	})
+}
+
+// 子树条目数超过服务端的size limit时分页取出
+func TestLdapGetStatisticsPaged(t *testing.T) {
+	saved := ldapStatsPageSize
+	ldapStatsPageSize = 2
+	defer func() { ldapStatsPageSize = saved }()
+	srv, tree := newTestLdap(t)
+	seedPagedLeafs(srv, 5)
+	srv.sizeLimit = 3
+	stats, err := tree.GetStatistics("m", "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Leafs != 5 || stats.Uids != 5 {
+		t.Errorf("leafs %d uids %d, want 5 and 5", stats.Leafs, stats.Uids)
+	}
+	if _, err = tree.GetLeafNodesByOrg("m", "m"); err == nil {
+		t.Error("unpaged search succeeded above the size limit")
+	}
+}